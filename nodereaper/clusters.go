@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/aws"
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/configmap"
+	"github.com/wish/nodereaper/pkg/controller"
+	"github.com/wish/nodereaper/pkg/deletion"
+	"github.com/wish/nodereaper/pkg/metrics"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// clusterController is the controller of one of the clusters managed in multi-cluster mode.
+// Each cluster has its own manager, ASG cache, state and leader lease, kept in its own namespace
+type clusterController struct {
+	name string
+	mgr  manager.Manager
+}
+
+// runClusters runs the controller for every cluster in --clusters, serving their metrics and health together
+func runClusters(opts *config.Ops) {
+	clusters, _ := config.ParseClusters(opts.Clusters)
+	logrus.Infof("Starting controller for %v clusters...", len(clusters))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	termCtx, stopSignals := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stopSignals()
+
+	reporters := make([]*metrics.Reporter, len(clusters))
+	for i, cluster := range clusters {
+		reporters[i] = metrics.NewForCluster(cluster.Name)
+	}
+	if err := metrics.Register(reporters...); err != nil {
+		logrus.Fatalf("Error registering metrics: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "OK\n")
+	})
+	health := serveHealthChecks(mux)
+	mux.Handle("/metrics", metrics.Handler())
+	srv, err := newMetricsServer(opts, mux)
+	if err != nil {
+		logrus.Fatalf("Error creating HTTP server: %v", err)
+	}
+	serveMetrics(opts, srv)
+
+	if opts.EnablePprof {
+		pprofSrv := servePprof(opts)
+		defer pprofSrv.Shutdown(context.Background())
+	}
+
+	// Every cluster shares the replica's ID, so its leases show which pod holds them
+	leaseID := opts.NodeName + "_" + strconv.Itoa(int(time.Now().UnixNano()%9999999))
+	checks := map[string]healthz.Checker{}
+	controllers := make([]*clusterController, len(clusters))
+	for i, cluster := range clusters {
+		controllers[i] = newCluster(ctx, opts.ForCluster(cluster), leaseID, checks, reporters[i])
+	}
+	health.set(checks)
+
+	context.AfterFunc(termCtx, func() {
+		logrus.Infof("Received SIGTERM or SIGINT. Shutting down.")
+	})
+	// Every manager shuts down like a single cluster controller's. If any of them stops on its
+	// own, having lost its lease, the others are stopped too
+	managersCtx, stopManagers := context.WithCancel(termCtx)
+	defer stopManagers()
+	errs := make(chan error, len(controllers))
+	for _, cc := range controllers {
+		go func() {
+			err := cc.mgr.Start(managersCtx)
+			if err != nil {
+				err = fmt.Errorf("cluster %v: %v", cc.name, err)
+			}
+			stopManagers()
+			errs <- err
+		}()
+	}
+	var mgrErr error
+	for range controllers {
+		if err := <-errs; err != nil && mgrErr == nil {
+			mgrErr = err
+		}
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("Error shutting down HTTP server: %v", err)
+	}
+	if mgrErr != nil {
+		logrus.Fatalf("Error running controller: %v", mgrErr)
+	}
+	logrus.Info("Shutdown complete")
+}
+
+// newCluster creates the cluster's manager, which follows the cluster's state once started, and acts
+// on it once we hold its lease. Health checks are added to checks under the cluster's name
+func newCluster(ctx context.Context, opts *config.Ops, leaseID string, checks map[string]healthz.Checker, reporter *metrics.Reporter) *clusterController {
+	name := opts.ClusterName
+	var restConfig *rest.Config
+	err := controller.RetryStartup("loading kubernetes client config of cluster "+name, func() (err error) {
+		restConfig, err = controller.RestConfig(opts.Kubeconfig, opts.Master, opts.Context)
+		return err
+	})
+	if err != nil {
+		logrus.Fatalf("Error loading kubernetes client config of cluster %v: %v", name, err)
+	}
+	controller.SetClientLimits(restConfig, "nodereaper-controller", opts.KubeAPIQPS, opts.KubeAPIBurst)
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		logrus.Fatalf("Error creating kubernetes client of cluster %v: %v", name, err)
+	}
+	mgr, err := newManager(opts, restConfig, clientset, opts.LockConfigMapName, leaseID)
+	if err != nil {
+		logrus.Fatalf("Error creating manager of cluster %v: %v", name, err)
+	}
+	c, err := controller.New(mgr, opts.InstanceGroupLabel)
+	if err != nil {
+		logrus.Fatalf("Error creating controller of cluster %v: %v", name, err)
+	}
+
+	// Only the cluster the controller runs in has its node, which it must delete before any other.
+	// Elsewhere a missing node doesn't mean we're being deleted
+	var hosted bool
+	err = controller.RetryStartup("looking up own node in cluster "+name, func() (err error) {
+		hosted, err = c.HasNode(ctx, opts.NodeName)
+		return err
+	})
+	if err != nil {
+		logrus.Fatalf("Error looking up own node in cluster %v: %v", name, err)
+	}
+	if !hosted {
+		logrus.Infof("Own node %v is not in cluster %v, it will never be deleted first there", opts.NodeName, name)
+		opts.NodeName = ""
+	}
+
+	apiTimeout, _ := config.ParseDuration(opts.APITimeout)
+	var locks *configmap.ConfigMap
+	err = controller.RetryStartup("creating locks configmap of cluster "+name, func() (err error) {
+		locks, err = configmap.New(ctx, clientset, opts.Namespace, opts.LockConfigMapName, apiTimeout)
+		return err
+	})
+	if err != nil {
+		logrus.Fatalf("Error creating locks configmap of cluster %v: %v", name, err)
+	}
+
+	awsPollPeriod, _ := config.ParseDuration(opts.AwsPollPeriod)
+	provider, err := aws.NewAPIProvider(awsPollPeriod, opts.AsgFilter(), opts.AsgNameTag(), reporter)
+	if err != nil {
+		logrus.Fatalf("Error creating AWS informer of cluster %v: %v", name, err)
+	}
+
+	deleter := deletion.New(opts, c, provider, locks, reporter)
+	addNotifiers(opts, deleter)
+
+	leading, err := addDeleter(mgr, deleter, provider, "deletion state of cluster "+name, func() {
+		logrus.Infof("Got leader lease of cluster %v", name)
+	})
+	if err != nil {
+		logrus.Fatalf("Error adding deleter of cluster %v to manager: %v", name, err)
+	}
+	addHealthChecks(checks, name+"/", opts, c, deleter, provider, leading)
+	return &clusterController{name, mgr}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/cron"
+)
+
+// cronCheckLookahead is how far ahead `nodereaper cron-check` looks for matching windows
+const cronCheckLookahead = 366 * 24 * time.Hour
+
+// cronCheckOps are the options for `nodereaper cron-check`, which checks a deletionSchedule
+// before it is committed to the configmap
+type cronCheckOps struct {
+	At    string `long:"at" description:"The time to check, as RFC3339 or \"2006-01-02 15:04\" in --tz. Defaults to now"`
+	Tz    string `long:"tz" description:"The time zone to read --at in and print times in. Schedules always match in UTC" default:"UTC"`
+	Count int    `long:"count" description:"The number of matching windows to print" default:"5"`
+}
+
+func runCronCheck(args []string) {
+	opts := &cronCheckOps{}
+	parser := flags.NewParser(opts, flags.Default)
+	parser.Usage = "cron-check [OPTIONS] SPEC"
+	args, err := parser.ParseArgs(args)
+	if err != nil {
+		os.Exit(1)
+	}
+	if len(args) != 1 {
+		logrus.Fatalf("Expected a single schedule, e.g. nodereaper cron-check \"* 18-20 * * 0,6\"")
+	}
+
+	schedule, err := cron.ParseStandard(args[0])
+	if err != nil {
+		logrus.Fatalf("Invalid schedule %v: %v", args[0], err)
+	}
+	loc, err := time.LoadLocation(opts.Tz)
+	if err != nil {
+		logrus.Fatalf("Invalid time zone %v: %v", opts.Tz, err)
+	}
+	at := time.Now()
+	if opts.At != "" {
+		if at, err = parseCronCheckTime(opts.At, loc); err != nil {
+			logrus.Fatalf("Invalid time %v: %v", opts.At, err)
+		}
+	}
+
+	// Like the controller, match in UTC
+	at = at.In(time.UTC)
+	fmt.Printf("%v matches %v: %v\n", args[0], at.In(loc).Format(time.RFC3339), schedule.Matches(at))
+
+	windows := schedule.Windows(at, opts.Count, at.Add(cronCheckLookahead))
+	if len(windows) == 0 {
+		fmt.Printf("No matching windows within %v\n", cronCheckLookahead)
+		return
+	}
+	fmt.Println("Next matching windows:")
+	for _, w := range windows {
+		fmt.Printf("  %v - %v (%v)\n", w.Start.In(loc).Format(time.RFC3339), w.End.In(loc).Format(time.RFC3339), w.End.Sub(w.Start))
+	}
+}
+
+func parseCronCheckTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04", s, loc)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/admin"
+	"github.com/wish/nodereaper/pkg/aws"
+	"github.com/wish/nodereaper/pkg/controller"
+	"github.com/wish/nodereaper/pkg/deletion"
+)
+
+const (
+	// debugStatePath serves the state dump on the metrics listener
+	debugStatePath = "/debug/state"
+	// debugStateVersion is bumped whenever a field of the dump changes meaning, for tools parsing it
+	debugStateVersion = 1
+	// recentErrorCount is how many warnings and errors the dump keeps
+	recentErrorCount = 100
+)
+
+// debugState is everything needed to understand what the controller is doing, in one document
+// meant to be attached to support tickets
+type debugState struct {
+	Version      int                    `json:"version"`
+	Time         time.Time              `json:"time"`
+	Role         string                 `json:"role"`
+	LastPoll     time.Time              `json:"lastPoll"`
+	Groups       []deletion.GroupStatus `json:"groups"`
+	Config       []deletion.GroupConfig `json:"config"`
+	AWS          aws.CacheSummary       `json:"aws"`
+	Lease        controller.LeaseInfo   `json:"lease"`
+	LeaseError   string                 `json:"leaseError,omitempty"`
+	SaveError    string                 `json:"saveError,omitempty"`
+	RecentErrors []recentError          `json:"recentErrors"`
+}
+
+// recentError is a warning or error that was logged
+type recentError struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// recentErrorsHook remembers the last warnings and errors logged, oldest first
+type recentErrorsHook struct {
+	mu      sync.Mutex
+	entries []recentError
+}
+
+func (h *recentErrorsHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+func (h *recentErrorsHook) Fire(entry *logrus.Entry) error {
+	e := recentError{entry.Time, entry.Level.String(), entry.Message, nil}
+	if len(entry.Data) > 0 {
+		e.Fields = map[string]interface{}{}
+		for k, v := range entry.Data {
+			// Errors marshal to {} otherwise
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			e.Fields[k] = v
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, e)
+	if len(h.entries) > recentErrorCount {
+		h.entries = h.entries[len(h.entries)-recentErrorCount:]
+	}
+	return nil
+}
+
+func (h *recentErrorsHook) recent() []recentError {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]recentError{}, h.entries...)
+}
+
+var recentErrors = &recentErrorsHook{}
+
+// debugStateHandler serves the state dump to requests carrying the admin token
+func debugStateHandler(token string, deleter *deletion.Deleter, provider *aws.APIProvider, leaseInfo func(context.Context) (controller.LeaseInfo, error), apiTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !admin.Authenticated(r, token) {
+			logrus.Warnf("Rejected unauthenticated request %v %v from %v", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		state := debugState{
+			Version:      debugStateVersion,
+			Time:         time.Now(),
+			Role:         "leader",
+			LastPoll:     deleter.LastPoll(),
+			Groups:       deleter.Groups(),
+			Config:       deleter.Config(),
+			AWS:          provider.CacheSummary(),
+			RecentErrors: recentErrors.recent(),
+		}
+		if deleter.Standby() {
+			state.Role = "standby"
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), apiTimeout)
+		defer cancel()
+		info, err := leaseInfo(ctx)
+		state.Lease = info
+		if err != nil {
+			state.LeaseError = err.Error()
+		}
+		if err := deleter.LastSaveError(); err != nil {
+			state.SaveError = err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			logrus.Errorf("Error writing state dump: %v", err)
+		}
+	}
+}
@@ -13,16 +13,26 @@ import (
 
 	"github.com/wish/nodereaper/pkg/configmap"
 
-	_ "net/http/pprof"
+	"net/http/pprof"
 
 	flags "github.com/jessevdk/go-flags"
 
 	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/admin"
 	"github.com/wish/nodereaper/pkg/aws"
+	"github.com/wish/nodereaper/pkg/cloudevents"
 	"github.com/wish/nodereaper/pkg/config"
 	"github.com/wish/nodereaper/pkg/controller"
+	"github.com/wish/nodereaper/pkg/datadog"
 	"github.com/wish/nodereaper/pkg/deletion"
 	"github.com/wish/nodereaper/pkg/metrics"
+	"github.com/wish/nodereaper/pkg/ratelog"
+	"github.com/wish/nodereaper/pkg/slack"
+	"github.com/wish/nodereaper/pkg/webhook"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 func setupLogging(logLevel string) {
@@ -38,22 +48,158 @@ func setupLogging(logLevel string) {
 		FullTimestamp: true,
 	}
 	logrus.SetFormatter(formatter)
+
+	// Keep the last warnings and errors for the state dump
+	logrus.AddHook(recentErrors)
 }
 
-func parseKvList(s string) map[string]string {
-	filter := map[string]string{}
-	for _, item := range strings.Split(s, ",") {
-		if !strings.Contains(item, "=") {
-			continue
-		} else {
-			spl := strings.Split(item, "=")
-			filter[spl[0]] = spl[1]
+// serveWebhook starts the validating admission webhook that protects the force deletion label,
+// and optionally the nodes being deleted
+func serveWebhook(opts *config.Ops, deleter *deletion.Deleter) *http.Server {
+	serviceAccount := func(name string) string {
+		return fmt.Sprintf("system:serviceaccount:%v:%v", opts.Namespace, name)
+	}
+	labelWriters := []string{serviceAccount("nodereaper")}
+	if opts.WebhookLabelWriters != "" {
+		labelWriters = strings.Split(opts.WebhookLabelWriters, ",")
+	}
+	taintWriters := []string{serviceAccount("nodereaper"), serviceAccount("nodereaperd")}
+	if opts.WebhookTaintWriters != "" {
+		taintWriters = strings.Split(opts.WebhookTaintWriters, ",")
+	}
+
+	// The label's key is protected, whatever its value
+	forceDeletionLabel, _, _ := config.SplitLabel(opts.ForceDeletionLabel)
+	mux := http.NewServeMux()
+	validator := webhook.New(forceDeletionLabel, config.DeletionTaint, labelWriters, taintWriters)
+	if opts.WebhookGuardDeletion {
+		// Whoever may taint a node for deletion also deletes it: nodereaperd, or the controller with drainMode server
+		validator.ProtectDeletion(func(nodeName string) (string, bool) {
+			state, ok := deleter.LeavingState(nodeName)
+			return string(state), ok
+		}, taintWriters)
+	}
+	mux.Handle(webhook.Path, validator)
+	srv := newServer(opts, opts.WebhookBindAddress, mux)
+	go func() {
+		if err := srv.ListenAndServeTLS(opts.WebhookTLSCertFile, opts.WebhookTLSKeyFile); err != nil && err != http.ErrServerClosed {
+			logrus.Fatalf("Error serving admission webhook at %v: %v", opts.WebhookBindAddress, err)
+		}
+	}()
+	logrus.Infof("Serving admission webhook at %v%v", opts.WebhookBindAddress, webhook.Path)
+	return srv
+}
+
+// addNotifiers sends the deleter's lifecycle events everywhere they're configured to go
+func addNotifiers(opts *config.Ops, deleter *deletion.Deleter) {
+	if opts.LifecycleSNSTopicArn != "" || opts.LifecycleSQSQueueURL != "" {
+		deleter.AddNotifier(deletion.PublisherNotifier(aws.NewPublisher(opts.LifecycleSNSTopicArn, opts.LifecycleSQSQueueURL)))
+	}
+	if opts.DatadogAPIKey != "" {
+		tags := []string{}
+		if opts.ClusterName != "" {
+			tags = append(tags, "cluster:"+opts.ClusterName)
+		}
+		deleter.AddNotifier(datadog.New(opts.DatadogAPIKey, opts.DatadogSite, tags))
+	}
+	if opts.CloudEventsSink != "" {
+		deleter.AddNotifier(cloudevents.New(opts.CloudEventsSink, opts.ClusterName))
+	}
+}
+
+// servePprof serves the pprof handlers on their own listener, so they aren't exposed with the metrics
+func servePprof(opts *config.Ops) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	srv := &http.Server{
+		Addr:    opts.PprofBindAddress,
+		Handler: mux,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Error serving pprof at %v: %v", opts.PprofBindAddress, err)
+		}
+	}()
+	logrus.Infof("Serving pprof at %v/debug/pprof/", opts.PprofBindAddress)
+	return srv
+}
+
+// runPlan prints what a single poll cycle would do, without taking the leader lease
+// or acting on anything
+func runPlan(opts *config.Ops) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopCh := ctx.Done()
+
+	var restConfig *rest.Config
+	err := controller.RetryStartup("loading kubernetes client config", func() (err error) {
+		restConfig, err = controller.RestConfig(opts.Kubeconfig, opts.Master, opts.Context)
+		return err
+	})
+	if err != nil {
+		logrus.Fatalf("Error loading kubernetes client config: %v", err)
+	}
+	controller.SetClientLimits(restConfig, "nodereaper-controller", opts.KubeAPIQPS, opts.KubeAPIBurst)
+	cacheOpts, err := controller.CacheOptions(opts.NodeSelector)
+	if err != nil {
+		logrus.Fatalf("Error parsing node selector: %v", err)
+	}
+	mgr, err := controller.NewManager(restConfig, manager.Options{Cache: cacheOpts})
+	if err != nil {
+		logrus.Fatalf("Error creating manager: %v", err)
+	}
+	c, err := controller.New(mgr, opts.InstanceGroupLabel)
+	if err != nil {
+		logrus.Fatalf("Error creating controller: %v", err)
+	}
+	go func() {
+		if err := mgr.Start(ctx); err != nil {
+			logrus.Fatalf("Error running manager: %v", err)
 		}
+	}()
+	if !mgr.GetCache().WaitForCacheSync(ctx) {
+		logrus.Fatal("Failed to sync informer cache")
+	}
+
+	apiTimeout, _ := config.ParseDuration(opts.APITimeout)
+	var locks *configmap.ConfigMap
+	err = controller.RetryStartup("creating locks configmap", func() (err error) {
+		locks, err = configmap.New(ctx, c.Clientset, opts.Namespace, opts.LockConfigMapName, apiTimeout)
+		return err
+	})
+	if err != nil {
+		logrus.Fatalf("Error creating locks configmap: %v", err)
+	}
+
+	awsPollPeriod, _ := config.ParseDuration(opts.AwsPollPeriod)
+	reporter := metrics.New()
+	provider, err := aws.NewAPIProvider(awsPollPeriod, opts.AsgFilter(), opts.AsgNameTag(), reporter)
+	if err != nil {
+		logrus.Fatalf("Error creating AWS informer: %v", err)
+	}
+	provider.Run(stopCh)
+
+	deleter := deletion.New(opts, c, provider, locks, reporter)
+	if err := deleter.Plan(ctx, os.Stdout); err != nil {
+		logrus.Fatalf("Error planning deletions: %v", err)
 	}
-	return filter
 }
 
 func main() {
+	// `nodereaper status` has its own options, none of which the controller requires
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		runStatus(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cron-check" {
+		runCronCheck(os.Args[2:])
+		return
+	}
+
 	opts := &config.Ops{}
 	parser := flags.NewParser(opts, flags.Default)
 	if _, err := parser.Parse(); err != nil {
@@ -67,94 +213,200 @@ func main() {
 
 	setupLogging(opts.LogLevel)
 
-	// Validate poll period
-	if opts.PollPeriod != "" {
-		_, err := config.ParseDuration(opts.PollPeriod)
-		if err != nil {
-			logrus.Fatalf("Error parsing poll period: %v", err)
+	// Report every problem with the flags and the configmap at once, instead of one at a time,
+	// or as a panic in the middle of a poll
+	if errs := opts.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			logrus.Error(err)
 		}
+		logrus.Fatalf("Found %v configuration problems", len(errs))
 	}
+	logRepeatInterval, _ := config.ParseDuration(opts.LogRepeatInterval)
+	ratelog.SetInterval(logRepeatInterval)
 
-	// Validate aws period
-	if opts.AwsPollPeriod != "" {
-		_, err := config.ParseDuration(opts.AwsPollPeriod)
-		if err != nil {
-			logrus.Fatalf("Error parsing AWS poll period: %v", err)
-		}
+	if opts.Plan {
+		runPlan(opts)
+		return
+	}
+
+	if opts.Clusters != "" {
+		runClusters(opts)
+		return
 	}
 
 	logrus.Info("Starting controller...")
 
-	// Handle termination
-	stopCh := make(chan struct{})
-	srv := &http.Server{
-		Addr: opts.BindAddr,
+	// Handle termination. Signals are handled from the start, so a replica still waiting
+	// for the lease shuts down gracefully too
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	termCtx, stopSignals := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stopSignals()
+	// The pprof import registers its handlers on http.DefaultServeMux, so metrics are served on their own mux
+	mux := http.NewServeMux()
+	srv, err := newMetricsServer(opts, mux)
+	if err != nil {
+		logrus.Fatalf("Error creating HTTP server: %v", err)
 	}
 
-	defer srv.Shutdown(context.Background())
-	defer close(stopCh)
-
-	// Controller watches nodes for changes
-	c, err := controller.NewController(nil, nil)
+	var restConfig *rest.Config
+	err = controller.RetryStartup("loading kubernetes client config", func() (err error) {
+		restConfig, err = controller.RestConfig(opts.Kubeconfig, opts.Master, opts.Context)
+		return err
+	})
 	if err != nil {
-		logrus.Fatalf("Error creating controller: %v", err)
+		logrus.Fatalf("Error loading kubernetes client config: %v", err)
+	}
+	controller.SetClientLimits(restConfig, "nodereaper-controller", opts.KubeAPIQPS, opts.KubeAPIBurst)
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		logrus.Fatalf("Error creating kubernetes client: %v", err)
 	}
 
-	// Prometheus metrics
-	metrics := metrics.New()
+	// Prometheus metrics, served along with the manager's own
+	reporter := metrics.New()
+	if err := metrics.Register(reporter); err != nil {
+		logrus.Fatalf("Error registering metrics: %v", err)
+	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "OK\n")
 	})
-	http.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "OK\n")
-	})
-	http.HandleFunc("/metrics", metrics.Handler)
-	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			logrus.Errorf("Error serving HTTP at %v: %v", opts.BindAddr, err)
-		}
-	}()
+	health := serveHealthChecks(mux)
+	mux.Handle("/metrics", metrics.Handler())
+	serveMetrics(opts, srv)
+
+	if opts.EnablePprof {
+		pprofSrv := servePprof(opts)
+		defer pprofSrv.Shutdown(context.Background())
+	}
 
-	locks, err := configmap.New(c.Clientset, opts.Namespace, opts.LockConfigMapName)
+	apiTimeout, _ := config.ParseDuration(opts.APITimeout)
+	var locks *configmap.ConfigMap
+	err = controller.RetryStartup("creating locks configmap", func() (err error) {
+		locks, err = configmap.New(ctx, clientset, opts.Namespace, opts.LockConfigMapName, apiTimeout)
+		return err
+	})
 	if err != nil {
 		logrus.Fatalf("Error creating locks configmap: %v", err)
 	}
 
 	randomID := int(time.Now().UnixNano() % 9999999)
-	leaderLease := configmap.NewLeaderLease(locks, "leader", opts.NodeName+"_"+strconv.Itoa(randomID))
-	for {
-		logrus.Info("Trying to acquire leader lease")
-		got, err := leaderLease.TryAcquireLease()
-		if !got || err != nil {
-			logrus.Warnf("Could not acquire leader lease: %v", err)
-		} else {
-			break
+	leaseID := opts.NodeName + "_" + strconv.Itoa(randomID)
+	leaseName := opts.LockConfigMapName
+	// With shards, the lease of a shard takes the place of the leader lease, and the shard's
+	// state is kept in its own configmap of the same name
+	stateLocks := locks
+	// The lease of our shard is renewed until the manager's elector takes it over
+	stopRenewing := make(chan struct{})
+	if opts.Shards > 1 {
+		shard, ok := acquireShard(termCtx, clientset, opts.Namespace, opts.LockConfigMapName, opts.Shards, leaseID)
+		if !ok {
+			logrus.Info("Received SIGTERM or SIGINT before holding a shard. Shutting down.")
+			return
+		}
+		opts = opts.ForShard(shard)
+		leaseName = deletion.ShardConfigMapName(opts.LockConfigMapName, shard)
+		go controller.KeepLease(termCtx, clientset, opts.Namespace, leaseName, leaseID, stopRenewing)
+		err = controller.RetryStartup("creating shard configmap", func() (err error) {
+			stateLocks, err = configmap.New(ctx, clientset, opts.Namespace, leaseName, apiTimeout)
+			return err
+		})
+		if err != nil {
+			logrus.Fatalf("Error creating shard configmap: %v", err)
 		}
-		time.Sleep(10 * time.Second)
 	}
-	logrus.Infof("Got leader lease")
-	go leaderLease.ManageLease(stopCh)
+
+	// The manager fills the controller's cache, and runs the deleter on the replica holding the lease
+	mgr, err := newManager(opts, restConfig, clientset, leaseName, leaseID)
+	if err != nil {
+		logrus.Fatalf("Error creating manager: %v", err)
+	}
+	if opts.Shards > 1 {
+		go func() {
+			<-mgr.Elected()
+			close(stopRenewing)
+		}()
+		err = mgr.Add(controller.EveryReplica(func(ctx context.Context) error {
+			watchShards(ctx, clientset, opts.Namespace, opts.LockConfigMapName, opts.Shards, reporter)
+			return nil
+		}))
+		if err != nil {
+			logrus.Fatalf("Error adding shard watch to manager: %v", err)
+		}
+	}
+	c, err := controller.New(mgr, opts.InstanceGroupLabel)
+	if err != nil {
+		logrus.Fatalf("Error creating controller: %v", err)
+	}
 
 	awsPollPeriod, _ := config.ParseDuration(opts.AwsPollPeriod)
 	// APIProvider handles cloud-specific info and actions
-	provider, err := aws.NewAPIProvider(awsPollPeriod, parseKvList(opts.AwsAsgFilter), opts.AwsAsgNameTag)
+	provider, err := aws.NewAPIProvider(awsPollPeriod, opts.AsgFilter(), opts.AsgNameTag(), reporter)
 	if err != nil {
 		logrus.Fatalf("Error creating AWS informer: %v", err)
 	}
 
 	// The thing that actually performs the deletion
-	deleter := deletion.New(opts, c, provider, locks, metrics)
+	deleter := deletion.New(opts, c, provider, stateLocks, reporter)
+	addNotifiers(opts, deleter)
+	if opts.SlackBotToken != "" {
+		deleter.SetApprover(slack.NewApprover(opts.SlackBotToken, opts.SlackChannel, opts.ClusterName))
+	}
 
-	c.Run(stopCh)
-	provider.Run(stopCh)
-	deleter.Run(stopCh)
+	// Every replica serves the webhook, not just the leader
+	if opts.WebhookBindAddress != "" {
+		webhookSrv := serveWebhook(opts, deleter)
+		defer webhookSrv.Shutdown(context.Background())
+	}
 
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGTERM)
-	signal.Notify(sigterm, syscall.SIGINT)
-	<-sigterm
+	// Admin API exposing the deleter's state, and a dump of everything for support tickets
+	if opts.AdminToken != "" {
+		leaseInfo := func(ctx context.Context) (controller.LeaseInfo, error) {
+			return controller.GetLeaseInfo(ctx, clientset, opts.Namespace, leaseName, leaseID)
+		}
+		mux.Handle(admin.PathPrefix, protectAdmin(opts, admin.New(deleter, opts.AdminToken)))
+		mux.Handle(debugStatePath, protectAdmin(opts, debugStateHandler(opts.AdminToken, deleter, provider, leaseInfo, apiTimeout)))
+	} else {
+		logrus.Info("No admin token set. Admin API and state dump are disabled")
+	}
+	if opts.GRPCBindAddress != "" {
+		grpcSrv, err := serveControl(opts, deleter)
+		if err != nil {
+			logrus.Fatalf("Error serving the control API: %v", err)
+		}
+		defer grpcSrv.GracefulStop()
+	}
 
-	logrus.Infof("Received SIGTERM or SIGINT. Shutting down.")
+	// Until we're the leader, follow the leader's state so metrics and the admin API keep
+	// working, and we can take over without waiting for caches to sync
+	leading, err := addDeleter(mgr, deleter, provider, "deletion state", func() {
+		logrus.Info("Got leader lease")
+		// Slack only reaches a single replica with interactions, so only the leader handles them
+		if opts.SlackBotToken != "" {
+			mux.Handle(slack.InteractionsPath, slack.NewHandler(deleter, opts.SlackSigningSecret))
+		}
+	})
+	if err != nil {
+		logrus.Fatalf("Error adding deleter to manager: %v", err)
+	}
+	checks := map[string]healthz.Checker{}
+	addHealthChecks(checks, "", opts, c, deleter, provider, leading)
+	health.set(checks)
 
+	context.AfterFunc(termCtx, func() {
+		logrus.Infof("Received SIGTERM or SIGINT. Shutting down.")
+	})
+	// Once stopped, the manager has stopped polling, persisted the latest state, and handed
+	// the lease to another replica. Only then do we stop serving
+	mgrErr := mgr.Start(termCtx)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("Error shutting down HTTP server: %v", err)
+	}
+	if mgrErr != nil {
+		logrus.Fatalf("Error running controller: %v", mgrErr)
+	}
+	logrus.Info("Shutdown complete")
 }
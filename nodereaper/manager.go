@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/aws"
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/controller"
+	"github.com/wish/nodereaper/pkg/deletion"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// newManager creates the manager of the cluster at restConfig, whose replicas elect their leader
+// with the Lease of the given name
+func newManager(opts *config.Ops, restConfig *rest.Config, clientset kubernetes.Interface, leaseName, leaseID string) (manager.Manager, error) {
+	cacheOpts, err := controller.CacheOptions(opts.NodeSelector)
+	if err != nil {
+		return nil, err
+	}
+	mgrOpts := manager.Options{Cache: cacheOpts}
+	if err := controller.LeaderElection(&mgrOpts, clientset, opts.Namespace, leaseName, leaseID); err != nil {
+		return nil, err
+	}
+	return controller.NewManager(restConfig, mgrOpts)
+}
+
+// addDeleter runs the deleter under the manager. Every replica follows the leader's state once
+// the caches have synced, and the replica holding the lease acts on it, calling onLeading first.
+// On shutdown the manager stops polling, then the leader persists its state, and only then
+// releases the lease to another replica. The returned function reports whether the deleter is acting.
+// stateName describes the deleter's state in logs
+func addDeleter(mgr manager.Manager, deleter *deletion.Deleter, provider *aws.APIProvider, stateName string, onLeading func()) (func() bool, error) {
+	var leading atomic.Bool
+	observing := make(chan struct{})
+	err := mgr.Add(controller.EveryReplica(func(ctx context.Context) error {
+		provider.Run(ctx.Done())
+		deleter.Observe(ctx)
+		close(observing)
+		<-ctx.Done()
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		// Run takes over from the state Observe follows, so it must come second
+		select {
+		case <-observing:
+		case <-ctx.Done():
+			return nil
+		}
+		onLeading()
+		deleter.Run(ctx)
+		leading.Store(true)
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := deleter.Shutdown(shutdownCtx); err != nil {
+			logrus.Errorf("Error saving %v: %v", stateName, err)
+		}
+		return nil
+	}))
+	return leading.Load, err
+}
+
+// addHealthChecks adds the checks of a controller to checks, each name prefixed with prefix. Until
+// leading returns true, we're healthy once the caches sync
+func addHealthChecks(checks map[string]healthz.Checker, prefix string, opts *config.Ops, c *controller.Controller, deleter *deletion.Deleter, provider *aws.APIProvider, leading func() bool) {
+	pollPeriod, _ := config.ParseDuration(opts.PollPeriod)
+	awsPollPeriod, _ := config.ParseDuration(opts.AwsPollPeriod)
+	checks[prefix+"informers"] = func(*http.Request) error {
+		if !c.HasSynced() {
+			return fmt.Errorf("informer caches not synced")
+		}
+		return nil
+	}
+	checks[prefix+"poll"] = func(*http.Request) error {
+		if !leading() {
+			return nil
+		}
+		if age := time.Since(deleter.LastPoll()); age > 3*pollPeriod {
+			return fmt.Errorf("last successful poll was %v ago", age.Round(time.Second))
+		}
+		return nil
+	}
+	checks[prefix+"aws"] = func(*http.Request) error {
+		if !leading() {
+			return nil
+		}
+		if age := time.Since(provider.LastSync()); age > 3*awsPollPeriod {
+			return fmt.Errorf("AWS cache was last updated %v ago", age.Round(time.Second))
+		}
+		return nil
+	}
+	checks[prefix+"configmap"] = func(*http.Request) error {
+		if !leading() {
+			return nil
+		}
+		if err := deleter.LastSaveError(); err != nil {
+			return fmt.Errorf("last state write failed: %v", err)
+		}
+		return nil
+	}
+}
+
+// healthChecks serves health checks at /healthcheck, and each of them at /healthcheck/<name>.
+// Until they're set, every check passes, so the replica stays live while it starts
+type healthChecks struct {
+	handler atomic.Pointer[healthz.Handler]
+}
+
+func serveHealthChecks(mux *http.ServeMux) *healthChecks {
+	h := &healthChecks{}
+	h.handler.Store(&healthz.Handler{})
+	handler := http.StripPrefix("/healthcheck", h)
+	mux.Handle("/healthcheck", handler)
+	mux.Handle("/healthcheck/", handler)
+	return h
+}
+
+func (h *healthChecks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.Load().ServeHTTP(w, r)
+}
+
+// set starts serving checks, which mustn't be modified afterwards
+func (h *healthChecks) set(checks map[string]healthz.Checker) {
+	h.handler.Store(&healthz.Handler{Checks: checks})
+}
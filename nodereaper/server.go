@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/admin"
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/control"
+	"github.com/wish/nodereaper/pkg/deletion"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// newServer creates a server with the timeouts from opts, so slow or stuck clients can't hold connections forever
+func newServer(opts *config.Ops, addr string, handler http.Handler) *http.Server {
+	readTimeout, _ := config.ParseDuration(opts.HTTPReadTimeout)
+	writeTimeout, _ := config.ParseDuration(opts.HTTPWriteTimeout)
+	idleTimeout, _ := config.ParseDuration(opts.HTTPIdleTimeout)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// newMetricsServer creates the server for the metrics listener, which also serves the admin API.
+// With a client CA, client certificates are verified when given, and required by protectAdmin
+func newMetricsServer(opts *config.Ops, handler http.Handler) (*http.Server, error) {
+	srv := newServer(opts, opts.BindAddr, handler)
+	if opts.TLSClientCAFile == "" {
+		return srv, nil
+	}
+	pool, err := loadClientCAs(opts)
+	if err != nil {
+		return nil, err
+	}
+	// Prometheus and the kubelet's probes don't present certificates, so they can't be required for every request
+	srv.TLSConfig = &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+	return srv, nil
+}
+
+// loadClientCAs reads the CAs client certificates must be signed by
+func loadClientCAs(opts *config.Ops) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(opts.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificates found in client CA %v", opts.TLSClientCAFile)
+	}
+	return pool, nil
+}
+
+// serveMetrics serves the metrics listener in the background, over HTTPS if opts has a certificate
+func serveMetrics(opts *config.Ops, srv *http.Server) {
+	go func() {
+		var err error
+		if opts.TLSCertFile != "" {
+			err = srv.ListenAndServeTLS(opts.TLSCertFile, opts.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Error serving HTTP at %v: %v", opts.BindAddr, err)
+		}
+	}()
+}
+
+// protectAdmin requires an allowed client certificate for the admin endpoints, with a client CA
+func protectAdmin(opts *config.Ops, h http.Handler) http.Handler {
+	if opts.TLSClientCAFile == "" {
+		return h
+	}
+	return admin.RequireClientCert(allowedClients(opts), h)
+}
+
+// allowedClients returns the names of the client certificates allowed to use the admin APIs
+func allowedClients(opts *config.Ops) []string {
+	allowed := []string{}
+	for _, name := range strings.Split(opts.AdminAllowedClients, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allowed = append(allowed, name)
+		}
+	}
+	return allowed
+}
+
+// serveControl serves the gRPC control API in the background, over TLS if opts has a certificate.
+// It takes the admin token like the admin API, and with a client CA, requires an allowed client
+// certificate for every call, as no probes or scrapers share its listener
+func serveControl(opts *config.Ops, deleter *deletion.Deleter) (*grpc.Server, error) {
+	serverOpts := []grpc.ServerOption{}
+	if opts.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading TLS certificate: %v", err)
+		}
+		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+		if opts.TLSClientCAFile != "" {
+			pool, err := loadClientCAs(opts)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.ClientCAs = pool
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(control.RequireClientCert(allowedClients(opts))))
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	listener, err := net.Listen("tcp", opts.GRPCBindAddress)
+	if err != nil {
+		return nil, fmt.Errorf("Error listening at %v: %v", opts.GRPCBindAddress, err)
+	}
+	srv := control.NewGRPCServer(deleter, opts.AdminToken, serverOpts...)
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			logrus.Errorf("Error serving gRPC at %v: %v", opts.GRPCBindAddress, err)
+		}
+	}()
+	return srv, nil
+}
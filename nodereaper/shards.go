@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/controller"
+	"github.com/wish/nodereaper/pkg/deletion"
+	"github.com/wish/nodereaper/pkg/metrics"
+	"github.com/wish/nodereaper/pkg/ratelog"
+	"k8s.io/client-go/kubernetes"
+)
+
+// shardCheckPeriod is how often every replica checks for shards no replica holds
+const shardCheckPeriod = 30 * time.Second
+
+// acquireShard blocks until we hold the Lease of one of the shards, named like the shard's configmap, and
+// returns the shard. A replica holds a single shard, so the shards spread over the replicas, and a shard whose
+// replica went away is taken over by a spare once its lease expires. Returns false if ctx is cancelled first
+func acquireShard(ctx context.Context, clientset kubernetes.Interface, namespace, lockConfigMapName string, shards int, id string) (int, bool) {
+	for {
+		for shard := 0; shard < shards; shard++ {
+			name := deletion.ShardConfigMapName(lockConfigMapName, shard)
+			got, err := controller.ClaimLease(ctx, clientset, namespace, name, id)
+			if err != nil {
+				logrus.Warnf("Could not acquire lease of shard %v: %v", shard, err)
+			}
+			if got {
+				logrus.Infof("Got lease of shard %v of %v", shard, shards)
+				return shard, true
+			}
+		}
+		logrus.Info("Every shard is held by another replica. Waiting to take one over")
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+// watchShards reports the shards whose lease no replica holds until ctx is cancelled. Their groups
+// aren't driven by anyone, which happens with fewer replicas than shards, so they're counted in the
+// nodereaper_unowned_shards metric, and logged
+func watchShards(ctx context.Context, clientset kubernetes.Interface, namespace, lockConfigMapName string, shards int, reporter *metrics.Reporter) {
+	for {
+		// Spares take a shard over within a retry period of its lease expiring, so don't report one straight away
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(shardCheckPeriod):
+		}
+		unowned := []int{}
+		for shard := 0; shard < shards; shard++ {
+			holder, err := controller.LeaseHolder(ctx, clientset, namespace, deletion.ShardConfigMapName(lockConfigMapName, shard))
+			if err != nil {
+				ratelog.Warnf("shard-lease", "Could not read lease of shard %v: %v", shard, err)
+				continue
+			}
+			if holder == "" {
+				unowned = append(unowned, shard)
+			}
+		}
+		reporter.SetUnownedShards(shards, len(unowned))
+		if len(unowned) > 0 {
+			ratelog.Warnf("unowned-shards", "No replica holds shards %v of %v, so their groups aren't driven. Run at least %v replicas", unowned, shards, shards)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/controller"
+	"github.com/wish/nodereaper/pkg/deletion"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// statusOps are the options for `nodereaper status`, which is meant to be run from
+// a laptop rather than in the cluster
+type statusOps struct {
+	Namespace         string `long:"namespace" short:"n" env:"NAMESPACE" description:"The namespace the controller resides in" required:"true"`
+	LockConfigMapName string `long:"lock-configmap-name" env:"LOCK_CONFIGMAP_NAME" description:"The name of the configmap the controller stores state in" default:"nodereaper-locks"`
+	Kubeconfig        string `long:"kubeconfig" description:"Path to a kubeconfig. Defaults to $KUBECONFIG or ~/.kube/config"`
+	Context           string `long:"context" description:"The kubeconfig context to use. Defaults to the current context"`
+	Shards            int    `long:"shards" description:"The controller's --shards, to show the groups of every shard" default:"1"`
+}
+
+func runStatus(args []string) {
+	opts := &statusOps{}
+	parser := flags.NewParser(opts, flags.Default)
+	parser.Usage = "status [OPTIONS]"
+	if _, err := parser.ParseArgs(args); err != nil {
+		os.Exit(1)
+	}
+
+	path := opts.Kubeconfig
+	if path == "" {
+		path = controller.DefaultKubeconfigPath()
+	}
+	config, err := controller.RestConfig(path, "", opts.Context)
+	if err != nil {
+		logrus.Fatalf("Error loading kubeconfig: %v", err)
+	}
+	controller.SetClientLimits(config, "nodereaper-status", 0, 0)
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		logrus.Fatalf("Failed to create k8s clientset: %v", err)
+	}
+
+	state := deletion.SerializedState{
+		NodeStates: map[string]deletion.NodeState{},
+		Groups:     map[string]deletion.GroupSummary{},
+	}
+	for _, name := range deletion.StateConfigMapNames(opts.LockConfigMapName, opts.Shards) {
+		cmap, err := clientset.CoreV1().ConfigMaps(opts.Namespace).Get(context.Background(), name, meta_v1.GetOptions{})
+		if err != nil {
+			logrus.Fatalf("Error reading configmap %v/%v: %v", opts.Namespace, name, err)
+		}
+		raw, ok := cmap.Data[deletion.StateKey]
+		if !ok {
+			logrus.Fatalf("Configmap %v/%v has no saved state. Is the controller running?", opts.Namespace, name)
+		}
+		shardState := deletion.SerializedState{}
+		if err := json.Unmarshal([]byte(raw), &shardState); err != nil {
+			logrus.Fatalf("Error unmarshalling state in %v: %v", name, err)
+		}
+		mergeState(&state, shardState)
+	}
+
+	if err := printStatus(state); err != nil {
+		logrus.Fatalf("Error printing status: %v", err)
+	}
+}
+
+// mergeState adds the state of a shard to the state of the other shards. The update time is
+// the oldest, so a shard whose replica stopped saving stands out
+func mergeState(state *deletion.SerializedState, shard deletion.SerializedState) {
+	for name, node := range shard.NodeStates {
+		state.NodeStates[name] = node
+	}
+	for key, group := range shard.Groups {
+		state.Groups[key] = group
+	}
+	if state.UpdateTime.IsZero() || shard.UpdateTime.Before(state.UpdateTime) {
+		state.UpdateTime = shard.UpdateTime
+	}
+}
+
+func printStatus(state deletion.SerializedState) error {
+	if state.UpdateTime.IsZero() {
+		fmt.Println("State was saved by a controller too old to record group summaries")
+	} else {
+		fmt.Printf("State saved at %v (%v ago)\n\n", state.UpdateTime.Format(time.RFC3339), time.Since(state.UpdateTime).Round(time.Second))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tDESIRED\tNODES\tWANT DELETE\tDETACHED\tREADY TO DELETE\tDELETING\tPAUSED\tENABLED\tBLOCKED")
+
+	keys := []string{}
+	for key := range state.Groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		group := state.Groups[key]
+		name := group.Name
+		if name == "" {
+			name = "<none>"
+		}
+		desired := "-"
+		if group.DesiredSize != nil {
+			desired = fmt.Sprint(*group.DesiredSize)
+		}
+		total := 0
+		for _, n := range group.States {
+			total += n
+		}
+		blocked := []string{}
+		for blocker, n := range group.Blockers {
+			blocked = append(blocked, fmt.Sprintf("%v=%v", blocker, n))
+		}
+		sort.Strings(blocked)
+		if len(blocked) == 0 {
+			blocked = append(blocked, "-")
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", name, desired, total,
+			group.States[deletion.WantDelete], group.States[deletion.Detached], group.States[deletion.ReadyToDelete], group.States[deletion.Deleting],
+			group.Paused, group.DeletionEnabled, strings.Join(blocked, ","))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// Nodes an operator has acted on are worth calling out individually
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	names := []string{}
+	for name, node := range state.NodeStates {
+		if node.RequestedReason != "" || node.SnoozedUntil != nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	fmt.Println()
+	fmt.Fprintln(w, "NODE\tSTATE\tREQUESTED BY\tREASON\tSNOOZED UNTIL")
+	for _, name := range names {
+		node := state.NodeStates[name]
+		snoozed := "-"
+		if node.SnoozedUntil != nil {
+			snoozed = node.SnoozedUntil.Format(time.RFC3339)
+		}
+		requestedBy, reason := "-", "-"
+		if node.RequestedReason != "" {
+			requestedBy, reason = node.RequestedBy, node.RequestedReason
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", name, node.State, requestedBy, reason, snoozed)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/wish/nodereaper/pkg/admin"
+	"github.com/wish/nodereaper/pkg/deletion"
+)
+
+// nodereaperctl can be installed as `kubectl-nodereaper` somewhere on $PATH
+// to use it as a kubectl plugin, eg `kubectl nodereaper status`
+type ops struct {
+	Server string `long:"server" env:"NODEREAPER_SERVER" description:"Address of the nodereaper controller's admin API" default:"http://localhost:9656"`
+	Token  string `long:"token" env:"NODEREAPER_ADMIN_TOKEN" description:"Bearer token for the admin API" required:"yes"`
+	JSON   bool   `long:"json" description:"Print raw JSON responses instead of tables"`
+	Cert   string `long:"cert" env:"NODEREAPER_CLIENT_CERT" description:"Client certificate to present, if the controller requires one"`
+	Key    string `long:"key" env:"NODEREAPER_CLIENT_KEY" description:"Key of the client certificate"`
+	CA     string `long:"ca" env:"NODEREAPER_CA" description:"CA to verify the controller's certificate with, instead of the system's"`
+
+	Status        statusCommand        `command:"status" description:"Show the deletion state of every group, or of a single node"`
+	History       historyCommand       `command:"history" description:"Show the most recent deletions, with how long they took and their outcome"`
+	RequestDelete requestDeleteCommand `command:"request-delete" description:"Request that the controller safely delete a node"`
+	Snooze        snoozeCommand        `command:"snooze" description:"Stop the controller from deleting a node for a while"`
+	Reconcile     reconcileCommand     `command:"reconcile" description:"Evaluate a node right away, and show what the controller decided and what blocks it"`
+	PauseGroup    pauseGroupCommand    `command:"pause-group" description:"Stop deleting nodes in a group"`
+	ResumeGroup   resumeGroupCommand   `command:"resume-group" description:"Resume deleting nodes in a paused group"`
+	Config        configCommand        `command:"config" description:"Show the configuration in effect for every group, or a single group, and where each setting comes from"`
+}
+
+var opts = &ops{}
+
+func client() *admin.Client {
+	if opts.Cert == "" && opts.CA == "" {
+		return admin.NewClient(opts.Server, opts.Token)
+	}
+	tlsConfig, err := clientTLSConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return admin.NewTLSClient(opts.Server, opts.Token, tlsConfig)
+}
+
+func clientTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if opts.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if opts.CA != "" {
+		pem, err := ioutil.ReadFile(opts.CA)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CA: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA %v", opts.CA)
+		}
+	}
+	return tlsConfig, nil
+}
+
+type statusCommand struct {
+	Args struct {
+		Node string `positional-arg-name:"node"`
+	} `positional-args:"yes"`
+}
+
+func (c *statusCommand) Execute(args []string) error {
+	if c.Args.Node != "" {
+		node, err := client().Node(c.Args.Node)
+		if err != nil {
+			return err
+		}
+		if opts.JSON {
+			return printJSON(node)
+		}
+		w := newTable("NODE", "GROUP", "STATE", "REASON", "BLOCKERS", "AGE", "LAST TRANSITION")
+		printNodeRow(w, *node)
+		return w.Flush()
+	}
+
+	groups, err := client().Groups()
+	if err != nil {
+		return err
+	}
+	if opts.JSON {
+		return printJSON(groups)
+	}
+	w := newTable("GROUP", "DESIRED", "NODES", "WANT DELETE", "DETACHED", "READY TO DELETE", "DELETING", "PAUSED", "ENABLED")
+	for _, group := range groups {
+		desired := "-"
+		if group.DesiredSize != nil {
+			desired = fmt.Sprint(*group.DesiredSize)
+		}
+		counts := map[deletion.State]int{}
+		for _, node := range group.Nodes {
+			counts[node.State]++
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", displayGroup(group.Name), desired, len(group.Nodes),
+			counts[deletion.WantDelete], counts[deletion.Detached], counts[deletion.ReadyToDelete], counts[deletion.Deleting],
+			group.Paused, group.DeletionEnabled)
+	}
+	return w.Flush()
+}
+
+type historyCommand struct {
+	Since string `long:"since" description:"Only show deletions since this RFC 3339 time, date, or duration ago (e.g. 2021-03-02, 7d)"`
+	Until string `long:"until" description:"Only show deletions before this RFC 3339 time, date, or duration ago"`
+}
+
+func (c *historyCommand) Execute(args []string) error {
+	entries, err := client().History(c.Since, c.Until)
+	if err != nil {
+		return err
+	}
+	if opts.JSON {
+		return printJSON(entries)
+	}
+	w := newTable("TIME", "NODE", "GROUP", "REASON", "REQUESTED BY", "DURATION", "OUTCOME")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", e.Time.Format(time.RFC3339), e.Node, displayGroup(e.Group), orDash(string(e.Reason)), orDash(e.RequestedBy),
+			orDash(e.Duration), orDash(e.Outcome))
+	}
+	return w.Flush()
+}
+
+type requestDeleteCommand struct {
+	Reason    string `long:"reason" short:"r" description:"Why the node should be deleted" required:"yes"`
+	Requester string `long:"requester" env:"USER" description:"Who is requesting the deletion"`
+	Args      struct {
+		Node string `positional-arg-name:"node" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *requestDeleteCommand) Execute(args []string) error {
+	node, err := client().RequestDeletion(c.Args.Node, c.Reason, c.Requester)
+	if err != nil {
+		return err
+	}
+	return printNode(node)
+}
+
+type snoozeCommand struct {
+	For       string `long:"for" description:"How long to snooze the node for (e.g. 12h, 7d). 0 cancels an existing snooze" default:"24h"`
+	Requester string `long:"requester" env:"USER" description:"Who is snoozing the node"`
+	Args      struct {
+		Node string `positional-arg-name:"node" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *snoozeCommand) Execute(args []string) error {
+	node, err := client().SnoozeNode(c.Args.Node, c.For, c.Requester)
+	if err != nil {
+		return err
+	}
+	return printNode(node)
+}
+
+type reconcileCommand struct {
+	Args struct {
+		Node string `positional-arg-name:"node" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *reconcileCommand) Execute(args []string) error {
+	result, err := client().Reconcile(c.Args.Node)
+	if err != nil {
+		return err
+	}
+	if opts.JSON {
+		return printJSON(result)
+	}
+	blockers := []string{}
+	for _, b := range result.Blockers {
+		blockers = append(blockers, string(b))
+	}
+	state := string(result.State)
+	if result.PreviousState != result.State {
+		state = fmt.Sprintf("%v -> %v", result.PreviousState, result.State)
+	}
+	reason := string(result.Reason)
+	if result.Exclusion != "" {
+		reason = string(result.Exclusion)
+	}
+	w := newTable("NODE", "GROUP", "DECISION", "REASON", "STATE", "BLOCKERS")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", result.Node, displayGroup(result.Group), result.Decision, orDash(reason),
+		orDash(state), orDash(strings.Join(blockers, ",")))
+	return w.Flush()
+}
+
+type groupArgs struct {
+	Requester string `long:"requester" env:"USER" description:"Who is making the change"`
+	Args      struct {
+		Group string `positional-arg-name:"group" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+type pauseGroupCommand struct {
+	groupArgs
+}
+
+func (c *pauseGroupCommand) Execute(args []string) error {
+	group, err := client().SetGroupPaused(c.Args.Group, true, c.Requester)
+	if err != nil {
+		return err
+	}
+	return printGroup(group)
+}
+
+type resumeGroupCommand struct {
+	groupArgs
+}
+
+func (c *resumeGroupCommand) Execute(args []string) error {
+	group, err := client().SetGroupPaused(c.Args.Group, false, c.Requester)
+	if err != nil {
+		return err
+	}
+	return printGroup(group)
+}
+
+type configCommand struct {
+	Args struct {
+		Group string `positional-arg-name:"group"`
+	} `positional-args:"yes"`
+}
+
+func (c *configCommand) Execute(args []string) error {
+	configs := []deletion.GroupConfig{}
+	if c.Args.Group != "" {
+		config, err := client().GroupConfig(c.Args.Group)
+		if err != nil {
+			return err
+		}
+		configs = append(configs, *config)
+	} else {
+		var err error
+		if configs, err = client().Config(); err != nil {
+			return err
+		}
+	}
+	if opts.JSON {
+		return printJSON(configs)
+	}
+	w := newTable("GROUP", "SETTING", "VALUE", "SOURCE")
+	for _, config := range configs {
+		keys := []string{}
+		for key := range config.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			setting := config.Settings[key]
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", displayGroup(config.Name), key, orDash(setting.Value), setting.Source)
+		}
+	}
+	return w.Flush()
+}
+
+func printNode(node *deletion.NodeStatus) error {
+	if opts.JSON {
+		return printJSON(node)
+	}
+	w := newTable("NODE", "GROUP", "STATE", "REASON", "BLOCKERS", "AGE", "LAST TRANSITION")
+	printNodeRow(w, *node)
+	return w.Flush()
+}
+
+func printNodeRow(w *tabwriter.Writer, node deletion.NodeStatus) {
+	blockers := []string{}
+	for _, b := range node.Blockers {
+		blockers = append(blockers, string(b))
+	}
+	lastTransition := "-"
+	if node.LastTransitionTime != nil {
+		lastTransition = node.LastTransitionTime.Format(time.RFC3339)
+	}
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", node.Name, displayGroup(node.Group), node.State, orDash(string(node.Reason)),
+		orDash(strings.Join(blockers, ",")), time.Since(node.CreationTime).Round(time.Minute), lastTransition)
+}
+
+func printGroup(group *deletion.GroupStatus) error {
+	if opts.JSON {
+		return printJSON(group)
+	}
+	w := newTable("NODE", "GROUP", "STATE", "REASON", "BLOCKERS", "AGE", "LAST TRANSITION")
+	for _, node := range group.Nodes {
+		printNodeRow(w, node)
+	}
+	return w.Flush()
+}
+
+func newTable(headers ...string) *tabwriter.Writer {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	return w
+}
+
+func displayGroup(name string) string {
+	if name == "" {
+		return "<none>"
+	}
+	return name
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func main() {
+	parser := flags.NewParser(opts, flags.Default)
+	if _, err := parser.Parse(); err != nil {
+		// Parse() prints the error already, including errors returned by commands
+		os.Exit(1)
+	}
+}
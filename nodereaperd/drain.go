@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/deletion"
+	core_v1 "k8s.io/api/core/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// evictionRetryPeriod is how often evictions blocked by a PodDisruptionBudget, and pods that
+// haven't terminated yet, are checked again
+const evictionRetryPeriod = 5 * time.Second
+
+// evictPods evicts every pod on the node but DaemonSet and mirror pods through the Eviction API,
+// which respects PodDisruptionBudgets, and waits for them to terminate for at most timeout, or
+// indefinitely if it's 0. Pods keep their own termination grace period
+func evictPods(clientset kubernetes.Interface, nodeName string, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	podList, err := clientset.CoreV1().Pods(meta_v1.NamespaceAll).List(ctx, meta_v1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%v", nodeName),
+	})
+	if err != nil {
+		return fmt.Errorf("Error listing pods on node %v: %v", nodeName, err)
+	}
+	pods := []core_v1.Pod{}
+	for _, pod := range podList.Items {
+		if drainablePod(&pod) {
+			pods = append(pods, pod)
+		}
+	}
+
+	err = wait.PollUntilContextCancel(ctx, evictionRetryPeriod, true, func(ctx context.Context) (bool, error) {
+		remaining := pods[:0]
+		for _, pod := range pods {
+			gone, err := evictPod(ctx, clientset, &pod)
+			if err != nil {
+				return false, err
+			}
+			if !gone {
+				remaining = append(remaining, pod)
+			}
+		}
+		pods = remaining
+		if len(pods) > 0 {
+			logrus.Infof("Waiting for %v pods to be evicted from %v", len(pods), nodeName)
+		}
+		return len(pods) == 0, nil
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("%v pods were not evicted from node %v within %v", len(pods), nodeName, timeout)
+	}
+	return err
+}
+
+// drainablePod returns false for the pods a drain leaves on the node: DaemonSet pods, which the
+// DaemonSet controller would recreate there, and mirror pods, which go down with the node
+func drainablePod(pod *core_v1.Pod) bool {
+	if _, ok := pod.Annotations[deletion.MirrorPodAnnotation]; ok {
+		return false
+	}
+	if owner := meta_v1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
+
+// evictPod requests the pod's eviction unless it's already terminating, and returns true once it's gone.
+// An eviction a PodDisruptionBudget doesn't allow yet is retried on the next call
+func evictPod(ctx context.Context, clientset kubernetes.Interface, pod *core_v1.Pod) (bool, error) {
+	current, err := clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, meta_v1.GetOptions{})
+	if k8s_errors.IsNotFound(err) || (err == nil && current.UID != pod.UID) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error getting pod %v/%v: %v", pod.Namespace, pod.Name, err)
+	}
+	if current.DeletionTimestamp != nil {
+		return false, nil
+	}
+
+	err = clientset.CoreV1().Pods(pod.Namespace).EvictV1(ctx, &policy_v1.Eviction{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	})
+	switch {
+	case err == nil:
+		logrus.Infof("Evicted pod %v/%v", pod.Namespace, pod.Name)
+		return false, nil
+	case k8s_errors.IsNotFound(err):
+		return true, nil
+	case k8s_errors.IsTooManyRequests(err):
+		logrus.Debugf("Eviction of pod %v/%v blocked by a disruption budget", pod.Namespace, pod.Name)
+		return false, nil
+	default:
+		return false, fmt.Errorf("Error evicting pod %v/%v: %v", pod.Namespace, pod.Name, err)
+	}
+}
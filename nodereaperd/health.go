@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// The informer resyncs every 5 minutes, so a working watch delivers an event at least that often
+const watchStaleAfter = 15 * time.Minute
+
+// daemonStatus is what the health endpoints report about the node watch
+type daemonStatus struct {
+	mu        sync.Mutex
+	lastEvent time.Time
+	draining  bool
+}
+
+// statusResponse is served at /status
+type statusResponse struct {
+	Synced    bool      `json:"synced"`
+	LastEvent time.Time `json:"lastEvent"`
+	Draining  bool      `json:"draining"`
+}
+
+func newDaemonStatus() *daemonStatus {
+	// Count from startup until the first event arrives
+	return &daemonStatus{lastEvent: time.Now()}
+}
+
+func (s *daemonStatus) eventReceived() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastEvent = time.Now()
+}
+
+func (s *daemonStatus) setDraining(draining bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = draining
+}
+
+func (s *daemonStatus) get() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastEvent, s.draining
+}
+
+// checkWatch fails if no event arrived for watchStaleAfter. Events aren't delivered while the
+// node is being deleted, so that never fails it
+func (s *daemonStatus) checkWatch() error {
+	lastEvent, draining := s.get()
+	if !draining && time.Since(lastEvent) > watchStaleAfter {
+		return fmt.Errorf("no node event since %v", lastEvent.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// serveHealth serves /healthcheck for the liveness probe, /ready for the readiness probe,
+// and the status behind them at /status. Each check is also served on its own, e.g. at /ready/watch
+func serveHealth(opts *ops, synced func() bool, status *daemonStatus) *http.Server {
+	watch := func(*http.Request) error {
+		return status.checkWatch()
+	}
+	liveness := &healthz.Handler{Checks: map[string]healthz.Checker{"watch": watch}}
+	readiness := &healthz.Handler{Checks: map[string]healthz.Checker{
+		"informers": func(*http.Request) error {
+			if !synced() {
+				return fmt.Errorf("informer caches not synced")
+			}
+			return nil
+		},
+		"watch": watch,
+	}}
+
+	mux := http.NewServeMux()
+	for path, handler := range map[string]http.Handler{"/healthcheck": liveness, "/ready": readiness} {
+		mux.Handle(path, http.StripPrefix(path, handler))
+		mux.Handle(path+"/", http.StripPrefix(path, handler))
+	}
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		lastEvent, draining := status.get()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statusResponse{synced(), lastEvent, draining})
+	})
+	srv := &http.Server{
+		Addr:    opts.HealthBindAddress,
+		Handler: mux,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Error serving health checks at %v: %v", opts.HealthBindAddress, err)
+		}
+	}()
+	return srv
+}
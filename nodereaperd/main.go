@@ -1,49 +1,119 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/openshift/cluster-api/pkg/drain"
+	"github.com/wish/nodereaper/pkg/config"
 	"github.com/wish/nodereaper/pkg/controller"
+	"github.com/wish/nodereaper/pkg/deletion"
+	"github.com/wish/nodereaper/pkg/rebootlock"
 
 	flags "github.com/jessevdk/go-flags"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	ctrlcache "sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/sirupsen/logrus"
 
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+type ops struct {
+	NodeName             string        `long:"node-name" env:"NODE_NAME" description:"The name of the host node" required:"yes"`
+	LogLevel             string        `long:"log-level" env:"LOG_LEVEL" description:"Log level" default:"info"`
+	DeletionLabel        string        `long:"force-deletion-label" env:"FORCE_DELETION_LABEL" description:"Delete this node if it has this label, written as key or key=value"`
+	DryRun               bool          `long:"dry-run" env:"DRY_RUN" description:"Don't actually perform deletions if true"`
+	DrainTimeout         time.Duration `long:"drain-timeout" env:"DRAIN_TIMEOUT" description:"duration to wait for a drain to complete before retrying" default:"2m"`
+	Kubeconfig           string        `long:"kubeconfig" env:"KUBECONFIG" description:"Path to a kubeconfig, for running as a host service. Uses the in-cluster service account if unset"`
+	Master               string        `long:"master" env:"KUBERNETES_MASTER" description:"The address of the Kubernetes API server. Overrides any value in the kubeconfig"`
+	Context              string        `long:"context" env:"KUBE_CONTEXT" description:"The kubeconfig context to use. Defaults to the current context"`
+	KubeAPIQPS           float32       `long:"kube-api-qps" env:"KUBE_API_QPS" description:"Maximum sustained queries per second to the Kubernetes API" default:"5"`
+	KubeAPIBurst         int           `long:"kube-api-burst" env:"KUBE_API_BURST" description:"Maximum burst of queries to the Kubernetes API" default:"10"`
+	RebootLock           string        `long:"reboot-lock" env:"REBOOT_LOCK" description:"Take a cluster-wide lock before draining so only one node powers off at a time. One of: kured, configmap" choice:"kured" choice:"configmap"`
+	RebootLockNamespace  string        `long:"reboot-lock-namespace" env:"REBOOT_LOCK_NAMESPACE" description:"The namespace of the daemonset or configmap holding the reboot lock" default:"kube-system"`
+	RebootLockName       string        `long:"reboot-lock-name" env:"REBOOT_LOCK_NAME" description:"The name of the daemonset or configmap holding the reboot lock" default:"kured"`
+	RebootLockAnnotation string        `long:"reboot-lock-annotation" env:"REBOOT_LOCK_ANNOTATION" description:"The annotation the reboot lock is stored in" default:"weave.works/kured-node-lock"`
+	RebootLockTTL        time.Duration `long:"reboot-lock-ttl" env:"REBOOT_LOCK_TTL" description:"How long another node's reboot lock is respected before it's taken over. Never expires if 0. A lock held by a node that no longer exists is always taken over" default:"1h"`
+	VerifyDeletionToken  bool          `long:"verify-deletion-token" env:"VERIFY_DELETION_TOKEN" description:"Only act on a deletion label whose value is the token the controller recorded for the node in its state"`
+	Namespace            string        `long:"namespace" env:"NAMESPACE" description:"The namespace the controller resides in, with --verify-deletion-token"`
+	LockConfigMapName    string        `long:"lock-configmap-name" env:"LOCK_CONFIGMAP_NAME" description:"The name of the configmap the controller stores state in, with --verify-deletion-token" default:"nodereaper-locks"`
+	Shards               int           `long:"shards" env:"SHARDS" description:"The controller's --shards, to find the state of the node's shard with --verify-deletion-token" default:"1"`
+	DeletionHandshake    bool          `long:"deletion-handshake" env:"DELETION_HANDSHAKE" description:"Acknowledge the deletion label, and wait for the controller to confirm it before draining. The controller must have --deletion-handshake too"`
+	NsenterPath          string        `long:"nsenter-path" env:"NSENTER_PATH" description:"The nsenter binary used to run the shutdown command in the host's mount namespace. Runs it directly if empty" default:"/usr/bin/nsenter"`
+	ShutdownMethod       string        `long:"shutdown-method" env:"SHUTDOWN_METHOD" description:"How to power off the host. auto detects the init system" choice:"auto" choice:"systemd" choice:"openrc" choice:"poweroff" default:"auto"`
+	ShutdownCommand      string        `long:"shutdown-command" env:"SHUTDOWN_COMMAND" description:"A command that powers off the host, overriding --shutdown-method"`
+	RebootCommand        string        `long:"reboot-command" env:"REBOOT_COMMAND" description:"A command that reboots the host, overriding --shutdown-method"`
+	WaitForDaemonSets    string        `long:"wait-for-daemonsets" env:"WAIT_FOR_DAEMONSETS" description:"Comma separated namespace/name of the DaemonSets whose pods to wait for after the deletion taint. Waits for every terminating pod if empty"`
+	TerminationTimeout   time.Duration `long:"termination-timeout" env:"TERMINATION_TIMEOUT" description:"How long to wait for pods after the deletion taint before shutting down anyway. Waits indefinitely if 0" default:"0s"`
+	HealthBindAddress    string        `long:"health-bind-address" env:"HEALTH_BIND_ADDRESS" description:"The address to serve health checks at. Disabled if empty" default:":9657"`
+}
+
 const (
-	deletionTaintName = "NodereaperDeletingNode"
+	rebootLockRetryPeriod = 30 * time.Second
+	// The controller saves its state at the end of the poll in which it labeled the node,
+	// so a deletion token is looked for until it has had time to
+	tokenRetryPeriod = 5 * time.Second
+	tokenTimeout     = 2 * time.Minute
+	// The controller confirms an acknowledged deletion label within a poll
+	handshakeRetryPeriod = 2 * time.Second
+	handshakeTimeout     = 2 * time.Minute
+	// podSyncTimeout is how long the initial list of the node's pods may take while waiting for them to terminate
+	podSyncTimeout = 2 * time.Minute
+	// taintEvictionPeriod is how long pods not tolerating the deletion taint are expected to take to start terminating
+	taintEvictionPeriod = 10 * time.Second
+	// drainPhaseDraining and drainPhaseDrained are recorded in config.DrainPhaseAnnotation, followed by
+	// the value of the deletion label they are for
+	drainPhaseDraining = "draining"
+	drainPhaseDrained  = "drained"
+	// eventComponent is the source of the events nodereaperd records on its node
+	eventComponent = "nodereaperd"
+	eventTimeout   = 10 * time.Second
+	// drainPhaseRebooting is recorded before rebooting a node, along with its boot ID in config.RebootBootIDAnnotation
+	drainPhaseRebooting = "rebooting"
 )
 
-type ops struct {
-	NodeName      string        `long:"node-name" env:"NODE_NAME" description:"The name of the host node" required:"yes"`
-	LogLevel      string        `long:"log-level" env:"LOG_LEVEL" description:"Log level" default:"info"`
-	DeletionLabel string        `long:"force-deletion-label" env:"FORCE_DELETION_LABEL" description:"Delete this node if it has this label"`
-	DryRun        bool          `long:"dry-run" env:"DRY_RUN" description:"Don't actually perform deletions if true"`
-	DrainTimeout  time.Duration `long:"drain-timeout" env:"DRAIN_TIMEOUT" description:"duration to wait for a drain to complete before retrying" default:"2m"`
+// deletionIDHook adds the ID of the deletion nodereaperd is carrying out to every log entry,
+// so its logs can be found along with the controller's
+type deletionIDHook struct {
+	mu sync.Mutex
+	id string
 }
 
-type wrappedLogger struct {
-	logger *logrus.Logger
+var deletionIDLog = &deletionIDHook{}
+
+func (h *deletionIDHook) Levels() []logrus.Level {
+	return logrus.AllLevels
 }
 
-func (l *wrappedLogger) Log(v ...interface{}) {
-	l.logger.Info(v...)
+func (h *deletionIDHook) Fire(entry *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.id != "" {
+		entry.Data["deletion_id"] = h.id
+	}
+	return nil
 }
 
-func (l *wrappedLogger) Logf(format string, v ...interface{}) {
-	l.logger.Infof(format, v...)
+func (h *deletionIDHook) set(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.id = id
 }
 
 func setupLogging(logLevel string) {
@@ -59,14 +129,10 @@ func setupLogging(logLevel string) {
 		FullTimestamp: true,
 	}
 	logrus.SetFormatter(formatter)
+	logrus.AddHook(deletionIDLog)
 }
 
-func getClientset() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
-	}
-
+func getClientset(config *rest.Config) (*kubernetes.Clientset, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
@@ -75,52 +141,214 @@ func getClientset() (*kubernetes.Clientset, error) {
 }
 
 func shouldShutdown(opts *ops, node *core_v1.Node) bool {
-	logrus.Trace("Checking if shutdown is needed")
-
 	// Delete the node if it is labeled for deletion
-	if opts.DeletionLabel != "" {
-		for label := range node.Labels {
-			if label == opts.DeletionLabel {
-				logrus.Infof("Node %v has deletion label %v", node.Name, opts.DeletionLabel)
-				return true
+	return config.HasLabel(opts.DeletionLabel, node.Labels)
+}
+
+// tokenVerified returns true if the value of the node's deletion label is the token the controller
+// recorded for the node in its saved state
+func tokenVerified(opts *ops, clientset *kubernetes.Clientset, node *core_v1.Node) bool {
+	key, _, _ := config.SplitLabel(opts.DeletionLabel)
+	value := node.Labels[key]
+	err := wait.PollImmediate(tokenRetryPeriod, tokenTimeout, func() (bool, error) {
+		// We don't know the node's shard, so look for it in every shard's state
+		for _, name := range deletion.StateConfigMapNames(opts.LockConfigMapName, opts.Shards) {
+			cmap, err := clientset.CoreV1().ConfigMaps(opts.Namespace).Get(context.Background(), name, meta_v1.GetOptions{})
+			if err != nil {
+				logrus.Warnf("Error reading configmap %v/%v: %v", opts.Namespace, name, err)
+				continue
+			}
+			state := deletion.SerializedState{}
+			if err := json.Unmarshal([]byte(cmap.Data[deletion.StateKey]), &state); err != nil {
+				logrus.Warnf("Error unmarshalling the controller's state in %v: %v", name, err)
+				continue
+			}
+			if nodeState, ok := state.NodeStates[node.Name]; ok {
+				return value != "" && nodeState.DeletionToken == value, nil
 			}
 		}
+		return false, nil
+	})
+	if err != nil {
+		logrus.Warnf("Not deleting node %v, as its deletion label value '%v' isn't the token the controller recorded", node.Name, value)
+		return false
 	}
+	return true
+}
 
-	return false
+// deletionConfirmed acknowledges the node's deletion label, and returns true once the controller
+// confirms it still wants the node deleted. It gives up if the label changes or nothing is confirmed in time
+func deletionConfirmed(opts *ops, clientset *kubernetes.Clientset, node *core_v1.Node) bool {
+	key, _, _ := config.SplitLabel(opts.DeletionLabel)
+	value := node.Labels[key]
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{deletion.DeletionAckAnnotation: value},
+		},
+	})
+	if _, err := clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch, meta_v1.PatchOptions{}); err != nil {
+		logrus.Errorf("Error acknowledging the deletion label of node %v: %v", node.Name, err)
+		return false
+	}
+	logrus.Infof("Acknowledged deletion label %v=%v, waiting for the controller to confirm", key, value)
+
+	err := wait.PollImmediate(handshakeRetryPeriod, handshakeTimeout, func() (bool, error) {
+		current, err := clientset.CoreV1().Nodes().Get(context.Background(), node.Name, meta_v1.GetOptions{})
+		if err != nil {
+			logrus.Warnf("Error getting node %v: %v", node.Name, err)
+			return false, nil
+		}
+		if label, ok := current.Labels[key]; !ok || label != value {
+			return false, fmt.Errorf("The deletion label changed while waiting")
+		}
+		confirmed, ok := current.Annotations[deletion.DeletionConfirmAnnotation]
+		return ok && confirmed == value, nil
+	})
+	if err != nil {
+		logrus.Warnf("Not deleting node %v, as the controller didn't confirm its deletion label '%v': %v", node.Name, value, err)
+		return false
+	}
+	logrus.Infof("Controller confirmed the deletion of node %v", node.Name)
+	return true
+}
+
+// drainPhase returns the drain phase recorded on the node, if it's for its current deletion label.
+// A node that was uncordoned since is drained again
+func drainPhase(opts *ops, node *core_v1.Node) string {
+	key, _, _ := config.SplitLabel(opts.DeletionLabel)
+	parts := strings.SplitN(node.Annotations[config.DrainPhaseAnnotation], ":", 2)
+	if len(parts) != 2 || parts[1] != node.Labels[key] || !node.Spec.Unschedulable {
+		return ""
+	}
+	return parts[0]
+}
+
+func setDrainPhase(opts *ops, clientset *kubernetes.Clientset, node *core_v1.Node, phase string) error {
+	key, _, _ := config.SplitLabel(opts.DeletionLabel)
+	// Label values can't contain a colon
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{config.DrainPhaseAnnotation: phase + ":" + node.Labels[key]},
+		},
+	})
+	if _, err := clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch, meta_v1.PatchOptions{}); err != nil {
+		return fmt.Errorf("Error recording drain phase %v of node %v: %v", phase, node.Name, err)
+	}
+	return nil
 }
 
-func drainNode(opts *ops, clientset *kubernetes.Clientset) error {
+// recordEvent records an event on the node, so its events tell how far the deletion got
+func recordEvent(clientset *kubernetes.Clientset, node *core_v1.Node, eventType, reason, message string) {
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+	if err := controller.PostNodeEvent(ctx, clientset, node, node.Annotations[config.DeletionIDAnnotation], eventComponent, eventType, reason, message); err != nil {
+		logrus.Warnf("Could not record %v event for node %v: %v", reason, node.Name, err)
+	}
+}
+
+// cordonNode marks the node unschedulable, unless it already is
+func cordonNode(clientset *kubernetes.Clientset, node *core_v1.Node) error {
+	if node.Spec.Unschedulable {
+		return nil
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{"unschedulable": true},
+	})
+	if _, err := clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch, meta_v1.PatchOptions{}); err != nil {
+		return fmt.Errorf("Error cordoning node %v: %v", node.Name, err)
+	}
+	logrus.Infof("Cordoned node %v", node.Name)
+	return nil
+}
+
+// rebooted returns true if the node was rebooted for its current deletion label
+func rebooted(opts *ops, node *core_v1.Node) bool {
+	bootID, ok := node.Annotations[config.RebootBootIDAnnotation]
+	return drainPhase(opts, node) == drainPhaseRebooting && ok && bootID != node.Status.NodeInfo.BootID
+}
+
+// setRebooting records the drain phase and boot ID of a node that's about to reboot
+func setRebooting(opts *ops, clientset *kubernetes.Clientset, node *core_v1.Node) error {
+	key, _, _ := config.SplitLabel(opts.DeletionLabel)
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				config.DrainPhaseAnnotation:   drainPhaseRebooting + ":" + node.Labels[key],
+				config.RebootBootIDAnnotation: node.Status.NodeInfo.BootID,
+			},
+		},
+	})
+	if _, err := clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch, meta_v1.PatchOptions{}); err != nil {
+		return fmt.Errorf("Error recording the reboot of node %v: %v", node.Name, err)
+	}
+	return nil
+}
+
+// finishReboot uncordons a node that's back from its reboot, and removes the deletion label,
+// which tells the controller the node is back in service
+func finishReboot(opts *ops, clientset *kubernetes.Clientset, node *core_v1.Node) error {
+	key, _, _ := config.SplitLabel(opts.DeletionLabel)
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{key: nil},
+			"annotations": map[string]interface{}{
+				config.DrainPhaseAnnotation:   nil,
+				config.RebootBootIDAnnotation: nil,
+			},
+		},
+		"spec": map[string]interface{}{"unschedulable": false},
+	})
+	if _, err := clientset.CoreV1().Nodes().Patch(context.Background(), node.Name, types.MergePatchType, patch, meta_v1.PatchOptions{}); err != nil {
+		return fmt.Errorf("Error uncordoning node %v: %v", node.Name, err)
+	}
+	logrus.Infof("Node %v is back from its reboot and was uncordoned", node.Name)
+	return nil
+}
+
+// drainNode drains the node, and with evictDaemonSets applies the deletion taint and waits for the pods to terminate
+func drainNode(opts *ops, clientset *kubernetes.Clientset, pods ctrlcache.Cache, evictDaemonSets bool) error {
 	logrus.Infof("Attempting shutdown of node %v", opts.NodeName)
 
-	// Drain the node of non-daemonset pods
-	node, err := clientset.CoreV1().Nodes().Get(opts.NodeName, meta_v1.GetOptions{})
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), opts.NodeName, meta_v1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("Error fetching node %v for deletion: %v", opts.NodeName, err)
 	}
-	err = drain.Drain(clientset, []*core_v1.Node{
-		node,
-	}, &drain.DrainOptions{
-		Force:              true,
-		IgnoreDaemonsets:   true,
-		GracePeriodSeconds: -1, // set to negative to allow for default pod grace periods
-		Timeout:            opts.DrainTimeout,
-		DeleteLocalData:    true,
-		Logger:             &wrappedLogger{logrus.StandardLogger()},
-	})
-	if err != nil {
-		return fmt.Errorf("Error draining pods from node %v: %v", opts.NodeName, err)
+
+	// Drain the node of non-daemonset pods, unless that was done before nodereaperd restarted
+	switch drainPhase(opts, node) {
+	case drainPhaseDrained:
+		logrus.Infof("Node %v was drained before nodereaperd restarted, resuming with the deletion taint", node.Name)
+	case drainPhaseDraining:
+		logrus.Infof("Resuming the drain of node %v, which was interrupted by a nodereaperd restart", node.Name)
+		fallthrough
+	default:
+		if err := setDrainPhase(opts, clientset, node, drainPhaseDraining); err != nil {
+			return err
+		}
+		recordEvent(clientset, node, core_v1.EventTypeNormal, "DrainStarted", "Draining the node's pods")
+		if err := evictPods(clientset, node.Name, opts.DrainTimeout); err != nil {
+			return fmt.Errorf("Error draining pods from node %v: %v", opts.NodeName, err)
+		}
+		if err := setDrainPhase(opts, clientset, node, drainPhaseDrained); err != nil {
+			return err
+		}
+		recordEvent(clientset, node, core_v1.EventTypeNormal, "DrainCompleted", "Drained the node's pods")
+	}
+
+	// The DaemonSet pods of a node that's rebooting come back with it
+	if !evictDaemonSets {
+		return nil
 	}
 
 	// Add NoExecute taint to gracefully remove DaemonSet pods
-	node, err = clientset.CoreV1().Nodes().Get(opts.NodeName, meta_v1.GetOptions{})
+	node, err = clientset.CoreV1().Nodes().Get(context.Background(), opts.NodeName, meta_v1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("Error fetching node %v for deletion: %v", opts.NodeName, err)
 	}
 
 	alreadyHasDeletionTaint := false
 	for _, taint := range node.Spec.Taints {
-		if taint.Key == deletionTaintName {
+		if taint.Key == config.DeletionTaint {
 			alreadyHasDeletionTaint = true
 			break
 		}
@@ -128,18 +356,19 @@ func drainNode(opts *ops, clientset *kubernetes.Clientset) error {
 
 	if !alreadyHasDeletionTaint {
 		node.Spec.Taints = append(node.Spec.Taints, core_v1.Taint{
-			Key:    deletionTaintName,
+			Key:    config.DeletionTaint,
 			Value:  "true",
 			Effect: "NoExecute",
 		})
-		_, err := clientset.CoreV1().Nodes().Update(node)
+		_, err := clientset.CoreV1().Nodes().Update(context.Background(), node, meta_v1.UpdateOptions{})
 		if err != nil {
 			return fmt.Errorf("Error adding taint to node %v: %v", opts.NodeName, err)
 		}
 		logrus.Infof("Applied deletion taint to node %v", node.Name)
+		recordEvent(clientset, node, core_v1.EventTypeNormal, "DeletionTaintApplied", "Applied the "+config.DeletionTaint+" taint to terminate DaemonSet pods")
 	}
 
-	err = waitForPodTermination(clientset, node.Name)
+	err = waitForPodTermination(opts, clientset, pods, node.Name)
 	if err != nil {
 		return err
 	}
@@ -147,34 +376,147 @@ func drainNode(opts *ops, clientset *kubernetes.Clientset) error {
 	return nil
 }
 
-func waitForPodTermination(clientset *kubernetes.Clientset, nodeName string) error {
-	for {
-		time.Sleep(10 * time.Second)
-		podsOnNode, err := clientset.CoreV1().Pods("").List(meta_v1.ListOptions{
-			FieldSelector: fmt.Sprintf("spec.nodeName=%v", nodeName),
-		})
-		if err != nil {
-			return fmt.Errorf("Error waiting for node %v to drain: %v", nodeName, err)
+// recordStaticPods logs the node's static pods, and lists them in config.NonEvictablePodsAnnotation.
+// Their mirror pods can't be evicted or removed by the deletion taint, and go down with the node
+func recordStaticPods(clientset *kubernetes.Clientset, nodeName string) error {
+	podsOnNode, err := clientset.CoreV1().Pods("").List(context.Background(), meta_v1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%v", nodeName),
+	})
+	if err != nil {
+		return fmt.Errorf("Error listing pods on node %v: %v", nodeName, err)
+	}
+	static := []string{}
+	for _, pod := range podsOnNode.Items {
+		if _, ok := pod.Annotations[deletion.MirrorPodAnnotation]; ok {
+			static = append(static, pod.Namespace+"/"+pod.Name)
+		}
+	}
+	if len(static) == 0 {
+		return nil
+	}
+	logrus.Infof("Not waiting for static pods %v on node %v, which can't be evicted", strings.Join(static, ", "), nodeName)
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{config.NonEvictablePodsAnnotation: strings.Join(static, ",")},
+		},
+	})
+	if _, err := clientset.CoreV1().Nodes().Patch(context.Background(), nodeName, types.MergePatchType, patch, meta_v1.PatchOptions{}); err != nil {
+		return fmt.Errorf("Error annotating node %v with its static pods: %v", nodeName, err)
+	}
+	return nil
+}
+
+// waitForPodTermination waits until no pod on the node is terminating, or only for the pods of
+// --wait-for-daemonsets, for at most --termination-timeout. It watches the node's pods in pods rather
+// than listing them, so it reacts as soon as the last one is gone
+func waitForPodTermination(opts *ops, clientset *kubernetes.Clientset, pods ctrlcache.Cache, nodeName string) error {
+	if err := recordStaticPods(clientset, nodeName); err != nil {
+		logrus.Warn(err)
+	}
+
+	// The pods are only watched from the first drain on, and getting the informer waits for it to sync
+	syncCtx, cancelSync := context.WithTimeout(context.Background(), podSyncTimeout)
+	defer cancelSync()
+	informer, err := pods.GetInformer(syncCtx, &core_v1.Pod{})
+	if err != nil {
+		return fmt.Errorf("Error waiting for node %v to drain: could not list its pods within %v: %v", nodeName, podSyncTimeout, err)
+	}
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+	if err != nil {
+		return fmt.Errorf("Error watching pods of node %v: %v", nodeName, err)
+	}
+	defer informer.RemoveEventHandler(registration)
+
+	// Pods that don't tolerate the deletion taint are waited for too, until the taint manager has had time to evict them
+	evictionDeadline := time.Now().Add(taintEvictionPeriod)
+	evictionTimer := time.AfterFunc(taintEvictionPeriod, notify)
+	defer evictionTimer.Stop()
+	var timeout <-chan time.Time
+	if opts.TerminationTimeout > 0 {
+		timeoutTimer := time.NewTimer(opts.TerminationTimeout)
+		defer timeoutTimer.Stop()
+		timeout = timeoutTimer.C
+	}
+
+	daemonSets := map[string]bool{}
+	for _, daemonSet := range strings.Split(opts.WaitForDaemonSets, ",") {
+		if daemonSet = strings.TrimSpace(daemonSet); daemonSet != "" {
+			daemonSets[daemonSet] = true
 		}
+	}
 
+	lastTerminating := -1
+	for {
+		evicting := time.Now().Before(evictionDeadline)
 		numTerminatingPodsOnNode := 0
-		for _, pod := range podsOnNode.Items {
-			if pod.DeletionTimestamp != nil {
+		podList := &core_v1.PodList{}
+		if err := pods.List(context.Background(), podList, client.UnsafeDisableDeepCopy); err != nil {
+			return fmt.Errorf("Error listing pods of node %v: %v", nodeName, err)
+		}
+		for i := range podList.Items {
+			if waitForPod(&podList.Items[i], daemonSets, evicting) {
 				numTerminatingPodsOnNode++
 			}
 		}
 		if numTerminatingPodsOnNode == 0 {
 			break
-		} else {
+		}
+		if numTerminatingPodsOnNode != lastTerminating {
 			logrus.Infof("Still terminating %v pods on %v", numTerminatingPodsOnNode, nodeName)
+			lastTerminating = numTerminatingPodsOnNode
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			logrus.Warnf("%v pods on %v are still terminating after %v, shutting down anyway", numTerminatingPodsOnNode, nodeName, opts.TerminationTimeout)
+			return nil
 		}
 	}
 	logrus.Infof("Successfully drained all drainable pods from %v", nodeName)
 	return nil
 }
 
+// waitForPod returns true if the node waits for the pod to go before shutting down. With daemonSets,
+// that's only the pods of those DaemonSets, written as namespace/name, that haven't exited yet.
+// Otherwise it's every terminating pod, and those the deletion taint is about to evict
+func waitForPod(pod *core_v1.Pod, daemonSets map[string]bool, evicting bool) bool {
+	// The kubelet recreates mirror pods as long as their static pod runs
+	if _, ok := pod.Annotations[deletion.MirrorPodAnnotation]; ok {
+		return false
+	}
+	if len(daemonSets) > 0 {
+		owner := meta_v1.GetControllerOf(pod)
+		if owner == nil || owner.Kind != "DaemonSet" || !daemonSets[pod.Namespace+"/"+owner.Name] {
+			return false
+		}
+		return pod.Status.Phase != core_v1.PodSucceeded && pod.Status.Phase != core_v1.PodFailed
+	}
+	deletionTaint := &core_v1.Taint{Key: config.DeletionTaint, Value: "true", Effect: core_v1.TaintEffectNoExecute}
+	return pod.DeletionTimestamp != nil || (evicting && !toleratesTaint(pod, deletionTaint))
+}
+
+func toleratesTaint(pod *core_v1.Pod, taint *core_v1.Taint) bool {
+	for i := range pod.Spec.Tolerations {
+		if pod.Spec.Tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
 func deleteK8sNode(clientset *kubernetes.Clientset, nodeName string) error {
-	err := clientset.CoreV1().Nodes().Delete(nodeName, &meta_v1.DeleteOptions{})
+	err := clientset.CoreV1().Nodes().Delete(context.Background(), nodeName, meta_v1.DeleteOptions{})
 	if err != nil {
 		return err
 	}
@@ -182,43 +524,123 @@ func deleteK8sNode(clientset *kubernetes.Clientset, nodeName string) error {
 	return nil
 }
 
-func runShutdownCommand() error {
-	logrus.Info("Attempting shutdown of node")
-	cmd := exec.Command("/usr/bin/nsenter", "-m/proc/1/ns/mnt", "/bin/systemctl", "poweroff")
-	cmd.Stdout = logrus.NewEntry(logrus.StandardLogger()).WriterLevel(logrus.InfoLevel)
-	cmd.Stderr = logrus.NewEntry(logrus.StandardLogger()).WriterLevel(logrus.WarnLevel)
-	return cmd.Run()
+func newRebootLock(opts *ops, clientset *kubernetes.Clientset) *rebootlock.Lock {
+	switch opts.RebootLock {
+	case "kured":
+		return rebootlock.NewDaemonSetLock(clientset, opts.RebootLockNamespace, opts.RebootLockName, opts.RebootLockAnnotation, opts.NodeName, opts.RebootLockTTL)
+	case "configmap":
+		return rebootlock.NewConfigMapLock(clientset, opts.RebootLockNamespace, opts.RebootLockName, opts.RebootLockAnnotation, opts.NodeName, opts.RebootLockTTL)
+	}
+	return nil
 }
 
-func tryDelete(opts *ops, clientset *kubernetes.Clientset, node *core_v1.Node) bool {
-	if shouldShutdown(opts, node) {
-		if opts.DryRun {
-			logrus.Infof("Would delete node if --dry-run/DRY_RUN was not true")
-			return false
+// waitForRebootLock blocks until this node holds the reboot lock
+func waitForRebootLock(lock *rebootlock.Lock) {
+	for {
+		acquired, err := lock.Acquire()
+		if err != nil {
+			logrus.Errorf("Error acquiring reboot lock: %v", err)
+		} else if acquired {
+			logrus.Info("Acquired reboot lock")
+			return
+		} else {
+			logrus.Info("Reboot lock is held by another node, waiting")
 		}
+		time.Sleep(rebootLockRetryPeriod)
+	}
+}
 
-		err := drainNode(opts, clientset)
-		if err != nil {
-			logrus.Errorf("Error draining node: %v", err)
+func releaseRebootLock(lock *rebootlock.Lock) {
+	if lock == nil {
+		return
+	}
+	if err := lock.Release(); err != nil {
+		logrus.Errorf("Error releasing reboot lock: %v", err)
+	}
+}
+
+// tryDelete drains the node labeled for deletion and deletes or reboots it, and returns true once
+// it is on its way down. It finishes a reboot instead if the node is back from one. pods holds the node's pods
+func tryDelete(opts *ops, clientset *kubernetes.Clientset, pods ctrlcache.Cache, lock *rebootlock.Lock, node *core_v1.Node) bool {
+	deletionIDLog.set(node.Annotations[config.DeletionIDAnnotation])
+	reboot := node.Annotations[config.NodeActionAnnotation] == config.NodeActionReboot
+	if reboot && rebooted(opts, node) {
+		if err := finishReboot(opts, clientset, node); err != nil {
+			logrus.Errorf("Node was rebooted but could not be put back in service: %v", err)
+			recordEvent(clientset, node, core_v1.EventTypeWarning, "RebootFailed", err.Error())
 			return false
 		}
+		recordEvent(clientset, node, core_v1.EventTypeNormal, "Rebooted", "The node is back from its reboot and was uncordoned")
+		releaseRebootLock(lock)
+		return false
+	}
+	if opts.VerifyDeletionToken && !tokenVerified(opts, clientset, node) {
+		return false
+	}
+	if opts.DeletionHandshake && !opts.DryRun && !deletionConfirmed(opts, clientset, node) {
+		return false
+	}
+	if opts.DryRun {
+		logrus.Infof("Would delete node if --dry-run/DRY_RUN was not true")
+		return false
+	}
 
-		err = deleteK8sNode(clientset, opts.NodeName)
-		if err != nil {
-			logrus.Errorf("Node was drained successfully but could not be deleted from k8s: %v", err)
+	// Keep new pods off the node while waiting for the reboot lock and draining
+	if err := cordonNode(clientset, node); err != nil {
+		logrus.Warn(err)
+	}
+
+	if lock != nil {
+		waitForRebootLock(lock)
+	}
+
+	err := drainNode(opts, clientset, pods, !reboot)
+	if err != nil {
+		logrus.Errorf("Error draining node: %v", err)
+		recordEvent(clientset, node, core_v1.EventTypeWarning, "DrainFailed", err.Error())
+		releaseRebootLock(lock)
+		return false
+	}
+
+	// The reboot lock is held until the node is back up
+	if reboot {
+		if err := setRebooting(opts, clientset, node); err != nil {
+			logrus.Errorf("Node was drained successfully but could not be rebooted: %v", err)
+			recordEvent(clientset, node, core_v1.EventTypeWarning, "RebootFailed", err.Error())
+			releaseRebootLock(lock)
 			return false
 		}
-
-		err = runShutdownCommand()
-		if err != nil {
-			logrus.Errorf("Node was drained successfully but could not be shutdown: %v", err)
+		recordEvent(clientset, node, core_v1.EventTypeNormal, "RebootInitiated", "Rebooting the node")
+		if err := runShutdownCommand(opts, config.NodeActionReboot); err != nil {
+			logrus.Errorf("Node was drained successfully but could not be rebooted: %v", err)
+			recordEvent(clientset, node, core_v1.EventTypeWarning, "RebootFailed", err.Error())
 			return false
 		}
-
-		// If we got this far, prepare to be deleted
 		return true
 	}
-	return false
+
+	err = deleteK8sNode(clientset, opts.NodeName)
+	if err != nil {
+		logrus.Errorf("Node was drained successfully but could not be deleted from k8s: %v", err)
+		recordEvent(clientset, node, core_v1.EventTypeWarning, "NodeDeletionFailed", err.Error())
+		releaseRebootLock(lock)
+		return false
+	}
+
+	// The node is gone from the cluster, so the next one can start draining
+	// while this one powers off
+	releaseRebootLock(lock)
+
+	recordEvent(clientset, node, core_v1.EventTypeNormal, "ShutdownInitiated", "Deleted the node and powering it off")
+	err = runShutdownCommand(opts, config.NodeActionPowerOff)
+	if err != nil {
+		logrus.Errorf("Node was drained successfully but could not be shutdown: %v", err)
+		recordEvent(clientset, node, core_v1.EventTypeWarning, "ShutdownFailed", err.Error())
+		return false
+	}
+
+	// If we got this far, prepare to be deleted
+	return true
 }
 
 func main() {
@@ -235,34 +657,112 @@ func main() {
 	}
 	setupLogging(opts.LogLevel)
 
-	clientset, err := getClientset()
+	if opts.VerifyDeletionToken {
+		if opts.Namespace == "" {
+			logrus.Fatalf("--verify-deletion-token requires --namespace")
+		}
+		if _, _, hasValue := config.SplitLabel(opts.DeletionLabel); hasValue {
+			logrus.Fatalf("--verify-deletion-token requires a --force-deletion-label without a value, for the controller to set a token")
+		}
+	}
+
+	for _, daemonSet := range strings.Split(opts.WaitForDaemonSets, ",") {
+		if parts := strings.Split(strings.TrimSpace(daemonSet), "/"); daemonSet != "" && (len(parts) != 2 || parts[0] == "" || parts[1] == "") {
+			logrus.Fatalf("Invalid --wait-for-daemonsets item %v, expected namespace/name", daemonSet)
+		}
+	}
+
+	// Fail now rather than on a drained node, and show which init system was detected
+	command, err := shutdownCommand(opts, config.NodeActionPowerOff)
+	if err != nil {
+		logrus.Fatalf("Error choosing shutdown command: %v", err)
+	}
+	logrus.Infof("Nodes will be shut down with %v", strings.Join(command, " "))
+
+	var restConfig *rest.Config
+	err = controller.RetryStartup("loading kubernetes client config", func() (err error) {
+		restConfig, err = controller.RestConfig(opts.Kubeconfig, opts.Master, opts.Context)
+		return err
+	})
+	if err != nil {
+		logrus.Fatalf("Error loading kubernetes client config: %v", err)
+	}
+	controller.SetClientLimits(restConfig, "nodereaperd", opts.KubeAPIQPS, opts.KubeAPIBurst)
+	clientset, err := getClientset(restConfig)
 	if err != nil {
 		logrus.Fatalf("Failed to create k8s clientset: %v", err)
 	}
 
-	// Handle termination
-	stopCh := make(chan struct{})
-	defer close(stopCh)
+	lock := newRebootLock(opts, clientset)
+
+	// The manager's cache only holds our node, and its pods once a drain waits for them
+	mgr, err := controller.NewManager(restConfig, manager.Options{Cache: controller.NodeCacheOptions(opts.NodeName)})
+	if err != nil {
+		logrus.Fatalf("Error creating manager: %v", err)
+	}
+	synced, err := controller.TrackCacheSync(mgr)
+	if err != nil {
+		logrus.Fatalf("Error creating node watcher: %v", err)
+	}
 
+	status := newDaemonStatus()
 	isDeleted := false
+	isLabeled := false
 	isHandling := sync.Mutex{}
 	upFunc := func(node *core_v1.Node) {
+		status.eventReceived()
 		isHandling.Lock()
 		defer isHandling.Unlock()
-		if !isDeleted {
-			isDeleted = tryDelete(opts, clientset, node)
+		if isDeleted {
+			return
 		}
+		// Every update of the node is an event, so the decision is only logged when it changes
+		shutdown := shouldShutdown(opts, node)
+		if shutdown != isLabeled {
+			if shutdown {
+				logrus.Infof("Node %v has deletion label %v", node.Name, opts.DeletionLabel)
+			} else {
+				logrus.Infof("Node %v no longer has deletion label %v", node.Name, opts.DeletionLabel)
+			}
+			isLabeled = shutdown
+		} else {
+			logrus.Debugf("Node %v labeled for deletion: %v", node.Name, shutdown)
+		}
+		if !shutdown {
+			return
+		}
+		// No more events are handled until tryDelete returns, and none are needed once the node is deleted
+		status.setDraining(true)
+		isDeleted = tryDelete(opts, clientset, mgr.GetCache(), lock, node)
+		status.setDraining(isDeleted)
 	}
-	c, err := controller.NewController(&opts.NodeName, &upFunc)
+	// Every change of the node, and every resync of the cache, is reconciled
+	err = builder.ControllerManagedBy(mgr).
+		For(&core_v1.Node{}).
+		Complete(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+			node := &core_v1.Node{}
+			if err := mgr.GetClient().Get(ctx, req.NamespacedName, node); err != nil {
+				status.eventReceived()
+				return reconcile.Result{}, client.IgnoreNotFound(err)
+			}
+			upFunc(node)
+			return reconcile.Result{}, nil
+		}))
 	if err != nil {
 		logrus.Fatalf("Error creating node watcher: %v", err)
 	}
-	c.Run(stopCh)
-
-	sigterm := make(chan os.Signal, 1)
-	signal.Notify(sigterm, syscall.SIGTERM)
-	signal.Notify(sigterm, syscall.SIGINT)
-	<-sigterm
+	if opts.HealthBindAddress != "" {
+		healthSrv := serveHealth(opts, synced, status)
+		defer healthSrv.Shutdown(context.Background())
+	}
 
-	logrus.Infof("Received SIGTERM or SIGINT. Shutting down.")
+	// Handle termination
+	ctx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stopSignals()
+	context.AfterFunc(ctx, func() {
+		logrus.Infof("Received SIGTERM or SIGINT. Shutting down.")
+	})
+	if err := mgr.Start(ctx); err != nil {
+		logrus.Fatalf("Error watching node: %v", err)
+	}
 }
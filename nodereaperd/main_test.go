@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/deletion"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeAPIServer serves a single node, applying patches to it as merge patches, and no pods.
+// With confirm set, it confirms a deletion label as soon as it's acknowledged, like the controller
+type fakeAPIServer struct {
+	mu      sync.Mutex
+	node    map[string]interface{}
+	confirm bool
+	events  []string
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/v1/nodes/") && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(s.node)
+	case strings.HasPrefix(r.URL.Path, "/api/v1/nodes/") && r.Method == http.MethodPatch:
+		body, _ := ioutil.ReadAll(r.Body)
+		patch := map[string]interface{}{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mergePatch(s.node, patch)
+		annotations, _ := s.node["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+		if ack, ok := annotations[deletion.DeletionAckAnnotation]; ok && s.confirm {
+			annotations[deletion.DeletionConfirmAnnotation] = ack
+		}
+		json.NewEncoder(w).Encode(s.node)
+	case r.URL.Path == "/api/v1/pods":
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": "PodList", "apiVersion": "v1", "items": []interface{}{}})
+	case strings.HasSuffix(r.URL.Path, "/events") && r.Method == http.MethodPost:
+		event := core_v1.Event{}
+		json.NewDecoder(r.Body).Decode(&event)
+		s.events = append(s.events, event.Reason)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(event)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func mergePatch(dst, patch map[string]interface{}) {
+	for key, value := range patch {
+		switch v := value.(type) {
+		case nil:
+			delete(dst, key)
+		case map[string]interface{}:
+			m, ok := dst[key].(map[string]interface{})
+			if !ok {
+				m = map[string]interface{}{}
+				dst[key] = m
+			}
+			mergePatch(m, v)
+		default:
+			dst[key] = v
+		}
+	}
+}
+
+func (s *fakeAPIServer) getNode(t *testing.T) *core_v1.Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, _ := json.Marshal(s.node)
+	node := &core_v1.Node{}
+	if err := json.Unmarshal(data, node); err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func (s *fakeAPIServer) setNode(t *testing.T, node *core_v1.Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, _ := json.Marshal(node)
+	s.node = map[string]interface{}{}
+	if err := json.Unmarshal(data, &s.node); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRebootHandshake(t *testing.T) {
+	server := &fakeAPIServer{confirm: true}
+	server.setNode(t, &core_v1.Node{
+		TypeMeta: meta_v1.TypeMeta{Kind: "Node", APIVersion: "v1"},
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "node",
+			Labels:      map[string]string{"nodereaper/delete": "token"},
+			Annotations: map[string]string{config.NodeActionAnnotation: config.NodeActionReboot},
+		},
+		Status: core_v1.NodeStatus{NodeInfo: core_v1.NodeSystemInfo{BootID: "boot-1"}},
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	// The fake server only speaks JSON, not the protobuf the clientset prefers
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: httpServer.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &ops{
+		NodeName:          "node",
+		DeletionLabel:     "nodereaper/delete",
+		DeletionHandshake: true,
+		RebootCommand:     "true",
+	}
+
+	// The label is acknowledged and confirmed, then the node is drained without the deletion taint and rebooted
+	if !tryDelete(opts, clientset, nil, nil, server.getNode(t)) {
+		t.Fatalf("Expected the node to be rebooted, events %v", server.events)
+	}
+	node := server.getNode(t)
+	if node.Annotations[deletion.DeletionAckAnnotation] != "token" {
+		t.Errorf("Expected the deletion label to be acknowledged, got annotations %v", node.Annotations)
+	}
+	if node.Annotations[config.DrainPhaseAnnotation] != drainPhaseRebooting+":token" || node.Annotations[config.RebootBootIDAnnotation] != "boot-1" {
+		t.Errorf("Expected the reboot and boot ID to be recorded, got annotations %v", node.Annotations)
+	}
+	if !node.Spec.Unschedulable {
+		t.Errorf("Expected the node to stay cordoned during the reboot")
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == config.DeletionTaint {
+			t.Errorf("Expected a rebooted node not to get the deletion taint")
+		}
+	}
+
+	// The node is only back once it has a new boot ID
+	if rebooted(opts, node) {
+		t.Errorf("Expected the node not to be back from its reboot with the same boot ID")
+	}
+	node.Status.NodeInfo.BootID = "boot-2"
+	server.setNode(t, node)
+	if tryDelete(opts, clientset, nil, nil, server.getNode(t)) {
+		t.Errorf("Expected a node back from its reboot not to be deleted")
+	}
+	node = server.getNode(t)
+	if _, ok := node.Labels["nodereaper/delete"]; ok {
+		t.Errorf("Expected the deletion label to be removed, telling the controller the node is back")
+	}
+	if _, ok := node.Annotations[config.DrainPhaseAnnotation]; ok {
+		t.Errorf("Expected the drain phase to be cleared, got annotations %v", node.Annotations)
+	}
+	if node.Spec.Unschedulable {
+		t.Errorf("Expected the node to be uncordoned")
+	}
+	if len(server.events) == 0 || server.events[len(server.events)-1] != "Rebooted" {
+		t.Errorf("Expected a Rebooted event last, got %v", server.events)
+	}
+}
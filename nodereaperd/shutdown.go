@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/config"
+)
+
+const (
+	shutdownAuto     = "auto"
+	shutdownSystemd  = "systemd"
+	shutdownOpenRC   = "openrc"
+	shutdownPoweroff = "poweroff"
+)
+
+// shutdownPresets and rebootPresets are the commands that power off and reboot a host with each init system
+var (
+	shutdownPresets = map[string][]string{
+		shutdownSystemd:  {"systemctl", "poweroff"},
+		shutdownOpenRC:   {"openrc-shutdown", "--poweroff", "now"},
+		shutdownPoweroff: {"poweroff"},
+	}
+	rebootPresets = map[string][]string{
+		shutdownSystemd:  {"systemctl", "reboot"},
+		shutdownOpenRC:   {"openrc-shutdown", "--reboot", "now"},
+		shutdownPoweroff: {"reboot"},
+	}
+)
+
+// hostRoot is where the host's filesystem can be seen from. With nsenter, nodereaperd runs in
+// a container sharing the host's PID namespace, so it's the root of the host's init process
+func hostRoot(opts *ops) string {
+	if opts.NsenterPath == "" {
+		return "/"
+	}
+	return "/proc/1/root"
+}
+
+// detectShutdownMethod picks the preset for the host's init system, falling back to plain poweroff
+func detectShutdownMethod(root string) string {
+	// The same checks systemd's sd_booted() and OpenRC's rc-status make
+	if _, err := os.Stat(filepath.Join(root, "run/systemd/system")); err == nil {
+		return shutdownSystemd
+	}
+	if _, err := os.Stat(filepath.Join(root, "run/openrc")); err == nil {
+		return shutdownOpenRC
+	}
+	return shutdownPoweroff
+}
+
+// shutdownCommand returns the command that powers off the host, or reboots it with config.NodeActionReboot.
+// It's run through nsenter in the host's mount namespace unless --nsenter-path is empty
+func shutdownCommand(opts *ops, action string) ([]string, error) {
+	command, presets := strings.Fields(opts.ShutdownCommand), shutdownPresets
+	if action == config.NodeActionReboot {
+		command, presets = strings.Fields(opts.RebootCommand), rebootPresets
+	}
+	if len(command) == 0 {
+		method := opts.ShutdownMethod
+		if method == shutdownAuto {
+			method = detectShutdownMethod(hostRoot(opts))
+		}
+		preset, ok := presets[method]
+		if !ok {
+			return nil, fmt.Errorf("Unknown shutdown method %v", method)
+		}
+		command = preset
+	}
+	if opts.NsenterPath == "" {
+		return command, nil
+	}
+	return append([]string{opts.NsenterPath, "-m/proc/1/ns/mnt"}, command...), nil
+}
+
+func runShutdownCommand(opts *ops, action string) error {
+	command, err := shutdownCommand(opts, action)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Attempting %v of node with %v", action, strings.Join(command, " "))
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdout = logrus.NewEntry(logrus.StandardLogger()).WriterLevel(logrus.InfoLevel)
+	cmd.Stderr = logrus.NewEntry(logrus.StandardLogger()).WriterLevel(logrus.WarnLevel)
+	return cmd.Run()
+}
@@ -0,0 +1,397 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/deletion"
+)
+
+const (
+	// PathPrefix is the path under which every admin endpoint is served
+	PathPrefix = "/api/v1/"
+	// RoleHeader is set on every response to "leader", or "standby" for a replica that only
+	// reports the leader's persisted state and can't act on nodes
+	RoleHeader = "X-Nodereaper-Role"
+)
+
+// Deleter is the part of *deletion.Deleter the admin API serves
+type Deleter interface {
+	Standby() bool
+	Groups() []deletion.GroupStatus
+	Group(name string) *deletion.GroupStatus
+	Config() []deletion.GroupConfig
+	GroupConfig(name string) *deletion.GroupConfig
+	Node(name string) *deletion.NodeStatus
+	History() []deletion.HistoryEntry
+	RequestDeletion(ctx context.Context, nodeName, reason, requester string) error
+	SnoozeNode(ctx context.Context, nodeName string, duration time.Duration, requester string) error
+	Approve(ctx context.Context, nodeName string, approved bool, approver string) error
+	Reconcile(ctx context.Context, nodeName string) (*deletion.Reconciliation, error)
+	SetGroupPaused(ctx context.Context, groupName string, paused bool, requester string) error
+}
+
+// Server serves the admin API, which exposes and acts on the deleter's live state
+type Server struct {
+	deleter Deleter
+	token   string
+}
+
+// New creates an admin API server. Every request must carry the given token
+// as an "Authorization: Bearer" header
+func New(deleter Deleter, token string) *Server {
+	return &Server{
+		deleter,
+		token,
+	}
+}
+
+// ServeHTTP routes admin API requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticated(r) {
+		logrus.Warnf("Rejected unauthenticated admin request %v %v from %v", r.Method, r.URL.Path, r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if s.deleter.Standby() {
+		w.Header().Set(RoleHeader, "standby")
+	} else {
+		w.Header().Set(RoleHeader, "leader")
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, PathPrefix), "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "groups":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.deleter.Groups())
+	case len(parts) == 1 && parts[0] == "config":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, s.deleter.Config())
+	case len(parts) == 1 && parts[0] == "history":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		since, err := parseHistoryTime(r.URL.Query().Get("since"), time.Now())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since: "+err.Error())
+			return
+		}
+		until, err := parseHistoryTime(r.URL.Query().Get("until"), time.Now())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid until: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, deletion.FilterHistory(s.deleter.History(), since, until))
+	case len(parts) == 2 && parts[0] == "groups":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		group := s.deleter.Group(parts[1])
+		if group == nil {
+			writeError(w, http.StatusNotFound, "group "+parts[1]+" is not tracked by nodereaper")
+			return
+		}
+		writeJSON(w, http.StatusOK, group)
+	case len(parts) == 3 && parts[0] == "groups" && parts[2] == "config":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		c := s.deleter.GroupConfig(parts[1])
+		if c == nil {
+			writeError(w, http.StatusNotFound, "group "+parts[1]+" is not tracked by nodereaper")
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	case len(parts) == 3 && parts[0] == "groups" && (parts[2] == "pause" || parts[2] == "resume"):
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.setGroupPaused(w, r, parts[1], parts[2] == "pause")
+	case len(parts) == 2 && parts[0] == "nodes":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		node := s.deleter.Node(parts[1])
+		if node == nil {
+			writeError(w, http.StatusNotFound, "node "+parts[1]+" is not tracked by nodereaper")
+			return
+		}
+		writeJSON(w, http.StatusOK, node)
+	case len(parts) == 3 && parts[0] == "nodes" && parts[2] == "delete":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.requestDeletion(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "nodes" && parts[2] == "snooze":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.snoozeNode(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "nodes" && parts[2] == "reconcile":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.reconcileNode(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "nodes" && (parts[2] == "approve" || parts[2] == "deny"):
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.approveNode(w, r, parts[1], parts[2] == "approve")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// DeletionRequest is the body of a request to delete a node
+type DeletionRequest struct {
+	Reason    string `json:"reason"`
+	Requester string `json:"requester"`
+}
+
+func (s *Server) requestDeletion(w http.ResponseWriter, r *http.Request, nodeName string) {
+	req := DeletionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Reason == "" {
+		writeError(w, http.StatusBadRequest, "a reason is required")
+		return
+	}
+	if req.Requester == "" {
+		req.Requester = r.RemoteAddr
+	}
+
+	err := s.deleter.RequestDeletion(r.Context(), nodeName, req.Reason, req.Requester)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusAccepted, s.deleter.Node(nodeName))
+	case deletion.ErrStandby:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case deletion.ErrNodeNotTracked:
+		writeError(w, http.StatusNotFound, err.Error())
+	case deletion.ErrNodeIgnored, deletion.ErrAlreadyDeleting:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// SnoozeRequest is the body of a request to snooze a node
+type SnoozeRequest struct {
+	// Duration accepts the same values as config.ParseDuration. "0" cancels the snooze
+	Duration  string `json:"duration"`
+	Requester string `json:"requester"`
+}
+
+func (s *Server) snoozeNode(w http.ResponseWriter, r *http.Request, nodeName string) {
+	req := SnoozeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Duration == "" {
+		writeError(w, http.StatusBadRequest, "a duration is required")
+		return
+	}
+	duration, err := config.ParseDuration(req.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Requester == "" {
+		req.Requester = r.RemoteAddr
+	}
+
+	err = s.deleter.SnoozeNode(r.Context(), nodeName, duration, req.Requester)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, s.deleter.Node(nodeName))
+	case deletion.ErrStandby:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case deletion.ErrNodeNotTracked:
+		writeError(w, http.StatusNotFound, err.Error())
+	case deletion.ErrAlreadyDeleting:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// ApprovalAnswer is the body of a request to approve or deny a node's deletion
+type ApprovalAnswer struct {
+	Requester string `json:"requester"`
+}
+
+func (s *Server) approveNode(w http.ResponseWriter, r *http.Request, nodeName string, approved bool) {
+	req := ApprovalAnswer{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+	}
+	if req.Requester == "" {
+		req.Requester = r.RemoteAddr
+	}
+
+	err := s.deleter.Approve(r.Context(), nodeName, approved, req.Requester)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, s.deleter.Node(nodeName))
+	case deletion.ErrStandby:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case deletion.ErrNodeNotTracked:
+		writeError(w, http.StatusNotFound, err.Error())
+	case deletion.ErrNotAwaitingApproval:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (s *Server) reconcileNode(w http.ResponseWriter, r *http.Request, nodeName string) {
+	result, err := s.deleter.Reconcile(r.Context(), nodeName)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, result)
+	case deletion.ErrStandby:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case deletion.ErrNodeNotTracked:
+		writeError(w, http.StatusNotFound, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// PauseRequest is the body of a request to pause or resume a group
+type PauseRequest struct {
+	Requester string `json:"requester"`
+}
+
+func (s *Server) setGroupPaused(w http.ResponseWriter, r *http.Request, groupName string, paused bool) {
+	req := PauseRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+	}
+	if req.Requester == "" {
+		req.Requester = r.RemoteAddr
+	}
+
+	err := s.deleter.SetGroupPaused(r.Context(), groupName, paused, req.Requester)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, s.deleter.Group(groupName))
+	case deletion.ErrStandby:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	case deletion.ErrGroupNotTracked:
+		writeError(w, http.StatusNotFound, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (s *Server) authenticated(r *http.Request) bool {
+	return Authenticated(r, s.token)
+}
+
+// Authenticated returns whether the request carries the token as an "Authorization: Bearer" header,
+// for endpoints outside the admin API that are protected the same way
+func Authenticated(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	given := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// RequireClientCert wraps h to reject requests without a verified client certificate whose common name,
+// or one of whose DNS names, is allowed. Any verified certificate is accepted if allowed is empty
+func RequireClientCert(allowed []string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ClientAllowed(r.TLS, allowed) {
+			logrus.Warnf("Rejected admin request %v %v from %v without an allowed client certificate", r.Method, r.URL.Path, r.RemoteAddr)
+			writeError(w, http.StatusForbidden, "client certificate required")
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ClientAllowed reports whether a connection's verified client certificate is allowed, as RequireClientCert checks
+func ClientAllowed(state *tls.ConnectionState, allowed []string) bool {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return false
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	cert := state.VerifiedChains[0][0]
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		for _, a := range allowed {
+			if name != "" && name == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseHistoryTime parses an RFC 3339 time, a date, or a duration (e.g. 7d) before now.
+// An empty value is the zero time
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	d, err := config.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected an RFC 3339 time, a date or a duration, got %v", value)
+	}
+	return now.Add(-d), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.Errorf("Error writing admin response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,306 @@
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/deletion"
+)
+
+// fakeDeleter tracks a single node of a single group, and records who acted on them
+type fakeDeleter struct {
+	node       deletion.NodeStatus
+	group      deletion.GroupStatus
+	history    []deletion.HistoryEntry
+	standby    bool
+	requester  string
+	snoozedFor time.Duration
+}
+
+func (d *fakeDeleter) Standby() bool                    { return d.standby }
+func (d *fakeDeleter) History() []deletion.HistoryEntry { return d.history }
+
+func (d *fakeDeleter) Groups() []deletion.GroupStatus {
+	return []deletion.GroupStatus{*d.Group(d.group.Name)}
+}
+
+func (d *fakeDeleter) Group(name string) *deletion.GroupStatus {
+	if name != d.group.Name {
+		return nil
+	}
+	group := d.group
+	group.Nodes = []deletion.NodeStatus{d.node}
+	return &group
+}
+
+func (d *fakeDeleter) Config() []deletion.GroupConfig {
+	return []deletion.GroupConfig{*d.GroupConfig(d.group.Name)}
+}
+
+func (d *fakeDeleter) GroupConfig(name string) *deletion.GroupConfig {
+	if name != d.group.Name {
+		return nil
+	}
+	return &deletion.GroupConfig{Name: d.group.Name, Key: d.group.Key, MaxSurge: d.group.MaxSurge}
+}
+
+func (d *fakeDeleter) Node(name string) *deletion.NodeStatus {
+	if name != d.node.Name {
+		return nil
+	}
+	node := d.node
+	return &node
+}
+
+func (d *fakeDeleter) act(nodeName, requester string) error {
+	if d.standby {
+		return deletion.ErrStandby
+	}
+	if nodeName != d.node.Name {
+		return deletion.ErrNodeNotTracked
+	}
+	d.requester = requester
+	return nil
+}
+
+func (d *fakeDeleter) RequestDeletion(ctx context.Context, nodeName, reason, requester string) error {
+	if err := d.act(nodeName, requester); err != nil {
+		return err
+	}
+	if d.node.State != deletion.DontWantDelete {
+		return deletion.ErrAlreadyDeleting
+	}
+	d.node.State, d.node.RequestedReason, d.node.RequestedBy = deletion.WantDelete, reason, requester
+	return nil
+}
+
+func (d *fakeDeleter) SnoozeNode(ctx context.Context, nodeName string, duration time.Duration, requester string) error {
+	if err := d.act(nodeName, requester); err != nil {
+		return err
+	}
+	d.snoozedFor = duration
+	return nil
+}
+
+func (d *fakeDeleter) Approve(ctx context.Context, nodeName string, approved bool, approver string) error {
+	if err := d.act(nodeName, approver); err != nil {
+		return err
+	}
+	if d.node.State != deletion.WantDelete {
+		return deletion.ErrNotAwaitingApproval
+	}
+	if approved {
+		d.node.State = deletion.Detached
+	}
+	return nil
+}
+
+func (d *fakeDeleter) Reconcile(ctx context.Context, nodeName string) (*deletion.Reconciliation, error) {
+	if err := d.act(nodeName, ""); err != nil {
+		return nil, err
+	}
+	return &deletion.Reconciliation{Node: nodeName, Group: d.group.Name, Decision: deletion.DecisionKeep, State: d.node.State}, nil
+}
+
+func (d *fakeDeleter) SetGroupPaused(ctx context.Context, groupName string, paused bool, requester string) error {
+	if d.standby {
+		return deletion.ErrStandby
+	}
+	if groupName != d.group.Name {
+		return deletion.ErrGroupNotTracked
+	}
+	d.group.Paused, d.requester = paused, requester
+	return nil
+}
+
+func newTestDeleter() *fakeDeleter {
+	return &fakeDeleter{
+		node:    deletion.NodeStatus{Name: "node", Group: "group", State: deletion.DontWantDelete, Blockers: []deletion.Blocker{}},
+		group:   deletion.GroupStatus{Name: "group", Key: "___ig___group", MaxSurge: 1},
+		history: []deletion.HistoryEntry{{Node: "old", Group: "group", Time: time.Now().Add(-time.Hour)}},
+	}
+}
+
+// serve makes a request to the admin API of deleter, with a JSON body unless body is empty
+func serve(deleter Deleter, method, path, token, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	New(deleter, "secret").ServeHTTP(w, req)
+	return w
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		expected int
+		// response decodes the body, whose shape depends on the route
+		response interface{}
+	}{
+		{http.MethodGet, "/api/v1/groups", "", http.StatusOK, &[]deletion.GroupStatus{}},
+		{http.MethodGet, "/api/v1/groups/group", "", http.StatusOK, &deletion.GroupStatus{}},
+		{http.MethodGet, "/api/v1/groups/group/config", "", http.StatusOK, &deletion.GroupConfig{}},
+		{http.MethodGet, "/api/v1/config", "", http.StatusOK, &[]deletion.GroupConfig{}},
+		{http.MethodGet, "/api/v1/history", "", http.StatusOK, &[]deletion.HistoryEntry{}},
+		{http.MethodGet, "/api/v1/nodes/node", "", http.StatusOK, &deletion.NodeStatus{}},
+		{http.MethodPost, "/api/v1/nodes/node/delete", `{"reason": "bad disk", "requester": "ops"}`, http.StatusAccepted, &deletion.NodeStatus{}},
+		{http.MethodPost, "/api/v1/nodes/node/snooze", `{"duration": "2h"}`, http.StatusOK, &deletion.NodeStatus{}},
+		{http.MethodPost, "/api/v1/nodes/node/reconcile", "", http.StatusOK, &deletion.Reconciliation{}},
+		{http.MethodPost, "/api/v1/nodes/node/deny", "", http.StatusConflict, &map[string]string{}},
+		{http.MethodPost, "/api/v1/groups/group/pause", `{"requester": "ops"}`, http.StatusOK, &deletion.GroupStatus{}},
+		{http.MethodPost, "/api/v1/groups/group/resume", "", http.StatusOK, &deletion.GroupStatus{}},
+	}
+	for _, test := range tests {
+		w := serve(newTestDeleter(), test.method, test.path, "secret", test.body)
+		if w.Code != test.expected {
+			t.Errorf("%v %v: expected %v, got %v: %v", test.method, test.path, test.expected, w.Code, w.Body)
+			continue
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%v %v: expected a JSON response, got %v", test.method, test.path, contentType)
+		}
+		if role := w.Header().Get(RoleHeader); role != "leader" {
+			t.Errorf("%v %v: expected the leader role, got %q", test.method, test.path, role)
+		}
+		decoder := json.NewDecoder(w.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(test.response); err != nil {
+			t.Errorf("%v %v: unexpected response: %v", test.method, test.path, err)
+		}
+	}
+}
+
+func TestActions(t *testing.T) {
+	deleter := newTestDeleter()
+	w := serve(deleter, http.MethodPost, "/api/v1/nodes/node/delete", "secret", `{"reason": "bad disk", "requester": "ops"}`)
+	node := deletion.NodeStatus{}
+	json.NewDecoder(w.Body).Decode(&node)
+	if node.State != deletion.WantDelete || node.RequestedReason != "bad disk" || node.RequestedBy != "ops" {
+		t.Errorf("Expected the node to be requested for deletion by ops, got %+v", node)
+	}
+
+	// Without a requester, the caller's address is recorded
+	serve(deleter, http.MethodPost, "/api/v1/nodes/node/approve", "secret", "")
+	if deleter.node.State != deletion.Detached || deleter.requester != "192.0.2.1:1234" {
+		t.Errorf("Expected the node to be approved by the caller, got %v by %v", deleter.node.State, deleter.requester)
+	}
+
+	serve(deleter, http.MethodPost, "/api/v1/nodes/node/snooze", "secret", `{"duration": "2h"}`)
+	if deleter.snoozedFor != 2*time.Hour {
+		t.Errorf("Expected the node to be snoozed for 2h, got %v", deleter.snoozedFor)
+	}
+
+	w = serve(deleter, http.MethodPost, "/api/v1/groups/group/pause", "secret", `{"requester": "ops"}`)
+	group := deletion.GroupStatus{}
+	json.NewDecoder(w.Body).Decode(&group)
+	if !group.Paused || deleter.requester != "ops" {
+		t.Errorf("Expected the group to be paused by ops, got %+v by %v", group, deleter.requester)
+	}
+
+	w = serve(deleter, http.MethodGet, "/api/v1/history?since=30m", "secret", "")
+	history := []deletion.HistoryEntry{}
+	json.NewDecoder(w.Body).Decode(&history)
+	if len(history) != 0 {
+		t.Errorf("Expected the entry from an hour ago to be filtered out, got %v", history)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		body     string
+		standby  bool
+		expected int
+	}{
+		{"no token", http.MethodGet, "/api/v1/groups", "", "", false, http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/api/v1/groups", "wrong", "", false, http.StatusUnauthorized},
+		{"unknown route", http.MethodGet, "/api/v1/pods", "secret", "", false, http.StatusNotFound},
+		{"unknown group", http.MethodGet, "/api/v1/groups/other", "secret", "", false, http.StatusNotFound},
+		{"unknown group config", http.MethodGet, "/api/v1/groups/other/config", "secret", "", false, http.StatusNotFound},
+		{"pause unknown group", http.MethodPost, "/api/v1/groups/other/pause", "secret", "", false, http.StatusNotFound},
+		{"unknown node", http.MethodGet, "/api/v1/nodes/other", "secret", "", false, http.StatusNotFound},
+		{"delete unknown node", http.MethodPost, "/api/v1/nodes/other/delete", "secret", `{"reason": "bad disk"}`, false, http.StatusNotFound},
+		{"reconcile unknown node", http.MethodPost, "/api/v1/nodes/other/reconcile", "secret", "", false, http.StatusNotFound},
+		{"list groups with POST", http.MethodPost, "/api/v1/groups", "secret", "", false, http.StatusMethodNotAllowed},
+		{"get config with DELETE", http.MethodDelete, "/api/v1/config", "secret", "", false, http.StatusMethodNotAllowed},
+		{"get history with POST", http.MethodPost, "/api/v1/history", "secret", "", false, http.StatusMethodNotAllowed},
+		{"get node with POST", http.MethodPost, "/api/v1/nodes/node", "secret", "", false, http.StatusMethodNotAllowed},
+		{"delete with GET", http.MethodGet, "/api/v1/nodes/node/delete", "secret", "", false, http.StatusMethodNotAllowed},
+		{"snooze with GET", http.MethodGet, "/api/v1/nodes/node/snooze", "secret", "", false, http.StatusMethodNotAllowed},
+		{"reconcile with GET", http.MethodGet, "/api/v1/nodes/node/reconcile", "secret", "", false, http.StatusMethodNotAllowed},
+		{"approve with GET", http.MethodGet, "/api/v1/nodes/node/approve", "secret", "", false, http.StatusMethodNotAllowed},
+		{"pause with GET", http.MethodGet, "/api/v1/groups/group/pause", "secret", "", false, http.StatusMethodNotAllowed},
+		{"no reason", http.MethodPost, "/api/v1/nodes/node/delete", "secret", `{}`, false, http.StatusBadRequest},
+		{"invalid body", http.MethodPost, "/api/v1/nodes/node/delete", "secret", `reason`, false, http.StatusBadRequest},
+		{"invalid duration", http.MethodPost, "/api/v1/nodes/node/snooze", "secret", `{"duration": "soon"}`, false, http.StatusBadRequest},
+		{"invalid since", http.MethodGet, "/api/v1/history?since=soon", "secret", "", false, http.StatusBadRequest},
+		{"not awaiting approval", http.MethodPost, "/api/v1/nodes/node/approve", "secret", "", false, http.StatusConflict},
+		{"standby", http.MethodPost, "/api/v1/nodes/node/delete", "secret", `{"reason": "bad disk"}`, true, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		deleter := newTestDeleter()
+		deleter.standby = test.standby
+		w := serve(deleter, test.method, test.path, test.token, test.body)
+		if w.Code != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.name, test.expected, w.Code)
+		}
+		resp := map[string]string{}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp["error"] == "" {
+			t.Errorf("%v: expected an error message, got %v, %v", test.name, resp, err)
+		}
+	}
+
+	// A standby still serves reads, and says so
+	deleter := newTestDeleter()
+	deleter.standby = true
+	w := serve(deleter, http.MethodGet, "/api/v1/nodes/node", "secret", "")
+	node := deletion.NodeStatus{}
+	json.NewDecoder(w.Body).Decode(&node)
+	if w.Code != http.StatusOK || w.Header().Get(RoleHeader) != "standby" || !reflect.DeepEqual(node, deleter.node) {
+		t.Errorf("Expected the node from a standby, got %v %v %+v", w.Code, w.Header().Get(RoleHeader), node)
+	}
+}
+
+func TestClientAllowed(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}, DNSNames: []string{"ops.example.com"}}
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	tests := []struct {
+		state    *tls.ConnectionState
+		allowed  []string
+		expected bool
+	}{
+		{nil, nil, false},
+		{&tls.ConnectionState{}, nil, false},
+		{verified, nil, true},
+		{verified, []string{"ops"}, true},
+		{verified, []string{"deploy", "ops.example.com"}, true},
+		{verified, []string{"deploy"}, false},
+	}
+	for i, test := range tests {
+		if allowed := ClientAllowed(test.state, test.allowed); allowed != test.expected {
+			t.Errorf("Test %v: expected allowed %v, got %v", i, test.expected, allowed)
+		}
+	}
+}
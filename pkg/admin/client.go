@@ -0,0 +1,171 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/deletion"
+)
+
+// Client talks to the admin API of a running nodereaper controller
+type Client struct {
+	server     string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates an admin API client for the controller at server (e.g. http://localhost:9656)
+func NewClient(server, token string) *Client {
+	return &Client{
+		strings.TrimSuffix(server, "/"),
+		token,
+		&http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// NewTLSClient creates an admin API client for a controller serving HTTPS, presenting the
+// client certificate in tlsConfig if the controller requires one
+func NewTLSClient(server, token string, tlsConfig *tls.Config) *Client {
+	c := NewClient(server, token)
+	c.httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	return c
+}
+
+// Groups lists every group and its nodes
+func (c *Client) Groups() ([]deletion.GroupStatus, error) {
+	groups := []deletion.GroupStatus{}
+	err := c.do(http.MethodGet, "groups", nil, &groups)
+	return groups, err
+}
+
+// Group gets the state of a single group
+func (c *Client) Group(name string) (*deletion.GroupStatus, error) {
+	group := &deletion.GroupStatus{}
+	err := c.do(http.MethodGet, "groups/"+name, nil, group)
+	return group, err
+}
+
+// Config gets the configuration in effect for every group
+func (c *Client) Config() ([]deletion.GroupConfig, error) {
+	configs := []deletion.GroupConfig{}
+	err := c.do(http.MethodGet, "config", nil, &configs)
+	return configs, err
+}
+
+// GroupConfig gets the configuration in effect for a single group
+func (c *Client) GroupConfig(name string) (*deletion.GroupConfig, error) {
+	config := &deletion.GroupConfig{}
+	err := c.do(http.MethodGet, "groups/"+name+"/config", nil, config)
+	return config, err
+}
+
+// SetGroupPaused pauses or resumes deletion in a group
+func (c *Client) SetGroupPaused(name string, paused bool, requester string) (*deletion.GroupStatus, error) {
+	action := "resume"
+	if paused {
+		action = "pause"
+	}
+	group := &deletion.GroupStatus{}
+	err := c.do(http.MethodPost, "groups/"+name+"/"+action, &PauseRequest{
+		Requester: requester,
+	}, group)
+	return group, err
+}
+
+// Node gets the state of a single node
+func (c *Client) Node(name string) (*deletion.NodeStatus, error) {
+	node := &deletion.NodeStatus{}
+	err := c.do(http.MethodGet, "nodes/"+name, nil, node)
+	return node, err
+}
+
+// RequestDeletion asks the controller to delete the node
+func (c *Client) RequestDeletion(name, reason, requester string) (*deletion.NodeStatus, error) {
+	node := &deletion.NodeStatus{}
+	err := c.do(http.MethodPost, "nodes/"+name+"/delete", &DeletionRequest{
+		Reason:    reason,
+		Requester: requester,
+	}, node)
+	return node, err
+}
+
+// Reconcile asks the controller to evaluate the node right away, and returns its decision
+func (c *Client) Reconcile(name string) (*deletion.Reconciliation, error) {
+	result := &deletion.Reconciliation{}
+	err := c.do(http.MethodPost, "nodes/"+name+"/reconcile", nil, result)
+	return result, err
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.server+PathPrefix+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rsp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode >= 300 {
+		apiErr := map[string]string{}
+		if err := json.NewDecoder(rsp.Body).Decode(&apiErr); err != nil || apiErr["error"] == "" {
+			return fmt.Errorf("%v %v returned %v", method, path, rsp.Status)
+		}
+		return fmt.Errorf("%v %v returned %v: %v", method, path, rsp.Status, apiErr["error"])
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(rsp.Body).Decode(out)
+}
+
+// SnoozeNode holds a node in its current state for the given duration (e.g. "24h", "7d")
+func (c *Client) SnoozeNode(name, duration, requester string) (*deletion.NodeStatus, error) {
+	node := &deletion.NodeStatus{}
+	err := c.do(http.MethodPost, "nodes/"+name+"/snooze", &SnoozeRequest{
+		Duration:  duration,
+		Requester: requester,
+	}, node)
+	return node, err
+}
+
+// History lists the most recent deletions, oldest first. since and until can be RFC 3339 times,
+// dates or durations before now, and are left open if empty
+func (c *Client) History(since, until string) ([]deletion.HistoryEntry, error) {
+	query := url.Values{}
+	if since != "" {
+		query.Set("since", since)
+	}
+	if until != "" {
+		query.Set("until", until)
+	}
+	path := "history"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	entries := []deletion.HistoryEntry{}
+	err := c.do(http.MethodGet, path, nil, &entries)
+	return entries, err
+}
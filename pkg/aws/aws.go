@@ -2,6 +2,7 @@ package aws
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -9,14 +10,23 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/sirupsen/logrus"
 	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/metrics"
+	"github.com/wish/nodereaper/pkg/ratelog"
 	core_v1 "k8s.io/api/core/v1"
 )
 
+const (
+	// refreshRetryPeriod is how long to wait before refreshing the same missing group or instance again
+	refreshRetryPeriod = time.Minute
+)
+
 // APIProvider handles AWS specific logic
 type APIProvider struct {
 	client                    *autoscaling.AutoScaling
@@ -24,23 +34,45 @@ type APIProvider struct {
 	filters                   map[string]string
 	nameTag                   string
 	cacheMu                   *sync.Mutex
+	syncMu                    *sync.Mutex
 	asgCache                  []*asg
 	nodeInstanceConfiguration map[string]*string
 	pollPeriod                time.Duration
+	lastSync                  time.Time
+	// lastRefresh is when each missing group or instance was last refreshed on demand. Guarded by cacheMu
+	lastRefresh map[string]time.Time
+	// impairedSince is when each instance failing its system or instance status checks became impaired,
+	// by instance ID. Guarded by cacheMu
+	impairedSince map[string]time.Time
+	metrics       *metrics.Reporter
 }
 
-// NewAPIProvider creates an AWS api instance
-func NewAPIProvider(pollPeriod time.Duration, filters map[string]string, nameTag string) (*APIProvider, error) {
+// NewAPIProvider creates an AWS api instance. Every API call is recorded in reporter
+func NewAPIProvider(pollPeriod time.Duration, filters map[string]string, nameTag string, reporter *metrics.Reporter) (*APIProvider, error) {
 	sess := session.Must(session.NewSession())
+	sess.Handlers.Complete.PushBack(func(r *request.Request) {
+		code := ""
+		if r.Error != nil {
+			code = "Unknown"
+			if awsErr, ok := r.Error.(awserr.Error); ok {
+				code = awsErr.Code()
+			}
+		}
+		reporter.RecordProviderCall(metrics.ProviderCall{Service: r.ClientInfo.ServiceName, Operation: r.Operation.Name}, time.Since(r.Time), code)
+	})
 	provider := &APIProvider{
 		client:                    autoscaling.New(sess),
 		ec2Client:                 ec2.New(sess),
 		filters:                   filters,
 		nameTag:                   nameTag,
 		cacheMu:                   &sync.Mutex{},
+		syncMu:                    &sync.Mutex{},
 		asgCache:                  make([]*asg, 0),
 		nodeInstanceConfiguration: make(map[string]*string),
 		pollPeriod:                pollPeriod,
+		lastRefresh:               make(map[string]time.Time),
+		impairedSince:             make(map[string]time.Time),
+		metrics:                   reporter,
 	}
 	return provider, nil
 }
@@ -53,60 +85,301 @@ func (d *APIProvider) Run(stopCh <-chan struct{}) {
 	}, d.pollPeriod, stopCh)
 }
 
-// Sync queries the AWS API to fetch the asgs and instances in the cluster
+// SetAsgFilter changes the tags an ASG must have to be considered, and the tag naming its group.
+// The cache is synced again right away if either changed
+func (d *APIProvider) SetAsgFilter(filters map[string]string, nameTag string) {
+	d.cacheMu.Lock()
+	changed := !reflect.DeepEqual(filters, d.filters) || nameTag != d.nameTag
+	d.filters, d.nameTag = filters, nameTag
+	d.cacheMu.Unlock()
+	if changed {
+		logrus.Infof("ASG filter changed to %v with name tag '%v'. Syncing AWS cache", filters, nameTag)
+		go d.sync()
+	}
+}
+
+// asgFilter returns the current filters and name tag
+func (d *APIProvider) asgFilter() (map[string]string, string) {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	return d.filters, d.nameTag
+}
+
+// Sync queries the AWS API to fetch the asgs and instances in the cluster.
+// Syncs are serialized by syncMu, so a sync with an outdated filter can't finish last
 func (d *APIProvider) sync() {
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
 	logrus.Tracef("Syncing AWS cache")
-	newAsgs, err := getAsgs(d.client, d.ec2Client, d.filters, d.nameTag)
+	filters, nameTag := d.asgFilter()
+	newAsgs, err := getAsgs(d.client, d.ec2Client, filters, nameTag)
 	if err != nil {
-		logrus.Errorf("Could not update AWS ASG cache: %v", err)
+		ratelog.Errorf("aws-asg-cache", "Could not update AWS ASG cache: %v", err)
 		return
 	}
+	impaired, err := getImpairedInstances(d.ec2Client)
+	if err != nil {
+		ratelog.Errorf("aws-instance-status-cache", "Could not update AWS instance status cache: %v", err)
+	}
 	d.cacheMu.Lock()
 	d.asgCache = newAsgs
 
 	for _, asg := range newAsgs {
-		for _, instance := range asg.Instances {
-			if instance.InstanceId != nil {
-				if instance.LaunchConfigurationName != nil {
-					d.nodeInstanceConfiguration[*instance.InstanceId] = instance.LaunchConfigurationName
-				} else if instance.LaunchTemplate != nil {
-					launchTemplate := fmt.Sprintf("%v-%v", *instance.LaunchTemplate.LaunchTemplateId, *instance.LaunchTemplate.Version)
-					d.nodeInstanceConfiguration[*instance.InstanceId] = &launchTemplate
+		d.cacheInstances(asg)
+	}
+
+	detachedInstances := getDetachedInstances(d.ec2Client, filters)
+	for _, detachedInstance := range detachedInstances {
+		//Delete all detached instances
+		d.nodeInstanceConfiguration[*detachedInstance.InstanceId] = nil
+	}
+
+	if err == nil {
+		for id, since := range impaired {
+			// Keep the first time an impairment without a start time was seen
+			if since.IsZero() {
+				if last, ok := d.impairedSince[id]; ok {
+					since = last
 				} else {
-					// In this case, the launch config/template is so old it literally doesn't exist
-					// so we know that it's outdated
-					d.nodeInstanceConfiguration[*instance.InstanceId] = nil
+					since = time.Now()
 				}
 			}
+			impaired[id] = since
 		}
+		d.impairedSince = impaired
 	}
 
-	detachedInstances := getDetachedInstances(d.ec2Client, d.filters)
-	for _, detachedInstance := range detachedInstances {
-		//Delete all detached instances
-		d.nodeInstanceConfiguration[*detachedInstance.InstanceId] = nil
+	for key, last := range d.lastRefresh {
+		if time.Since(last) >= refreshRetryPeriod {
+			delete(d.lastRefresh, key)
+		}
 	}
 
+	d.lastSync = time.Now()
+	d.metrics.SetProviderCacheSync(d.lastSync)
 	d.cacheMu.Unlock()
 	logrus.Tracef("Finished syncing AWS cache")
 }
 
+// cacheInstances records the launch configuration of every instance in the ASG. Callers must hold cacheMu
+func (d *APIProvider) cacheInstances(asg *asg) {
+	for _, instance := range asg.Instances {
+		if instance.InstanceId != nil {
+			if instance.LaunchConfigurationName != nil {
+				d.nodeInstanceConfiguration[*instance.InstanceId] = instance.LaunchConfigurationName
+			} else if instance.LaunchTemplate != nil {
+				launchTemplate := fmt.Sprintf("%v-%v", *instance.LaunchTemplate.LaunchTemplateId, *instance.LaunchTemplate.Version)
+				d.nodeInstanceConfiguration[*instance.InstanceId] = &launchTemplate
+			} else {
+				// In this case, the launch config/template is so old it literally doesn't exist
+				// so we know that it's outdated
+				d.nodeInstanceConfiguration[*instance.InstanceId] = nil
+			}
+		}
+	}
+}
+
+// refreshAllowed returns true, and records the attempt, if key wasn't refreshed on demand
+// within refreshRetryPeriod. Callers must hold cacheMu
+func (d *APIProvider) refreshAllowed(key string) bool {
+	if last, ok := d.lastRefresh[key]; ok && time.Since(last) < refreshRetryPeriod {
+		return false
+	}
+	d.lastRefresh[key] = time.Now()
+	return true
+}
+
+// findGroup returns the cached ASG with the given name, or nil. Callers must hold cacheMu
+func (d *APIProvider) findGroup(groupName string) *asg {
+	for _, group := range d.asgCache {
+		if group.Name == groupName {
+			return group
+		}
+	}
+	return nil
+}
+
+// refreshGroup fetches a group missing from the cache, instead of waiting for the next sync.
+// Returns true if the cache was updated
+func (d *APIProvider) refreshGroup(groupName string) bool {
+	d.cacheMu.Lock()
+	allowed := d.findGroup(groupName) == nil && d.refreshAllowed("group/"+groupName)
+	d.cacheMu.Unlock()
+	if !allowed {
+		return false
+	}
+
+	_, nameTag := d.asgFilter()
+	asgNames := []*string{aws.String(groupName)}
+	if nameTag != "" {
+		// The group is named by a tag, so look up which ASGs have it
+		asgNames = []*string{}
+		err := d.client.DescribeTagsPages(&autoscaling.DescribeTagsInput{
+			Filters: []*autoscaling.Filter{
+				{Name: aws.String("key"), Values: []*string{aws.String(nameTag)}},
+				{Name: aws.String("value"), Values: []*string{aws.String(groupName)}},
+			},
+		}, func(page *autoscaling.DescribeTagsOutput, lastPage bool) bool {
+			for _, tag := range page.Tags {
+				asgNames = append(asgNames, tag.ResourceId)
+			}
+			return true
+		})
+		if err != nil {
+			ratelog.Warnf("aws-group-asg/"+groupName, "Could not look up the ASG of group %v: %v", groupName, err)
+			return false
+		}
+		if len(asgNames) == 0 {
+			return false
+		}
+	}
+	return d.refreshAsgs(asgNames)
+}
+
+// refreshInstance fetches the ASG of an instance missing from the cache, instead of waiting for the next sync.
+// Returns true if the cache was updated
+func (d *APIProvider) refreshInstance(instanceID string) bool {
+	d.cacheMu.Lock()
+	_, cached := d.nodeInstanceConfiguration[instanceID]
+	allowed := !cached && d.refreshAllowed("instance/"+instanceID)
+	d.cacheMu.Unlock()
+	if !allowed {
+		return false
+	}
+
+	out, err := d.client.DescribeAutoScalingInstances(&autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		ratelog.Warnf("aws-instance-asg/"+instanceID, "Could not look up the ASG of instance %v: %v", instanceID, err)
+		return false
+	}
+	asgNames := []*string{}
+	for _, instance := range out.AutoScalingInstances {
+		asgNames = append(asgNames, instance.AutoScalingGroupName)
+	}
+	if len(asgNames) == 0 {
+		return false
+	}
+	return d.refreshAsgs(asgNames)
+}
+
+// refreshAsgs fetches the named ASGs and adds them to the cache, replacing any cached copies.
+// ASGs that don't match the filters are left out, as in a full sync
+func (d *APIProvider) refreshAsgs(asgNames []*string) bool {
+	filters, nameTag := d.asgFilter()
+	asgs, err := describeAsgs(d.client, d.ec2Client, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: asgNames,
+	}, filters, nameTag)
+	if err != nil {
+		logrus.Warnf("Could not refresh ASGs %v: %v", aws.StringValueSlice(asgNames), err)
+		return false
+	}
+
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	for _, a := range asgs {
+		replaced := false
+		for i, cached := range d.asgCache {
+			if *cached.AutoScalingGroupName == *a.AutoScalingGroupName {
+				d.asgCache[i] = a
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			d.asgCache = append(d.asgCache, a)
+		}
+		d.cacheInstances(a)
+		logrus.Debugf("Refreshed ASG %v on demand", *a.AutoScalingGroupName)
+	}
+	return len(asgs) > 0
+}
+
+// LastSync returns when the ASG cache was last successfully updated
+func (d *APIProvider) LastSync() time.Time {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	return d.lastSync
+}
+
+// CachedGroup summarizes an ASG in the cache
+type CachedGroup struct {
+	Name             string `json:"name"`
+	AutoScalingGroup string `json:"autoScalingGroup"`
+	DesiredCapacity  int64  `json:"desiredCapacity"`
+	Instances        int    `json:"instances"`
+}
+
+// CacheSummary summarizes what the ASG cache holds, for debugging
+type CacheSummary struct {
+	LastSync          time.Time     `json:"lastSync"`
+	Groups            []CachedGroup `json:"groups"`
+	Instances         int           `json:"instances"`
+	ImpairedInstances int           `json:"impairedInstances"`
+}
+
+// CacheSummary returns a summary of the ASG cache
+func (d *APIProvider) CacheSummary() CacheSummary {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	summary := CacheSummary{
+		LastSync:          d.lastSync,
+		Groups:            []CachedGroup{},
+		Instances:         len(d.nodeInstanceConfiguration),
+		ImpairedInstances: len(d.impairedSince),
+	}
+	for _, a := range d.asgCache {
+		group := CachedGroup{Name: a.Name, Instances: len(a.Instances)}
+		if a.AutoScalingGroupName != nil {
+			group.AutoScalingGroup = *a.AutoScalingGroupName
+		}
+		if a.DesiredCapacity != nil {
+			group.DesiredCapacity = *a.DesiredCapacity
+		}
+		summary.Groups = append(summary.Groups, group)
+	}
+	return summary
+}
+
 // DesiredGroupSize returns the size that the instanceGroup (ASG in AWS) should be.
 // The deletion controller shouldn't delete a node whose instanceGroup is already depleted
+// A group missing from the cache is refreshed on demand
 func (d *APIProvider) DesiredGroupSize(groupName string) (int, error) {
+	d.refreshGroup(groupName)
+
 	d.cacheMu.Lock()
 	defer d.cacheMu.Unlock()
-	for _, group := range d.asgCache {
-		if group.Name == groupName {
-			return int(*group.DesiredCapacity), nil
-		}
+	if group := d.findGroup(groupName); group != nil {
+		return int(*group.DesiredCapacity), nil
 	}
 
 	return 0, fmt.Errorf("Could not find ASG with name %v", groupName)
 }
 
-// OutdatedLaunchConfig checks if a node has become outdated compared to the ASG configuration
+// GroupExists returns true if there is an ASG with the given name. A group missing from the
+// cache is refreshed on demand first
+func (d *APIProvider) GroupExists(groupName string) (bool, error) {
+	d.refreshGroup(groupName)
+
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	if d.lastSync.IsZero() {
+		return false, fmt.Errorf("The ASG cache was never synced")
+	}
+	return d.findGroup(groupName) != nil, nil
+}
+
+// OutdatedLaunchConfig checks if a node has become outdated compared to the ASG configuration.
+// A group or instance missing from the cache is refreshed on demand
 func (d *APIProvider) OutdatedLaunchConfig(opts *config.Ops, node *core_v1.Node) (bool, error) {
+	if groupName := node.Labels[opts.InstanceGroupLabel]; groupName != "" {
+		d.refreshGroup(groupName)
+		if instanceID, err := nodeInstanceID(node); err == nil {
+			d.refreshInstance(instanceID)
+		}
+	}
+
 	d.cacheMu.Lock()
 	defer d.cacheMu.Unlock()
 
@@ -143,8 +416,21 @@ func (d *APIProvider) OutdatedLaunchConfig(opts *config.Ops, node *core_v1.Node)
 	return false, nil
 }
 
-// PreDrain removes the node from its ASG
-// and sets the delete behavior to terminate, instead of stop
+// InstanceImpaired returns when the node's instance started failing its EC2 system or instance
+// status checks, as of the last sync. It returns false if the instance passes them
+func (d *APIProvider) InstanceImpaired(node *core_v1.Node) (time.Time, bool) {
+	instanceID, err := nodeInstanceID(node)
+	if err != nil {
+		return time.Time{}, false
+	}
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	since, ok := d.impairedSince[instanceID]
+	return since, ok
+}
+
+// PreDrain sets the delete behavior to terminate, instead of stop.
+// It doesn't need the node's ASG, which may no longer exist
 func (d *APIProvider) PreDrain(opts *config.Ops, node *core_v1.Node) error {
 	// Get the node instance ID
 	id, err := nodeInstanceID(node)
@@ -152,18 +438,6 @@ func (d *APIProvider) PreDrain(opts *config.Ops, node *core_v1.Node) error {
 		return fmt.Errorf("Could not get instance-id for node %v: %v", node.Name, err)
 	}
 
-	// Find the asg of the node
-	var nodeGroup *asg
-	for _, group := range d.asgCache {
-		if node.Labels[opts.InstanceGroupLabel] == group.Name {
-			nodeGroup = group
-			break
-		}
-	}
-	if nodeGroup == nil {
-		return fmt.Errorf("Could not find ASG for node %v", node.Name)
-	}
-
 	// Spot instances default to terminate on stop and trying to set the shutdown behaviour causes an error.
 	if node.Labels["node-role.kubernetes.io/spot-worker"] != "true" {
 		// Make sure that when nodereaperd shuts down the node, it is actually terminated
@@ -240,8 +514,11 @@ type asg struct {
 
 // GetAsgs gets the AutoScalingGroups that match the given filters
 func getAsgs(svc *autoscaling.AutoScaling, svcEC2 *ec2.EC2, filter map[string]string, nametag string) ([]*asg, error) {
+	return describeAsgs(svc, svcEC2, &autoscaling.DescribeAutoScalingGroupsInput{}, filter, nametag)
+}
 
-	input := &autoscaling.DescribeAutoScalingGroupsInput{}
+// describeAsgs gets the AutoScalingGroups selected by input that match the given filters
+func describeAsgs(svc *autoscaling.AutoScaling, svcEC2 *ec2.EC2, input *autoscaling.DescribeAutoScalingGroupsInput, filter map[string]string, nametag string) ([]*asg, error) {
 	groups := []*asg{}
 
 	err := svc.DescribeAutoScalingGroupsPages(input,
@@ -360,6 +637,34 @@ func convertGroup(g *autoscaling.Group) (*asg, error) {
 	return a, nil
 }
 
+// getImpairedInstances returns when each running instance that is failing its system or instance
+// status checks became impaired, by instance ID. The time is zero if EC2 doesn't report it
+func getImpairedInstances(svcEC2 *ec2.EC2) (map[string]time.Time, error) {
+	impaired := map[string]time.Time{}
+	err := svcEC2.DescribeInstanceStatusPages(&ec2.DescribeInstanceStatusInput{},
+		func(page *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+			for _, status := range page.InstanceStatuses {
+				if status.InstanceId == nil {
+					continue
+				}
+				for _, summary := range []*ec2.InstanceStatusSummary{status.SystemStatus, status.InstanceStatus} {
+					if summary == nil || aws.StringValue(summary.Status) != ec2.SummaryStatusImpaired {
+						continue
+					}
+					since, seen := impaired[*status.InstanceId]
+					for _, detail := range summary.Details {
+						if detail.ImpairedSince != nil && (!seen || since.IsZero() || detail.ImpairedSince.Before(since)) {
+							since = *detail.ImpairedSince
+						}
+					}
+					impaired[*status.InstanceId] = since
+				}
+			}
+			return true
+		})
+	return impaired, err
+}
+
 func getDetachedInstances(svcEC2 *ec2.EC2, filter map[string]string) []*ec2.Instance {
 	detachedInstances := []*ec2.Instance{}
 	input := &ec2.DescribeInstancesInput{
@@ -400,3 +705,99 @@ func getDetachedInstances(svcEC2 *ec2.EC2, filter map[string]string) []*ec2.Inst
 		})
 	return detachedInstances
 }
+
+// TerminateNode terminates the node's instance. It's used when the controller drains
+// nodes itself, so nodereaperd isn't there to power the instance off
+func (d *APIProvider) TerminateNode(opts *config.Ops, node *core_v1.Node) error {
+	id, err := nodeInstanceID(node)
+	if err != nil {
+		return fmt.Errorf("Could not get instance-id for node %v: %v", node.Name, err)
+	}
+	_, err = d.ec2Client.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: []*string{
+			&id,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Error terminating node %v (%v): %v", node.Name, id, err)
+	}
+	logrus.Infof("Terminated instance %v of node %v", id, node.Name)
+	return nil
+}
+
+// InstanceTerminated returns true if the node's instance is shutting down, terminated, or no longer exists
+func (d *APIProvider) InstanceTerminated(node *core_v1.Node) (bool, error) {
+	id, err := nodeInstanceID(node)
+	if err != nil {
+		return false, fmt.Errorf("Could not get instance-id for node %v: %v", node.Name, err)
+	}
+	out, err := d.ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{
+			&id,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidInstanceID.NotFound" {
+			return true, nil
+		}
+		return false, fmt.Errorf("Error describing instance %v of node %v: %v", id, node.Name, err)
+	}
+	for _, reservation := range out.Reservations {
+		for _, instance := range reservation.Instances {
+			if instance.State == nil {
+				continue
+			}
+			switch aws.StringValue(instance.State.Name) {
+			case ec2.InstanceStateNameShuttingDown, ec2.InstanceStateNameTerminated:
+				return true, nil
+			default:
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// CompleteLifecycleHooks lets the node's ASG go ahead with terminating its instance,
+// if a termination lifecycle hook is holding it
+func (d *APIProvider) CompleteLifecycleHooks(node *core_v1.Node) error {
+	id, err := nodeInstanceID(node)
+	if err != nil {
+		return fmt.Errorf("Could not get instance-id for node %v: %v", node.Name, err)
+	}
+	out, err := d.client.DescribeAutoScalingInstances(&autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{
+			&id,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Error describing ASG instance %v of node %v: %v", id, node.Name, err)
+	}
+	for _, instance := range out.AutoScalingInstances {
+		if aws.StringValue(instance.LifecycleState) != autoscaling.LifecycleStateTerminatingWait {
+			continue
+		}
+		hooks, err := d.client.DescribeLifecycleHooks(&autoscaling.DescribeLifecycleHooksInput{
+			AutoScalingGroupName: instance.AutoScalingGroupName,
+		})
+		if err != nil {
+			return fmt.Errorf("Error describing lifecycle hooks of ASG %v: %v", aws.StringValue(instance.AutoScalingGroupName), err)
+		}
+		for _, hook := range hooks.LifecycleHooks {
+			if aws.StringValue(hook.LifecycleTransition) != "autoscaling:EC2_INSTANCE_TERMINATING" {
+				continue
+			}
+			_, err := d.client.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+				AutoScalingGroupName:  instance.AutoScalingGroupName,
+				LifecycleHookName:     hook.LifecycleHookName,
+				InstanceId:            &id,
+				LifecycleActionResult: aws.String("CONTINUE"),
+			})
+			if err != nil {
+				return fmt.Errorf("Error completing lifecycle hook %v for node %v (%v): %v", aws.StringValue(hook.LifecycleHookName), node.Name, id, err)
+			}
+			logrus.Infof("Completed lifecycle hook %v for node %v", aws.StringValue(hook.LifecycleHookName), node.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Publisher sends messages to an SNS topic, an SQS queue, or both
+type Publisher struct {
+	snsClient *sns.SNS
+	sqsClient *sqs.SQS
+	topicArn  string
+	queueURL  string
+}
+
+// NewPublisher creates a publisher. Either destination may be empty
+func NewPublisher(topicArn, queueURL string) *Publisher {
+	sess := session.Must(session.NewSession())
+	return &Publisher{
+		snsClient: sns.New(sess),
+		sqsClient: sqs.New(sess),
+		topicArn:  topicArn,
+		queueURL:  queueURL,
+	}
+}
+
+// Publish sends the message to every configured destination
+func (p *Publisher) Publish(ctx context.Context, message string) error {
+	if p.topicArn != "" {
+		_, err := p.snsClient.PublishWithContext(ctx, &sns.PublishInput{
+			TopicArn: &p.topicArn,
+			Message:  &message,
+		})
+		if err != nil {
+			return fmt.Errorf("Error publishing to SNS topic %v: %v", p.topicArn, err)
+		}
+	}
+	if p.queueURL != "" {
+		_, err := p.sqsClient.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+			QueueUrl:    &p.queueURL,
+			MessageBody: &message,
+		})
+		if err != nil {
+			return fmt.Errorf("Error sending to SQS queue %v: %v", p.queueURL, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package calendar
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Cache fetches calendars by URL, refetching them at most once per refresh period.
+// If a fetch fails the last calendar successfully fetched is kept
+type Cache struct {
+	mu      sync.Mutex
+	refresh time.Duration
+	entries map[string]*entry
+}
+
+type entry struct {
+	calendar *Calendar
+	fetched  time.Time
+}
+
+// NewCache creates an empty calendar cache
+func NewCache(refresh time.Duration) *Cache {
+	return &Cache{
+		refresh: refresh,
+		entries: map[string]*entry{},
+	}
+}
+
+// Get returns the calendar at url. If it has never been fetched successfully,
+// the returned calendar is empty, which allows no deletions, along with the error
+func (c *Cache) Get(ctx context.Context, url string) (*Calendar, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[url]
+	if !ok {
+		e = &entry{calendar: &Calendar{}}
+		c.entries[url] = e
+	}
+	if ok && time.Since(e.fetched) < c.refresh {
+		return e.calendar, nil
+	}
+
+	cal, err := fetch(ctx, url)
+	// Failures are retried after the refresh period too, so an unreachable server isn't hit every poll
+	e.fetched = time.Now()
+	if err != nil {
+		return e.calendar, err
+	}
+	e.calendar = cal
+	return cal, nil
+}
+
+func fetch(ctx context.Context, url string) (*Calendar, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating request for calendar %v: %v", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching calendar %v: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching calendar %v: %v", url, resp.Status)
+	}
+	cal, err := Parse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing calendar %v: %v", url, err)
+	}
+	return cal, nil
+}
@@ -0,0 +1,344 @@
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Recurring events are only expanded this far into the future
+	horizon = 366 * 24 * time.Hour
+)
+
+// Calendar is the set of events in an ICS (RFC 5545) calendar. Only the parts needed for
+// maintenance windows are supported: DTSTART, DTEND or DURATION, and daily or weekly RRULEs
+type Calendar struct {
+	events []event
+}
+
+type event struct {
+	start    time.Time
+	duration time.Duration
+	rule     *rrule
+}
+
+// rrule is a daily or weekly recurrence rule
+type rrule struct {
+	freq     string
+	interval int
+	count    int
+	until    *time.Time
+	byDay    []time.Weekday
+}
+
+// Active returns true if an event is in progress at t
+func (c *Calendar) Active(t time.Time) bool {
+	for _, e := range c.events {
+		active := false
+		e.occurrences(t.Add(-e.duration), t, func(start time.Time) bool {
+			if !start.After(t) && start.Add(e.duration).After(t) {
+				active = true
+				return false
+			}
+			return true
+		})
+		if active {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the start of the next event at or after t, or false if there is none
+func (c *Calendar) Next(t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	for _, e := range c.events {
+		e.occurrences(t, t.Add(horizon), func(start time.Time) bool {
+			if !start.Before(t) {
+				if !found || start.Before(next) {
+					next, found = start, true
+				}
+				return false
+			}
+			return true
+		})
+	}
+	return next, found
+}
+
+// occurrences calls f with the start of each occurrence of the event beginning in [from, to],
+// in order, until f returns false
+func (e *event) occurrences(from, to time.Time, f func(time.Time) bool) {
+	if e.rule == nil {
+		if !e.start.Before(from) && !e.start.After(to) {
+			f(e.start)
+		}
+		return
+	}
+
+	r := e.rule
+	days := r.interval
+	if r.freq == "WEEKLY" {
+		days *= 7
+	}
+	n := 0
+	for period := e.start; !period.After(to); period = period.AddDate(0, 0, days) {
+		for _, start := range r.expand(period) {
+			if start.Before(e.start) {
+				continue
+			}
+			n++
+			if (r.count > 0 && n > r.count) || (r.until != nil && start.After(*r.until)) || start.After(to) {
+				return
+			}
+			if !start.Before(from) && !f(start) {
+				return
+			}
+		}
+	}
+}
+
+// expand returns the occurrences within a single period of the rule
+func (r *rrule) expand(period time.Time) []time.Time {
+	if r.freq != "WEEKLY" || len(r.byDay) == 0 {
+		return []time.Time{period}
+	}
+	// Weeks start on Monday, as RFC 5545 defaults to
+	offset := (int(period.Weekday()) + 6) % 7
+	weekStart := period.AddDate(0, 0, -offset)
+	ret := []time.Time{}
+	for i := 0; i < 7; i++ {
+		day := weekStart.AddDate(0, 0, i)
+		for _, wd := range r.byDay {
+			if day.Weekday() == wd {
+				ret = append(ret, day)
+			}
+		}
+	}
+	return ret
+}
+
+// Parse reads an ICS calendar
+func Parse(r io.Reader) (*Calendar, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Calendar{}
+	var props map[string]property
+	for _, line := range lines {
+		switch line {
+		case "BEGIN:VEVENT":
+			props = map[string]property{}
+			continue
+		case "END:VEVENT":
+			if props == nil {
+				return nil, fmt.Errorf("END:VEVENT without BEGIN:VEVENT")
+			}
+			e, err := parseEvent(props)
+			if err != nil {
+				return nil, err
+			}
+			c.events = append(c.events, e)
+			props = nil
+			continue
+		}
+		if props == nil {
+			continue
+		}
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, err
+		}
+		props[p.name] = p
+	}
+	return c, nil
+}
+
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// unfold joins continuation lines, which start with a space or tab
+func unfold(r io.Reader) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func parseProperty(line string) (property, error) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return property{}, fmt.Errorf("Invalid calendar line '%v'", line)
+	}
+	parts := strings.Split(line[:colon], ";")
+	p := property{
+		name:   strings.ToUpper(parts[0]),
+		params: map[string]string{},
+		value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			p.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return p, nil
+}
+
+func parseEvent(props map[string]property) (event, error) {
+	dtstart, ok := props["DTSTART"]
+	if !ok {
+		return event{}, fmt.Errorf("Event has no DTSTART")
+	}
+	start, err := parseTime(dtstart)
+	if err != nil {
+		return event{}, err
+	}
+	e := event{start: start}
+
+	if dtend, ok := props["DTEND"]; ok {
+		end, err := parseTime(dtend)
+		if err != nil {
+			return event{}, err
+		}
+		e.duration = end.Sub(start)
+	} else if duration, ok := props["DURATION"]; ok {
+		if e.duration, err = parseDuration(duration.value); err != nil {
+			return event{}, err
+		}
+	} else if dtstart.params["VALUE"] == "DATE" {
+		e.duration = 24 * time.Hour
+	}
+
+	if rule, ok := props["RRULE"]; ok {
+		if e.rule, err = parseRule(rule.value, start.Location()); err != nil {
+			return event{}, err
+		}
+	}
+	return e, nil
+}
+
+func parseTime(p property) (time.Time, error) {
+	loc := time.UTC
+	if tzid, ok := p.params["TZID"]; ok {
+		var err error
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, fmt.Errorf("Unknown time zone %v: %v", tzid, err)
+		}
+	}
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.ParseInLocation(layout, p.value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid %v '%v'", p.name, p.value)
+}
+
+// parseDuration parses the subset of RFC 5545 durations made of weeks, days, hours, minutes and seconds
+func parseDuration(s string) (time.Duration, error) {
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("Invalid DURATION '%v'", s)
+	}
+	units := map[byte]time.Duration{
+		'W': 7 * 24 * time.Hour,
+		'D': 24 * time.Hour,
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+	}
+	var d time.Duration
+	num := ""
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 'T':
+		case c >= '0' && c <= '9':
+			num += string(c)
+		default:
+			unit, ok := units[c]
+			n, err := strconv.Atoi(num)
+			if !ok || err != nil {
+				return 0, fmt.Errorf("Invalid DURATION '%v'", s)
+			}
+			d += time.Duration(n) * unit
+			num = ""
+		}
+	}
+	return d, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+func parseRule(s string, loc *time.Location) (*rrule, error) {
+	r := &rrule{interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid RRULE '%v'", s)
+		}
+		var err error
+		switch strings.ToUpper(kv[0]) {
+		case "FREQ":
+			r.freq = strings.ToUpper(kv[1])
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(kv[1])
+		case "COUNT":
+			r.count, err = strconv.Atoi(kv[1])
+		case "UNTIL":
+			var until time.Time
+			until, err = parseTime(property{name: "UNTIL", params: map[string]string{}, value: kv[1]})
+			if err == nil && !strings.HasSuffix(kv[1], "Z") {
+				until = time.Date(until.Year(), until.Month(), until.Day(), until.Hour(), until.Minute(), until.Second(), 0, loc)
+			}
+			r.until = &until
+		case "BYDAY":
+			for _, day := range strings.Split(kv[1], ",") {
+				wd, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("Unsupported BYDAY '%v' in RRULE '%v'", day, s)
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("Unsupported RRULE part '%v' in '%v'", kv[0], s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid RRULE '%v': %v", s, err)
+		}
+	}
+	if r.freq != "DAILY" && r.freq != "WEEKLY" {
+		return nil, fmt.Errorf("Unsupported RRULE frequency '%v', only DAILY and WEEKLY are supported", r.freq)
+	}
+	if r.interval < 1 {
+		return nil, fmt.Errorf("Invalid RRULE interval in '%v'", s)
+	}
+	return r, nil
+}
@@ -0,0 +1,75 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const maintenance = `BEGIN:VCALENDAR
+VERSION:2.0
+BEGIN:VEVENT
+SUMMARY:One-off window
+DTSTART:20210305T020000Z
+DTEND:20210305T040000Z
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Tuesday and Thursday
+ nights
+DTSTART:20210309T220000Z
+DURATION:PT2H
+RRULE:FREQ=WEEKLY;BYDAY=TU,TH;COUNT=3
+END:VEVENT
+END:VCALENDAR
+`
+
+type test struct {
+	t   time.Time
+	res bool
+}
+
+func TestActive(t *testing.T) {
+	c, err := Parse(strings.NewReader(maintenance))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []test{
+		{time.Date(2021, time.March, 5, 1, 59, 59, 0, time.UTC), false},
+		{time.Date(2021, time.March, 5, 2, 0, 0, 0, time.UTC), true},
+		{time.Date(2021, time.March, 5, 3, 59, 59, 0, time.UTC), true},
+		{time.Date(2021, time.March, 5, 4, 0, 0, 0, time.UTC), false},
+
+		// Recurrences, ending at midnight
+		{time.Date(2021, time.March, 9, 23, 0, 0, 0, time.UTC), true},
+		{time.Date(2021, time.March, 10, 23, 0, 0, 0, time.UTC), false},
+		{time.Date(2021, time.March, 11, 23, 30, 0, 0, time.UTC), true},
+		{time.Date(2021, time.March, 11, 23, 59, 59, 0, time.UTC), true},
+		{time.Date(2021, time.March, 12, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(2021, time.March, 16, 23, 0, 0, 0, time.UTC), true},
+
+		// COUNT=3 ends the series
+		{time.Date(2021, time.March, 18, 23, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, test := range tests {
+		if c.Active(test.t) != test.res {
+			t.Errorf("Failed testing date %s, got result %v, wanted %v", test.t, !test.res, test.res)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	c, err := Parse(strings.NewReader(maintenance))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, ok := c.Next(time.Date(2021, time.March, 10, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2021, time.March, 11, 22, 0, 0, 0, time.UTC); !ok || !next.Equal(want) {
+		t.Errorf("Got next window %v (%v), wanted %v", next, ok, want)
+	}
+	if _, ok := c.Next(time.Date(2021, time.March, 17, 0, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("Got a next window after the series ended")
+	}
+}
@@ -0,0 +1,86 @@
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/deletion"
+)
+
+const (
+	specVersion = "1.0"
+	contentType = "application/cloudevents+json"
+	// typePrefix is followed by the lifecycle phase, e.g. com.wish.nodereaper.node.detached
+	typePrefix = "com.wish.nodereaper.node."
+)
+
+// Notifier sends each lifecycle event to a sink as a CloudEvent, using the HTTP binding in structured mode
+type Notifier struct {
+	sink   string
+	source string
+}
+
+type event struct {
+	SpecVersion     string                  `json:"specversion"`
+	ID              string                  `json:"id"`
+	Source          string                  `json:"source"`
+	Type            string                  `json:"type"`
+	Subject         string                  `json:"subject"`
+	Time            time.Time               `json:"time"`
+	DataContentType string                  `json:"datacontenttype"`
+	Data            deletion.LifecycleEvent `json:"data"`
+}
+
+// New creates a notifier posting to sink. The event source identifies the cluster if it has a name
+func New(sink, cluster string) *Notifier {
+	source := "/nodereaper"
+	if cluster != "" {
+		source += "/" + cluster
+	}
+	return &Notifier{
+		sink:   sink,
+		source: source,
+	}
+}
+
+// Notify posts the event to the sink
+func (n *Notifier) Notify(ctx context.Context, e deletion.LifecycleEvent) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	body, err := json.Marshal(event{
+		SpecVersion:     specVersion,
+		ID:              hex.EncodeToString(id),
+		Source:          n.source,
+		Type:            typePrefix + e.Phase,
+		Subject:         e.Node,
+		Time:            e.Time,
+		DataContentType: "application/json",
+		Data:            e,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.sink, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Error sending CloudEvent to %v: %v", n.sink, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("CloudEvents sink %v returned %v", n.sink, resp.Status)
+	}
+	return nil
+}
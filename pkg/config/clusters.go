@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClusterPlaceholder is replaced by the cluster's name in --aws-asg-filter, so that in
+// multi-cluster mode each cluster only considers its own ASGs
+const ClusterPlaceholder = "{cluster}"
+
+// Cluster is one of the clusters managed in multi-cluster mode
+type Cluster struct {
+	Name    string
+	Context string
+}
+
+// ParseClusters parses a comma separated list of clusters, each written as name=context,
+// like "prod-a=arn:aws:eks:us-east-1:123:cluster/prod-a,prod-b=prod-b". The context
+// defaults to the name if the "=context" is left out
+func ParseClusters(s string) ([]Cluster, error) {
+	ret := []Cluster{}
+	seen := map[string]bool{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		cluster := Cluster{item, item}
+		if i := strings.Index(item, "="); i >= 0 {
+			cluster = Cluster{strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])}
+		}
+		if cluster.Name == "" || cluster.Context == "" {
+			return nil, fmt.Errorf("Invalid cluster '%v', expected name=context", item)
+		}
+		if seen[cluster.Name] {
+			return nil, fmt.Errorf("Cluster %v is listed twice", cluster.Name)
+		}
+		seen[cluster.Name] = true
+		ret = append(ret, cluster)
+	}
+	return ret, nil
+}
+
+// ForCluster returns a copy of the options for managing one of the clusters in multi-cluster mode
+func (o *Ops) ForCluster(cluster Cluster) *Ops {
+	ret := *o
+	ret.Clusters = ""
+	ret.Context = cluster.Context
+	ret.ClusterName = cluster.Name
+	ret.AwsAsgFilter = strings.Replace(o.AwsAsgFilter, ClusterPlaceholder, cluster.Name, -1)
+	return &ret
+}
+
+// ForShard returns a copy of the options for driving one of the shards
+func (o *Ops) ForShard(shard int) *Ops {
+	ret := *o
+	ret.Shard = shard
+	return &ret
+}
@@ -12,15 +12,53 @@ import (
 )
 
 var defaults map[string]string = map[string]string{
-	"maxSurge":              "1",
-	"maxUnavailable":        "0",
-	"deleteOldLaunchConfig": "false",
-	"deletionAge":           "",
-	"deletionAgeJitter":     "",
-	"deletionSchedule":      "",
-	"startupGracePeriod":    "",
-	"ignoreSelector":        "kubernetes.io/role=master",
-	"ignore":                "false",
+	"maxSurge":                 "1",
+	"maxUnavailable":           "0",
+	"maxTotalSurge":            "",
+	"maxNotReadyNodes":         "",
+	"maxNodeLifetime":          "",
+	"minReadyNodes":            "0",
+	"protectLastN":             "0",
+	"deleteSingletonWorkloads": "false",
+	"criticalPodSelector":      "",
+	"headroomCheck":            "",
+	"maxTransitionErrorRate":   "",
+	"transitionErrorWindow":    "10m",
+	"deleteOldLaunchConfig":    "false",
+	"orphanedGroupPolicy":      "alert",
+	"orphanedGroupGracePeriod": "1h",
+	"deletionAge":              "",
+	"deletionAgeJitter":        "",
+	"recycleRate":              "",
+	"instanceImpairedPeriod":   "",
+	"cordonedDeletionAge":      "",
+	"keepCordonedNodes":        "false",
+	"podFailureThreshold":      "",
+	"podFailurePeriod":         "10m",
+	"deletionSchedule":         "",
+	"deletionCalendar":         "",
+	"deletionsPerWindow":       "0",
+	"paceDeletions":            "false",
+	"startupGracePeriod":       "",
+	"providerIDTimeout":        "5m",
+	"ignoreSelector":           "kubernetes.io/role=master",
+	"ignoreTaints":             "",
+	"annotateForAutoscaler":    "false",
+	"drainMode":                "agent",
+	"nodeAction":               "power-off",
+	"approvalWebhook":          "",
+	"interactiveApproval":      "false",
+	"preDrainHooks":            "",
+	"preDeleteHooks":           "",
+	"preDeleteJobTemplate":     "",
+	"preDeleteJobTimeout":      "30m",
+	"preDrainHookTimeout":      "30s",
+	"preDrainHookRetries":      "3",
+	"pollPeriod":               "",
+	"pollJitter":               "10%",
+	"ignore":                   "false",
+	"awsAsgFilter":             "",
+	"awsAsgNameTag":            "",
 }
 
 // DynamicConfig represents the settings specified by configmap
@@ -58,6 +96,11 @@ func (c *DynamicConfig) Reload() error {
 	return nil
 }
 
+// Load replaces the settings with the given configmap keys, as Reload does with the mounted files
+func (c *DynamicConfig) Load(settings map[string]string) {
+	c.loadFromMap(settings)
+}
+
 func (c *DynamicConfig) loadFromMap(inp map[string]string) {
 	newSettings := map[string]map[string]string{}
 	for key, value := range inp {
@@ -111,6 +154,45 @@ func (c *DynamicConfig) GetString(groupName, key string) string {
 	panic("No default exists for setting " + key)
 }
 
+// Settings returns every setting in effect for the group, after applying global settings and defaults
+func (c *DynamicConfig) Settings(groupName string) map[string]string {
+	ret := map[string]string{}
+	for key := range defaults {
+		ret[key] = c.GetString(groupName, key)
+	}
+	return ret
+}
+
+// Where a setting in effect for a group comes from
+const (
+	SourceGroup   = "group"
+	SourceGlobal  = "global"
+	SourceDefault = "default"
+)
+
+// Setting is the value of a setting in effect for a group, and where it comes from
+type Setting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// EffectiveSettings returns every setting in effect for the group, like Settings,
+// along with whether it was set for the group, globally or is the default
+func (c *DynamicConfig) EffectiveSettings(groupName string) map[string]Setting {
+	ret := map[string]Setting{}
+	for key, value := range defaults {
+		setting := Setting{value, SourceDefault}
+		if value, ok := c.settings[""][key]; ok {
+			setting = Setting{value, SourceGlobal}
+		}
+		if value, ok := c.settings[groupName][key]; ok {
+			setting = Setting{value, SourceGroup}
+		}
+		ret[key] = setting
+	}
+	return ret
+}
+
 // GetBool returns a bool parsed from a configmap key
 func (c *DynamicConfig) GetBool(groupName, key string) bool {
 	if groupSettings, ok := c.settings[groupName]; ok {
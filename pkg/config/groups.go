@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// GroupRule assigns the nodes matching Selector to the virtual group Name, if they don't have the instance group label
+type GroupRule struct {
+	Name     string
+	Selector labels.Selector
+}
+
+// ParseGroupRules parses a semicolon separated list of rules, each written as name=selector,
+// like "spot=node-type=spot;gpu=accelerator in (a,b)". An empty selector matches every node
+func ParseGroupRules(s string) ([]GroupRule, error) {
+	ret := []GroupRule{}
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.Index(item, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid group rule '%v', expected name=selector", item)
+		}
+		selector, err := labels.Parse(item[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid selector in group rule '%v': %v", item, err)
+		}
+		ret = append(ret, GroupRule{Name: strings.TrimSpace(item[:i]), Selector: selector})
+	}
+	return ret, nil
+}
+
+// MatchGroupRule returns the first rule matching the labels, or nil if none do
+func MatchGroupRule(rules []GroupRule, nodeLabels map[string]string) *GroupRule {
+	for i := range rules {
+		if rules[i].Selector.Matches(labels.Set(nodeLabels)) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package config
+
+import "strings"
+
+// SplitLabel splits a label flag, written as key or key=value, into its parts
+func SplitLabel(spec string) (key, value string, hasValue bool) {
+	if i := strings.Index(spec, "="); i >= 0 {
+		return spec[:i], spec[i+1:], true
+	}
+	return spec, "", false
+}
+
+// HasLabel returns true if the labels have the spec's key and, if the spec has a value, that value
+func HasLabel(spec string, labels map[string]string) bool {
+	if spec == "" {
+		return false
+	}
+	key, value, hasValue := SplitLabel(spec)
+	actual, ok := labels[key]
+	return ok && (!hasValue || actual == value)
+}
+
+// RequestLabel is one of the labels requesting deletion, and the reason reported for the nodes that have it
+type RequestLabel struct {
+	Label string
+	// Reason is empty if the label didn't specify one
+	Reason string
+}
+
+// ParseRequestLabels parses a comma separated list of labels, each written as key or key=value,
+// optionally followed by :reason
+func ParseRequestLabels(s string) []RequestLabel {
+	ret := []RequestLabel{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		label := RequestLabel{Label: item}
+		if i := strings.LastIndex(item, ":"); i >= 0 && i < len(item)-1 {
+			label.Label, label.Reason = item[:i], item[i+1:]
+		}
+		ret = append(ret, label)
+	}
+	return ret
+}
@@ -3,37 +3,183 @@ package config
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	// DeletionTaint is the taint nodereaperd applies to a node it is deleting
+	DeletionTaint = "NodereaperDeletingNode"
+	// DrainPhaseAnnotation records how far nodereaperd got draining a node, so it can resume after a restart
+	DrainPhaseAnnotation = "nodereaper.wish.com/drain-phase"
+	// NonEvictablePodsAnnotation lists the static pods nodereaperd found on a node it is deleting,
+	// which can't be evicted and are left to go down with the node
+	NonEvictablePodsAnnotation = "nodereaper.wish.com/non-evictable-pods"
+	// NodeActionAnnotation is set by the controller along with the deletion label, to what nodereaperd
+	// does with the drained node: NodeActionPowerOff, the default, or NodeActionReboot
+	NodeActionAnnotation = "nodereaper.wish.com/node-action"
+	// DeletionIDAnnotation is set by the controller to the ID of the node's deletion, which its logs,
+	// nodereaperd's logs, events and the deletion history all carry
+	DeletionIDAnnotation = "nodereaper.wish.com/deletion-id"
+	// RebootBootIDAnnotation is the boot ID of a node nodereaperd is rebooting, to tell once it's back
+	RebootBootIDAnnotation = "nodereaper.wish.com/reboot-boot-id"
+
+	// NodeActionPowerOff has nodereaperd delete the node and power it off
+	NodeActionPowerOff = "power-off"
+	// NodeActionReboot has nodereaperd reboot the node, and put it back in service once it's up
+	NodeActionReboot = "reboot"
+)
+
 // Ops represents the commandline/environment options for the program
 type Ops struct {
 	DynamicConfig
-	NodeName             string `long:"node-name" env:"NODE_NAME" description:"The name of the host node" required:"yes"`
-	LogLevel             string `long:"log-level" env:"LOG_LEVEL" description:"Log level" default:"info"`
-	BindAddr             string `long:"bind-address" short:"p" env:"BIND_ADDRESS" default:":9656" description:"address for binding metrics listener"`
-	PollPeriod           string `long:"poll-period" env:"POLL_PERIOD" description:"Check for deletion every period (5s, 3m, 1h, ...)" default:"15s"`
-	AwsPollPeriod        string `long:"aws-poll-period" env:"AWS_POLL_PERIOD" description:"Update aws state every period" default:"30s"`
-	InstanceGroupLabel   string `long:"instance-group-label" env:"INSTANCE_GROUP_LABEL" description:"The node label whose value is the name of the instance group"`
-	RequestDeletionLabel string `long:"request-deletion-label" env:"REQUEST_DELETION_LABEL" description:"Delete this node if it has this label"`
-	ForceDeletionLabel   string `long:"force-deletion-label" env:"FORCE_DELETION_LABEL" description:"The controller sets this label to force a node to delete itself" required:"true"`
-	AwsAsgFilter         string `long:"aws-asg-filter" env:"AWS_ASG_FILTER" description:"Restrict the AWS ASGs that this tool considers. Comma separated map (e.g. k1=v1,k2=v2)"`
-	AwsAsgNameTag        string `long:"aws-asg-name-tag" env:"AWS_ASG_NAME_TAG" description:"The tag on an ASG that should be interpreted as its name"`
-	Namespace            string `long:"namespace" env:"NAMESPACE" description:"The namespace the controller resides in" required:"true"`
-	LockConfigMapName    string `long:"lock-configmap-name" env:"LOCK_CONFIGMAP_NAME" description:"The name of the configmap to store locks" default:"nodereaper-locks"`
+	NodeName             string  `long:"node-name" env:"NODE_NAME" description:"The name of the host node" required:"yes"`
+	LogLevel             string  `long:"log-level" env:"LOG_LEVEL" description:"Log level" default:"info"`
+	LogRepeatInterval    string  `long:"log-repeat-interval" env:"LOG_REPEAT_INTERVAL" description:"Log a recurring error, like a missing ASG, at most once per interval, with how many times it repeated. Every occurrence is still logged at trace level. Disabled if 0" default:"10m"`
+	Kubeconfig           string  `long:"kubeconfig" env:"KUBECONFIG" description:"Path to a kubeconfig, for running outside the cluster. Uses the in-cluster service account if unset"`
+	Master               string  `long:"master" env:"KUBERNETES_MASTER" description:"The address of the Kubernetes API server. Overrides any value in the kubeconfig"`
+	Context              string  `long:"context" env:"KUBE_CONTEXT" description:"The kubeconfig context to use. Defaults to the current context"`
+	Shards               int     `long:"shards" env:"SHARDS" description:"Split the instance groups between this many replicas, each driving the groups that hash to the shard whose lease it holds" default:"1"`
+	Clusters             string  `long:"clusters" env:"CLUSTERS" description:"Manage several clusters from one controller. Comma separated list of name=context, with contexts from --kubeconfig"`
+	BindAddr             string  `long:"bind-address" short:"p" env:"BIND_ADDRESS" default:":9656" description:"address for binding metrics listener"`
+	EnablePprof          bool    `long:"enable-pprof" env:"ENABLE_PPROF" description:"Serve the pprof handlers on pprof-bind-address"`
+	PprofBindAddress     string  `long:"pprof-bind-address" env:"PPROF_BIND_ADDRESS" description:"Address to serve pprof on, with --enable-pprof" default:"localhost:6060"`
+	HTTPReadTimeout      string  `long:"http-read-timeout" env:"HTTP_READ_TIMEOUT" description:"Timeout for reading each request to the metrics listener and the admission webhook, body included. Unlimited if 0" default:"30s"`
+	HTTPWriteTimeout     string  `long:"http-write-timeout" env:"HTTP_WRITE_TIMEOUT" description:"Timeout for writing each response of the metrics listener and the admission webhook. Unlimited if 0" default:"1m"`
+	HTTPIdleTimeout      string  `long:"http-idle-timeout" env:"HTTP_IDLE_TIMEOUT" description:"How long idle keep-alive connections are kept open. Unlimited if 0" default:"2m"`
+	TLSCertFile          string  `long:"tls-cert-file" env:"TLS_CERT_FILE" description:"Serve the metrics listener, admin API included, over HTTPS with this certificate"`
+	TLSKeyFile           string  `long:"tls-key-file" env:"TLS_KEY_FILE" description:"TLS key for the metrics listener"`
+	TLSClientCAFile      string  `long:"tls-client-ca-file" env:"TLS_CLIENT_CA_FILE" description:"Require a client certificate signed by this CA for the admin API, the gRPC control API and the state dump"`
+	AdminAllowedClients  string  `long:"admin-allowed-clients" env:"ADMIN_ALLOWED_CLIENTS" description:"Comma separated common names or DNS names of the client certificates allowed to use the admin API and the gRPC control API. Any certificate signed by --tls-client-ca-file if unset"`
+	PollPeriod           string  `long:"poll-period" env:"POLL_PERIOD" description:"Check for deletion every period (5s, 3m, 1h, ...)" default:"15s"`
+	FastPollPeriod       string  `long:"fast-poll-period" env:"FAST_POLL_PERIOD" description:"Check for cheap reasons to delete a node, like the request deletion label, every period. Disabled if 0" default:"2s"`
+	MaxGroupsPerPoll     int     `long:"max-groups-per-poll" env:"MAX_GROUPS_PER_POLL" description:"Evaluate at most this many groups in each poll, the longest waiting first. Unlimited if 0" default:"0"`
+	APITimeout           string  `long:"api-timeout" env:"API_TIMEOUT" description:"Timeout for each individual Kubernetes API call" default:"30s"`
+	KubeAPIQPS           float32 `long:"kube-api-qps" env:"KUBE_API_QPS" description:"Maximum sustained queries per second to the Kubernetes API" default:"5"`
+	KubeAPIBurst         int     `long:"kube-api-burst" env:"KUBE_API_BURST" description:"Maximum burst of queries to the Kubernetes API" default:"10"`
+	AwsPollPeriod        string  `long:"aws-poll-period" env:"AWS_POLL_PERIOD" description:"Update aws state every period" default:"30s"`
+	ProviderConcurrency  int     `long:"provider-concurrency" env:"PROVIDER_CONCURRENCY" description:"Maximum number of concurrent cloud provider calls, like detaching an instance" default:"10"`
+	ProviderQPS          float64 `long:"provider-qps" env:"PROVIDER_QPS" description:"Maximum sustained cloud provider calls per second. Unlimited if 0" default:"5"`
+	ProviderTimeout      string  `long:"provider-timeout" env:"PROVIDER_TIMEOUT" description:"Timeout for each individual cloud provider call, including time spent waiting for a free slot" default:"60s"`
+	InstanceGroupLabel   string  `long:"instance-group-label" env:"INSTANCE_GROUP_LABEL" description:"The node label whose value is the name of the instance group"`
+	NodeSelector         string  `long:"node-selector" env:"NODE_SELECTOR" description:"Only watch and manage nodes matching this label selector (e.g. kubernetes.io/role=node,team in (a,b))"`
+	GroupRules           string  `long:"group-rules" env:"GROUP_RULES" description:"Assign nodes without the instance group label to virtual groups by label selector. A semicolon separated list of name=selector (e.g. spot=node-type=spot;gpu=accelerator in (a,b)), where the first matching rule wins"`
+	RequestDeletionLabel string  `long:"request-deletion-label" env:"REQUEST_DELETION_LABEL" description:"Delete this node if it has this label, written as key or key=value. A comma separated list, where each label can be followed by :reason to report its own reason"`
+	SecurityRecycleLabel string  `long:"security-recycle-label" env:"SECURITY_RECYCLE_LABEL" description:"Delete this node first, regardless of the deletion schedule, if it has this label or annotation"`
+	ForceDeletionLabel   string  `long:"force-deletion-label" env:"FORCE_DELETION_LABEL" description:"The controller sets this label to force a node to delete itself, written as key or key=value" required:"true"`
+	AwsAsgFilter         string  `long:"aws-asg-filter" env:"AWS_ASG_FILTER" description:"Restrict the AWS ASGs that this tool considers. Comma separated map (e.g. k1=v1,k2=v2)"`
+	AwsAsgNameTag        string  `long:"aws-asg-name-tag" env:"AWS_ASG_NAME_TAG" description:"The tag on an ASG that should be interpreted as its name"`
+	Namespace            string  `long:"namespace" env:"NAMESPACE" description:"The namespace the controller resides in" required:"true"`
+	LockConfigMapName    string  `long:"lock-configmap-name" env:"LOCK_CONFIGMAP_NAME" description:"The name of the configmap to store locks" default:"nodereaper-locks"`
+	Plan                 bool    `long:"plan" description:"Print the deletions the controller would make in one poll cycle, then exit without acting"`
+	AdminToken           string  `long:"admin-token" env:"ADMIN_TOKEN" description:"Bearer token required by the admin API. The admin API is disabled if unset"`
+	GRPCBindAddress      string  `long:"grpc-bind-address" env:"GRPC_BIND_ADDRESS" description:"Address to serve the gRPC control API on, e.g. :9657. It requires --admin-token, and is served over TLS with --tls-cert-file, and with --tls-client-ca-file, mutual TLS. Disabled if unset"`
+	WebhookBindAddress   string  `long:"webhook-bind-address" env:"WEBHOOK_BIND_ADDRESS" description:"Address to serve the validating admission webhook on, e.g. :9443. The webhook is disabled if unset"`
+	WebhookTLSCertFile   string  `long:"webhook-tls-cert-file" env:"WEBHOOK_TLS_CERT_FILE" description:"TLS certificate for the admission webhook"`
+	WebhookTLSKeyFile    string  `long:"webhook-tls-key-file" env:"WEBHOOK_TLS_KEY_FILE" description:"TLS key for the admission webhook"`
+	WebhookLabelWriters  string  `long:"webhook-label-writers" env:"WEBHOOK_LABEL_WRITERS" description:"Comma separated users allowed to set the force deletion label. Defaults to the nodereaper service account in NAMESPACE"`
+	WebhookTaintWriters  string  `long:"webhook-taint-writers" env:"WEBHOOK_TAINT_WRITERS" description:"Comma separated users allowed to set the deletion taint. Defaults to the nodereaper and nodereaperd service accounts in NAMESPACE"`
+	NodeDeletionRequests bool    `long:"node-deletion-requests" env:"NODE_DELETION_REQUESTS" description:"Delete the nodes named by NodeDeletionRequest objects in any namespace, and report their progress in the objects' status"`
+	WebhookGuardDeletion bool    `long:"webhook-guard-deletion" env:"WEBHOOK_GUARD_DELETION" description:"Have the admission webhook also reject deleting nodes that nodereaper has detached or is deleting, unless they have the nodereaper.wish.com/force-delete annotation"`
+	DeletionHandshake    bool    `long:"deletion-handshake" env:"DELETION_HANDSHAKE" description:"Confirm nodereaperd's acknowledgment of the deletion label before it drains the node. nodereaperd must have --deletion-handshake too"`
+	VerifyDeletions      bool    `long:"verify-deletions" env:"VERIFY_DELETIONS" description:"After a node is deleted, check that its group is back to its desired Ready nodes, its pods were rescheduled and its instance terminated, and report the outcome"`
+	NodeFinalizer        bool    `long:"node-finalizer" env:"NODE_FINALIZER" description:"Put a finalizer on managed nodes, so that a deleted node is only removed once its instance is terminated and its lifecycle hooks are completed"`
+	ClusterAPIVersion    string  `long:"cluster-api-version" env:"CLUSTER_API_VERSION" description:"The API version of Cluster API Machines, for nodes with a cluster.x-k8s.io/machine annotation" default:"v1alpha3"`
+	LifecycleSNSTopicArn string  `long:"lifecycle-sns-topic-arn" env:"LIFECYCLE_SNS_TOPIC_ARN" description:"SNS topic to publish deletion lifecycle events to"`
+	LifecycleSQSQueueURL string  `long:"lifecycle-sqs-queue-url" env:"LIFECYCLE_SQS_QUEUE_URL" description:"SQS queue to send deletion lifecycle events to"`
+	ClusterName          string  `long:"cluster-name" env:"CLUSTER_NAME" description:"The name of the cluster, used to tag notifications"`
+	DatadogAPIKey        string  `long:"datadog-api-key" env:"DATADOG_API_KEY" description:"Post Datadog events when deletions start, finish or fail. Disabled if unset"`
+	DatadogSite          string  `long:"datadog-site" env:"DATADOG_SITE" description:"The Datadog site to post events to" default:"datadoghq.com"`
+	SlackBotToken        string  `long:"slack-bot-token" env:"SLACK_BOT_TOKEN" description:"Slack bot token used to ask for approval in groups with interactiveApproval"`
+	SlackSigningSecret   string  `long:"slack-signing-secret" env:"SLACK_SIGNING_SECRET" description:"Signing secret of the Slack app, to authenticate approve/deny button presses"`
+	SlackChannel         string  `long:"slack-channel" env:"SLACK_CHANNEL" description:"Slack channel approval requests are posted to"`
+	CloudEventsSink      string  `long:"cloudevents-sink" env:"CLOUDEVENTS_SINK" description:"URL to send a CloudEvent to for each deletion lifecycle event. Disabled if unset"`
+
+	// Shard is the shard this replica holds the lease of, with more than one shard
+	Shard int `no-flag:"true"`
+}
+
+// AsgFilter returns the tags an ASG must have to be considered: the global awsAsgFilter setting,
+// or --aws-asg-filter without it. ClusterPlaceholder is replaced by the cluster's name
+func (o *Ops) AsgFilter() map[string]string {
+	value := o.GetString("", "awsAsgFilter")
+	if value == "" {
+		value = o.AwsAsgFilter
+	}
+	if o.ClusterName != "" {
+		value = strings.Replace(value, ClusterPlaceholder, o.ClusterName, -1)
+	}
+	filter := map[string]string{}
+	for _, item := range strings.Split(value, ",") {
+		if i := strings.Index(item, "="); i > 0 {
+			filter[item[:i]] = item[i+1:]
+		}
+	}
+	return filter
+}
+
+// AsgNameTag returns the tag whose value is an ASG's group name: the global awsAsgNameTag setting,
+// or --aws-asg-name-tag without it
+func (o *Ops) AsgNameTag() string {
+	if value := o.GetString("", "awsAsgNameTag"); value != "" {
+		return value
+	}
+	return o.AwsAsgNameTag
+}
+
+// longUnits are the units ParseDuration accepts on top of time.ParseDuration's
+var longUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
 }
 
 // ParseDuration parses the exact same duration values as time.ParseDuration
-// with the addition of 'd' (day) values, like "30d"
+// with the addition of 'd' (day) and 'w' (week) values, which can be combined
+// with each other and the usual units, like "30d", "2w", "1w3d" or "1d12h"
 func ParseDuration(duration string) (time.Duration, error) {
-	if duration[len(duration)-1:] == "d" {
-		num, err := strconv.ParseInt(duration[:len(duration)-1], 10, 64)
-		if err != nil {
-			var ret time.Duration
-			return ret, fmt.Errorf("Error parsing '%v' as number of days: %v", duration, err)
+	s, sign := duration, time.Duration(1)
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+	if s == "" {
+		return time.ParseDuration(duration)
+	}
+
+	// Add up the days and weeks, and leave the rest to time.ParseDuration
+	var long time.Duration
+	rest := ""
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+		if i < 0 {
+			i = len(s)
+		}
+		j := strings.IndexFunc(s[i:], func(r rune) bool { return (r >= '0' && r <= '9') || r == '.' })
+		if j < 0 {
+			j = len(s) - i
+		}
+		num, unit := s[:i], s[i:i+j]
+		s = s[i+j:]
+
+		if unitDuration, ok := longUnits[unit]; ok {
+			n, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, fmt.Errorf("Error parsing '%v' as a number of %v: %v", duration, unit, err)
+			}
+			long += time.Duration(n * float64(unitDuration))
+		} else {
+			rest += num + unit
 		}
-		duration = fmt.Sprintf("%vh", num*24)
 	}
 
-	return time.ParseDuration(duration)
+	var short time.Duration
+	if rest != "" {
+		var err error
+		if short, err = time.ParseDuration(rest); err != nil {
+			return 0, fmt.Errorf("Error parsing duration '%v': %v", duration, err)
+		}
+	}
+	return sign * (long + short), nil
 }
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in  string
+		out time.Duration
+	}{
+		{"15s", 15 * time.Second},
+		{"1h30m", 90 * time.Minute},
+		{"0", 0},
+		{"30d", 30 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"1w3d", 10 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"1w1d1h1m", 8*24*time.Hour + time.Hour + time.Minute},
+		{"-1d", -24 * time.Hour},
+	}
+	for _, test := range tests {
+		d, err := ParseDuration(test.in)
+		if err != nil {
+			t.Errorf("Error parsing %v: %v", test.in, err)
+		} else if d != test.out {
+			t.Errorf("Expected %v to parse as %v, got %v", test.in, test.out, d)
+		}
+	}
+
+	for _, in := range []string{"", "d", "1x", "1d2", "w1", "-"} {
+		if d, err := ParseDuration(in); err == nil {
+			t.Errorf("Expected an error parsing %q, got %v", in, d)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := &DynamicConfig{}
+	c.loadFromMap(map[string]string{
+		"global.deletionAge":          "30d",
+		"global.paceDeletions":        "yes",
+		"group.a.deletionSchedule":    "* 25 * * *",
+		"group.a.maxSurge":            "2",
+		"group.b.notASetting":         "1",
+		"group.b.criticalPodSelector": "app in (",
+	})
+	errs := c.Validate()
+	if len(errs) != 4 {
+		t.Fatalf("Expected every invalid setting to be reported, got %v", errs)
+	}
+}
+
+func TestHasLabel(t *testing.T) {
+	labels := map[string]string{"delete": "false", "other": ""}
+	tests := []struct {
+		spec string
+		res  bool
+	}{
+		{"delete", true},
+		{"delete=true", false},
+		{"delete=false", true},
+		{"other=", true},
+		{"missing", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if HasLabel(test.spec, labels) != test.res {
+			t.Errorf("Expected HasLabel(%q) to be %v", test.spec, test.res)
+		}
+	}
+}
+
+func TestParseRequestLabels(t *testing.T) {
+	labels := ParseRequestLabels("example.com/delete=true, ci.example.com/delete:ci_requested,,scanner:")
+	expected := []RequestLabel{
+		{"example.com/delete=true", ""},
+		{"ci.example.com/delete", "ci_requested"},
+		{"scanner:", ""},
+	}
+	if len(labels) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, labels)
+	}
+	for i := range expected {
+		if labels[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], labels[i])
+		}
+	}
+}
+
+func TestParseGroupRules(t *testing.T) {
+	rules, err := ParseGroupRules("spot=node-type=spot; gpu=accelerator in (a,b);;rest=")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rules) != 3 || rules[0].Name != "spot" || rules[1].Name != "gpu" || rules[2].Name != "rest" {
+		t.Fatalf("Unexpected rules %v", rules)
+	}
+
+	tests := []struct {
+		labels   map[string]string
+		expected string
+	}{
+		{map[string]string{"node-type": "spot", "accelerator": "a"}, "spot"},
+		{map[string]string{"node-type": "on-demand", "accelerator": "b"}, "gpu"},
+		{map[string]string{}, "rest"},
+	}
+	for _, test := range tests {
+		rule := MatchGroupRule(rules, test.labels)
+		if rule == nil || rule.Name != test.expected {
+			t.Errorf("Expected %v to match rule %v, got %v", test.labels, test.expected, rule)
+		}
+	}
+
+	for _, invalid := range []string{"spot", "=node-type=spot", "spot=node-type in spot"} {
+		if _, err := ParseGroupRules(invalid); err == nil {
+			t.Errorf("Expected an error parsing %v", invalid)
+		}
+	}
+}
+
+func TestParseClusters(t *testing.T) {
+	clusters, err := ParseClusters("prod-a=arn:aws:eks:us-east-1:123:cluster/prod-a, prod-b,,")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Cluster{{"prod-a", "arn:aws:eks:us-east-1:123:cluster/prod-a"}, {"prod-b", "prod-b"}}
+	if len(clusters) != len(expected) || clusters[0] != expected[0] || clusters[1] != expected[1] {
+		t.Fatalf("Expected %v, got %v", expected, clusters)
+	}
+
+	opts := &Ops{AwsAsgFilter: "kubernetes.io/cluster/{cluster}=owned", Context: "other"}
+	clusterOpts := opts.ForCluster(clusters[0])
+	if clusterOpts.AwsAsgFilter != "kubernetes.io/cluster/prod-a=owned" || clusterOpts.Context != expected[0].Context || clusterOpts.ClusterName != "prod-a" {
+		t.Errorf("Unexpected options for %v: %+v", clusters[0], clusterOpts)
+	}
+	if opts.Context != "other" {
+		t.Errorf("Expected the original options to be left alone")
+	}
+
+	for _, invalid := range []string{"=ctx", "a=", "a,a=b"} {
+		if _, err := ParseClusters(invalid); err == nil {
+			t.Errorf("Expected an error parsing %v", invalid)
+		}
+	}
+}
+
+func TestValidatePreDeleteJobTemplate(t *testing.T) {
+	valid := "template:\n  spec:\n    containers:\n    - name: flush\n      image: busybox"
+	if err := validateSetting("preDeleteJobTemplate", valid); err != nil {
+		t.Errorf("Expected a valid job spec, got %v", err)
+	}
+	if err := validateSetting("preDeleteJobTemplate", "template:\n  spec:\n    containres: []"); err == nil {
+		t.Errorf("Expected a misspelled field to be rejected")
+	}
+}
+
+func TestValidateNodeAction(t *testing.T) {
+	for _, action := range []string{NodeActionPowerOff, NodeActionReboot} {
+		if err := validateSetting("nodeAction", action); err != nil {
+			t.Errorf("Expected %v to be valid, got %v", action, err)
+		}
+	}
+	if err := validateSetting("nodeAction", "shutdown"); err == nil {
+		t.Errorf("Expected an unknown node action to be rejected")
+	}
+}
+
+func TestEffectiveSettings(t *testing.T) {
+	c := DynamicConfig{}
+	c.loadFromMap(map[string]string{
+		"global.maxSurge":          "2",
+		"group.workers.maxSurge":   "25%",
+		"global.deletionSchedule":  "* 18-20 * * *",
+		"group.workers.drainMode":  "server",
+		"group.other.drainMode":    "agent",
+		"group.workers.unknownKey": "1",
+	})
+	tests := []struct {
+		group, key string
+		want       Setting
+	}{
+		{"workers", "maxSurge", Setting{"25%", SourceGroup}},
+		{"workers", "deletionSchedule", Setting{"* 18-20 * * *", SourceGlobal}},
+		{"workers", "drainMode", Setting{"server", SourceGroup}},
+		{"workers", "maxUnavailable", Setting{"0", SourceDefault}},
+		{"spot", "maxSurge", Setting{"2", SourceGlobal}},
+		{"spot", "drainMode", Setting{"agent", SourceDefault}},
+	}
+	for _, test := range tests {
+		if got := c.EffectiveSettings(test.group)[test.key]; got != test.want {
+			t.Errorf("Expected %v of group %v to be %v, got %v", test.key, test.group, test.want, got)
+		}
+	}
+	if _, ok := c.EffectiveSettings("workers")["unknownKey"]; ok {
+		t.Errorf("Expected settings without a default to be left out")
+	}
+}
+
+func TestAsgFilter(t *testing.T) {
+	o := &Ops{AwsAsgFilter: "team=infra,kubernetes.io/cluster/{cluster}=owned", AwsAsgNameTag: "Name", ClusterName: "prod"}
+	expected := map[string]string{"team": "infra", "kubernetes.io/cluster/prod": "owned"}
+	if filter := o.AsgFilter(); !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected the flag's filter %v, got %v", expected, filter)
+	}
+	if tag := o.AsgNameTag(); tag != "Name" {
+		t.Errorf("Expected the flag's name tag, got %v", tag)
+	}
+
+	o.loadFromMap(map[string]string{
+		"global.awsAsgFilter":  "team=data,role=node",
+		"global.awsAsgNameTag": "Group",
+	})
+	expected = map[string]string{"team": "data", "role": "node"}
+	if filter := o.AsgFilter(); !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected the setting's filter %v, got %v", expected, filter)
+	}
+	if tag := o.AsgNameTag(); tag != "Group" {
+		t.Errorf("Expected the setting's name tag, got %v", tag)
+	}
+	if err := validateSetting("awsAsgFilter", "team=data,role"); err == nil {
+		t.Errorf("Expected an item without a value to be rejected")
+	}
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wish/nodereaper/pkg/cron"
+	batch_v1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// durationSettings and selectorSettings are the dynamic settings whose syntax Validate checks,
+// on top of booleans and deletionSchedule
+var (
+	durationSettings = map[string]bool{
+		"maxNodeLifetime":          true,
+		"transitionErrorWindow":    true,
+		"orphanedGroupGracePeriod": true,
+		"deletionAge":              true,
+		"deletionAgeJitter":        true,
+		"instanceImpairedPeriod":   true,
+		"cordonedDeletionAge":      true,
+		"podFailurePeriod":         true,
+		"startupGracePeriod":       true,
+		"providerIDTimeout":        true,
+		"preDrainHookTimeout":      true,
+		"preDeleteJobTimeout":      true,
+		"pollPeriod":               true,
+	}
+	selectorSettings = map[string]bool{
+		"ignoreSelector":      true,
+		"criticalPodSelector": true,
+	}
+)
+
+// Validate checks the flags and the dynamic configuration in one pass, and returns every problem found
+func (o *Ops) Validate() []error {
+	errs := []error{}
+
+	durations := []struct {
+		flag, value string
+		positive    bool
+	}{
+		{"poll-period", o.PollPeriod, true},
+		{"fast-poll-period", o.FastPollPeriod, false},
+		{"aws-poll-period", o.AwsPollPeriod, true},
+		{"api-timeout", o.APITimeout, true},
+		{"provider-timeout", o.ProviderTimeout, true},
+		{"log-repeat-interval", o.LogRepeatInterval, false},
+		{"http-read-timeout", o.HTTPReadTimeout, false},
+		{"http-write-timeout", o.HTTPWriteTimeout, false},
+		{"http-idle-timeout", o.HTTPIdleTimeout, false},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		if duration, err := ParseDuration(d.value); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid --%v: %v", d.flag, err))
+		} else if d.positive && duration <= 0 {
+			errs = append(errs, fmt.Errorf("--%v must be positive, got %v", d.flag, d.value))
+		}
+	}
+
+	labelFlags := []struct{ flag, value string }{
+		{"instance-group-label", o.InstanceGroupLabel},
+		{"force-deletion-label", o.ForceDeletionLabel},
+	}
+	for _, l := range ParseRequestLabels(o.RequestDeletionLabel) {
+		labelFlags = append(labelFlags, struct{ flag, value string }{"request-deletion-label", l.Label})
+	}
+	for _, l := range labelFlags {
+		if l.value == "" {
+			continue
+		}
+		key, value, _ := SplitLabel(l.value)
+		problems := append(validation.IsQualifiedName(key), validation.IsValidLabelValue(value)...)
+		if len(problems) > 0 {
+			errs = append(errs, fmt.Errorf("Invalid --%v %v: %v", l.flag, l.value, strings.Join(problems, ", ")))
+		}
+	}
+
+	if o.SecurityRecycleLabel != "" {
+		if problems := validation.IsQualifiedName(o.SecurityRecycleLabel); len(problems) > 0 {
+			errs = append(errs, fmt.Errorf("Invalid --security-recycle-label %v: %v", o.SecurityRecycleLabel, strings.Join(problems, ", ")))
+		}
+	}
+	if _, err := labels.Parse(o.NodeSelector); err != nil {
+		errs = append(errs, fmt.Errorf("Invalid --node-selector: %v", err))
+	}
+	if rules, err := ParseGroupRules(o.GroupRules); err != nil {
+		errs = append(errs, fmt.Errorf("Invalid --group-rules: %v", err))
+	} else {
+		for _, rule := range rules {
+			problems := validation.IsValidLabelValue(rule.Name)
+			if rule.Name == "" {
+				problems = append(problems, "must not be empty")
+			}
+			if len(problems) > 0 {
+				errs = append(errs, fmt.Errorf("Invalid --group-rules group name '%v': %v", rule.Name, strings.Join(problems, ", ")))
+			}
+		}
+	}
+	for _, item := range strings.Split(o.AwsAsgFilter, ",") {
+		if item == "" {
+			continue
+		}
+		if parts := strings.Split(item, "="); len(parts) != 2 || parts[0] == "" {
+			errs = append(errs, fmt.Errorf("Invalid --aws-asg-filter item %v, expected key=value", item))
+		}
+	}
+
+	if o.Shards < 1 {
+		errs = append(errs, fmt.Errorf("--shards must be positive, got %v", o.Shards))
+	}
+	if o.Clusters != "" {
+		if _, err := ParseClusters(o.Clusters); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid --clusters: %v", err))
+		}
+		if o.Kubeconfig == "" {
+			errs = append(errs, fmt.Errorf("--clusters requires --kubeconfig"))
+		}
+		// These are served on a single endpoint each, which can't tell the clusters apart
+		perCluster := []struct{ flag, value string }{
+			{"admin-token", o.AdminToken},
+			{"webhook-bind-address", o.WebhookBindAddress},
+			{"grpc-bind-address", o.GRPCBindAddress},
+			{"slack-bot-token", o.SlackBotToken},
+		}
+		if o.Shards > 1 {
+			perCluster = append(perCluster, struct{ flag, value string }{"shards", fmt.Sprint(o.Shards)})
+		}
+		for _, f := range perCluster {
+			if f.value != "" {
+				errs = append(errs, fmt.Errorf("--%v isn't supported with --clusters", f.flag))
+			}
+		}
+	}
+	if o.ProviderConcurrency <= 0 {
+		errs = append(errs, fmt.Errorf("--provider-concurrency must be positive, got %v", o.ProviderConcurrency))
+	}
+	if o.WebhookBindAddress != "" && (o.WebhookTLSCertFile == "" || o.WebhookTLSKeyFile == "") {
+		errs = append(errs, fmt.Errorf("The admission webhook requires --webhook-tls-cert-file and --webhook-tls-key-file"))
+	}
+	if o.GRPCBindAddress != "" && o.AdminToken == "" {
+		errs = append(errs, fmt.Errorf("--grpc-bind-address requires --admin-token"))
+	}
+	if o.WebhookGuardDeletion && o.WebhookBindAddress == "" {
+		errs = append(errs, fmt.Errorf("--webhook-guard-deletion requires --webhook-bind-address"))
+	}
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		errs = append(errs, fmt.Errorf("--tls-cert-file and --tls-key-file must be set together"))
+	}
+	if o.TLSClientCAFile != "" && o.TLSCertFile == "" {
+		errs = append(errs, fmt.Errorf("--tls-client-ca-file requires --tls-cert-file"))
+	}
+	if o.AdminAllowedClients != "" && o.TLSClientCAFile == "" {
+		errs = append(errs, fmt.Errorf("--admin-allowed-clients requires --tls-client-ca-file"))
+	}
+	if o.SlackBotToken != "" && (o.SlackChannel == "" || o.SlackSigningSecret == "") {
+		errs = append(errs, fmt.Errorf("Slack approvals require --slack-channel and --slack-signing-secret"))
+	}
+
+	if err := o.Reload(); err != nil {
+		errs = append(errs, fmt.Errorf("Error loading config: %v", err))
+	}
+	return append(errs, o.DynamicConfig.Validate()...)
+}
+
+// Validate checks every loaded setting, which would otherwise only fail, or panic, once it's used
+func (c *DynamicConfig) Validate() []error {
+	errs := []error{}
+	groups := []string{}
+	for group := range c.settings {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		keys := []string{}
+		for key := range c.settings[group] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := "global." + key
+			if group != "" {
+				name = "group." + group + "." + key
+			}
+			if err := validateSetting(key, c.settings[group][key]); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid setting %v: %v", name, err))
+			}
+		}
+	}
+	return errs
+}
+
+func validateSetting(key, value string) error {
+	defaultValue, ok := defaults[key]
+	if !ok {
+		return fmt.Errorf("Unknown setting")
+	}
+	switch {
+	case defaultValue == "true" || defaultValue == "false":
+		if value != "true" && value != "false" {
+			return fmt.Errorf("'%v' is neither 'true' nor 'false'", value)
+		}
+	case durationSettings[key] && value != "":
+		if _, err := ParseDuration(value); err != nil {
+			return err
+		}
+	case selectorSettings[key]:
+		if _, err := labels.Parse(value); err != nil {
+			return err
+		}
+	case key == "preDeleteJobTemplate" && value != "":
+		if err := yaml.UnmarshalStrict([]byte(value), &batch_v1.JobSpec{}); err != nil {
+			return err
+		}
+	case key == "nodeAction":
+		if value != NodeActionPowerOff && value != NodeActionReboot {
+			return fmt.Errorf("'%v' is neither '%v' nor '%v'", value, NodeActionPowerOff, NodeActionReboot)
+		}
+	case key == "deletionSchedule" && value != "":
+		if _, err := cron.ParseStandard(value); err != nil {
+			return err
+		}
+	case key == "awsAsgFilter":
+		for _, item := range strings.Split(value, ",") {
+			if parts := strings.Split(item, "="); item != "" && (len(parts) != 2 || parts[0] == "") {
+				return fmt.Errorf("Invalid item %v, expected key=value", item)
+			}
+		}
+	}
+	return nil
+}
@@ -1,8 +1,10 @@
 package configmap
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	core_v1 "k8s.io/api/core/v1"
@@ -12,21 +14,24 @@ import (
 
 // ConfigMap represents a configmap of some kind
 type ConfigMap struct {
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	namespace string
 	name      string
 	mu        *sync.Mutex
+	// timeout bounds every individual call to the API server
+	timeout time.Duration
 }
 
 // New creates a new ConfigMap
-func New(clientset *kubernetes.Clientset, namespace, name string) (*ConfigMap, error) {
+func New(ctx context.Context, clientset kubernetes.Interface, namespace, name string, timeout time.Duration) (*ConfigMap, error) {
 	cmap := &ConfigMap{
 		clientset,
 		namespace,
 		name,
 		&sync.Mutex{},
+		timeout,
 	}
-	_, err := cmap.getOrCreate()
+	_, err := cmap.getOrCreate(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -34,29 +39,39 @@ func New(clientset *kubernetes.Clientset, namespace, name string) (*ConfigMap, e
 }
 
 // Store stores the value at the given key
-func (c *ConfigMap) Store(key string, value *string) error {
+func (c *ConfigMap) Store(ctx context.Context, key string, value *string) error {
+	return c.StoreAll(ctx, map[string]*string{key: value})
+}
+
+// StoreAll stores every value at its key in a single write. A nil value deletes the key
+func (c *ConfigMap) StoreAll(ctx context.Context, values map[string]*string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	cmap, err := c.getOrCreate()
+	cmap, err := c.getOrCreate(ctx)
 	if err != nil {
 		return err
 	}
-	if value != nil {
-		cmap.Data[key] = *value
-	} else {
-		delete(cmap.Data, key)
+	for key, value := range values {
+		if value != nil {
+			cmap.Data[key] = *value
+		} else {
+			delete(cmap.Data, key)
+		}
 	}
-	_, err = c.clientset.CoreV1().ConfigMaps(c.namespace).Update(cmap)
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	_, err = c.clientset.CoreV1().ConfigMaps(c.namespace).Update(ctx, cmap, meta_v1.UpdateOptions{})
 	return err
 }
 
 // Load gets the value of the given key, or nil if it doesn't exist
-func (c *ConfigMap) Load(key string) (*string, error) {
+func (c *ConfigMap) Load(ctx context.Context, key string) (*string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	cmap, err := c.getOrCreate()
+	cmap, err := c.getOrCreate(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -66,15 +81,31 @@ func (c *ConfigMap) Load(key string) (*string, error) {
 	return nil, nil
 }
 
-func (c *ConfigMap) getOrCreate() (*core_v1.ConfigMap, error) {
-	cmap, err := c.clientset.CoreV1().ConfigMaps(c.namespace).Get(c.name, meta_v1.GetOptions{})
-	if err != nil || cmap == nil {
+// LoadAll gets every key and value, in a single read
+func (c *ConfigMap) LoadAll(ctx context.Context) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cmap, err := c.getOrCreate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cmap.Data, nil
+}
+
+func (c *ConfigMap) getOrCreate(ctx context.Context) (*core_v1.ConfigMap, error) {
+	getCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	cmap, err := c.clientset.CoreV1().ConfigMaps(c.namespace).Get(getCtx, c.name, meta_v1.GetOptions{})
+	if err != nil {
 		logrus.Infof("Failed to get configmap %v/%v, creating...", c.namespace, c.name)
-		cmap, err = c.clientset.CoreV1().ConfigMaps(c.namespace).Create(&core_v1.ConfigMap{
+		createCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		cmap, err = c.clientset.CoreV1().ConfigMaps(c.namespace).Create(createCtx, &core_v1.ConfigMap{
 			ObjectMeta: meta_v1.ObjectMeta{
 				Name: c.name,
 			},
-		})
+		}, meta_v1.CreateOptions{})
 		if err != nil {
 			return nil, fmt.Errorf("Error creating configmap %v/%v: %v", c.namespace, c.name, err)
 		}
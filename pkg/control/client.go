@@ -0,0 +1,53 @@
+package control
+
+import (
+	"context"
+	"crypto/tls"
+
+	pb "github.com/wish/nodereaper/proto/nodereaper/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client talks to the control API of a running nodereaper controller
+type Client struct {
+	pb.ControlClient
+	conn *grpc.ClientConn
+}
+
+// NewClient creates a control API client for the controller at target (e.g. localhost:9657),
+// over TLS if tlsConfig is set. Extra dial options, e.g. a custom dialer, are applied last
+func NewClient(target, token string, tlsConfig *tls.Config, opts ...grpc.DialOption) (*Client, error) {
+	transport := insecure.NewCredentials()
+	if tlsConfig != nil {
+		transport = credentials.NewTLS(tlsConfig)
+	}
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(transport),
+		grpc.WithPerRPCCredentials(bearerToken(token)),
+	}, opts...)
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{pb.NewControlClient(conn), conn}, nil
+}
+
+// Close closes the client's connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// bearerToken sends the token as "authorization: Bearer" metadata on every call
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false, as the admin API can be served over plain HTTP too
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
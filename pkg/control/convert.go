@@ -0,0 +1,78 @@
+package control
+
+import (
+	"time"
+
+	"github.com/wish/nodereaper/pkg/deletion"
+	pb "github.com/wish/nodereaper/proto/nodereaper/v1"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var states = map[deletion.State]pb.State{
+	deletion.DontWantDelete: pb.State_DONT_WANT_DELETE,
+	deletion.WantDelete:     pb.State_WANT_DELETE,
+	deletion.Detached:       pb.State_DETACHED,
+	deletion.ReadyToDelete:  pb.State_READY_TO_DELETE,
+	deletion.Deleting:       pb.State_DELETING,
+}
+
+func nodeToProto(node *deletion.NodeStatus) *pb.NodeStatus {
+	ret := &pb.NodeStatus{
+		Name:               node.Name,
+		Group:              node.Group,
+		State:              states[node.State],
+		Reason:             string(node.Reason),
+		RequestedReason:    node.RequestedReason,
+		RequestedBy:        node.RequestedBy,
+		SnoozedUntil:       timestamp(node.SnoozedUntil),
+		CreationTime:       timestamppb.New(node.CreationTime),
+		LastTransitionTime: timestamp(node.LastTransitionTime),
+	}
+	for _, blocker := range node.Blockers {
+		ret.Blockers = append(ret.Blockers, string(blocker))
+	}
+	return ret
+}
+
+func groupToProto(group *deletion.GroupStatus) *pb.GroupStatus {
+	ret := &pb.GroupStatus{
+		Name:            group.Name,
+		Key:             group.Key,
+		IsReal:          group.IsReal,
+		MaxSurge:        int64(group.MaxSurge),
+		MaxUnavailable:  int64(group.MaxUnavailable),
+		Paused:          group.Paused,
+		DeletionEnabled: group.DeletionEnabled,
+	}
+	if group.DesiredSize != nil {
+		ret.DesiredSize = proto.Int64(int64(*group.DesiredSize))
+	}
+	for i := range group.Nodes {
+		ret.Nodes = append(ret.Nodes, nodeToProto(&group.Nodes[i]))
+	}
+	return ret
+}
+
+func historyToProto(entry *deletion.HistoryEntry) *pb.HistoryEntry {
+	return &pb.HistoryEntry{
+		Node:        entry.Node,
+		Group:       entry.Group,
+		Reason:      string(entry.Reason),
+		RequestedBy: entry.RequestedBy,
+		Time:        timestamppb.New(entry.Time),
+		DeletionId:  entry.DeletionID,
+		Completed:   timestamp(entry.Completed),
+		Duration:    entry.Duration,
+		Outcome:     entry.Outcome,
+	}
+}
+
+// timestamp leaves unset times unset
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
@@ -0,0 +1,222 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/admin"
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/deletion"
+	pb "github.com/wish/nodereaper/proto/nodereaper/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RoleHeader is set in every response's header metadata to "leader", or "standby" for a replica
+// that only reports the leader's persisted state and can't act on nodes, like admin.RoleHeader
+const RoleHeader = "x-nodereaper-role"
+
+// Deleter is the part of *deletion.Deleter the control API serves, like admin.Deleter
+type Deleter interface {
+	Standby() bool
+	Groups() []deletion.GroupStatus
+	Group(name string) *deletion.GroupStatus
+	Node(name string) *deletion.NodeStatus
+	History() []deletion.HistoryEntry
+	RequestDeletion(ctx context.Context, nodeName, reason, requester string) error
+	SnoozeNode(ctx context.Context, nodeName string, duration time.Duration, requester string) error
+	Approve(ctx context.Context, nodeName string, approved bool, approver string) error
+	SetGroupPaused(ctx context.Context, groupName string, paused bool, requester string) error
+}
+
+// Server serves the gRPC control API, which mirrors the admin HTTP API
+type Server struct {
+	pb.UnimplementedControlServer
+	deleter Deleter
+	token   string
+}
+
+// New creates a control API server. Every call must carry the given token
+// as "authorization: Bearer" metadata
+func New(deleter Deleter, token string) *Server {
+	return &Server{deleter: deleter, token: token}
+}
+
+// NewGRPCServer creates a gRPC server with the control API registered on it
+func NewGRPCServer(deleter Deleter, token string, opts ...grpc.ServerOption) *grpc.Server {
+	s := New(deleter, token)
+	opts = append(opts, grpc.UnaryInterceptor(s.authenticate))
+	srv := grpc.NewServer(opts...)
+	pb.RegisterControlServer(srv, s)
+	return srv
+}
+
+// RequireClientCert rejects calls without a verified client certificate whose name is allowed,
+// like admin.RequireClientCert. Any verified certificate is accepted if allowed is empty
+func RequireClientCert(allowed []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var state *tls.ConnectionState
+		if p, ok := peer.FromContext(ctx); ok {
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				state = &tlsInfo.State
+			}
+		}
+		if !admin.ClientAllowed(state, allowed) {
+			logrus.Warnf("Rejected control API call %v from %v without an allowed client certificate", info.FullMethod, requester(ctx, ""))
+			return nil, status.Error(codes.PermissionDenied, "client certificate required")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authenticate rejects calls without the token, before they reach the deleter
+func (s *Server) authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if !strings.HasPrefix(auth, "Bearer ") {
+			continue
+		}
+		given := strings.TrimPrefix(auth, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) == 1 {
+			role := "leader"
+			if s.deleter.Standby() {
+				role = "standby"
+			}
+			grpc.SetHeader(ctx, metadata.Pairs(RoleHeader, role))
+			return handler(ctx, req)
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+// ListGroups returns every group and the state of each of its nodes
+func (s *Server) ListGroups(ctx context.Context, req *pb.ListGroupsRequest) (*pb.ListGroupsResponse, error) {
+	resp := &pb.ListGroupsResponse{}
+	for _, group := range s.deleter.Groups() {
+		resp.Groups = append(resp.Groups, groupToProto(&group))
+	}
+	return resp, nil
+}
+
+// GetGroup returns a single group
+func (s *Server) GetGroup(ctx context.Context, req *pb.GetGroupRequest) (*pb.GroupStatus, error) {
+	group := s.deleter.Group(req.GetName())
+	if group == nil {
+		return nil, status.Errorf(codes.NotFound, "group %v is not tracked by nodereaper", req.GetName())
+	}
+	return groupToProto(group), nil
+}
+
+// GetNode returns the state of a single node
+func (s *Server) GetNode(ctx context.Context, req *pb.GetNodeRequest) (*pb.NodeStatus, error) {
+	node := s.deleter.Node(req.GetName())
+	if node == nil {
+		return nil, status.Errorf(codes.NotFound, "node %v is not tracked by nodereaper", req.GetName())
+	}
+	return nodeToProto(node), nil
+}
+
+// RequestDeletion moves a node to want_delete on an operator's behalf
+func (s *Server) RequestDeletion(ctx context.Context, req *pb.RequestDeletionRequest) (*pb.NodeStatus, error) {
+	if req.GetReason() == "" {
+		return nil, status.Error(codes.InvalidArgument, "a reason is required")
+	}
+	err := s.deleter.RequestDeletion(ctx, req.GetNode(), req.GetReason(), requester(ctx, req.GetRequester()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.GetNode(ctx, &pb.GetNodeRequest{Name: req.GetNode()})
+}
+
+// SnoozeNode holds a node in its current state for a while
+func (s *Server) SnoozeNode(ctx context.Context, req *pb.SnoozeNodeRequest) (*pb.NodeStatus, error) {
+	if req.GetDuration() == "" {
+		return nil, status.Error(codes.InvalidArgument, "a duration is required")
+	}
+	duration, err := config.ParseDuration(req.GetDuration())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = s.deleter.SnoozeNode(ctx, req.GetNode(), duration, requester(ctx, req.GetRequester()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.GetNode(ctx, &pb.GetNodeRequest{Name: req.GetNode()})
+}
+
+// Approve answers the interactive approval request of a node in want_delete
+func (s *Server) Approve(ctx context.Context, req *pb.ApproveRequest) (*pb.NodeStatus, error) {
+	err := s.deleter.Approve(ctx, req.GetNode(), req.GetApproved(), requester(ctx, req.GetRequester()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.GetNode(ctx, &pb.GetNodeRequest{Name: req.GetNode()})
+}
+
+// PauseGroup stops moving nodes in the group past want_delete
+func (s *Server) PauseGroup(ctx context.Context, req *pb.SetGroupPausedRequest) (*pb.GroupStatus, error) {
+	return s.setGroupPaused(ctx, req, true)
+}
+
+// ResumeGroup undoes PauseGroup
+func (s *Server) ResumeGroup(ctx context.Context, req *pb.SetGroupPausedRequest) (*pb.GroupStatus, error) {
+	return s.setGroupPaused(ctx, req, false)
+}
+
+func (s *Server) setGroupPaused(ctx context.Context, req *pb.SetGroupPausedRequest, paused bool) (*pb.GroupStatus, error) {
+	err := s.deleter.SetGroupPaused(ctx, req.GetGroup(), paused, requester(ctx, req.GetRequester()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return s.GetGroup(ctx, &pb.GetGroupRequest{Name: req.GetGroup()})
+}
+
+// ListHistory returns the most recent deletions, oldest first
+func (s *Server) ListHistory(ctx context.Context, req *pb.ListHistoryRequest) (*pb.ListHistoryResponse, error) {
+	var since, until time.Time
+	if req.GetSince() != nil {
+		since = req.GetSince().AsTime()
+	}
+	if req.GetUntil() != nil {
+		until = req.GetUntil().AsTime()
+	}
+	resp := &pb.ListHistoryResponse{}
+	for _, entry := range deletion.FilterHistory(s.deleter.History(), since, until) {
+		resp.Entries = append(resp.Entries, historyToProto(&entry))
+	}
+	return resp, nil
+}
+
+// requester defaults to the caller's address, like the admin API does
+func requester(ctx context.Context, given string) string {
+	if given != "" {
+		return given
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// toStatus maps the deleter's errors to the gRPC codes matching the admin API's HTTP statuses
+func toStatus(err error) error {
+	switch err {
+	case deletion.ErrStandby:
+		return status.Error(codes.Unavailable, err.Error())
+	case deletion.ErrNodeNotTracked, deletion.ErrGroupNotTracked:
+		return status.Error(codes.NotFound, err.Error())
+	case deletion.ErrNodeIgnored, deletion.ErrAlreadyDeleting, deletion.ErrNotAwaitingApproval:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,342 @@
+package control
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/deletion"
+	"github.com/wish/nodereaper/pkg/metrics"
+	pb "github.com/wish/nodereaper/proto/nodereaper/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeDeleter tracks a single node of a single group, and records who acted on them
+type fakeDeleter struct {
+	node       deletion.NodeStatus
+	group      deletion.GroupStatus
+	history    []deletion.HistoryEntry
+	standby    bool
+	requester  string
+	snoozedFor time.Duration
+}
+
+func (d *fakeDeleter) Standby() bool                    { return d.standby }
+func (d *fakeDeleter) History() []deletion.HistoryEntry { return d.history }
+
+func (d *fakeDeleter) Groups() []deletion.GroupStatus {
+	return []deletion.GroupStatus{*d.Group(d.group.Name)}
+}
+
+func (d *fakeDeleter) Group(name string) *deletion.GroupStatus {
+	if name != d.group.Name {
+		return nil
+	}
+	group := d.group
+	group.Nodes = []deletion.NodeStatus{d.node}
+	return &group
+}
+
+func (d *fakeDeleter) Node(name string) *deletion.NodeStatus {
+	if name != d.node.Name {
+		return nil
+	}
+	node := d.node
+	return &node
+}
+
+func (d *fakeDeleter) act(nodeName, requester string) error {
+	if d.standby {
+		return deletion.ErrStandby
+	}
+	if nodeName != d.node.Name {
+		return deletion.ErrNodeNotTracked
+	}
+	d.requester = requester
+	return nil
+}
+
+func (d *fakeDeleter) RequestDeletion(ctx context.Context, nodeName, reason, requester string) error {
+	if err := d.act(nodeName, requester); err != nil {
+		return err
+	}
+	if d.node.State == deletion.Deleting {
+		return deletion.ErrAlreadyDeleting
+	}
+	d.node.State, d.node.Reason, d.node.RequestedReason = deletion.WantDelete, metrics.OperatorRequested, reason
+	return nil
+}
+
+func (d *fakeDeleter) SnoozeNode(ctx context.Context, nodeName string, duration time.Duration, requester string) error {
+	if err := d.act(nodeName, requester); err != nil {
+		return err
+	}
+	d.snoozedFor = duration
+	return nil
+}
+
+func (d *fakeDeleter) Approve(ctx context.Context, nodeName string, approved bool, approver string) error {
+	if err := d.act(nodeName, approver); err != nil {
+		return err
+	}
+	if d.node.State != deletion.WantDelete {
+		return deletion.ErrNotAwaitingApproval
+	}
+	if approved {
+		d.node.State = deletion.ReadyToDelete
+	}
+	return nil
+}
+
+func (d *fakeDeleter) SetGroupPaused(ctx context.Context, groupName string, paused bool, requester string) error {
+	if groupName != d.group.Name {
+		return deletion.ErrGroupNotTracked
+	}
+	d.group.Paused, d.requester = paused, requester
+	return nil
+}
+
+// newTestClient serves the control API for deleter over an in-memory connection
+func newTestClient(t *testing.T, deleter Deleter, token string) *Client {
+	return dialTest(t, serveTest(t, deleter), token, nil)
+}
+
+// serveTest serves the control API for deleter on an in-memory listener
+func serveTest(t *testing.T, deleter Deleter, opts ...grpc.ServerOption) *bufconn.Listener {
+	listener := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(deleter, "secret", opts...)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+	return listener
+}
+
+func dialTest(t *testing.T, listener *bufconn.Listener, token string, tlsConfig *tls.Config) *Client {
+	client, err := NewClient("passthrough:///bufnet", token, tlsConfig, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// issueCert creates a certificate for name signed by ca, or self-signed if ca is nil
+func issueCert(t *testing.T, name string, ca *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := template, interface{}(key)
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestRoundTrip(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	size := 3
+	deleter := &fakeDeleter{
+		node: deletion.NodeStatus{
+			Name: "node", Group: "group", State: deletion.DontWantDelete, CreationTime: created,
+			Blockers: []deletion.Blocker{deletion.MaxSurgeReached},
+		},
+		group: deletion.GroupStatus{Name: "group", Key: "___ig___group", IsReal: true, DesiredSize: &size, MaxSurge: 1},
+		history: []deletion.HistoryEntry{
+			{Node: "old", Group: "group", Reason: metrics.TooOld, Time: created},
+			{Node: "new", Group: "group", Reason: metrics.TooOld, Time: created.Add(time.Hour), Outcome: "succeeded"},
+		},
+	}
+	client := newTestClient(t, deleter, "secret")
+	ctx := context.Background()
+
+	var header metadata.MD
+	groups, err := client.ListGroups(ctx, &pb.ListGroupsRequest{}, grpc.Header(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role := header.Get(RoleHeader); len(role) != 1 || role[0] != "leader" {
+		t.Errorf("Expected the leader role in the header, got %v", role)
+	}
+	if len(groups.Groups) != 1 || groups.Groups[0].GetDesiredSize() != 3 || groups.Groups[0].MaxSurge != 1 || len(groups.Groups[0].Nodes) != 1 {
+		t.Fatalf("Unexpected groups %v", groups)
+	}
+	node := groups.Groups[0].Nodes[0]
+	if node.State != pb.State_DONT_WANT_DELETE || !node.CreationTime.AsTime().Equal(created) || len(node.Blockers) != 1 || node.Blockers[0] != "max_surge_reached" {
+		t.Errorf("Unexpected node %v", node)
+	}
+	if node.SnoozedUntil != nil || node.LastTransitionTime != nil {
+		t.Errorf("Expected unset times to stay unset, got %v", node)
+	}
+
+	node, err = client.RequestDeletion(ctx, &pb.RequestDeletionRequest{Node: "node", Reason: "bad disk", Requester: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.State != pb.State_WANT_DELETE || node.Reason != "operator_requested" || node.RequestedReason != "bad disk" || deleter.requester != "alice" {
+		t.Errorf("Unexpected node %v requested by %v", node, deleter.requester)
+	}
+
+	if _, err = client.SnoozeNode(ctx, &pb.SnoozeNodeRequest{Node: "node", Duration: "2h"}); err != nil {
+		t.Fatal(err)
+	}
+	if deleter.snoozedFor != 2*time.Hour || deleter.requester != "bufconn" {
+		t.Errorf("Expected a 2h snooze by the caller's address, got %v by %v", deleter.snoozedFor, deleter.requester)
+	}
+
+	group, err := client.PauseGroup(ctx, &pb.SetGroupPausedRequest{Group: "group", Requester: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !group.Paused || deleter.requester != "bob" {
+		t.Errorf("Expected the group paused by bob, got %v by %v", group, deleter.requester)
+	}
+
+	node, err = client.Approve(ctx, &pb.ApproveRequest{Node: "node", Approved: true, Requester: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.State != pb.State_READY_TO_DELETE || deleter.requester != "carol" {
+		t.Errorf("Expected the node approved by carol, got %v by %v", node, deleter.requester)
+	}
+
+	history, err := client.ListHistory(ctx, &pb.ListHistoryRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Entries) != 2 || history.Entries[1].Node != "new" || history.Entries[1].Outcome != "succeeded" {
+		t.Errorf("Unexpected history %v", history)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	deleter := &fakeDeleter{
+		node:  deletion.NodeStatus{Name: "node", Group: "group", State: deletion.Deleting},
+		group: deletion.GroupStatus{Name: "group"},
+	}
+	client := newTestClient(t, deleter, "secret")
+	unauthorized := newTestClient(t, deleter, "wrong")
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		call     func() error
+		standby  bool
+		expected codes.Code
+	}{
+		{"wrong token", func() error {
+			_, err := unauthorized.GetNode(ctx, &pb.GetNodeRequest{Name: "node"})
+			return err
+		}, false, codes.Unauthenticated},
+		{"unknown node", func() error {
+			_, err := client.GetNode(ctx, &pb.GetNodeRequest{Name: "other"})
+			return err
+		}, false, codes.NotFound},
+		{"unknown group", func() error {
+			_, err := client.ResumeGroup(ctx, &pb.SetGroupPausedRequest{Group: "other"})
+			return err
+		}, false, codes.NotFound},
+		{"no reason", func() error {
+			_, err := client.RequestDeletion(ctx, &pb.RequestDeletionRequest{Node: "node"})
+			return err
+		}, false, codes.InvalidArgument},
+		{"invalid duration", func() error {
+			_, err := client.SnoozeNode(ctx, &pb.SnoozeNodeRequest{Node: "node", Duration: "soon"})
+			return err
+		}, false, codes.InvalidArgument},
+		{"already deleting", func() error {
+			_, err := client.RequestDeletion(ctx, &pb.RequestDeletionRequest{Node: "node", Reason: "bad disk"})
+			return err
+		}, false, codes.FailedPrecondition},
+		{"not awaiting approval", func() error {
+			_, err := client.Approve(ctx, &pb.ApproveRequest{Node: "node", Approved: false})
+			return err
+		}, false, codes.FailedPrecondition},
+		{"standby", func() error {
+			_, err := client.RequestDeletion(ctx, &pb.RequestDeletionRequest{Node: "node", Reason: "bad disk"})
+			return err
+		}, true, codes.Unavailable},
+	}
+	for _, test := range tests {
+		deleter.standby = test.standby
+		if code := status.Code(test.call()); code != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.name, test.expected, code)
+		}
+	}
+}
+
+func TestClientCert(t *testing.T) {
+	deleter := &fakeDeleter{
+		node:  deletion.NodeStatus{Name: "node", Group: "group", State: deletion.DontWantDelete},
+		group: deletion.GroupStatus{Name: "group"},
+	}
+	ca := issueCert(t, "ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	listener := serveTest(t, deleter,
+		grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{issueCert(t, "bufnet", &ca)},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		})),
+		grpc.ChainUnaryInterceptor(RequireClientCert([]string{"ops"})),
+	)
+	allowed, other := issueCert(t, "ops", &ca), issueCert(t, "deploy", &ca)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		cert     *tls.Certificate
+		expected codes.Code
+	}{
+		{"allowed", &allowed, codes.OK},
+		{"not allowed", &other, codes.PermissionDenied},
+		// The handshake fails, so the call never reaches the server
+		{"no certificate", nil, codes.Unavailable},
+	}
+	for _, test := range tests {
+		tlsConfig := &tls.Config{RootCAs: pool, ServerName: "bufnet"}
+		if test.cert != nil {
+			tlsConfig.Certificates = []tls.Certificate{*test.cert}
+		}
+		client := dialTest(t, listener, "secret", tlsConfig)
+		if _, err := client.GetNode(ctx, &pb.GetNodeRequest{Name: "node"}); status.Code(err) != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.name, test.expected, err)
+		}
+	}
+}
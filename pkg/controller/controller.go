@@ -1,128 +1,216 @@
 package controller
 
 import (
+	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	core_v1 "k8s.io/api/core/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/watch"
-	listers_v1 "k8s.io/client-go/listers/core/v1"
 )
 
-// Controller calls onChange when the resource changes
+const (
+	// groupIndex indexes nodes by the value of their instance group label
+	groupIndex = "group"
+	// resyncPeriod is how often the cache replays every object, making extra sure nothing was missed
+	resyncPeriod = 5 * time.Minute
+)
+
+// Controller reads nodes, pods and PodDisruptionBudgets from the manager's cache, and writes through the clientset
 type Controller struct {
-	Clientset *kubernetes.Clientset
-	informer  cache.Controller
-	indexer   cache.Indexer
-	lister    listers_v1.NodeLister
+	Clientset kubernetes.Interface
+	// Dynamic reaches the custom resources that have no typed client
+	Dynamic dynamic.Interface
+	reader  client.Reader
+	synced  func() bool
+}
+
+// CacheOptions restricts the manager's cache to the nodes matching nodeSelector, if it's set
+func CacheOptions(nodeSelector string) (cache.Options, error) {
+	opts := cache.Options{SyncPeriod: ptr(resyncPeriod)}
+	if nodeSelector == "" {
+		return opts, nil
+	}
+	selector, err := labels.Parse(nodeSelector)
+	if err != nil {
+		return opts, err
+	}
+	opts.ByObject = map[client.Object]cache.ByObject{&core_v1.Node{}: {Label: selector}}
+	return opts, nil
 }
 
-// Run starts the controller loop
-func (c *Controller) Run(stopCh <-chan struct{}) {
-	go c.informer.Run(stopCh)
+// NodeCacheOptions restricts the manager's cache to a single node and the pods on it, for the daemon running there
+func NodeCacheOptions(nodeName string) cache.Options {
+	return cache.Options{
+		SyncPeriod: ptr(resyncPeriod),
+		ByObject: map[client.Object]cache.ByObject{
+			&core_v1.Node{}: {Field: fields.OneTermEqualSelector("metadata.name", nodeName)},
+			&core_v1.Pod{}:  {Field: fields.OneTermEqualSelector("spec.nodeName", nodeName)},
+		},
+	}
+}
 
-	// Wait for the caches to be synced before starting workers
-	logrus.Info("Waiting for initial cache sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.informer.HasSynced); !ok {
-		logrus.Error("Failed to sync informer cache")
-		return
+// New creates a controller over the manager's cache, which it fills with every node, pod and
+// PodDisruptionBudget once the manager starts. A non-empty groupLabel indexes the nodes by
+// that label for NodesInGroup
+func New(mgr manager.Manager, groupLabel string) (*Controller, error) {
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
 	}
-	logrus.Info("cache synced")
+
+	ctx := context.Background()
+	indexer := mgr.GetFieldIndexer()
+	for _, index := range Indexes(groupLabel) {
+		if err := indexer.IndexField(ctx, index.Object, index.Field, index.Extract); err != nil {
+			return nil, err
+		}
+	}
+	// Informers are otherwise only started on first use, which would block a poll until they sync
+	for _, obj := range []client.Object{&core_v1.Node{}, &core_v1.Pod{}, &policy_v1.PodDisruptionBudget{}} {
+		if _, err := mgr.GetCache().GetInformer(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+
+	synced, err := TrackCacheSync(mgr)
+	if err != nil {
+		return nil, err
+	}
+	return &Controller{
+		Clientset: clientset,
+		Dynamic:   dynamicClient,
+		reader:    mgr.GetCache(),
+		synced:    synced,
+	}, nil
+}
+
+// TrackCacheSync returns a function reporting whether the manager's caches have synced, for health checks
+func TrackCacheSync(mgr manager.Manager) (func() bool, error) {
+	var synced atomic.Bool
+	// The manager only starts runnables once its caches have synced
+	err := mgr.Add(EveryReplica(func(ctx context.Context) error {
+		synced.Store(true)
+		logrus.Info("cache synced")
+		return nil
+	}))
+	return synced.Load, err
+}
+
+// everyReplica is a runnable that runs whether or not the replica holds the leader lease
+type everyReplica manager.RunnableFunc
+
+// EveryReplica wraps f as a runnable that the manager starts on every replica, not only on the leader
+func EveryReplica(f func(ctx context.Context) error) manager.Runnable {
+	return everyReplica(f)
+}
+
+func (r everyReplica) Start(ctx context.Context) error {
+	return r(ctx)
+}
+
+func (r everyReplica) NeedLeaderElection() bool {
+	return false
+}
+
+// HasSynced returns true once every informer has done its initial list
+func (c *Controller) HasSynced() bool {
+	return c.synced()
 }
 
 // NodeByName returns the node with the given name, or nil if it doesn't exist
 func (c *Controller) NodeByName(name string) (*core_v1.Node, error) {
-	nodeIface, exists, err := c.indexer.GetByKey(name)
+	node := &core_v1.Node{}
+	err := c.reader.Get(context.Background(), client.ObjectKey{Name: name}, node)
+	if k8s_errors.IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
-		return nil, nil
+	return node, nil
+}
+
+// HasNode asks the API server whether the node exists, without waiting for the cache to sync
+func (c *Controller) HasNode(ctx context.Context, name string) (bool, error) {
+	_, err := c.Clientset.CoreV1().Nodes().Get(ctx, name, meta_v1.GetOptions{})
+	if k8s_errors.IsNotFound(err) {
+		return false, nil
 	}
-	return nodeIface.(*core_v1.Node), nil
+	return err == nil, err
 }
 
 // ListNodes returns every node in the cluster
 func (c *Controller) ListNodes() ([]*core_v1.Node, error) {
-	return c.lister.List(labels.Everything())
+	return c.listNodes()
 }
 
-// NewController creates a controller that calls the given function on resource changes
-func NewController(nodeName *string, handler *func(*core_v1.Node)) (*Controller, error) {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
-	}
+// NodesInGroup returns every node whose instance group label is the given group, from the cache.
+// Returns no nodes if the controller wasn't created with an instance group label
+func (c *Controller) NodesInGroup(group string) ([]*core_v1.Node, error) {
+	return c.listNodes(client.MatchingFields{groupIndex: group})
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
+func (c *Controller) listNodes(opts ...client.ListOption) ([]*core_v1.Node, error) {
+	// Like the listers the cache replaces, objects are shared with the cache and must not be modified
+	list := &core_v1.NodeList{}
+	if err := c.reader.List(context.Background(), list, append(opts, client.UnsafeDisableDeepCopy)...); err != nil {
 		return nil, err
 	}
-
-	var lw *cache.ListWatch
-	if nodeName == nil {
-		lw = &cache.ListWatch{
-			ListFunc: func(opts meta_v1.ListOptions) (runtime.Object, error) {
-				return clientset.CoreV1().Nodes().List(opts)
-			},
-			WatchFunc: func(opts meta_v1.ListOptions) (watch.Interface, error) {
-				return clientset.CoreV1().Nodes().Watch(opts)
-			},
-		}
-	} else {
-		lw = cache.NewListWatchFromClient(
-			clientset.CoreV1().RESTClient(),
-			"nodes",
-			meta_v1.NamespaceAll,
-			fields.OneTermEqualSelector("metadata.name", *nodeName),
-		)
-	}
-
-	handlerFuncs := cache.ResourceEventHandlerFuncs{}
-	if handler != nil {
-		handlerFuncs = cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				if node, ok := obj.(*core_v1.Node); ok {
-					(*handler)(node)
-				}
-			},
-			UpdateFunc: func(oldObj, obj interface{}) {
-				if node, ok := obj.(*core_v1.Node); ok {
-					(*handler)(node)
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				if node, ok := obj.(*core_v1.Node); ok {
-					(*handler)(node)
-				}
-			},
-		}
+	nodes := make([]*core_v1.Node, len(list.Items))
+	for i := range list.Items {
+		nodes[i] = &list.Items[i]
 	}
+	return nodes, nil
+}
 
-	indexer, informer := cache.NewIndexerInformer(
-		lw,
-		&core_v1.Node{},
-		5*time.Minute, // Do a full update every 5 minutes, making extra sure nothing was missed
-		handlerFuncs,
-		cache.Indexers{},
-	)
+// Index is a field index of the cache the controller reads from
+type Index struct {
+	Object  client.Object
+	Field   string
+	Extract client.IndexerFunc
+}
 
-	lister := listers_v1.NewNodeLister(indexer)
+// Indexes returns the indexes the controller's queries need. A non-empty groupLabel indexes the
+// nodes by that label for NodesInGroup
+func Indexes(groupLabel string) []Index {
+	groupOf := func(client.Object) []string { return nil }
+	if groupLabel != "" {
+		groupOf = func(obj client.Object) []string {
+			return []string{obj.GetLabels()[groupLabel]}
+		}
+	}
+	return []Index{
+		{&core_v1.Node{}, groupIndex, groupOf},
+		{&core_v1.Pod{}, nodeNameIndex, podNodeName},
+		{&core_v1.Pod{}, ownerIndex, podOwner},
+	}
+}
 
-	controller := Controller{
-		clientset,
-		informer,
-		indexer,
-		lister,
+// NewForReader creates a controller that reads from reader, which must have the fields of Indexes, instead
+// of a manager's cache. It doesn't watch the cluster and is always synced, e.g. to evaluate fixed nodes in tests
+func NewForReader(reader client.Reader) *Controller {
+	return &Controller{
+		reader: reader,
+		synced: func() bool { return true },
 	}
+}
 
-	return &controller, nil
+func ptr[T any](v T) *T {
+	return &v
 }
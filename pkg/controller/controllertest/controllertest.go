@@ -0,0 +1,23 @@
+// Package controllertest creates controllers over fixed objects, for tests
+package controllertest
+
+import (
+	"github.com/wish/nodereaper/pkg/controller"
+	core_v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// New creates a controller over a fixed set of nodes and pods, which doesn't watch the cluster or sync
+func New(nodes []*core_v1.Node, pods []*core_v1.Pod) *controller.Controller {
+	builder := fake.NewClientBuilder()
+	for _, index := range controller.Indexes("") {
+		builder = builder.WithIndex(index.Object, index.Field, index.Extract)
+	}
+	for _, node := range nodes {
+		builder = builder.WithObjects(node.DeepCopy())
+	}
+	for _, pod := range pods {
+		builder = builder.WithObjects(pod.DeepCopy())
+	}
+	return controller.NewForReader(builder.Build())
+}
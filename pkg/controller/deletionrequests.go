@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// NodeDeletionRequestGroup is the API group of NodeDeletionRequests, see deploy/nodedeletionrequest-crd.yaml
+	NodeDeletionRequestGroup = "nodereaper.wish.com"
+	// NodeDeletionRequestVersion is the served version of NodeDeletionRequests
+	NodeDeletionRequestVersion = "v1alpha1"
+)
+
+// NodeDeletionRequest asks the controller to delete a node, and reports its progress in Status
+type NodeDeletionRequest struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata"`
+	Spec               NodeDeletionRequestSpec   `json:"spec"`
+	Status             NodeDeletionRequestStatus `json:"status,omitempty"`
+}
+
+// NodeDeletionRequestSpec names the node to delete, and why
+type NodeDeletionRequestSpec struct {
+	NodeName string `json:"nodeName"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// NodeDeletionRequestStatus is written by the controller as it deletes the node
+type NodeDeletionRequestStatus struct {
+	// Phase is empty until the controller first sees the request
+	Phase   string `json:"phase,omitempty"`
+	Message string `json:"message,omitempty"`
+	// NodeState is the node's state of deletion, while the controller tracks the node
+	NodeState          string                         `json:"nodeState,omitempty"`
+	ObservedGeneration int64                          `json:"observedGeneration,omitempty"`
+	Conditions         []NodeDeletionRequestCondition `json:"conditions,omitempty"`
+}
+
+// NodeDeletionRequestCondition records when the request reached a phase
+type NodeDeletionRequestCondition struct {
+	Type               string                  `json:"type"`
+	Status             core_v1.ConditionStatus `json:"status"`
+	Reason             string                  `json:"reason,omitempty"`
+	Message            string                  `json:"message,omitempty"`
+	LastTransitionTime meta_v1.Time            `json:"lastTransitionTime"`
+}
+
+// NodeDeletionRequestList is a list of NodeDeletionRequests
+type NodeDeletionRequestList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata"`
+	Items            []NodeDeletionRequest `json:"items"`
+}
+
+// Like Machines, NodeDeletionRequests and PreDeleteHooks have no typed client and go through the dynamic client
+func crdResource(resource string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: NodeDeletionRequestGroup, Version: NodeDeletionRequestVersion, Resource: resource}
+}
+
+// ListNodeDeletionRequests lists the NodeDeletionRequests in every namespace
+func (c *Controller) ListNodeDeletionRequests(ctx context.Context) ([]NodeDeletionRequest, error) {
+	list, err := c.Dynamic.Resource(crdResource("nodedeletionrequests")).List(ctx, meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]NodeDeletionRequest, len(list.Items))
+	for i, item := range list.Items {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &requests[i]); err != nil {
+			return nil, fmt.Errorf("Error parsing NodeDeletionRequest %v/%v: %v", item.GetNamespace(), item.GetName(), err)
+		}
+	}
+	return requests, nil
+}
+
+// UpdateNodeDeletionRequestStatus replaces the status of a NodeDeletionRequest. It fails
+// with a conflict if the request changed since it was read
+func (c *Controller) UpdateNodeDeletionRequestStatus(ctx context.Context, request *NodeDeletionRequest) error {
+	request.APIVersion = NodeDeletionRequestGroup + "/" + NodeDeletionRequestVersion
+	request.Kind = "NodeDeletionRequest"
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(request)
+	if err != nil {
+		return err
+	}
+	_, err = c.Dynamic.Resource(crdResource("nodedeletionrequests")).Namespace(request.Namespace).UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, meta_v1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/config"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// EventNamespace is where node events are created, matching the kubelet's behaviour
+	EventNamespace = meta_v1.NamespaceDefault
+)
+
+// RecordNodeEvent creates a kubernetes Event attached to the given node. Events about a deletion are
+// annotated with its deletionID
+func (c *Controller) RecordNodeEvent(ctx context.Context, node *core_v1.Node, deletionID, component, eventType, reason, message string) error {
+	return PostNodeEvent(ctx, c.Clientset, node, deletionID, component, eventType, reason, message)
+}
+
+// PostNodeEvent is RecordNodeEvent for callers with only a clientset, like nodereaperd
+func PostNodeEvent(ctx context.Context, clientset kubernetes.Interface, node *core_v1.Node, deletionID, component, eventType, reason, message string) error {
+	now := meta_v1.NewTime(time.Now())
+	var annotations map[string]string
+	if deletionID != "" {
+		annotations = map[string]string{config.DeletionIDAnnotation: deletionID}
+	}
+	_, err := clientset.CoreV1().Events(EventNamespace).Create(ctx, &core_v1.Event{
+		ObjectMeta: meta_v1.ObjectMeta{
+			GenerateName: node.Name + ".",
+			Namespace:    EventNamespace,
+			Annotations:  annotations,
+		},
+		InvolvedObject: core_v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       node.Name,
+			UID:        node.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         core_v1.EventSource{Component: component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, meta_v1.CreateOptions{})
+	return err
+}
@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	k8s_runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/clientcmd/api/latest"
+)
+
+// RestConfig builds the client config for talking to the cluster. With neither a kubeconfig
+// nor a master URL it uses the in-cluster service account. master overrides the kubeconfig's server
+func RestConfig(kubeconfig, master, kubeContext string) (*rest.Config, error) {
+	if kubeconfig == "" && master == "" {
+		return rest.InClusterConfig()
+	}
+	if kubeconfig == "" {
+		return &rest.Config{Host: master}, nil
+	}
+
+	// Like kubectl, accept a $KUBECONFIG style list, but only the first file is read
+	path := strings.Split(kubeconfig, string(os.PathListSeparator))[0]
+	config, err := LoadKubeconfig(path, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	if master != "" {
+		config.Host = master
+	}
+	return config, nil
+}
+
+// SetClientLimits sets the client-side rate limits and identifies requests from component
+// in the user agent, so cluster admins can tell nodereaper's traffic apart. Zero values
+// keep client-go's defaults
+func SetClientLimits(config *rest.Config, component string, qps float32, burst int) {
+	config.QPS = qps
+	config.Burst = burst
+	config.UserAgent = fmt.Sprintf("%v (%v/%v)", component, runtime.GOOS, runtime.GOARCH)
+}
+
+// DefaultKubeconfigPath returns the kubeconfig kubectl would use: the first entry
+// of $KUBECONFIG, falling back to ~/.kube/config
+func DefaultKubeconfigPath() string {
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return strings.Split(env, string(os.PathListSeparator))[0]
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// LoadKubeconfig builds a client config from a kubeconfig file, using the named context
+// or the file's current-context if kubeContext is empty.
+// Only a single file is read; multiple $KUBECONFIG entries are not merged
+func LoadKubeconfig(path, kubeContext string) (*rest.Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading kubeconfig %v: %v", path, err)
+	}
+	obj, err := k8s_runtime.Decode(latest.Codec, contents)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing kubeconfig %v: %v", path, err)
+	}
+	kubeconfig, ok := obj.(*clientcmdapi.Config)
+	if !ok {
+		return nil, fmt.Errorf("Kubeconfig %v has unexpected type %T", path, obj)
+	}
+
+	if kubeContext == "" {
+		kubeContext = kubeconfig.CurrentContext
+	}
+	ctx, ok := kubeconfig.Contexts[kubeContext]
+	if !ok {
+		return nil, fmt.Errorf("Context '%v' not found in kubeconfig %v", kubeContext, path)
+	}
+	cluster, ok := kubeconfig.Clusters[ctx.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("Cluster '%v' not found in kubeconfig %v", ctx.Cluster, path)
+	}
+	authInfo, ok := kubeconfig.AuthInfos[ctx.AuthInfo]
+	if !ok {
+		authInfo = &clientcmdapi.AuthInfo{}
+	}
+	if authInfo.AuthProvider != nil {
+		return nil, fmt.Errorf("Auth provider '%v' in kubeconfig %v is not supported, use a token, client certificate or exec plugin", authInfo.AuthProvider.Name, path)
+	}
+
+	// Relative paths in a kubeconfig are relative to the file itself
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	config := &rest.Config{
+		Host:            cluster.Server,
+		BearerToken:     authInfo.Token,
+		BearerTokenFile: resolve(authInfo.TokenFile),
+		Username:        authInfo.Username,
+		Password:        authInfo.Password,
+		ExecProvider:    authInfo.Exec,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure: cluster.InsecureSkipTLSVerify,
+			CAFile:   resolve(cluster.CertificateAuthority),
+			CAData:   cluster.CertificateAuthorityData,
+			CertFile: resolve(authInfo.ClientCertificate),
+			CertData: authInfo.ClientCertificateData,
+			KeyFile:  resolve(authInfo.ClientKey),
+			KeyData:  authInfo.ClientKeyData,
+		},
+		Impersonate: rest.ImpersonationConfig{
+			UserName: authInfo.Impersonate,
+			Groups:   authInfo.ImpersonateGroups,
+			Extra:    authInfo.ImpersonateUserExtra,
+		},
+	}
+	return config, nil
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	coordination_v1 "k8s.io/api/coordination/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// leaseDuration is how long a lease that isn't renewed is kept, before another replica takes over
+	leaseDuration = time.Minute
+	// renewDeadline is how long the leader keeps trying to renew its lease before giving up leadership
+	renewDeadline = 40 * time.Second
+	// retryPeriod is how often the leader renews its lease, and standbys try to take it over
+	retryPeriod = 10 * time.Second
+)
+
+// LeaseInfo describes a Lease as last written
+type LeaseInfo struct {
+	Leader        string    `json:"leader"`
+	LastLeaseTime time.Time `json:"lastLeaseTime"`
+	MyID          string    `json:"myID"`
+	Held          bool      `json:"held"`
+}
+
+// LeaderElection has the manager elect its leader with the Lease of the given name in namespace, held
+// as id. The lease is released on shutdown, so another replica can take over without waiting for it to expire
+func LeaderElection(opts *manager.Options, clientset kubernetes.Interface, namespace, name, id string) error {
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, namespace, name,
+		clientset.CoreV1(), clientset.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: id})
+	if err != nil {
+		return err
+	}
+	opts.LeaderElection = true
+	opts.LeaderElectionResourceLockInterface = lock
+	opts.LeaderElectionReleaseOnCancel = true
+	opts.LeaseDuration = ptr(leaseDuration)
+	opts.RenewDeadline = ptr(renewDeadline)
+	opts.RetryPeriod = ptr(retryPeriod)
+	return nil
+}
+
+// ClaimLease takes the Lease of the given name in namespace for id, if it's free or expired, or renews it
+// if id holds it, and returns whether id holds it. A manager electing its leader with the same Lease and
+// id then leads straight away
+func ClaimLease(ctx context.Context, clientset kubernetes.Interface, namespace, name, id string) (bool, error) {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	now := meta_v1.NewMicroTime(time.Now())
+	spec := coordination_v1.LeaseSpec{
+		HolderIdentity:       &id,
+		LeaseDurationSeconds: ptr(int32(leaseDuration.Seconds())),
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+
+	lease, err := leases.Get(ctx, name, meta_v1.GetOptions{})
+	if k8s_errors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordination_v1.Lease{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       spec,
+		}, meta_v1.CreateOptions{})
+		if k8s_errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	holder := leaseHolder(lease)
+	if holder != "" && holder != id && !leaseExpired(lease, now.Time) {
+		return false, nil
+	}
+	if holder == id {
+		spec.AcquireTime = lease.Spec.AcquireTime
+		spec.LeaseTransitions = lease.Spec.LeaseTransitions
+	} else {
+		transitions := int32(0)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		spec.LeaseTransitions = &transitions
+	}
+	lease.Spec = spec
+	// Another replica claiming the lease at the same time makes the update conflict
+	_, err = leases.Update(ctx, lease, meta_v1.UpdateOptions{})
+	if k8s_errors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// KeepLease renews the Lease of the given name in namespace, claimed by id with ClaimLease, until
+// stop is closed or ctx is cancelled. The claim would otherwise expire if the manager's caches take
+// longer than the lease duration to sync, before its elector takes the lease over
+func KeepLease(ctx context.Context, clientset kubernetes.Interface, namespace, name, id string, stop <-chan struct{}) {
+	ticker := time.NewTicker(retryPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		held, err := ClaimLease(ctx, clientset, namespace, name, id)
+		if err != nil {
+			logrus.Warnf("Error renewing lease %v: %v", name, err)
+		} else if !held {
+			logrus.Errorf("Lease %v was taken over by another replica", name)
+		}
+	}
+}
+
+// LeaseHolder returns who holds the Lease of the given name in namespace, or "" if it's free or expired
+func LeaseHolder(ctx context.Context, clientset kubernetes.Interface, namespace, name string) (string, error) {
+	lease, err := clientset.CoordinationV1().Leases(namespace).Get(ctx, name, meta_v1.GetOptions{})
+	if k8s_errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if leaseExpired(lease, time.Now()) {
+		return "", nil
+	}
+	return leaseHolder(lease), nil
+}
+
+// GetLeaseInfo reads the Lease of the given name in namespace, without trying to acquire it
+func GetLeaseInfo(ctx context.Context, clientset kubernetes.Interface, namespace, name, id string) (LeaseInfo, error) {
+	info := LeaseInfo{MyID: id}
+	lease, err := clientset.CoordinationV1().Leases(namespace).Get(ctx, name, meta_v1.GetOptions{})
+	if k8s_errors.IsNotFound(err) {
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	info.Leader = leaseHolder(lease)
+	if lease.Spec.RenewTime != nil {
+		info.LastLeaseTime = lease.Spec.RenewTime.Time
+	}
+	info.Held = info.Leader != "" && info.Leader == id && !leaseExpired(lease, time.Now())
+	return info, nil
+}
+
+func leaseHolder(lease *coordination_v1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+func leaseExpired(lease *coordination_v1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expiry)
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	coordination_v1 "k8s.io/api/coordination/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClaimLease(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewClientset()
+	leases := clientset.CoordinationV1().Leases("ns")
+	renewedAt := func(renewed time.Time) *coordination_v1.Lease {
+		lease, err := leases.Get(ctx, "shard", meta_v1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lease.Spec.RenewTime = &meta_v1.MicroTime{Time: renewed}
+		if lease, err = leases.Update(ctx, lease, meta_v1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		return lease
+	}
+
+	if holder, err := LeaseHolder(ctx, clientset, "ns", "shard"); err != nil || holder != "" {
+		t.Fatalf("Expected a missing lease to be free, got %q, %v", holder, err)
+	}
+	if held, err := ClaimLease(ctx, clientset, "ns", "shard", "a"); err != nil || !held {
+		t.Fatalf("Expected a to claim a missing lease, got %v, %v", held, err)
+	}
+	if held, err := ClaimLease(ctx, clientset, "ns", "shard", "b"); err != nil || held {
+		t.Fatalf("Expected b not to claim a's lease, got %v, %v", held, err)
+	}
+
+	// Claiming a lease we hold renews it
+	acquired := renewedAt(time.Now().Add(-30 * time.Second)).Spec.AcquireTime.Time
+	if held, err := ClaimLease(ctx, clientset, "ns", "shard", "a"); err != nil || !held {
+		t.Fatalf("Expected a to renew its lease, got %v, %v", held, err)
+	}
+	lease, _ := leases.Get(ctx, "shard", meta_v1.GetOptions{})
+	if time.Since(lease.Spec.RenewTime.Time) > time.Second || !lease.Spec.AcquireTime.Time.Equal(acquired) {
+		t.Errorf("Expected the lease to be renewed without being acquired again, got %+v", lease.Spec)
+	}
+	if holder, err := LeaseHolder(ctx, clientset, "ns", "shard"); err != nil || holder != "a" {
+		t.Errorf("Expected the lease to be held by a, got %q, %v", holder, err)
+	}
+
+	// An expired lease is free for anyone to take over
+	renewedAt(time.Now().Add(-time.Hour))
+	if holder, err := LeaseHolder(ctx, clientset, "ns", "shard"); err != nil || holder != "" {
+		t.Errorf("Expected an expired lease to be free, got %q, %v", holder, err)
+	}
+	if held, err := ClaimLease(ctx, clientset, "ns", "shard", "b"); err != nil || !held {
+		t.Fatalf("Expected b to take over the expired lease, got %v, %v", held, err)
+	}
+	if holder, err := LeaseHolder(ctx, clientset, "ns", "shard"); err != nil || holder != "b" {
+		t.Errorf("Expected the lease to be held by b, got %q, %v", holder, err)
+	}
+}
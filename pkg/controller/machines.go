@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"context"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8s_types "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// MachineAnnotation is set by Cluster API on nodes it manages, naming the node's Machine
+	MachineAnnotation = "cluster.x-k8s.io/machine"
+	// MachineNamespaceAnnotation is set by Cluster API alongside MachineAnnotation
+	MachineNamespaceAnnotation = "cluster.x-k8s.io/cluster-namespace"
+	// MachineGroup is the API group of Cluster API Machines
+	MachineGroup = "cluster.x-k8s.io"
+)
+
+// Cluster API is a CRD with no typed client, so Machines go through the dynamic client
+func machineResource(version string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: MachineGroup, Version: version, Resource: "machines"}
+}
+
+// PatchMachine patches a Cluster API Machine
+func (c *Controller) PatchMachine(ctx context.Context, version, namespace, name string, pt k8s_types.PatchType, data []byte) error {
+	_, err := c.Dynamic.Resource(machineResource(version)).Namespace(namespace).Patch(ctx, name, pt, data, meta_v1.PatchOptions{})
+	return err
+}
+
+// DeleteMachine deletes a Cluster API Machine, which drains and deletes its node and instance
+func (c *Controller) DeleteMachine(ctx context.Context, version, namespace, name string) error {
+	return c.Dynamic.Resource(machineResource(version)).Namespace(namespace).Delete(ctx, name, meta_v1.DeleteOptions{})
+}
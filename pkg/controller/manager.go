@@ -0,0 +1,26 @@
+package controller
+
+import (
+	"sync"
+
+	"github.com/bombsimon/logrusr/v4"
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+)
+
+var setLogger sync.Once
+
+// NewManager creates a controller-runtime manager for the cluster at config, logging through logrus.
+// Metrics and health checks are served on the listener of the binary along with its other endpoints,
+// so the manager doesn't serve them itself
+func NewManager(config *rest.Config, opts manager.Options) (manager.Manager, error) {
+	setLogger.Do(func() {
+		log.SetLogger(logrusr.New(logrus.StandardLogger()))
+	})
+	opts.Metrics = metricsserver.Options{BindAddress: "0"}
+	opts.HealthProbeBindAddress = "0"
+	return manager.New(config, opts)
+}
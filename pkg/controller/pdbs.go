@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"context"
+
+	core_v1 "k8s.io/api/core/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ListPodDisruptionBudgets returns every PodDisruptionBudget in the cluster, from the cache
+func (c *Controller) ListPodDisruptionBudgets() ([]*policy_v1.PodDisruptionBudget, error) {
+	return c.listPDBs()
+}
+
+// PodDisruptionBudgetsForPod returns the PodDisruptionBudgets whose selector matches the pod.
+// A pod covered by no budget returns an empty list and no error
+func (c *Controller) PodDisruptionBudgetsForPod(pod *core_v1.Pod) ([]*policy_v1.PodDisruptionBudget, error) {
+	pdbs, err := c.listPDBs(client.InNamespace(pod.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	ret := []*policy_v1.PodDisruptionBudget{}
+	for _, pdb := range pdbs {
+		// Like the PodDisruptionBudget lister, an empty selector matches nothing
+		selector, err := meta_v1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		ret = append(ret, pdb)
+	}
+	return ret, nil
+}
+
+func (c *Controller) listPDBs(opts ...client.ListOption) ([]*policy_v1.PodDisruptionBudget, error) {
+	if c.reader == nil {
+		return nil, ErrPodsNotWatched
+	}
+	list := &policy_v1.PodDisruptionBudgetList{}
+	if err := c.reader.List(context.Background(), list, append(opts, client.UnsafeDisableDeepCopy)...); err != nil {
+		return nil, err
+	}
+	pdbs := make([]*policy_v1.PodDisruptionBudget, len(list.Items))
+	for i := range list.Items {
+		pdbs[i] = &list.Items[i]
+	}
+	return pdbs, nil
+}
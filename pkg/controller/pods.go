@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_types "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// nodeNameIndex indexes pods by spec.nodeName
+	nodeNameIndex = "nodeName"
+	// ownerIndex indexes pods by the UID of their controller, e.g. a ReplicaSet
+	ownerIndex = "owner"
+)
+
+// ErrPodsNotWatched is returned when querying pods or PDBs from a controller without a cache
+var ErrPodsNotWatched = errors.New("controller does not watch pods")
+
+func podNodeName(obj client.Object) []string {
+	pod, ok := obj.(*core_v1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil
+	}
+	return []string{pod.Spec.NodeName}
+}
+
+func podOwner(obj client.Object) []string {
+	owner := meta_v1.GetControllerOf(obj)
+	if owner == nil {
+		return nil
+	}
+	return []string{string(owner.UID)}
+}
+
+// PodsByOwner returns every pod controlled by the object with the given UID, from the cache
+func (c *Controller) PodsByOwner(uid k8s_types.UID) ([]*core_v1.Pod, error) {
+	return c.listPods(client.MatchingFields{ownerIndex: string(uid)})
+}
+
+// PodsOnNode returns every pod scheduled on the given node, from the cache
+func (c *Controller) PodsOnNode(nodeName string) ([]*core_v1.Pod, error) {
+	return c.listPods(client.MatchingFields{nodeNameIndex: nodeName})
+}
+
+func (c *Controller) listPods(opts ...client.ListOption) ([]*core_v1.Pod, error) {
+	if c.reader == nil {
+		return nil, ErrPodsNotWatched
+	}
+	list := &core_v1.PodList{}
+	if err := c.reader.List(context.Background(), list, append(opts, client.UnsafeDisableDeepCopy)...); err != nil {
+		return nil, err
+	}
+	pods := make([]*core_v1.Pod, len(list.Items))
+	for i := range list.Items {
+		pods[i] = &list.Items[i]
+	}
+	return pods, nil
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	batch_v1 "k8s.io/api/batch/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// PreDeleteHook is a template for a Job the controller runs before draining a node,
+// see deploy/predeletehook-crd.yaml
+type PreDeleteHook struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata"`
+	Spec               PreDeleteHookSpec `json:"spec"`
+}
+
+// PreDeleteHookSpec is the Job to run for each node
+type PreDeleteHookSpec struct {
+	// PinToNode runs the Job's pod on the node being deleted
+	PinToNode bool            `json:"pinToNode,omitempty"`
+	Template  JobTemplateSpec `json:"template"`
+}
+
+// JobTemplateSpec describes the Job created from a PreDeleteHook
+type JobTemplateSpec struct {
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+	Spec               batch_v1.JobSpec `json:"spec"`
+}
+
+// GetPreDeleteHook gets a PreDeleteHook. Like NodeDeletionRequests, they go through the dynamic client
+func (c *Controller) GetPreDeleteHook(ctx context.Context, namespace, name string) (*PreDeleteHook, error) {
+	obj, err := c.Dynamic.Resource(crdResource("predeletehooks")).Namespace(namespace).Get(ctx, name, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	hook := &PreDeleteHook{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, hook); err != nil {
+		return nil, fmt.Errorf("Error parsing PreDeleteHook %v/%v: %v", namespace, name, err)
+	}
+	return hook, nil
+}
+
+// GetJob gets a Job
+func (c *Controller) GetJob(ctx context.Context, namespace, name string) (*batch_v1.Job, error) {
+	return c.Clientset.BatchV1().Jobs(namespace).Get(ctx, name, meta_v1.GetOptions{})
+}
+
+// CreateJob creates a Job
+func (c *Controller) CreateJob(ctx context.Context, job *batch_v1.Job) (*batch_v1.Job, error) {
+	return c.Clientset.BatchV1().Jobs(job.Namespace).Create(ctx, job, meta_v1.CreateOptions{})
+}
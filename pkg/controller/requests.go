@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"context"
+
+	core_v1 "k8s.io/api/core/v1"
+	policy_v1 "k8s.io/api/policy/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_types "k8s.io/apimachinery/pkg/types"
+)
+
+// PatchNode patches a node
+func (c *Controller) PatchNode(ctx context.Context, name string, pt k8s_types.PatchType, data []byte) (*core_v1.Node, error) {
+	return c.Clientset.CoreV1().Nodes().Patch(ctx, name, pt, data, meta_v1.PatchOptions{})
+}
+
+// EvictPod evicts a pod through the Eviction API, which respects PodDisruptionBudgets.
+// A TooManyRequests error means a budget doesn't allow the eviction yet
+func (c *Controller) EvictPod(ctx context.Context, pod *core_v1.Pod) error {
+	return c.Clientset.CoreV1().Pods(pod.Namespace).EvictV1(ctx, &policy_v1.Eviction{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	})
+}
+
+// DeleteNode deletes a node object
+func (c *Controller) DeleteNode(ctx context.Context, name string) error {
+	return c.Clientset.CoreV1().Nodes().Delete(ctx, name, meta_v1.DeleteOptions{})
+}
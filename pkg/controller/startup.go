@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// startupBackoff retries a startup dependency for about a minute and a half, long enough
+// to ride out a control plane restart
+var startupBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    8,
+	Cap:      30 * time.Second,
+}
+
+// RetryStartup calls f until it succeeds, backing off between attempts. It returns f's last
+// error if f still fails once startupBackoff is exhausted
+func RetryStartup(what string, f func() error) error {
+	var lastErr error
+	err := wait.ExponentialBackoff(startupBackoff, func() (bool, error) {
+		if lastErr = f(); lastErr != nil {
+			logrus.Warnf("Error %v, retrying: %v", what, lastErr)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return lastErr
+	}
+	return nil
+}
@@ -83,3 +83,33 @@ func TestMinues(t *testing.T) {
 		}
 	}
 }
+
+func TestWindows(t *testing.T) {
+	// Weekends from 6 to 8 pm
+	s, err := ParseStandard("* 18-20 * * 0,6")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Saturday the 6th, during the first window
+	from := time.Date(2021, time.March, 6, 19, 30, 0, 0, time.UTC)
+	windows := s.Windows(from, 3, from.Add(14*24*time.Hour))
+	expected := []Window{
+		{time.Date(2021, time.March, 6, 19, 30, 0, 0, time.UTC), time.Date(2021, time.March, 6, 21, 0, 0, 0, time.UTC)},
+		{time.Date(2021, time.March, 7, 18, 0, 0, 0, time.UTC), time.Date(2021, time.March, 7, 21, 0, 0, 0, time.UTC)},
+		{time.Date(2021, time.March, 13, 18, 0, 0, 0, time.UTC), time.Date(2021, time.March, 13, 21, 0, 0, 0, time.UTC)},
+	}
+	if len(windows) != len(expected) {
+		t.Fatalf("Expected %v windows, got %v", len(expected), windows)
+	}
+	for i := range expected {
+		if !windows[i].Start.Equal(expected[i].Start) || !windows[i].End.Equal(expected[i].End) {
+			t.Errorf("Expected window %v to be %v, got %v", i, expected[i], windows[i])
+		}
+	}
+
+	// Nothing matches before the limit
+	if windows := s.Windows(from.Add(2*time.Hour), 3, from.Add(12*time.Hour)); len(windows) != 0 {
+		t.Errorf("Expected no windows, got %v", windows)
+	}
+}
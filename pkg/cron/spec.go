@@ -81,3 +81,28 @@ func dayMatches(s *Schedule, t time.Time) bool {
 	}
 	return domMatch || dowMatch
 }
+
+// Window is a span of consecutive minutes matched by a schedule
+type Window struct {
+	Start, End time.Time
+}
+
+// Windows returns up to n windows matched by the schedule, starting with the one containing
+// from, if any. Times are matched in from's location. A window still open at limit ends there
+func (s *Schedule) Windows(from time.Time, n int, limit time.Time) []Window {
+	windows := []Window{}
+	var start time.Time
+	for t := from.Truncate(time.Minute); t.Before(limit) && len(windows) < n; t = t.Add(time.Minute) {
+		matches := s.Matches(t)
+		if matches && start.IsZero() {
+			start = t
+		} else if !matches && !start.IsZero() {
+			windows = append(windows, Window{start, t})
+			start = time.Time{}
+		}
+	}
+	if !start.IsZero() && len(windows) < n {
+		windows = append(windows, Window{start, limit})
+	}
+	return windows
+}
@@ -0,0 +1,99 @@
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/wish/nodereaper/pkg/deletion"
+)
+
+// Notifier posts Datadog events when node deletions start, finish or fail,
+// so node churn can be overlaid on dashboards
+type Notifier struct {
+	apiKey string
+	url    string
+	tags   []string
+}
+
+type event struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	AlertType      string   `json:"alert_type"`
+	AggregationKey string   `json:"aggregation_key"`
+	SourceTypeName string   `json:"source_type_name"`
+	Tags           []string `json:"tags"`
+}
+
+// New creates a notifier for the given Datadog site, e.g. datadoghq.com. tags are added to every event
+func New(apiKey, site string, tags []string) *Notifier {
+	return &Notifier{
+		apiKey: apiKey,
+		url:    fmt.Sprintf("https://api.%v/api/v1/events", site),
+		tags:   tags,
+	}
+}
+
+// Notify posts an event for the phases that start, finish or fail a deletion, and ignores the rest
+func (n *Notifier) Notify(ctx context.Context, e deletion.LifecycleEvent) error {
+	var title, alertType string
+	switch e.Phase {
+	case string(deletion.Deleting):
+		title, alertType = fmt.Sprintf("Nodereaper started deleting node %v", e.Node), "info"
+	case deletion.DeletedPhase:
+		title, alertType = fmt.Sprintf("Nodereaper deleted node %v", e.Node), "success"
+	case deletion.FailedPhase:
+		title, alertType = fmt.Sprintf("Nodereaper failed to delete node %v", e.Node), "error"
+	default:
+		return nil
+	}
+
+	text := fmt.Sprintf("Node %v in group %v", e.Node, e.Group)
+	if e.Reason != "" {
+		text += fmt.Sprintf(", deleted because %v", e.Reason)
+	}
+	if e.RequestedBy != "" {
+		text += fmt.Sprintf(", requested by %v", e.RequestedBy)
+	}
+	if e.Error != "" {
+		text += fmt.Sprintf(": %v", e.Error)
+	}
+
+	tags := append([]string{
+		"group:" + e.Group,
+		"node:" + e.Node,
+		"phase:" + e.Phase,
+	}, n.tags...)
+	if e.Reason != "" {
+		tags = append(tags, "reason:"+string(e.Reason))
+	}
+	if e.DeletionID != "" {
+		tags = append(tags, "deletion_id:"+e.DeletionID)
+	}
+	body, _ := json.Marshal(event{
+		Title:          title,
+		Text:           text,
+		AlertType:      alertType,
+		AggregationKey: e.Node,
+		SourceTypeName: "nodereaper",
+		Tags:           tags,
+	})
+
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", n.apiKey)
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Error posting Datadog event: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Datadog returned %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package deletion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/metrics"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// ApprovalRequest is POSTed to a group's approvalWebhook before a node leaves WantDelete
+type ApprovalRequest struct {
+	Node        string         `json:"node"`
+	Group       string         `json:"group"`
+	Reason      metrics.Reason `json:"reason,omitempty"`
+	RequestedBy string         `json:"requestedBy,omitempty"`
+}
+
+// ApprovalResponse is the webhook's answer. Deletion only proceeds if Allowed is true
+type ApprovalResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// approvals remembers which nodes the approval webhook last turned down, for status
+type approvals struct {
+	mu     sync.Mutex
+	denied map[string]string
+}
+
+func (a *approvals) set(nodeName string, allowed bool, reason string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if allowed {
+		delete(a.denied, nodeName)
+	} else {
+		a.denied[nodeName] = reason
+	}
+}
+
+func (a *approvals) isDenied(nodeName string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.denied[nodeName]
+	return ok
+}
+
+// Approver asks a person to approve a node's deletion, e.g. in chat. Their answer
+// is passed back through Deleter.Approve
+type Approver interface {
+	RequestApproval(ctx context.Context, req ApprovalRequest) error
+}
+
+// SetApprover sets who is asked to approve deletions in groups with interactiveApproval
+func (d *Deleter) SetApprover(a Approver) {
+	d.approver = a
+}
+
+// approved returns true if the node may leave WantDelete, according to
+// the group's approvalWebhook and interactiveApproval
+func (d *Deleter) approved(ctx context.Context, node *core_v1.Node) (bool, error) {
+	if ok, err := d.webhookApproved(ctx, node); !ok {
+		return false, err
+	}
+	return d.interactivelyApproved(ctx, node)
+}
+
+// interactivelyApproved requests approval from the Approver the first time the node is checked,
+// then holds it until someone answers. The request and answer are persisted with the node's state
+func (d *Deleter) interactivelyApproved(ctx context.Context, node *core_v1.Node) (bool, error) {
+	if !d.opts.GetBool(d.groupName(node), "interactiveApproval") {
+		return true, nil
+	}
+	nodeState := d.nodeState(node)
+	if nodeState == nil {
+		return false, ErrNodeNotTracked
+	}
+	switch {
+	case nodeState.ApprovedBy != "":
+		return true, nil
+	case nodeState.DeniedBy != "" || nodeState.ApprovalRequested:
+		return false, nil
+	case d.approver == nil:
+		return false, fmt.Errorf("Group of node %v requires interactiveApproval, but no approver is configured", node.Name)
+	}
+
+	entry := d.historyEntry(node)
+	err := d.approver.RequestApproval(ctx, ApprovalRequest{
+		Node:        entry.Node,
+		Group:       entry.Group,
+		Reason:      entry.Reason,
+		RequestedBy: entry.RequestedBy,
+	})
+	if err != nil {
+		return false, fmt.Errorf("Error requesting approval to delete node %v: %v", node.Name, err)
+	}
+	logrus.Infof("Requested approval to delete node %v", node.Name)
+	nodeState.ApprovalRequested = true
+	return false, nil
+}
+
+// webhookApproved asks the group's approvalWebhook whether the node may be removed. Nodes
+// in groups without a webhook are always approved. Denials are retried every poll
+func (d *Deleter) webhookApproved(ctx context.Context, node *core_v1.Node) (bool, error) {
+	url := d.opts.GetString(d.groupName(node), "approvalWebhook")
+	if url == "" {
+		return true, nil
+	}
+
+	entry := d.historyEntry(node)
+	body, _ := json.Marshal(ApprovalRequest{
+		Node:        entry.Node,
+		Group:       entry.Group,
+		Reason:      entry.Reason,
+		RequestedBy: entry.RequestedBy,
+	})
+	ctx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("Error creating approval request for node %v: %v", node.Name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("Error requesting approval to delete node %v: %v", node.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("Approval webhook returned %v for node %v", resp.Status, node.Name)
+	}
+
+	response := ApprovalResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return false, fmt.Errorf("Error parsing approval response for node %v: %v", node.Name, err)
+	}
+	d.approvals.set(node.Name, response.Allowed, response.Reason)
+	if !response.Allowed {
+		logrus.Infof("Deletion of node %v was not approved: %v", node.Name, response.Reason)
+	}
+	return response.Allowed, nil
+}
@@ -0,0 +1,88 @@
+package deletion
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/metrics"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeApprover records the approval requests it gets
+type fakeApprover struct {
+	requests []ApprovalRequest
+}
+
+func (a *fakeApprover) RequestApproval(ctx context.Context, req ApprovalRequest) error {
+	a.requests = append(a.requests, req)
+	return nil
+}
+
+func TestApproved(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := ApprovalRequest{}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch req.Node {
+		case "allowed":
+			json.NewEncoder(w).Encode(ApprovalResponse{Allowed: true})
+		case "denied":
+			json.NewEncoder(w).Encode(ApprovalResponse{Allowed: false, Reason: "change freeze"})
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer webhook.Close()
+
+	tests := []struct {
+		name           string
+		settings       map[string]string
+		node           string
+		nodeState      NodeState
+		approver       bool
+		approved       bool
+		err            bool
+		requested      bool
+		denialRecorded bool
+	}{
+		{"no approval needed", nil, "allowed", NodeState{}, false, true, false, false, false},
+		{"webhook allows", map[string]string{"global.approvalWebhook": webhook.URL}, "allowed", NodeState{}, false, true, false, false, false},
+		{"webhook denies", map[string]string{"global.approvalWebhook": webhook.URL}, "denied", NodeState{}, false, false, false, false, true},
+		{"webhook fails", map[string]string{"global.approvalWebhook": webhook.URL}, "failing", NodeState{}, false, false, true, false, false},
+		{"approval requested", map[string]string{"global.interactiveApproval": "true"}, "node", NodeState{}, true, false, false, true, false},
+		{"waiting for an answer", map[string]string{"global.interactiveApproval": "true"}, "node", NodeState{ApprovalRequested: true}, true, false, false, false, false},
+		{"approved", map[string]string{"global.interactiveApproval": "true"}, "node", NodeState{ApprovalRequested: true, ApprovedBy: "alice"}, true, true, false, false, false},
+		{"denied", map[string]string{"global.interactiveApproval": "true"}, "node", NodeState{ApprovalRequested: true, DeniedBy: "alice"}, true, false, false, false, false},
+		{"no approver", map[string]string{"global.interactiveApproval": "true"}, "node", NodeState{}, false, false, true, false, false},
+		{"webhook denies before asking", map[string]string{"global.approvalWebhook": webhook.URL, "global.interactiveApproval": "true"}, "denied", NodeState{}, true, false, false, false, true},
+	}
+	for _, test := range tests {
+		opts := &config.Ops{APITimeout: "10s"}
+		opts.Load(test.settings)
+		d := New(opts, nil, &fakeProvider{}, nil, metrics.New())
+		approver := &fakeApprover{}
+		if test.approver {
+			d.SetApprover(approver)
+		}
+		nodeState := test.nodeState
+		nodeState.Name, nodeState.State, nodeState.Reason = test.node, WantDelete, metrics.TooOld
+		d.states.Groups["___nogroup___"] = &Group{Nodes: map[string]*NodeState{test.node: &nodeState}}
+		node := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: test.node}}
+
+		approved, err := d.approved(context.Background(), node)
+		if approved != test.approved || (err != nil) != test.err {
+			t.Errorf("%v: expected approved=%v and error=%v, got %v and %v", test.name, test.approved, test.err, approved, err)
+		}
+		if requested := len(approver.requests) > 0; requested != test.requested || (requested && !nodeState.ApprovalRequested) {
+			t.Errorf("%v: expected approval requested=%v, got %v requests", test.name, test.requested, len(approver.requests))
+		}
+		if denied := d.approvals.isDenied(test.node); denied != test.denialRecorded {
+			t.Errorf("%v: expected the webhook denial recorded=%v, got %v", test.name, test.denialRecorded, denied)
+		}
+	}
+}
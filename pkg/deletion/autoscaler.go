@@ -0,0 +1,50 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// scaleDownDisabledAnnotation stops cluster-autoscaler from scaling down a node
+	scaleDownDisabledAnnotation = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+	// deletingAnnotation marks nodes nodereaper annotated for cluster-autoscaler itself,
+	// so we don't mistake our own scaleDownDisabledAnnotation for an operator's
+	deletingAnnotation = "nodereaper.wish.com/deleting"
+)
+
+// autoscalerDisabledScaleDown returns true if an operator asked cluster-autoscaler not to
+// scale down the node, which we take to mean nodereaper shouldn't delete it either
+func autoscalerDisabledScaleDown(node *core_v1.Node) bool {
+	if _, ours := node.Annotations[deletingAnnotation]; ours {
+		return false
+	}
+	return node.Annotations[scaleDownDisabledAnnotation] == "true"
+}
+
+// annotateForAutoscaler marks a node we're about to delete as off limits to cluster-autoscaler
+// if global.annotateForAutoscaler is set. Otherwise, cluster-autoscaler could scale down the same
+// node, or pick another node in the group, reducing capacity twice
+func (d *Deleter) annotateForAutoscaler(ctx context.Context, node *core_v1.Node) error {
+	if !d.opts.GetBool("", "annotateForAutoscaler") {
+		return nil
+	}
+	if _, ok := node.Annotations[deletingAnnotation]; ok {
+		return nil
+	}
+	annotations := map[string]string{
+		deletingAnnotation: "true",
+	}
+	// Don't take over an annotation an operator set
+	if node.Annotations[scaleDownDisabledAnnotation] != "true" {
+		annotations[scaleDownDisabledAnnotation] = "true"
+	}
+	if err := d.patchNode(ctx, node.Name, nodePatch{Annotations: annotations}); err != nil {
+		return fmt.Errorf("Error annotating node %v for cluster-autoscaler: %v", node.Name, err)
+	}
+	logrus.Debugf("Annotated node %v so cluster-autoscaler leaves it alone", node.Name)
+	return nil
+}
@@ -0,0 +1,191 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/ratelog"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// errorRateMinSamples is the fewest transitions in the window before the error rate is trusted
+	errorRateMinSamples = 5
+)
+
+// breakers are the circuit breakers that suspend every group. Guarded by statesMu
+type breakers struct {
+	clusterDegraded   bool
+	errorRateExceeded bool
+}
+
+// updateCircuitBreaker suspends every group when more nodes are NotReady than
+// global.maxNotReadyNodes allows, so we never add churn to an already degraded cluster.
+// Nodes we are deleting ourselves don't count. Callers must hold statesMu
+func (d *Deleter) updateCircuitBreaker(ctx context.Context, allNodes []*core_v1.Node) {
+	value := d.opts.GetString("", "maxNotReadyNodes")
+
+	notReady := 0
+	for _, node := range allNodes {
+		if d.beingDeleted(node.Name) || nodeReady(node) {
+			continue
+		}
+		notReady++
+	}
+
+	open := false
+	if value != "" {
+		open = notReady > percentOrNumToNum(value, len(allNodes), false)
+	}
+	d.metrics.SetCircuitBreaker(open, notReady)
+
+	if open != d.breakers.clusterDegraded {
+		d.breakers.clusterDegraded = open
+		if open {
+			d.recordControllerEvent(ctx, core_v1.EventTypeWarning, "DeletionsSuspended", fmt.Sprintf("%v of %v nodes are NotReady, more than maxNotReadyNodes %v. Suspending deletions", notReady, len(allNodes), value))
+		} else {
+			d.recordControllerEvent(ctx, core_v1.EventTypeNormal, "DeletionsResumed", fmt.Sprintf("%v of %v nodes are NotReady, within maxNotReadyNodes %v. Resuming deletions", notReady, len(allNodes), value))
+		}
+	}
+	d.states.Suspended = d.breakers.clusterDegraded || d.breakers.errorRateExceeded
+}
+
+// updateErrorBreaker suspends every group when more than global.maxTransitionErrorRate of the
+// transitions attempted within global.transitionErrorWindow failed, so a systemic problem like a
+// broken IAM policy doesn't leave half the fleet detached. Callers must hold statesMu
+func (d *Deleter) updateErrorBreaker(ctx context.Context) {
+	window := 10 * time.Minute
+	if value := d.opts.GetString("", "transitionErrorWindow"); value != "" {
+		if parsed, err := config.ParseDuration(value); err == nil {
+			window = parsed
+		} else {
+			ratelog.Errorf("transition-error-window", "Could not parse transitionErrorWindow %v: %v", value, err)
+		}
+	}
+	rate, samples := d.transitionErrors.rate(window)
+
+	open := false
+	value := d.opts.GetString("", "maxTransitionErrorRate")
+	if value != "" && samples >= errorRateMinSamples {
+		open = rate*100 > float64(percentOrNumToNum(value, 100, false))
+	}
+	d.metrics.SetErrorBreaker(open, rate)
+
+	if open != d.breakers.errorRateExceeded {
+		d.breakers.errorRateExceeded = open
+		if open {
+			d.recordControllerEvent(ctx, core_v1.EventTypeWarning, "DeletionsSuspended", fmt.Sprintf("%.0f%% of %v transitions in the last %v failed, more than maxTransitionErrorRate %v. Suspending deletions", rate*100, samples, window, value))
+		} else {
+			d.recordControllerEvent(ctx, core_v1.EventTypeNormal, "DeletionsResumed", fmt.Sprintf("Transition error rate is within maxTransitionErrorRate %v. Resuming deletions", value))
+		}
+	}
+	d.states.Suspended = d.breakers.clusterDegraded || d.breakers.errorRateExceeded
+}
+
+// recordControllerEvent logs the message and records it as an event on our own node,
+// the closest thing the controller has to an object of its own. A standby only logs it
+// at debug level, as the leader reports the same thing. Callers must hold statesMu
+func (d *Deleter) recordControllerEvent(ctx context.Context, eventType, reason, msg string) {
+	if d.standby {
+		logrus.Debug(msg)
+		return
+	}
+	if eventType == core_v1.EventTypeWarning {
+		logrus.Warn(msg)
+	} else {
+		logrus.Info(msg)
+	}
+
+	myNode, err := d.controller.NodeByName(d.opts.NodeName)
+	if err != nil || myNode == nil {
+		return
+	}
+	eventCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+	defer cancel()
+	if err := d.controller.RecordNodeEvent(eventCtx, myNode, "", eventComponent, eventType, reason, msg); err != nil {
+		logrus.Warnf("Error recording %v event: %v", reason, err)
+	}
+}
+
+// beingDeleted returns true if we have already started deleting the node, in which case
+// it's expected to go NotReady. Callers must hold statesMu
+func (d *Deleter) beingDeleted(nodeName string) bool {
+	for _, group := range d.states.Groups {
+		if node, ok := group.Nodes[nodeName]; ok {
+			return node.State == ReadyToDelete || node.State == Deleting
+		}
+	}
+	return false
+}
+
+func nodeReady(node *core_v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == core_v1.NodeReady {
+			return condition.Status == core_v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// transitionOutcome is the result of a single attempted state transition
+type transitionOutcome struct {
+	time   time.Time
+	failed bool
+}
+
+// errorTracker keeps the outcomes of recent transitions, for the error rate breaker.
+// Safe for concurrent use, since groups advance in parallel
+type errorTracker struct {
+	mu       sync.Mutex
+	outcomes []transitionOutcome
+}
+
+func (t *errorTracker) record(failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.outcomes = append(t.outcomes, transitionOutcome{time.Now(), failed})
+}
+
+// rate drops outcomes older than window, and returns the proportion of the rest that failed
+func (t *errorTracker) rate(window time.Duration) (float64, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := time.Now().Add(-window)
+	kept := t.outcomes[:0]
+	failed := 0
+	for _, outcome := range t.outcomes {
+		if outcome.time.Before(cutoff) {
+			continue
+		}
+		kept = append(kept, outcome)
+		if outcome.failed {
+			failed++
+		}
+	}
+	t.outcomes = kept
+	if len(kept) == 0 {
+		return 0, 0
+	}
+	return float64(failed) / float64(len(kept)), len(kept)
+}
+
+// trackTransitions wraps f to record whether each transition that acts on a node failed, and
+// to count the nodes reaching each stage of deletion. Deciding whether we want to delete a node
+// isn't counted as a transition, since it only reads state
+func (d *Deleter) trackTransitions(f StateTransitionFunction) StateTransitionFunction {
+	return func(ctx context.Context, nodeName string, oldState, newState State) (bool, error) {
+		ok, err := f(ctx, nodeName, oldState, newState)
+		if oldState != DontWantDelete {
+			d.transitionErrors.record(err != nil)
+		}
+		if ok {
+			d.recordStage(nodeName, newState)
+		}
+		return ok, err
+	}
+}
@@ -0,0 +1,23 @@
+package deletion
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorTrackerRate(t *testing.T) {
+	tracker := &errorTracker{}
+	tracker.outcomes = append(tracker.outcomes, transitionOutcome{time.Now().Add(-time.Hour), true})
+	tracker.record(true)
+	tracker.record(false)
+	tracker.record(false)
+	tracker.record(false)
+
+	rate, samples := tracker.rate(10 * time.Minute)
+	if samples != 4 {
+		t.Errorf("Expected outcomes outside the window to be dropped, got %v samples", samples)
+	}
+	if rate != 0.25 {
+		t.Errorf("Expected an error rate of 0.25, got %v", rate)
+	}
+}
@@ -0,0 +1,64 @@
+package deletion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/controller"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_types "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// deleteMachineAnnotation makes a MachineSet remove this Machine first when scaling down
+	deleteMachineAnnotation = "cluster.x-k8s.io/delete-machine"
+)
+
+// nodeMachine returns the Cluster API Machine backing the node, if any
+func nodeMachine(node *core_v1.Node) (namespace, name string, ok bool) {
+	name, ok = node.Annotations[controller.MachineAnnotation]
+	if !ok || name == "" {
+		return "", "", false
+	}
+	namespace = node.Annotations[controller.MachineNamespaceAnnotation]
+	if namespace == "" {
+		namespace = meta_v1.NamespaceDefault
+	}
+	return namespace, name, true
+}
+
+// detachMachine is DetachNode for Cluster API: the Machine is marked for deletion,
+// and left in place until the node is ready to be deleted
+func (d *Deleter) detachMachine(ctx context.Context, node *core_v1.Node) error {
+	namespace, name, _ := nodeMachine(node)
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				deleteMachineAnnotation: "nodereaper",
+			},
+		},
+	})
+	ctx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+	defer cancel()
+	if err := d.controller.PatchMachine(ctx, d.opts.ClusterAPIVersion, namespace, name, k8s_types.MergePatchType, patch); err != nil {
+		return fmt.Errorf("Error annotating machine %v/%v for node %v: %v", namespace, name, node.Name, err)
+	}
+	logrus.Infof("Marked machine %v/%v of node %v for deletion", namespace, name, node.Name)
+	return nil
+}
+
+// deleteMachine hands the node over to Cluster API, which drains it, deletes it and
+// terminates its instance. The owning MachineSet creates a replacement
+func (d *Deleter) deleteMachine(ctx context.Context, node *core_v1.Node) error {
+	namespace, name, _ := nodeMachine(node)
+	ctx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+	defer cancel()
+	if err := d.controller.DeleteMachine(ctx, d.opts.ClusterAPIVersion, namespace, name); err != nil {
+		return fmt.Errorf("Error deleting machine %v/%v for node %v: %v", namespace, name, node.Name, err)
+	}
+	logrus.Infof("Deleted machine %v/%v of node %v", namespace, name, node.Name)
+	return nil
+}
@@ -0,0 +1,34 @@
+package deletion
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// observeCordon records since when the node has been cordoned. Only cordons of nodes nodereaper
+// doesn't want to delete count, so its own cordons while draining are ignored
+func (n *NodeState) observeCordon(unschedulable bool, now time.Time) {
+	if !unschedulable || n.State != DontWantDelete {
+		n.CordonedSince = nil
+	} else if n.CordonedSince == nil {
+		n.CordonedSince = &now
+	}
+}
+
+// cordonedTooLong returns true if the node has been cordoned for longer than the group's
+// cordonedDeletionAge, and the group doesn't opt out with keepCordonedNodes
+func (d *Deleter) cordonedTooLong(node *core_v1.Node) bool {
+	groupName := d.groupName(node)
+	age := d.opts.GetDuration(groupName, "cordonedDeletionAge")
+	if age == nil || d.opts.GetBool(groupName, "keepCordonedNodes") {
+		return false
+	}
+	state := d.nodeState(node)
+	if state == nil || state.CordonedSince == nil || time.Since(*state.CordonedSince) < *age {
+		return false
+	}
+	logrus.Tracef("Node %v has been cordoned since %v", node.Name, *state.CordonedSince)
+	return true
+}
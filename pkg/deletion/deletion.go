@@ -1,28 +1,40 @@
 package deletion
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"hash/fnv"
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/calendar"
 	"github.com/wish/nodereaper/pkg/config"
 	"github.com/wish/nodereaper/pkg/configmap"
 	"github.com/wish/nodereaper/pkg/controller"
 	"github.com/wish/nodereaper/pkg/metrics"
+	"github.com/wish/nodereaper/pkg/ratelog"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	core_v1 "k8s.io/api/core/v1"
-	k8s_types "k8s.io/apimachinery/pkg/types"
 )
 
 const (
 	k8sRoleLabel = "kubernetes.io/role"
+	// StateKey is the key in the locks configmap under which SerializedState is stored
+	StateKey = "state"
+	// HistoryKey is the key of the deletion history in the locks configmap
+	HistoryKey = "history"
+	// calendarRefreshPeriod is how often deletionCalendar URLs are refetched
+	calendarRefreshPeriod = 10 * time.Minute
+	// maxTokenIdentityLength keeps deletion tokens within the 63 characters allowed in label values
+	maxTokenIdentityLength = 40
 )
 
 // APIProvider handles the provider-specific API requests needed for
@@ -30,9 +42,15 @@ const (
 type APIProvider interface {
 	Run(<-chan struct{})
 	DesiredGroupSize(string) (int, error)
+	GroupExists(string) (bool, error)
 	OutdatedLaunchConfig(*config.Ops, *core_v1.Node) (bool, error)
+	InstanceImpaired(*core_v1.Node) (time.Time, bool)
 	PreDrain(*config.Ops, *core_v1.Node) error
 	DetachNode(*config.Ops, *core_v1.Node) error
+	TerminateNode(*config.Ops, *core_v1.Node) error
+	InstanceTerminated(*core_v1.Node) (bool, error)
+	CompleteLifecycleHooks(*core_v1.Node) error
+	SetAsgFilter(map[string]string, string)
 }
 
 // Deleter handles the actual deletion logic
@@ -42,177 +60,544 @@ type Deleter struct {
 	provider       APIProvider
 	stateConfigmap *configmap.ConfigMap
 	metrics        *metrics.Reporter
+	statesMu       sync.Mutex
 	states         GroupStates
+	history        *history
+	// apiTimeout bounds each individual call to the Kubernetes API
+	apiTimeout time.Duration
+	// stopped is set by Shutdown, after which no more transitions are made. Guarded by statesMu
+	stopped bool
+	// standby is set by Observe until Run is called, while the deleter only follows the leader's
+	// persisted state. Guarded by statesMu
+	standby bool
+	health  *pollHealth
+	// leavingNodes are the nodes past WantDelete at the start of the poll. Read-only during Advance
+	leavingNodes     map[string]struct{}
+	breakers         breakers
+	transitionErrors *errorTracker
+	// serverDrains are the nodes the controller is draining itself, with drainMode: server
+	serverDrains       *drainTracker
+	approvals          *approvals
+	calendars          *calendar.Cache
+	notifiers          []Notifier
+	transitionFailures *failures
+	approver           Approver
+	// groupPolls schedules the evaluation of each group, by group key. Guarded by statesMu
+	groupPolls map[string]*groupPoll
+	// refreshGeneration counts calls to refreshStates, to find the nodes that weren't listed. Guarded by statesMu
+	refreshGeneration uint64
+	providerPool      *providerPool
+	podFailures       *podFailures
+	// pollLoop starts the poll loop once, whether by Observe or Run
+	pollLoop sync.Once
+	// groupRules assign nodes without the instance group label to virtual groups
+	groupRules []config.GroupRule
+	// verifications follow deleted nodes until their deletion is verified, by node name. Guarded by statesMu
+	verifications map[string]*deletionVerification
+	// untracked counts the nodes refreshStates left out, by group name and why. Guarded by statesMu
+	untracked map[string]map[metrics.Exclusion]int
+}
+
+// pollHealth tracks the outcome of polls separately from statesMu,
+// so health checks don't block on an in-progress poll
+type pollHealth struct {
+	mu          sync.Mutex
+	lastPoll    time.Time
+	lastSaveErr error
+	// leaving are the states of the nodes past WantDelete as of the last poll, for LeavingState
+	leaving map[string]State
 }
 
 // New creates the deleter
 func New(opts *config.Ops, controller *controller.Controller, provider APIProvider, stateMap *configmap.ConfigMap, metrics *metrics.Reporter) *Deleter {
+	apiTimeout, _ := config.ParseDuration(opts.APITimeout)
+	providerTimeout, _ := config.ParseDuration(opts.ProviderTimeout)
+	groupRules, _ := config.ParseGroupRules(opts.GroupRules)
 	return &Deleter{
-		opts,
-		controller,
-		provider,
-		stateMap,
-		metrics,
-		GroupStates{
-			Groups: make(map[string]*Group),
+		opts:           opts,
+		controller:     controller,
+		provider:       provider,
+		stateConfigmap: stateMap,
+		metrics:        metrics,
+		states: GroupStates{
+			Groups:        make(map[string]*Group),
+			MaxTotalSurge: -1,
 		},
+		history:            newHistory(),
+		apiTimeout:         apiTimeout,
+		health:             &pollHealth{},
+		leavingNodes:       map[string]struct{}{},
+		breakers:           breakers{},
+		transitionErrors:   &errorTracker{},
+		serverDrains:       &drainTracker{nodes: map[string]struct{}{}},
+		approvals:          &approvals{denied: map[string]string{}},
+		calendars:          calendar.NewCache(calendarRefreshPeriod),
+		transitionFailures: &failures{last: map[string]string{}},
+		groupPolls:         map[string]*groupPoll{},
+		providerPool:       newProviderPool(opts.ProviderConcurrency, opts.ProviderQPS, providerTimeout),
+		podFailures:        &podFailures{since: map[string]time.Time{}},
+		groupRules:         groupRules,
+		verifications:      map[string]*deletionVerification{},
+	}
+}
+
+// LastPoll returns when the last poll finished refreshing and advancing states.
+// Before the first poll it returns when Run was called
+func (d *Deleter) LastPoll() time.Time {
+	d.health.mu.Lock()
+	defer d.health.mu.Unlock()
+	return d.health.lastPoll
+}
+
+// LeavingState returns the state of the node if it was detached or being deleted as of the
+// last poll. Unlike Node, it doesn't wait for a poll in progress
+func (d *Deleter) LeavingState(nodeName string) (State, bool) {
+	d.health.mu.Lock()
+	defer d.health.mu.Unlock()
+	state, ok := d.health.leaving[nodeName]
+	return state, ok
+}
+
+// recordLeaving updates the states returned by LeavingState. Callers must hold statesMu
+func (d *Deleter) recordLeaving() {
+	leaving := map[string]State{}
+	for _, group := range d.states.Groups {
+		for _, node := range group.Nodes {
+			if node.State == Detached || node.State == ReadyToDelete || node.State == Deleting {
+				leaving[node.Name] = node.State
+			}
+		}
+	}
+	d.health.mu.Lock()
+	d.health.leaving = leaving
+	d.health.mu.Unlock()
+}
+
+// LastSaveError returns the error from the most recent attempt to persist state, if any
+func (d *Deleter) LastSaveError() error {
+	d.health.mu.Lock()
+	defer d.health.mu.Unlock()
+	return d.health.lastSaveErr
+}
+
+// Observe starts the deleter following the state persisted by the leader, and reporting it in
+// metrics and the admin API, without acting on any node. Call Run with the same ctx once we are the leader
+func (d *Deleter) Observe(ctx context.Context) {
+	d.statesMu.Lock()
+	d.standby = true
+	d.statesMu.Unlock()
+	d.metrics.SetLeader(false)
+	d.startPolling(ctx)
+}
+
+// Run starts the deleter deleting nodes, until ctx is cancelled. If it was observing,
+// it takes over from the leader's latest persisted state straight away
+func (d *Deleter) Run(ctx context.Context) {
+	pollPeriod, _ := config.ParseDuration(d.opts.PollPeriod)
+	d.health.mu.Lock()
+	d.health.lastPoll = time.Now()
+	d.health.mu.Unlock()
+
+	d.statesMu.Lock()
+	wasObserving := d.standby
+	if wasObserving {
+		// Start over as if restarted, adopting the state the previous leader persisted last
+		d.standby = false
+		d.states.Groups = make(map[string]*Group)
+		d.history.reset()
+	}
+	d.statesMu.Unlock()
+	d.metrics.SetLeader(true)
+
+	d.runFastPoll(ctx, pollPeriod)
+	if wasObserving {
+		go d.pollDeletions(ctx)
 	}
+	d.startPolling(ctx)
 }
 
-// Run starts the deleter deleting nodes
-func (d *Deleter) Run(stopCh <-chan struct{}) {
-	// go d.pollRecordMetrics(stopCh)
+// Standby returns true while the deleter is only observing the leader's state
+func (d *Deleter) Standby() bool {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+	return d.standby
+}
+
+func (d *Deleter) startPolling(ctx context.Context) {
 	pollPeriod, _ := config.ParseDuration(d.opts.PollPeriod)
-	go wait.Until(func() {
-		t := time.Now()
-		d.pollDeletions()
-		tookSeconds := time.Now().Sub(t)
-		logrus.Debugf("Poll cycle finished in %v", tookSeconds)
-	}, pollPeriod, stopCh)
+	d.pollLoop.Do(func() {
+		go wait.Until(func() {
+			t := time.Now()
+			d.pollDeletions(ctx)
+			tookSeconds := time.Now().Sub(t)
+			logrus.Debugf("Poll cycle finished in %v", tookSeconds)
+		}, pollPeriod, ctx.Done())
+	})
+}
+
+// Shutdown waits for any in-progress poll to finish, stops further transitions,
+// and persists the current state
+func (d *Deleter) Shutdown(ctx context.Context) error {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	d.stopped = true
+	// Nothing was loaded yet, or the state is the leader's to save, and saving would wipe it out
+	if d.standby || len(d.states.Groups) == 0 {
+		return nil
+	}
+	return d.saveState(ctx)
 }
 
-func (d *Deleter) pollDeletions() {
+func (d *Deleter) pollDeletions(ctx context.Context) {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	if d.stopped {
+		return
+	}
+	if d.standby {
+		d.observe(ctx)
+		return
+	}
+
+	if err := d.refreshStates(ctx); err != nil {
+		logrus.Error(err)
+		return
+	}
+	d.finishReboots(ctx)
+	d.syncDeletionRequests(ctx)
+	d.finalizeNodes(ctx)
+
+	if d.killMyselfFirst(ctx) {
+		// If we are killing our own node, do only that
+		myNode, err := d.controller.NodeByName(d.opts.NodeName)
+		if err != nil || myNode == nil {
+			logrus.Warnf("Couldn't find my own node %v while trying to delete it: %v", d.opts.NodeName, err)
+			return
+		}
+		if group, ok := d.states.Groups[d.nodeGroupKey(myNode)]; ok {
+			group.invalidateReasons()
+		}
+		d.states.AdvanceGroup(ctx, d.nodeGroupKey(myNode), d.trackTransitions(d.notifyTransitions(d.StateTransitionFunction)))
+	} else {
+		// If we aren't killing our node, advance every group that is due
+		now := time.Now()
+		due := d.boundGroups(d.dueGroups(now))
+		for _, key := range due {
+			d.states.Groups[key].invalidateReasons()
+		}
+		d.states.AdvanceGroups(ctx, due, d.trackTransitions(d.notifyTransitions(d.StateTransitionFunction)))
+		d.groupsEvaluated(due, now)
+	}
+	d.resumeServerDrains(ctx)
+	d.markNodes(ctx)
+	d.confirmDeletions(ctx)
+	d.verifyDeletions(ctx)
+
+	d.health.mu.Lock()
+	d.health.lastPoll = time.Now()
+	d.health.mu.Unlock()
+
+	// Save node states to configmap in case of restart
+	if err := d.saveState(ctx); err != nil {
+		logrus.Errorf("Error saving deletion state: %v", err)
+	}
+
+	// Update metrics with the new states
+	d.recordMetrics()
+	d.recordLeaving()
+}
+
+// observe rebuilds the states from the ones the leader persisted last, and records them
+// in metrics. Callers must hold statesMu
+func (d *Deleter) observe(ctx context.Context) {
+	d.states.Groups = make(map[string]*Group)
+	d.history.reset()
+	if err := d.refreshStates(ctx); err != nil {
+		logrus.Error(err)
+		return
+	}
+	d.recordMetrics()
+	d.recordLeaving()
+}
+
+// refreshStates reloads config and persisted state, and brings the group states
+// in line with the nodes currently in the cluster. Callers must hold statesMu
+func (d *Deleter) refreshStates(ctx context.Context) error {
 	// Reload configuration from the mounted configmap
 	err := d.opts.Reload()
 	if err != nil {
-		logrus.Errorf("Error loading config: %v", err)
-		return
+		return fmt.Errorf("Error loading config: %v", err)
 	}
+	d.provider.SetAsgFilter(d.opts.AsgFilter(), d.opts.AsgNameTag())
 
 	// Load the old node states from configmap
 	// we will adopt these if we didn't already have that node
 	oldNodeStates := SerializedState{
 		NodeStates: make(map[string]NodeState),
 	}
-	r, err := d.stateConfigmap.Load("state")
-	if err == nil && r != nil {
-		err = json.Unmarshal([]byte(*r), &oldNodeStates)
+	data, err := d.stateConfigmap.LoadAll(ctx)
+	if r, ok := data[StateKey]; err == nil && ok {
+		err = json.Unmarshal([]byte(r), &oldNodeStates)
 		if err != nil {
-			logrus.Errorf("Error unmarshalling node states: %v", err)
-			return
+			return fmt.Errorf("Error unmarshalling node states: %v", err)
+		}
+	}
+
+	if err == nil {
+		// Before HistoryKey, the history was saved with the node states
+		oldHistory := oldNodeStates.History
+		if r, ok := data[HistoryKey]; ok {
+			oldHistory = nil
+			if err := json.Unmarshal([]byte(r), &oldHistory); err != nil {
+				return fmt.Errorf("Error unmarshalling deletion history: %v", err)
+			}
 		}
+		d.history.adopt(oldHistory)
 	}
 
 	allNodes, err := d.controller.ListNodes()
 	if err != nil {
-		logrus.Errorf("Could not list nodes: %v", err)
-		return
+		return fmt.Errorf("Could not list nodes: %v", err)
 	}
-	allNodeNames := map[string]struct{}{}
+	d.refreshGeneration++
 
+	d.untracked = map[string]map[metrics.Exclusion]int{}
 	for _, node := range allNodes {
-		if d.totallyIgnore(node) {
+		// The groups of other shards are left to the replicas holding them
+		if !d.inShard(node) {
+			continue
+		}
+		// Freshly registered nodes can lack a ProviderID for a little while, and every provider call
+		// would fail for them. They're checked again next poll
+		if d.providerIDPending(node) {
+			logrus.Tracef("Ignoring node %v until it has a ProviderID", node.Name)
+			continue
+		}
+		if reason := d.untrackedReason(node); reason != "" {
+			groupName := d.groupName(node)
+			if d.untracked[groupName] == nil {
+				d.untracked[groupName] = map[metrics.Exclusion]int{}
+			}
+			d.untracked[groupName][reason]++
 			continue
 		}
 		groupKey := d.nodeGroupKey(node)
-		allNodeNames[node.Name] = struct{}{}
 		if _, ok := d.states.Groups[groupKey]; !ok {
 			desired := metrics.VeryHighFalseDesiredSize
 			if groupKey == "___master___" {
 				desired = 3
 			}
 			d.states.Groups[groupKey] = &Group{
-				Name:           node.Labels[d.opts.InstanceGroupLabel],
+				Name:           d.groupName(node),
 				Key:            groupKey,
 				IsReal:         groupKey == "___ig___"+node.Labels[d.opts.InstanceGroupLabel],
+				IsVirtual:      strings.HasPrefix(groupKey, "___rule___"),
 				MaxSurge:       1,
 				MaxUnavailable: 0,
 				NumDesired:     desired,
 				Nodes:          make(map[string]*NodeState),
 				PriorityNodes:  make(map[string]struct{}),
+				Paused:         oldNodeStates.PausedGroups[groupKey],
+				LastRecycle:    oldNodeStates.RecycledAt[groupKey],
+				Window:         oldNodeStates.DeletionWindows[groupKey],
 			}
 		}
 		if _, ok := d.states.Groups[groupKey].Nodes[node.Name]; !ok {
-			state := DontWantDelete
-			if oldState, ok := oldNodeStates.NodeStates[node.Name]; ok {
-				logrus.Tracef("Adopted old state of %v for node %v", oldState.State, node.Name)
-				state = oldState.State
-			}
-			d.states.Groups[groupKey].Nodes[node.Name] = &NodeState{
+			nodeState := &NodeState{
 				Name:         node.Name,
-				State:        state,
+				State:        DontWantDelete,
 				CreationTime: node.CreationTimestamp,
 			}
+			if oldState, ok := oldNodeStates.NodeStates[node.Name]; ok {
+				logrus.Tracef("Adopted old state of %v for node %v", oldState.State, node.Name)
+				nodeState.State = oldState.State
+				nodeState.RequestedReason = oldState.RequestedReason
+				nodeState.RequestedBy = oldState.RequestedBy
+				nodeState.SnoozedUntil = oldState.SnoozedUntil
+				nodeState.CordonedSince = oldState.CordonedSince
+				nodeState.RebootedAt = oldState.RebootedAt
+				nodeState.DeletionToken = oldState.DeletionToken
+				nodeState.DeletionID = oldState.DeletionID
+				nodeState.WantDeleteSince = oldState.WantDeleteSince
+				nodeState.Surged = oldState.Surged
+				nodeState.ApprovalRequested = oldState.ApprovalRequested
+				nodeState.ApprovedBy = oldState.ApprovedBy
+				nodeState.DeniedBy = oldState.DeniedBy
+				nodeState.Reason = oldState.Reason
+			}
+			d.states.Groups[groupKey].Nodes[node.Name] = nodeState
+		}
+		nodeState := d.states.Groups[groupKey].Nodes[node.Name]
+		nodeState.seen = d.refreshGeneration
+		// Deletions started before deletion IDs were introduced get one now
+		if nodeState.State != DontWantDelete && nodeState.DeletionID == "" {
+			nodeState.DeletionID = newDeletionID()
+		}
+		nodeState.exclusion = d.neverDeleteReason(node)
+		nodeState.NeverDelete = nodeState.exclusion != ""
+		nodeState.ProviderID = node.Spec.ProviderID
+		if node.Spec.ProviderID == "" {
+			ratelog.Errorf("missing-provider-id/"+node.Name, "Node %v still has no ProviderID %v after it was created. It won't be detached or deleted until it has one",
+				node.Name, time.Since(node.CreationTimestamp.Time).Round(time.Second))
+		}
+		nodeState.Ready = nodeReady(node) && !node.Spec.Unschedulable
+		nodeState.SecurityRecycle = d.securityRecycle(node)
+		nodeState.observeCordon(node.Spec.Unschedulable, time.Now())
+		if nodeState.State == Deleting && d.opts.VerifyDeletions {
+			d.rememberPodOwners(nodeState)
 		}
 	}
 
 	for groupKey, group := range d.states.Groups {
-		if group.IsReal {
-			desired, err := d.provider.DesiredGroupSize(group.Name)
-			if err == nil {
-				d.states.Groups[groupKey].NumDesired = desired
-			} else {
-				logrus.Warnf("Error getting desired size for group %v: %v", group.Key, err)
+		if group.IsReal || group.IsVirtual {
+			// Percentages are relative to the desired size. Nothing replaces the nodes of an orphaned group
+			// being deleted, and a virtual group has no desired size, so they are relative to its current size instead
+			sizeBase := group.size()
+			if group.IsReal {
+				d.checkOrphaned(ctx, group)
+				sizeBase = group.NumDesired
+				if d.orphanedGroupExpired(group) {
+					group.NumDesired = 0
+					sizeBase = group.size()
+				} else if group.OrphanedSince.IsZero() {
+					desired, err := d.provider.DesiredGroupSize(group.Name)
+					if err == nil {
+						d.states.Groups[groupKey].NumDesired = desired
+					} else {
+						ratelog.Warnf("desired-size/"+group.Key, "Error getting desired size for group %v: %v", group.Key, err)
+					}
+					sizeBase = group.NumDesired
+				}
 			}
 
-			group.MaxSurge = percentOrNumToNum(d.opts.GetString(group.Name, "maxSurge"), group.NumDesired, true)
-			group.MaxUnavailable = percentOrNumToNum(d.opts.GetString(group.Name, "maxUnavailable"), group.NumDesired, false)
+			group.MaxSurge = percentOrNumToNum(d.opts.GetString(group.Name, "maxSurge"), sizeBase, true)
+			group.MaxUnavailable = percentOrNumToNum(d.opts.GetString(group.Name, "maxUnavailable"), sizeBase, false)
 			group.DeletionSchedule = d.opts.GetSchedule(group.Name, "deletionSchedule")
+			group.DeletionCalendar = d.deletionCalendar(ctx, group.Name)
+			group.MinReadyNodes = percentOrNumToNum(d.opts.GetString(group.Name, "minReadyNodes"), sizeBase, true)
+			group.ProtectLastN = percentOrNumToNum(d.opts.GetString(group.Name, "protectLastN"), sizeBase, true)
+			group.DeletionsPerWindow = percentOrNumToNum(d.opts.GetString(group.Name, "deletionsPerWindow"), sizeBase, true)
+			group.PaceDeletions = d.opts.GetBool(group.Name, "paceDeletions")
+		}
+
+		// A window adopted from before a restart only counts if the schedule stayed open since
+		if !group.windowChecked {
+			group.windowChecked = true
+			if !group.Window.Start.IsZero() && !group.stayedOpen(group.Window.Start, time.Now()) {
+				logrus.Infof("Deletion window of group %v closed since %v, starting a new one", group.Name, group.Window.Start)
+				group.Window = DeletionWindow{}
+			}
 		}
 
 		for nodeName, node := range group.Nodes {
-			if _, ok := allNodeNames[nodeName]; !ok {
+			if node.seen != d.refreshGeneration {
 				logrus.Infof("Removing non-existent node %v from memory (last state %v)", nodeName, node.State)
+				if node.State == Deleting {
+					d.notify(ctx, LifecycleEvent{
+						Node:          nodeName,
+						Group:         group.Name,
+						RequestedBy:   node.RequestedBy,
+						DeletionID:    node.DeletionID,
+						Phase:         DeletedPhase,
+						PreviousPhase: string(node.State),
+						Time:          time.Now(),
+					})
+					d.history.complete(nodeName, time.Now())
+					d.metrics.RecordDeletionStage(group.Name, metrics.StageNodeDeleted)
+					if node.WantDeleteSince != nil {
+						d.metrics.RecordDeletionDuration(group.Name, time.Since(*node.WantDeleteSince))
+					}
+					d.startVerification(group, node)
+				}
 				delete(group.Nodes, nodeName)
-				continue
+				d.approvals.set(nodeName, true, "")
+				d.transitionFailures.changed(nodeName, "")
+				d.podFailures.observe(nodeName, false, time.Now())
 			}
-
-			realNode, err := d.controller.NodeByName(nodeName)
-			if realNode == nil || err != nil {
-				logrus.Errorf("Error fetching node %v: %v", nodeName, err)
-				continue
-			}
-			node.NeverDelete = d.countButNeverDelete(realNode)
 		}
 	}
 
-	if d.killMyselfFirst() {
-		// If we are killing our own node, do only that
-		myNode, err := d.controller.NodeByName(d.opts.NodeName)
-		if err != nil || myNode == nil {
-			logrus.Warnf("Couldn't find my own node %v while trying to delete it: %v", d.opts.NodeName, err)
-			return
+	for _, key := range d.states.removeEmptyGroups() {
+		logrus.Infof("Removing group %v from memory, as it has no nodes left", key)
+	}
+
+	d.states.MaxTotalSurge = d.maxTotalSurge()
+	d.updateCircuitBreaker(ctx, allNodes)
+	d.updateErrorBreaker(ctx)
+
+	d.leavingNodes = map[string]struct{}{}
+	for _, group := range d.states.Groups {
+		for _, node := range group.Nodes {
+			if node.State == Detached || node.State == ReadyToDelete || node.State == Deleting {
+				d.leavingNodes[node.Name] = struct{}{}
+			}
 		}
-		d.states.Groups[d.nodeGroupKey(myNode)].Advance(d.StateTransitionFunction)
-	} else {
-		// If we aren't killing our node, advance everything
-		d.states.Advance(d.StateTransitionFunction)
 	}
+	return nil
+}
 
-	// Save node states to configmap in case of restart
-	saved, err := json.Marshal(d.states.SerializeState())
+// saveState persists node states to the configmap. Callers must hold statesMu
+func (d *Deleter) saveState(ctx context.Context) error {
+	state := d.states.SerializeState()
+	state.Groups = d.groupSummaries()
+	state.UpdateTime = time.Now()
+	saved, err := json.Marshal(state)
 	if err != nil {
-		logrus.Errorf("Error serializing deletion state: %v", err)
-		return
+		return fmt.Errorf("Error serializing deletion state: %v", err)
+	}
+	report, err := json.Marshal(d.groupStatusReport(state.UpdateTime))
+	if err != nil {
+		return fmt.Errorf("Error serializing group status: %v", err)
 	}
-	s := string(saved)
-	d.stateConfigmap.Store("state", &s)
+	history, err := json.Marshal(d.history.entries())
+	if err != nil {
+		return fmt.Errorf("Error serializing deletion history: %v", err)
+	}
+	s, r, h := string(saved), string(report), string(history)
+	err = d.stateConfigmap.StoreAll(ctx, map[string]*string{StateKey: &s, GroupStatusKey: &r, HistoryKey: &h})
 
-	// Update metrics with the new states
-	d.recordMetrics()
+	d.health.mu.Lock()
+	d.health.lastSaveErr = err
+	d.health.mu.Unlock()
+	return err
 }
 
-func (d *Deleter) killMyselfFirst() bool {
+func (d *Deleter) killMyselfFirst(ctx context.Context) bool {
 	// If for any reason we should be killing the node we are running on
 	// we drop everything else and just commit suicide as quick as possible
 
+	// In multi-cluster mode, the clusters that don't host the controller have no node of ours
+	if d.opts.NodeName == "" {
+		return false
+	}
+
 	// If we can't find our own node, we're probably deleted already
 	myNode, err := d.controller.NodeByName(d.opts.NodeName)
-	if err != nil || myNode == nil {
+	if err != nil {
 		return true
 	}
+	if myNode == nil {
+		// With a node selector our own node may simply not be watched, in which case we never delete it
+		return d.opts.NodeSelector == ""
+	}
 	groupKey := d.nodeGroupKey(myNode)
 	//sometimes the k8s api does not return own node in list node right on creation so the map might have not been populated yet.
 	if d.states.Groups[groupKey] == nil || d.states.Groups[groupKey].Nodes[myNode.Name] == nil {
 		logrus.Infof("Own node %v not found, skipping... ", d.opts.NodeName)
 		return false
 	}
+	// An operator explicitly asked us to hold off on this node
+	if d.states.Groups[groupKey].Nodes[myNode.Name].snoozed() {
+		return false
+	}
 	// Keep going if we're already deleting
 	if d.states.Groups[groupKey].Nodes[myNode.Name].State != DontWantDelete {
 		return true
 	}
 	// Don't delete if we wouldn't want to
-	if killMyself, _ := d.StateTransitionFunction(d.opts.NodeName, DontWantDelete, WantDelete); !killMyself {
+	if killMyself, _ := d.StateTransitionFunction(ctx, d.opts.NodeName, DontWantDelete, WantDelete); !killMyself {
 		return false
 	}
 
@@ -222,12 +607,28 @@ func (d *Deleter) killMyselfFirst() bool {
 	return true
 }
 
+// maxTotalSurge reads global.maxTotalSurge, which is relative to the desired size of
+// every group combined. Returns -1 if it is unset
+func (d *Deleter) maxTotalSurge() int {
+	value := d.opts.GetString("", "maxTotalSurge")
+	if value == "" {
+		return -1
+	}
+	totalDesired := 0
+	for _, group := range d.states.Groups {
+		if group.IsReal && group.NumDesired != metrics.VeryHighFalseDesiredSize {
+			totalDesired += group.NumDesired
+		}
+	}
+	return percentOrNumToNum(value, totalDesired, true)
+}
+
 func percentOrNumToNum(value string, total int, roundUp bool) int {
 	if strings.HasSuffix(value, "%") {
 		n := value[:len(value)-1]
 		pct, err := strconv.ParseFloat(n, 64)
 		if err != nil {
-			logrus.Errorf("Could not parse %v as percentage", value)
+			ratelog.Errorf("parse-percent/"+value, "Could not parse %v as percentage", value)
 			return 0
 		}
 		if roundUp {
@@ -237,14 +638,14 @@ func percentOrNumToNum(value string, total int, roundUp bool) int {
 	}
 	n, err := strconv.Atoi(value)
 	if err != nil {
-		logrus.Errorf("Could not parse %v as integer", value)
+		ratelog.Errorf("parse-int/"+value, "Could not parse %v as integer", value)
 		return 0
 	}
 	return n
 }
 
 // StateTransitionFunction makes the needed decisions and API calls to move a node between states
-func (d *Deleter) StateTransitionFunction(nodeName string, oldState, newState State) (bool, error) {
+func (d *Deleter) StateTransitionFunction(ctx context.Context, nodeName string, oldState, newState State) (bool, error) {
 	node, err := d.controller.NodeByName(nodeName)
 	if err != nil {
 		return false, fmt.Errorf("Error reading node %v", nodeName)
@@ -254,35 +655,107 @@ func (d *Deleter) StateTransitionFunction(nodeName string, oldState, newState St
 
 	// Check if we want to delete
 	if oldState == DontWantDelete && newState == WantDelete {
-		wantDelete, _ := d.WantToDelete(node)
+		wantDelete, reason := d.WantToDelete(node)
+		// Keep the reason, so status and metrics don't need to evaluate it again
+		if nodeState := d.nodeState(node); nodeState != nil {
+			nodeState.Reason, nodeState.reasonValid = reason, true
+			if wantDelete {
+				nodeState.startDeletion()
+			}
+		}
+		if wantDelete && replacedOnReboot(reason) && d.opts.GetString(d.groupName(node), "nodeAction") == config.NodeActionReboot {
+			logrus.Infof("Replacing node %v rather than rebooting it, as a reboot doesn't fix %v", node.Name, reason)
+		}
+		// Start the wait for the next node to recycle
+		if wantDelete && reason == metrics.Recycled {
+			d.states.Groups[d.nodeGroupKey(node)].LastRecycle = time.Now()
+		}
 		return wantDelete, nil
 	}
 
+	if newState != Deleting && d.deletionHeld(node, oldState, newState) {
+		return false, nil
+	}
+
+	// Changes to production may need an external sign-off before a node is removed
+	if oldState == WantDelete {
+		if ok, err := d.approved(ctx, node); !ok {
+			return false, err
+		}
+	}
+
+	// Keep cluster-autoscaler away from the node before we start removing it
+	if newState == Detached || newState == ReadyToDelete {
+		if err := d.annotateForAutoscaler(ctx, node); err != nil {
+			return false, err
+		}
+	}
+
 	// Detach the node from the autoscaling group
 	if oldState == WantDelete && newState == Detached {
-		err := d.provider.DetachNode(d.opts, node)
+		if _, _, ok := nodeMachine(node); ok {
+			err := d.detachMachine(ctx, node)
+			return err == nil, err
+		}
+		// A node that's rebooted goes back to its group
+		if d.rebootsInPlace(node) {
+			logrus.Infof("Not detaching %v, as it is going to be rebooted", node.Name)
+			return true, nil
+		}
+		// There's nothing to detach the node from if its group no longer exists
+		if group := d.states.Groups[d.nodeGroupKey(node)]; group != nil && !group.OrphanedSince.IsZero() {
+			logrus.Infof("Not detaching %v, as its group %v no longer exists", node.Name, group.Name)
+			return true, nil
+		}
+		err := d.providerPool.do(ctx, "detach "+node.Name, func() error {
+			return d.provider.DetachNode(d.opts, node)
+		})
 		return err == nil, err
 	}
 
 	// If the machine thinks we're ready to delete this node
 	// we're ready
-	if oldState == WantDelete && newState == ReadyToDelete {
-		return true, nil
-	}
-	if oldState == Detached && newState == ReadyToDelete {
+	if (oldState == WantDelete || oldState == Detached) && newState == ReadyToDelete {
 		return true, nil
 	}
 
 	// Try actually deleting the node
 	if oldState == ReadyToDelete && newState == Deleting {
-		err := d.provider.PreDrain(d.opts, node)
-		if err != nil {
+		if ok, err := d.runPreDeleteHooks(ctx, node); !ok {
+			return false, err
+		}
+		if ok, err := d.runPreDeleteJobTemplate(ctx, node); !ok {
 			return false, err
 		}
-		err = d.applyDeletionLabel(node.Name)
+		if err := d.runPreDrainHooks(ctx, node); err != nil {
+			return false, err
+		}
+		// Cluster API drains and terminates the node itself, so nodereaperd isn't involved
+		if _, _, ok := nodeMachine(node); ok {
+			if err := d.deleteMachine(ctx, node); err != nil {
+				return false, err
+			}
+			d.history.record(d.historyEntry(node))
+			return true, nil
+		}
+		// The drain itself is started after the poll, see resumeServerDrains
+		if d.drainMode(node) == drainModeServer {
+			d.history.record(d.historyEntry(node))
+			return true, nil
+		}
+		if !d.rebootsInPlace(node) {
+			err := d.providerPool.do(ctx, "prepare "+node.Name+" for draining", func() error {
+				return d.provider.PreDrain(d.opts, node)
+			})
+			if err != nil {
+				return false, err
+			}
+		}
+		err := d.applyDeletionLabel(ctx, node)
 		if err != nil {
 			return false, err
 		}
+		d.history.record(d.historyEntry(node))
 		return true, nil
 	}
 
@@ -290,11 +763,16 @@ func (d *Deleter) StateTransitionFunction(nodeName string, oldState, newState St
 }
 
 func (d *Deleter) totallyIgnore(node *core_v1.Node) bool {
-	groupName := node.Labels[d.opts.InstanceGroupLabel]
+	return d.untrackedReason(node) != ""
+}
+
+// untrackedReason returns why the node isn't tracked at all, or "" if it is
+func (d *Deleter) untrackedReason(node *core_v1.Node) metrics.Exclusion {
+	groupName := d.groupName(node)
 	if gp := d.opts.GetDuration(groupName, "startupGracePeriod"); gp != nil {
 		if node.CreationTimestamp.Add(*gp).After(time.Now()) {
 			logrus.Tracef("Ignoring node %v because it is too new", node.Name)
-			return true
+			return metrics.StartupGracePeriod
 		}
 	}
 
@@ -307,51 +785,128 @@ func (d *Deleter) totallyIgnore(node *core_v1.Node) bool {
 	}
 	if !foundReady {
 		logrus.Tracef("Ignoring node %v because it is not Ready", node.Name)
-		return true
+		return metrics.NotReady
 	}
 
-	return false
+	return ""
+}
+
+// providerIDPending returns true if the node has no ProviderID yet, but was created less than providerIDTimeout ago
+func (d *Deleter) providerIDPending(node *core_v1.Node) bool {
+	if node.Spec.ProviderID != "" {
+		return false
+	}
+	timeout := d.opts.GetDuration(d.groupName(node), "providerIDTimeout")
+	return timeout != nil && node.CreationTimestamp.Add(*timeout).After(time.Now())
 }
 
-func (d *Deleter) countButNeverDelete(node *core_v1.Node) bool {
-	groupName := node.Labels[d.opts.InstanceGroupLabel]
-	if d.opts.GetBool(groupName, "ignore") {
+// neverDeleteReason returns why the node is tracked but never deleted, or "" if it can be
+func (d *Deleter) neverDeleteReason(node *core_v1.Node) metrics.Exclusion {
+	if node.DeletionTimestamp != nil {
+		logrus.Tracef("Ignoring node %v, as it is being deleted", node.Name)
+		return metrics.BeingDeleted
+	}
+
+	groupName := d.groupName(node)
+	if d.opts.GetBool(groupName, "ignore") && !d.pastMaxLifetime(node) {
 		logrus.Tracef("Ignoring node %v in group %v", node.Name, groupName)
-		return true
+		return metrics.Ignored
 	}
 
 	if ignoreSelector := d.opts.GetString(groupName, "ignoreSelector"); ignoreSelector != "" {
 		selector, _ := labels.Parse(ignoreSelector)
 		if selector.Matches(labels.Set(node.Labels)) {
 			logrus.Tracef("Ignoring node %v, as it matches the ignore selector %v", node.Name, ignoreSelector)
-			return true
+			return metrics.IgnoreSelector
+		}
+	}
+
+	if autoscalerDisabledScaleDown(node) {
+		logrus.Tracef("Ignoring node %v, as cluster-autoscaler scale down is disabled for it", node.Name)
+		return metrics.AutoscalerScaleDownDisabled
+	}
+
+	if ignoreTaints := d.opts.GetString(groupName, "ignoreTaints"); ignoreTaints != "" {
+		if taint, ok := matchTaints(ignoreTaints, node.Spec.Taints); ok {
+			logrus.Tracef("Ignoring node %v, as it has the ignored taint %v", node.Name, taint)
+			return metrics.IgnoreTaints
 		}
 	}
 
-	return false
+	return ""
+}
+
+// pastMaxLifetime returns true if the node is older than global.maxNodeLifetime. It is a
+// compliance backstop that applies whatever the group's own settings are, so it counts from
+// the node's creation: a reboot in place keeps the instance
+func (d *Deleter) pastMaxLifetime(node *core_v1.Node) bool {
+	lifetime := d.opts.GetDuration("", "maxNodeLifetime")
+	return lifetime != nil && time.Now().After(node.CreationTimestamp.Add(*lifetime))
+}
+
+// nodeSince returns when the node's age starts, which is its last reboot in place if it had one
+func (d *Deleter) nodeSince(node *core_v1.Node) time.Time {
+	if nodeState := d.nodeState(node); nodeState != nil {
+		return nodeState.since()
+	}
+	return node.CreationTimestamp.Time
+}
+
+// matchTaints returns the first spec in the comma separated list that one of the taints matches.
+// Each spec is key, key=value, key:effect or key=value:effect, like in `kubectl taint`
+func matchTaints(specs string, taints []core_v1.Taint) (string, bool) {
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		key, effect := spec, ""
+		if i := strings.LastIndex(key, ":"); i >= 0 {
+			key, effect = key[:i], key[i+1:]
+		}
+		value, hasValue := "", false
+		if i := strings.Index(key, "="); i >= 0 {
+			key, value, hasValue = key[:i], key[i+1:], true
+		}
+		for _, taint := range taints {
+			if taint.Key != key {
+				continue
+			}
+			if hasValue && taint.Value != value {
+				continue
+			}
+			if effect != "" && string(taint.Effect) != effect {
+				continue
+			}
+			return spec, true
+		}
+	}
+	return "", false
 }
 
 // WantToDelete determines whether the controller wants delete the node and returns the reason why if it does
 // The clauses are ordered the way they are for metrics reasons, ie if a node is both too old and has outdated
 // config, we probably want to report the outdated config, rather than the age
 func (d *Deleter) WantToDelete(node *core_v1.Node) (bool, metrics.Reason) {
-	groupName := node.Labels[d.opts.InstanceGroupLabel]
-
-	// Delete the node if it is requested for deletion
-	if d.opts.RequestDeletionLabel != "" {
-		for label := range node.Labels {
-			if label == d.opts.RequestDeletionLabel {
-				logrus.Tracef("Node %v has deletion label %v", node.Name, d.opts.RequestDeletionLabel)
-				return true, metrics.HasDeletionLabel
-			}
-		}
+	// The security recycle and request deletion labels come first, so they are reported as such
+	if _, requested := d.requestedDeletion(node); requested || d.securityRecycle(node) {
+		return d.cheapReason(node)
 	}
 
-	if d.opts.GetBool(groupName, "deleteOldLaunchConfig") {
-		// Delete the node if the API-specific logic thinks we should
+	groupName := d.groupName(node)
+	group := d.states.Groups[d.nodeGroupKey(node)]
+	if group != nil && !group.OrphanedSince.IsZero() {
+		// The launch configuration can't be compared without the group
+		if d.orphanedGroupExpired(group) {
+			logrus.Tracef("Node %v's group %v no longer exists", node.Name, groupName)
+			return true, metrics.OrphanedGroup
+		}
+	} else if d.opts.GetBool(groupName, "deleteOldLaunchConfig") && node.Spec.ProviderID != "" {
+		// Delete the node if the API-specific logic thinks we should. A reboot doesn't change the
+		// instance's launch configuration, so these nodes are replaced even if their group reboots nodes
 		providerWantsDelete, err := d.provider.OutdatedLaunchConfig(d.opts, node)
 		if err != nil {
-			logrus.Warnf("Error checking if %v has an outdated config: %v", node.Name, err)
+			ratelog.Warnf("outdated-config/"+node.Name, "Error checking if %v has an outdated config: %v", node.Name, err)
 		} else if providerWantsDelete {
 			logrus.Tracef("Node %v has a different configuration than its instanceGroup", node.Name)
 			return true, metrics.ConfigurationChanged
@@ -359,48 +914,151 @@ func (d *Deleter) WantToDelete(node *core_v1.Node) (bool, metrics.Reason) {
 
 	}
 
-	// Delete the node if it is past its maximum age
-	if deletionAge := d.opts.GetDuration(groupName, "deletionAge"); deletionAge != nil {
-		t := time.Now()
+	// Delete the node if its instance is failing its status checks, e.g. because of degraded hardware
+	if d.instanceImpaired(node) {
+		return true, metrics.InstanceImpaired
+	}
+
+	// Delete the node if an operator cordoned it and forgot about it
+	if d.cordonedTooLong(node) {
+		return true, metrics.CordonedTooLong
+	}
 
-		// Based on a hash of the node name, wait for up to DeletionAgeJitter after the node's
-		// DeletionAge before deleting.
-		jitter := 0 * time.Second
-		if maxAfter := d.opts.GetDuration(groupName, "deletionAgeJitter"); maxAfter != nil {
-			hasher := fnv.New32a()
-			hasher.Write([]byte(node.Name))
-			jitter = time.Duration((int64((hasher.Sum32() % 100)) * int64(*maxAfter)) / 100)
-		}
+	if want, reason := d.cheapReason(node); want {
+		return true, reason
+	}
 
-		if t.After(node.CreationTimestamp.Add(*deletionAge).Add(jitter)) {
-			logrus.Tracef("Node %v is more than %v old", node.Name, *deletionAge)
-			return true, metrics.TooOld
-		}
+	// Delete the node if its pods keep failing while they're fine on other nodes
+	if d.systemicPodFailures(node) {
+		return true, metrics.PodFailures
+	}
+
+	// Recycle the group's oldest node, if its recycleRate allows
+	if d.recycleDue(node) {
+		logrus.Tracef("Node %v is the next node to recycle in its group", node.Name)
+		return true, metrics.Recycled
 	}
 
 	return false, ""
 }
 
-func (d *Deleter) applyDeletionLabel(nodeName string) error {
-	patch, _ := json.Marshal(map[string]interface{}{
-		"metadata": map[string]interface{}{
-			"labels": map[string]interface{}{
-				d.opts.ForceDeletionLabel: "nodereaper",
-			},
-		},
+// deletionReason is the reason reported for a node, preferring an operator's request.
+// The reason evaluated while advancing the node's group is reused if there is one
+func (d *Deleter) deletionReason(node *NodeState, realNode *core_v1.Node) metrics.Reason {
+	if node.RequestedReason != "" {
+		return metrics.OperatorRequested
+	}
+	if node.reasonValid || (node.State != DontWantDelete && node.Reason != "") {
+		return node.Reason
+	}
+	_, reason := d.WantToDelete(realNode)
+	node.Reason, node.reasonValid = reason, true
+	return reason
+}
+
+// nodeState returns the tracked state of the node, or nil
+func (d *Deleter) nodeState(node *core_v1.Node) *NodeState {
+	if group, ok := d.states.Groups[d.nodeGroupKey(node)]; ok {
+		return group.Nodes[node.Name]
+	}
+	return nil
+}
+
+// applyDeletionLabel sets the force deletion label. Unless the label has a fixed value, its value is
+// a new token recorded in the node's state, which nodereaperd can check against the saved state
+func (d *Deleter) applyDeletionLabel(ctx context.Context, node *core_v1.Node) error {
+	key, value, hasValue := config.SplitLabel(d.opts.ForceDeletionLabel)
+	if !hasValue {
+		token, err := d.deletionToken()
+		if err != nil {
+			return fmt.Errorf("Error generating deletion token: %v", err)
+		}
+		value = token
+	}
+	// nodereaperd reads what to do with the node from the annotation, so it's set with the label
+	action := config.NodeActionPowerOff
+	if d.rebootsInPlace(node) {
+		action = config.NodeActionReboot
+	}
+	annotations := map[string]string{config.NodeActionAnnotation: action}
+	if nodeState := d.nodeState(node); nodeState != nil && nodeState.DeletionID != "" {
+		annotations[config.DeletionIDAnnotation] = nodeState.DeletionID
+	}
+	err := d.patchNode(ctx, node.Name, nodePatch{
+		Labels:      map[string]string{key: value},
+		Annotations: annotations,
 	})
-	_, err := d.controller.Clientset.CoreV1().Nodes().Patch(nodeName, k8s_types.MergePatchType, patch)
 	if err != nil {
 		return fmt.Errorf("Error applying deletion label: %v", err)
 	}
+	if nodeState := d.nodeState(node); nodeState != nil && !hasValue {
+		nodeState.DeletionToken = value
+	}
 	return nil
 }
 
+// deletionToken returns a label value identifying this controller, followed by a random nonce
+func (d *Deleter) deletionToken() (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	identity := strings.SplitN(d.opts.NodeName, ".", 2)[0]
+	if len(identity) > maxTokenIdentityLength {
+		identity = identity[:maxTokenIdentityLength]
+	}
+	identity = strings.Trim(identity, "-_.")
+	if identity == "" {
+		identity = "nodereaper"
+	}
+	return identity + "." + hex.EncodeToString(nonce), nil
+}
+
+// newDeletionID returns a random ID for a node's deletion, to follow it through both components' logs
+func newDeletionID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
+
+// deletionCalendar fetches the group's deletionCalendar, if it has one. A calendar that can't be
+// fetched allows no deletions until it can
+func (d *Deleter) deletionCalendar(ctx context.Context, groupName string) *calendar.Calendar {
+	url := d.opts.GetString(groupName, "deletionCalendar")
+	if url == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+	defer cancel()
+	cal, err := d.calendars.Get(ctx, url)
+	if err != nil {
+		ratelog.Warnf("calendar/"+groupName, "Error loading deletion calendar for group %v: %v", groupName, err)
+	}
+	return cal
+}
+
 func (d *Deleter) nodeGroupKey(node *core_v1.Node) string {
-	if node.Labels[d.opts.InstanceGroupLabel] == "" {
-		return "___nogroup___"
+	if name := node.Labels[d.opts.InstanceGroupLabel]; name != "" {
+		return "___ig___" + name
 	}
-	return "___ig___" + node.Labels[d.opts.InstanceGroupLabel]
+	if rule := config.MatchGroupRule(d.groupRules, node.Labels); rule != nil {
+		return "___rule___" + rule.Name
+	}
+	return "___nogroup___"
+}
+
+// groupName returns the node's instance group label or, without it, the name of the first
+// group rule it matches. Empty if neither
+func (d *Deleter) groupName(node *core_v1.Node) string {
+	if name := node.Labels[d.opts.InstanceGroupLabel]; name != "" {
+		return name
+	}
+	if rule := config.MatchGroupRule(d.groupRules, node.Labels); rule != nil {
+		return rule.Name
+	}
+	return ""
 }
 
 func (d *Deleter) recordMetrics() {
@@ -408,30 +1066,67 @@ func (d *Deleter) recordMetrics() {
 
 	for _, group := range d.states.Groups {
 		nodes := []metrics.Node{}
+		ready := 0
 		for _, node := range group.Nodes {
+			if node.Ready {
+				ready++
+			}
 			actualNode, err := d.controller.NodeByName(node.Name)
 			if actualNode == nil || err != nil {
 				continue
 			}
-			_, reason := d.WantToDelete(actualNode)
+			reason := d.deletionReason(node, actualNode)
 			nodes = append(nodes, metrics.Node{
 				State:  string(node.State),
 				Reason: reason,
 			})
 		}
 
-		// We say that deletion is disabled if `.ignore` is true
+		// We say that deletion is disabled if `.ignore` is true, the group is paused or suspended,
 		// or the deletion schedule does not allow deletion at this time
-		scheduleAllowsDeletion := group.DeletionSchedule == nil || group.DeletionSchedule.Matches(time.Now().In(time.UTC))
-		deletionEnabled := !d.opts.GetBool(group.Name, "ignore") && scheduleAllowsDeletion
+		scheduleAllowsDeletion := group.scheduleAllowsDeletion(time.Now().In(time.UTC))
+		deletionEnabled := !d.opts.GetBool(group.Name, "ignore") && scheduleAllowsDeletion && !group.Paused && !d.states.Suspended
 
 		g := metrics.GroupState{
 			GroupName:       group.Name,
 			WantedNodes:     group.NumDesired,
+			ReadyNodes:      ready,
 			Nodes:           nodes,
 			DeletionEnabled: deletionEnabled,
+			Orphaned:        !group.OrphanedSince.IsZero() && d.opts.GetString(group.Name, "orphanedGroupPolicy") != orphanedGroupIgnore,
+			ScheduleOpen:    scheduleAllowsDeletion,
+		}
+		if group.DeletionCalendar != nil {
+			if next, ok := group.DeletionCalendar.Next(time.Now()); ok {
+				g.NextDeletionWindow = &next
+			}
 		}
 		groupStates[g.GroupName] = g
 	}
 	d.metrics.SetGroupState(groupStates)
+
+	excluded := map[string]map[metrics.Exclusion]int{}
+	for groupName, reasons := range d.untracked {
+		excluded[groupName] = map[metrics.Exclusion]int{}
+		for reason, n := range reasons {
+			excluded[groupName][reason] = n
+		}
+	}
+	for _, group := range d.states.Groups {
+		for _, node := range group.Nodes {
+			exclusion := node.exclusion
+			// Nodes still waiting for their ProviderID within providerIDTimeout aren't tracked at all
+			if exclusion == "" && node.ProviderID == "" {
+				exclusion = metrics.MissingProviderID
+			}
+			if exclusion == "" {
+				continue
+			}
+			if excluded[group.Name] == nil {
+				excluded[group.Name] = map[metrics.Exclusion]int{}
+			}
+			excluded[group.Name][exclusion]++
+		}
+	}
+	d.metrics.SetExcludedNodes(excluded)
 }
@@ -0,0 +1,324 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/configmap"
+	"github.com/wish/nodereaper/pkg/controller"
+	"github.com/wish/nodereaper/pkg/controller/controllertest"
+	"github.com/wish/nodereaper/pkg/metrics"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMatchTaints(t *testing.T) {
+	taints := []core_v1.Taint{
+		{Key: "maintenance", Value: "true", Effect: core_v1.TaintEffectNoSchedule},
+		{Key: "dedicated", Value: "gpu", Effect: core_v1.TaintEffectNoExecute},
+	}
+	tests := []struct {
+		specs   string
+		matches bool
+	}{
+		{"maintenance", true},
+		{"maintenance=true", true},
+		{"maintenance=false", false},
+		{"maintenance:NoSchedule", true},
+		{"maintenance=true:NoExecute", false},
+		{"other, dedicated=gpu:NoExecute", true},
+		{"other", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if _, ok := matchTaints(test.specs, taints); ok != test.matches {
+			t.Errorf("Expected %q to match=%v", test.specs, test.matches)
+		}
+	}
+}
+
+func TestSetRequestPhase(t *testing.T) {
+	start := time.Date(2021, 3, 6, 9, 0, 0, 0, time.UTC)
+	status := controller.NodeDeletionRequestStatus{}
+	setRequestPhase(&status, requestAccepted, "DeletionRequested", "The node was marked for deletion", start)
+	setRequestPhase(&status, requestInProgress, "Deleting", "The node is detached", start.Add(time.Minute))
+	setRequestPhase(&status, requestInProgress, "Deleting", "The node is deleting", start.Add(2*time.Minute))
+
+	if status.Phase != requestInProgress || status.Message != "The node is deleting" {
+		t.Errorf("Expected phase %v with the latest message, got %v: %v", requestInProgress, status.Phase, status.Message)
+	}
+	if len(status.Conditions) != 2 {
+		t.Fatalf("Expected a condition per phase, got %v", status.Conditions)
+	}
+	if !status.Conditions[1].LastTransitionTime.Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the InProgress condition to keep the time it was first reached, got %v", status.Conditions[1].LastTransitionTime)
+	}
+}
+
+func TestFinalizerPatch(t *testing.T) {
+	if got := string(finalizerPatch(true)); got != `{"metadata":{"finalizers":["nodereaper.wish.com/cleanup"]}}` {
+		t.Errorf("Unexpected patch adding the finalizer: %v", got)
+	}
+	if got := string(finalizerPatch(false)); got != `{"metadata":{"$deleteFromPrimitiveList/finalizers":["nodereaper.wish.com/cleanup"]}}` {
+		t.Errorf("Unexpected patch removing the finalizer: %v", got)
+	}
+}
+
+func TestPreDeleteJobName(t *testing.T) {
+	name := preDeleteJobName("rebalance", "ip-10-0-1-23.ec2.internal")
+	if name != preDeleteJobName("rebalance", "ip-10-0-1-23.ec2.internal") {
+		t.Errorf("Expected the same job name for the same node")
+	}
+	if name == preDeleteJobName("rebalance", "ip-10-0-1-24.ec2.internal") {
+		t.Errorf("Expected different job names for different nodes, got %v", name)
+	}
+	long := preDeleteJobName(strings.Repeat("a", 53)+"-suffix", "node")
+	if len(long) > 63 || strings.Contains(long, "--") {
+		t.Errorf("Expected a job name within 63 characters without a dangling dash, got %v", long)
+	}
+}
+
+func TestHistoryOutcome(t *testing.T) {
+	start := time.Date(2021, 3, 2, 9, 0, 0, 0, time.UTC)
+	h := newHistory()
+	h.record(HistoryEntry{Node: "a", Time: start})
+	h.record(HistoryEntry{Node: "b", Time: start.Add(time.Hour)})
+	h.complete("a", start.Add(4*time.Minute))
+	h.setOutcome("a", OutcomeVerified)
+
+	entries := h.entries()
+	if entries[0].Duration != "4m0s" || entries[0].Outcome != OutcomeVerified {
+		t.Errorf("Expected a verified deletion that took 4m0s, got %+v", entries[0])
+	}
+	if entries[1].Completed != nil || entries[1].Outcome != "" {
+		t.Errorf("Expected the deletion in progress to have no outcome, got %+v", entries[1])
+	}
+	if filtered := FilterHistory(entries, start.Add(time.Minute), time.Time{}); len(filtered) != 1 || filtered[0].Node != "b" {
+		t.Errorf("Expected only the later deletion, got %+v", filtered)
+	}
+}
+
+func TestNodePatchRemovesAnnotations(t *testing.T) {
+	patch := nodePatch{
+		Annotations:       map[string]string{DeletionReasonAnnotation: "too_old"},
+		RemoveAnnotations: []string{DeletionRequesterAnnotation},
+	}
+	expected := `{"metadata":{"annotations":{"nodereaper.wish.com/deletion-reason":"too_old","nodereaper.wish.com/deletion-requested-by":null}}}`
+	if got := string(patch.marshal()); got != expected {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestProviderIDPending(t *testing.T) {
+	d := &Deleter{opts: &config.Ops{}}
+	tests := []struct {
+		age        time.Duration
+		providerID string
+		expected   bool
+	}{
+		{time.Minute, "", true},
+		{time.Hour, "", false},
+		{time.Minute, "aws:///us-east-1a/i-0123456789abcdef0", false},
+	}
+	for _, test := range tests {
+		node := &core_v1.Node{
+			ObjectMeta: meta_v1.ObjectMeta{Name: "node", CreationTimestamp: meta_v1.NewTime(time.Now().Add(-test.age))},
+			Spec:       core_v1.NodeSpec{ProviderID: test.providerID},
+		}
+		if pending := d.providerIDPending(node); pending != test.expected {
+			t.Errorf("Expected a node created %v ago with ProviderID '%v' to be pending %v, got %v", test.age, test.providerID, test.expected, pending)
+		}
+	}
+}
+
+func TestKillMyselfFirstWithoutOwnNode(t *testing.T) {
+	// In multi-cluster mode, clusters that don't host the controller are started without a node name
+	d := &Deleter{opts: &config.Ops{ClusterName: "remote"}}
+	if d.killMyselfFirst(context.Background()) {
+		t.Errorf("Expected a cluster without our own node to advance every group")
+	}
+}
+
+func TestMissingProviderIDTracked(t *testing.T) {
+	d := &Deleter{opts: &config.Ops{}}
+	node := &core_v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "node", CreationTimestamp: meta_v1.NewTime(time.Now().Add(-time.Hour))},
+		Status: core_v1.NodeStatus{Conditions: []core_v1.NodeCondition{
+			{Type: core_v1.NodeReady, Status: core_v1.ConditionTrue},
+		}},
+	}
+	if d.providerIDPending(node) {
+		t.Fatalf("Expected a node past providerIDTimeout to no longer be pending")
+	}
+	if reason := d.untrackedReason(node); reason != "" {
+		t.Errorf("Expected a Ready node past providerIDTimeout to be tracked, got exclusion %v", reason)
+	}
+	if !d.deletionHeld(node, WantDelete, Detached) || !d.deletionHeld(node, WantDelete, ReadyToDelete) {
+		t.Errorf("Expected a node without a ProviderID to be held in want_delete")
+	}
+}
+
+func TestRebootedNodeNotSelectedAgain(t *testing.T) {
+	d := &Deleter{
+		opts:   &config.Ops{RequestDeletionLabel: "nodereaper/delete:requested", SecurityRecycleLabel: "security/recycle", InstanceGroupLabel: "group"},
+		states: GroupStates{Groups: map[string]*Group{}},
+	}
+	created := time.Now().Add(-30 * 24 * time.Hour)
+	node := &core_v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:              "node",
+			CreationTimestamp: meta_v1.NewTime(created),
+			Labels:            map[string]string{"group": "nodes", "nodereaper/delete": "true", "security/recycle": "true"},
+		},
+	}
+	rebooted := time.Now()
+	d.states.Groups["___ig___nodes"] = &Group{Nodes: map[string]*NodeState{
+		"node": {Name: "node", CreationTime: node.CreationTimestamp, RebootedAt: &rebooted},
+	}}
+
+	// The labels that got the node rebooted are removed along with the reboot
+	patch := d.rebootedPatch(node)
+	for _, key := range patch.RemoveLabels {
+		delete(node.Labels, key)
+	}
+	if _, requested := d.requestedDeletion(node); requested || d.securityRecycle(node) {
+		t.Errorf("Expected the labels requesting deletion to be removed after a reboot, got %v", node.Labels)
+	}
+	// And its age starts over
+	if since := d.nodeSince(node); !since.Equal(rebooted) {
+		t.Errorf("Expected the node's age to count from its reboot at %v, got %v", rebooted, since)
+	}
+}
+
+func TestRebootKeepsInstance(t *testing.T) {
+	opts := &config.Ops{InstanceGroupLabel: "group"}
+	opts.Load(map[string]string{"global.maxNodeLifetime": "720h", "group.nodes.nodeAction": "reboot"})
+	d := &Deleter{opts: opts, states: GroupStates{Groups: map[string]*Group{}}}
+	node := &core_v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:              "node",
+			CreationTimestamp: meta_v1.NewTime(time.Now().Add(-31 * 24 * time.Hour)),
+			Labels:            map[string]string{"group": "nodes"},
+		},
+	}
+	rebooted := time.Now()
+	nodeState := &NodeState{Name: "node", CreationTime: node.CreationTimestamp, RebootedAt: &rebooted}
+	d.states.Groups["___ig___nodes"] = &Group{Nodes: map[string]*NodeState{"node": nodeState}}
+
+	// The reboot doesn't reset the instance's lifetime
+	if !d.pastMaxLifetime(node) {
+		t.Errorf("Expected the node's lifetime to count from its creation, not its reboot")
+	}
+
+	tests := []struct {
+		reason metrics.Reason
+		reboot bool
+	}{
+		{metrics.TooOld, true},
+		{metrics.OperatorRequested, true},
+		{metrics.ConfigurationChanged, false},
+		{metrics.MaxLifetime, false},
+	}
+	for _, test := range tests {
+		nodeState.Reason = test.reason
+		if reboot := d.rebootsInPlace(node); reboot != test.reboot {
+			t.Errorf("Expected a node deleted for %v to be rebooted=%v, got %v", test.reason, test.reboot, reboot)
+		}
+	}
+}
+
+func TestSingletonPods(t *testing.T) {
+	node := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "node"}}
+	pod := func(name, nodeName, owner string) *core_v1.Pod {
+		pod := &core_v1.Pod{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       core_v1.PodSpec{NodeName: nodeName},
+			Status:     core_v1.PodStatus{Phase: core_v1.PodRunning},
+		}
+		if owner != "" {
+			isController := true
+			pod.OwnerReferences = []meta_v1.OwnerReference{{Kind: "ReplicaSet", Name: owner, UID: k8s_types.UID(owner), Controller: &isController}}
+		}
+		return pod
+	}
+	finished := pod("web-finished", "other", "web")
+	finished.Status.Phase = core_v1.PodSucceeded
+
+	tests := []struct {
+		name       string
+		pods       []*core_v1.Pod
+		singletons int
+	}{
+		{"no controller", []*core_v1.Pod{pod("bare", "node", "")}, 1},
+		{"1 replica", []*core_v1.Pod{pod("web-1", "node", "web")}, 1},
+		{"2 replicas on one node", []*core_v1.Pod{pod("web-1", "node", "web"), pod("web-2", "node", "web")}, 2},
+		{"2 replicas on different nodes", []*core_v1.Pod{pod("web-1", "node", "web"), pod("web-2", "other", "web")}, 0},
+		{"finished replica on another node", []*core_v1.Pod{pod("web-1", "node", "web"), finished}, 1},
+	}
+	for _, test := range tests {
+		d := &Deleter{controller: controllertest.New([]*core_v1.Node{node}, test.pods)}
+		singletons, err := d.singletonPods(node)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if len(singletons) != test.singletons {
+			t.Errorf("%v: expected %v singleton pods, got %v", test.name, test.singletons, len(singletons))
+		}
+	}
+
+	// The node is held if its pods can't be listed
+	d := &Deleter{opts: &config.Ops{}, controller: &controller.Controller{}}
+	if !d.hostsSingletonWorkload(node) {
+		t.Errorf("Expected the node to be held when its pods can't be listed")
+	}
+}
+
+// benchmarkPollDeletions times whole polls of a leader over Ready nodes read from the controller's
+// cache, none of which want to be deleted, with the state saved to a configmap every poll. The fake
+// client standing in for the cache decodes every object it returns, so reads cost more than they do
+// from the informers, which only copy them
+func benchmarkPollDeletions(b *testing.B, numGroups, nodesPerGroup int) {
+	ctx := context.Background()
+	created := meta_v1.NewTime(time.Now().Add(-time.Hour))
+	nodes := []*core_v1.Node{}
+	for i := 0; i < numGroups; i++ {
+		for j := 0; j < nodesPerGroup; j++ {
+			node := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{
+				Name:              fmt.Sprintf("g%d-%d", i, j),
+				Labels:            map[string]string{"group": fmt.Sprintf("g%d", i)},
+				CreationTimestamp: created,
+			}}
+			node.Spec.ProviderID = fmt.Sprintf("aws:///us-east-1a/i-%d-%d", i, j)
+			node.Status.Conditions = []core_v1.NodeCondition{{Type: core_v1.NodeReady, Status: core_v1.ConditionTrue}}
+			nodes = append(nodes, node)
+		}
+	}
+
+	clientset := fake.NewClientset(&core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "nodereaper", Name: "nodereaper-locks"}})
+	locks, err := configmap.New(ctx, clientset, "nodereaper", "nodereaper-locks", time.Minute)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctrl := controllertest.New(nodes, nil)
+	ctrl.Clientset = clientset
+	opts := &config.Ops{InstanceGroupLabel: "group", APITimeout: "10s", ProviderTimeout: "10s"}
+	opts.Load(map[string]string{})
+	d := New(opts, ctrl, &fakeProvider{}, locks, metrics.New())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.pollDeletions(ctx)
+	}
+}
+
+func BenchmarkPollDeletions1k(b *testing.B) { benchmarkPollDeletions(b, 20, 50) }
+
+func BenchmarkPollDeletions5k(b *testing.B) { benchmarkPollDeletions(b, 100, 50) }
@@ -0,0 +1,115 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/controller"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// requestAccepted means the node was moved to WantDelete, or was already being deleted
+	requestAccepted = "Accepted"
+	// requestInProgress means the node is past WantDelete
+	requestInProgress = "InProgress"
+	// requestCompleted means the node was deleted, or rebooted. The request isn't looked at again
+	requestCompleted = "Completed"
+	// requestRejected means the node can't be deleted, e.g. because it isn't tracked. The request isn't looked at again
+	requestRejected = "Rejected"
+)
+
+// syncDeletionRequests acts on new NodeDeletionRequests, like RequestDeletion, and reports the progress
+// of the accepted ones in their status. Callers must hold statesMu
+func (d *Deleter) syncDeletionRequests(ctx context.Context) {
+	if !d.opts.NodeDeletionRequests {
+		return
+	}
+	listCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+	requests, err := d.controller.ListNodeDeletionRequests(listCtx)
+	cancel()
+	if err != nil {
+		logrus.Warnf("Could not list NodeDeletionRequests: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range requests {
+		request := &requests[i]
+		if request.Status.Phase == requestCompleted || request.Status.Phase == requestRejected {
+			continue
+		}
+		// Requests for nodes of other shards are left to the replicas holding them
+		if node, err := d.controller.NodeByName(request.Spec.NodeName); err == nil && node != nil && !d.inShard(node) {
+			continue
+		}
+		id := request.Namespace + "/" + request.Name
+		status := request.Status
+		status.Conditions = append([]controller.NodeDeletionRequestCondition{}, request.Status.Conditions...)
+
+		nodeState := d.trackedNode(request.Spec.NodeName)
+		switch {
+		case status.Phase == "":
+			reason := request.Spec.Reason
+			if reason == "" {
+				reason = "Requested by NodeDeletionRequest " + id
+			}
+			switch err := d.requestDeletion(ctx, request.Spec.NodeName, reason, "nodedeletionrequest:"+id); err {
+			case nil:
+				setRequestPhase(&status, requestAccepted, "DeletionRequested", "The node was marked for deletion", now)
+			case ErrAlreadyDeleting:
+				setRequestPhase(&status, requestAccepted, "AlreadyDeleting", "The node was already being deleted", now)
+			case ErrNodeIgnored:
+				setRequestPhase(&status, requestRejected, "NodeIgnored", err.Error(), now)
+			default:
+				setRequestPhase(&status, requestRejected, "NodeNotTracked", err.Error(), now)
+			}
+		case nodeState == nil:
+			setRequestPhase(&status, requestCompleted, "NodeDeleted", "The node no longer exists", now)
+		case status.Phase == requestInProgress && nodeState.State == DontWantDelete:
+			setRequestPhase(&status, requestCompleted, "NodeRebooted", "The node was rebooted", now)
+		case nodeState.State == Detached || nodeState.State == ReadyToDelete || nodeState.State == Deleting:
+			setRequestPhase(&status, requestInProgress, "Deleting", fmt.Sprintf("The node is %v", nodeState.State), now)
+		}
+
+		status.NodeState = ""
+		if nodeState := d.trackedNode(request.Spec.NodeName); nodeState != nil {
+			status.NodeState = string(nodeState.State)
+		}
+		status.ObservedGeneration = request.Generation
+		if reflect.DeepEqual(status, request.Status) {
+			continue
+		}
+
+		request.Status = status
+		updateCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+		err := d.controller.UpdateNodeDeletionRequestStatus(updateCtx, request)
+		cancel()
+		if err != nil {
+			logrus.Warnf("Could not update status of NodeDeletionRequest %v: %v", id, err)
+		}
+	}
+}
+
+// setRequestPhase moves the request to the phase, and records when it got there in its conditions
+func setRequestPhase(status *controller.NodeDeletionRequestStatus, phase, reason, msg string, now time.Time) {
+	status.Phase, status.Message = phase, msg
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == phase {
+			status.Conditions[i].Reason = reason
+			status.Conditions[i].Message = msg
+			return
+		}
+	}
+	status.Conditions = append(status.Conditions, controller.NodeDeletionRequestCondition{
+		Type:               phase,
+		Status:             core_v1.ConditionTrue,
+		Reason:             reason,
+		Message:            msg,
+		LastTransitionTime: meta_v1.NewTime(now),
+	})
+}
@@ -0,0 +1,177 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/ratelog"
+	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// drainModeServer makes the controller drain and terminate nodes itself,
+	// for clusters where the privileged nodereaperd daemonset can't run
+	drainModeServer = "server"
+	// drainModeAgent hands nodes to nodereaperd with the force deletion label
+	drainModeAgent = "agent"
+
+	drainRetryPeriod = 5 * time.Second
+)
+
+// drainTracker is the set of nodes with a server-side drain in progress
+type drainTracker struct {
+	mu    sync.Mutex
+	nodes map[string]struct{}
+}
+
+// start returns false if the node is already being drained
+func (t *drainTracker) start(nodeName string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.nodes[nodeName]; ok {
+		return false
+	}
+	t.nodes[nodeName] = struct{}{}
+	return true
+}
+
+func (t *drainTracker) done(nodeName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.nodes, nodeName)
+}
+
+func (d *Deleter) drainMode(node *core_v1.Node) string {
+	mode := d.opts.GetString(d.groupName(node), "drainMode")
+	if mode != drainModeServer && mode != drainModeAgent {
+		ratelog.Warnf("drain-mode/"+node.Name, "Unknown drainMode '%v' for node %v, using %v", mode, node.Name, drainModeAgent)
+		return drainModeAgent
+	}
+	return mode
+}
+
+// resumeServerDrains starts a drain for every Deleting node in a server drain group that isn't
+// already being drained. This also picks drains back up after a failure or a controller restart.
+// Callers must hold statesMu
+func (d *Deleter) resumeServerDrains(ctx context.Context) {
+	for _, group := range d.states.Groups {
+		for _, nodeState := range group.Nodes {
+			if nodeState.State != Deleting {
+				continue
+			}
+			node, err := d.controller.NodeByName(nodeState.Name)
+			if err != nil || node == nil {
+				continue
+			}
+			if _, _, ok := nodeMachine(node); ok || d.drainMode(node) != drainModeServer {
+				continue
+			}
+			if d.serverDrains.start(node.Name) {
+				go func(node *core_v1.Node) {
+					defer d.serverDrains.done(node.Name)
+					if err := d.serverDrain(ctx, node); err != nil {
+						logrus.Errorf("Error draining node %v: %v", node.Name, err)
+						if d.transitionFailures.changed(node.Name, err.Error()) {
+							d.notify(ctx, LifecycleEvent{
+								Node:          node.Name,
+								Group:         d.groupName(node),
+								Phase:         FailedPhase,
+								PreviousPhase: string(Deleting),
+								Time:          time.Now(),
+								Error:         err.Error(),
+							})
+						}
+					}
+				}(node.DeepCopy())
+			}
+		}
+	}
+}
+
+// serverDrain does what nodereaperd would: cordons the node, evicts its pods,
+// then terminates the instance and deletes the node
+func (d *Deleter) serverDrain(ctx context.Context, node *core_v1.Node) error {
+	logrus.Infof("Draining node %v", node.Name)
+	if err := d.cordon(ctx, node.Name); err != nil {
+		return err
+	}
+
+	for {
+		remaining, err := d.evictPods(ctx, node.Name)
+		if err != nil {
+			return err
+		}
+		if remaining == 0 {
+			break
+		}
+		logrus.Infof("Still draining %v pods from %v", remaining, node.Name)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(drainRetryPeriod):
+		}
+	}
+	logrus.Infof("Successfully drained all drainable pods from %v", node.Name)
+
+	// Terminate before deleting, so a failure is retried while the node still exists
+	err := d.providerPool.do(ctx, "terminate "+node.Name, func() error {
+		return d.provider.TerminateNode(d.opts, node)
+	})
+	if err != nil {
+		return err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+	defer cancel()
+	if err := d.controller.DeleteNode(callCtx, node.Name); err != nil && !k8s_errors.IsNotFound(err) {
+		return fmt.Errorf("Error deleting node %v: %v", node.Name, err)
+	}
+	logrus.Infof("Successfully deleted node %v from kubernetes", node.Name)
+	return nil
+}
+
+func (d *Deleter) cordon(ctx context.Context, nodeName string) error {
+	unschedulable := true
+	if err := d.patchNode(ctx, nodeName, nodePatch{Unschedulable: &unschedulable}); err != nil {
+		return fmt.Errorf("Error cordoning node %v: %v", nodeName, err)
+	}
+	return nil
+}
+
+// evictPods requests eviction of every drainable pod left on the node, and returns
+// how many haven't terminated yet. Evictions blocked by a PodDisruptionBudget are retried later
+func (d *Deleter) evictPods(ctx context.Context, nodeName string) (int, error) {
+	pods, err := d.controller.PodsOnNode(nodeName)
+	if err != nil {
+		return 0, fmt.Errorf("Error listing pods on node %v: %v", nodeName, err)
+	}
+
+	remaining := 0
+	for _, pod := range pods {
+		if !podActive(pod) || !podRescheduled(pod) {
+			continue
+		}
+		remaining++
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+		err := d.controller.EvictPod(callCtx, pod)
+		cancel()
+		switch {
+		case err == nil:
+			logrus.Infof("Evicted pod %v/%v from %v", pod.Namespace, pod.Name, nodeName)
+		case k8s_errors.IsNotFound(err):
+			remaining--
+		case k8s_errors.IsTooManyRequests(err):
+			logrus.Debugf("Eviction of pod %v/%v blocked by a disruption budget", pod.Namespace, pod.Name)
+		default:
+			return 0, fmt.Errorf("Error evicting pod %v/%v: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+	return remaining, nil
+}
@@ -0,0 +1,121 @@
+package deletion
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/controller/controllertest"
+	"github.com/wish/nodereaper/pkg/metrics"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeProvider records the instances it's asked to terminate, and has nothing to say about the
+// rest. With outdated set, every instance has an outdated launch configuration
+type fakeProvider struct {
+	mu         sync.Mutex
+	terminated []string
+	outdated   bool
+}
+
+func (p *fakeProvider) Run(<-chan struct{})                  {}
+func (p *fakeProvider) DesiredGroupSize(string) (int, error) { return 0, nil }
+func (p *fakeProvider) GroupExists(string) (bool, error)     { return true, nil }
+func (p *fakeProvider) OutdatedLaunchConfig(*config.Ops, *core_v1.Node) (bool, error) {
+	return p.outdated, nil
+}
+func (p *fakeProvider) InstanceImpaired(*core_v1.Node) (time.Time, bool) { return time.Time{}, false }
+func (p *fakeProvider) PreDrain(*config.Ops, *core_v1.Node) error        { return nil }
+func (p *fakeProvider) DetachNode(*config.Ops, *core_v1.Node) error      { return nil }
+func (p *fakeProvider) InstanceTerminated(*core_v1.Node) (bool, error)   { return false, nil }
+func (p *fakeProvider) CompleteLifecycleHooks(*core_v1.Node) error       { return nil }
+func (p *fakeProvider) SetAsgFilter(map[string]string, string)           {}
+
+func (p *fakeProvider) TerminateNode(_ *config.Ops, node *core_v1.Node) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.terminated = append(p.terminated, node.Name)
+	return nil
+}
+
+func (p *fakeProvider) terminatedNodes() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.terminated...)
+}
+
+// fakeNodeServer records the node requests it gets as "METHOD name", and answers them with an empty node
+type fakeNodeServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *fakeNodeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+strings.TrimPrefix(r.URL.Path, "/api/v1/nodes/"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"kind":"Node","apiVersion":"v1","metadata":{"name":"node"}}`))
+}
+
+func (s *fakeNodeServer) requestsMade() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+func TestResumeServerDrains(t *testing.T) {
+	newNode := func(name, group string) *core_v1.Node {
+		return &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: map[string]string{"group": group}}}
+	}
+	nodes := []*core_v1.Node{newNode("server", "server"), newNode("agent", "agent"), newNode("draining", "server"), newNode("waiting", "server")}
+
+	server := &fakeNodeServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	ctrl := controllertest.New(nodes, nil)
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: httpServer.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl.Clientset = clientset
+
+	opts := &config.Ops{InstanceGroupLabel: "group", APITimeout: "10s", ProviderTimeout: "10s"}
+	opts.Load(map[string]string{"group.server.drainMode": "server"})
+	provider := &fakeProvider{}
+	d := New(opts, ctrl, provider, nil, metrics.New())
+	d.states.Groups["___ig___server"] = &Group{Nodes: map[string]*NodeState{
+		"server":   {Name: "server", State: Deleting},
+		"draining": {Name: "draining", State: Deleting},
+		"waiting":  {Name: "waiting", State: ReadyToDelete},
+	}}
+	d.states.Groups["___ig___agent"] = &Group{Nodes: map[string]*NodeState{
+		"agent": {Name: "agent", State: Deleting},
+	}}
+	// A drain still running from an earlier poll isn't started again
+	d.serverDrains.start("draining")
+
+	// Only the Deleting node of the server drain group that isn't being drained yet is cordoned,
+	// terminated and deleted, e.g. after a controller restart
+	d.resumeServerDrains(context.Background())
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.requestsMade()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	requests := server.requestsMade()
+	if len(requests) != 2 || requests[0] != "PATCH server" || requests[1] != "DELETE server" {
+		t.Errorf("Expected the node to be cordoned then deleted, got requests %v", requests)
+	}
+	if terminated := provider.terminatedNodes(); len(terminated) != 1 || terminated[0] != "server" {
+		t.Errorf("Expected only the server drain node's instance to be terminated, got %v", terminated)
+	}
+}
@@ -0,0 +1,165 @@
+package deletion
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/metrics"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// runFastPoll acts on cheap reasons to delete a node, like the request deletion label, every
+// FastPollPeriod, so they don't wait for the full poll. Disabled if FastPollPeriod isn't shorter than PollPeriod
+func (d *Deleter) runFastPoll(ctx context.Context, pollPeriod time.Duration) {
+	fastPollPeriod, err := config.ParseDuration(d.opts.FastPollPeriod)
+	if err != nil || fastPollPeriod <= 0 || fastPollPeriod >= pollPeriod {
+		return
+	}
+	go wait.Until(func() {
+		d.pollFast(ctx)
+	}, fastPollPeriod, ctx.Done())
+}
+
+// pollFast advances only the groups with a node that has a cheap reason to be deleted,
+// but isn't wanted for deletion yet
+func (d *Deleter) pollFast(ctx context.Context) {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	// Nothing was loaded yet, or the full poll is deleting our own node first
+	if d.stopped || len(d.states.Groups) == 0 || d.deletingMyself() {
+		return
+	}
+	d.confirmDeletions(ctx)
+
+	urgent := []string{}
+	for key, group := range d.states.Groups {
+		for _, nodeState := range group.Nodes {
+			if nodeState.State != DontWantDelete || nodeState.NeverDelete || nodeState.snoozed() {
+				continue
+			}
+			node, err := d.controller.NodeByName(nodeState.Name)
+			if err != nil || node == nil {
+				continue
+			}
+			if want, _ := d.cheapReason(node); want {
+				urgent = append(urgent, key)
+				break
+			}
+		}
+	}
+	if len(urgent) == 0 {
+		return
+	}
+
+	logrus.Debugf("Fast poll advancing groups %v", urgent)
+	d.states.AdvanceGroups(ctx, urgent, d.trackTransitions(d.notifyTransitions(d.fastTransitions(d.StateTransitionFunction))))
+	d.markNodes(ctx)
+	if err := d.saveState(ctx); err != nil {
+		logrus.Errorf("Error saving deletion state: %v", err)
+	}
+	d.recordMetrics()
+	d.recordLeaving()
+}
+
+// fastTransitions only lets a node become WantDelete for a cheap reason. Any other
+// reason is left for the full poll
+func (d *Deleter) fastTransitions(f StateTransitionFunction) StateTransitionFunction {
+	return func(ctx context.Context, nodeName string, oldState, newState State) (bool, error) {
+		if oldState == DontWantDelete && newState == WantDelete {
+			node, err := d.controller.NodeByName(nodeName)
+			if err != nil || node == nil {
+				return false, err
+			}
+			if want, _ := d.cheapReason(node); !want {
+				return false, nil
+			}
+		}
+		return f(ctx, nodeName, oldState, newState)
+	}
+}
+
+// securityRecycle returns true if the node has the security recycle label or annotation
+func (d *Deleter) securityRecycle(node *core_v1.Node) bool {
+	if d.opts.SecurityRecycleLabel == "" {
+		return false
+	}
+	_, labeled := node.Labels[d.opts.SecurityRecycleLabel]
+	_, annotated := node.Annotations[d.opts.SecurityRecycleLabel]
+	return labeled || annotated
+}
+
+// requestedDeletion returns the reason of the first of the request deletion labels the node has
+func (d *Deleter) requestedDeletion(node *core_v1.Node) (metrics.Reason, bool) {
+	for _, l := range config.ParseRequestLabels(d.opts.RequestDeletionLabel) {
+		if config.HasLabel(l.Label, node.Labels) {
+			logrus.Tracef("Node %v has deletion label %v", node.Name, l.Label)
+			if l.Reason == "" {
+				return metrics.HasDeletionLabel, true
+			}
+			return metrics.Reason(l.Reason), true
+		}
+	}
+	return "", false
+}
+
+// deletingMyself returns true if the node we're running on is on its way out. Callers must hold statesMu
+func (d *Deleter) deletingMyself() bool {
+	myNode, err := d.controller.NodeByName(d.opts.NodeName)
+	if err != nil || myNode == nil {
+		return false
+	}
+	nodeState := d.nodeState(myNode)
+	return nodeState != nil && nodeState.State != DontWantDelete
+}
+
+// cheapReason checks the reasons to delete a node that only look at the node itself
+func (d *Deleter) cheapReason(node *core_v1.Node) (bool, metrics.Reason) {
+	groupName := d.groupName(node)
+
+	// Delete the node first if a security scanner asked for it
+	if d.securityRecycle(node) {
+		logrus.Tracef("Node %v has security recycle label %v", node.Name, d.opts.SecurityRecycleLabel)
+		return true, metrics.SecurityRecycle
+	}
+
+	// Delete the node if it is requested for deletion
+	if reason, ok := d.requestedDeletion(node); ok {
+		return true, reason
+	}
+
+	// Delete the node if it is past its maximum age
+	if deadline, ok := d.deletionAgeDeadline(groupName, node.Name, d.nodeSince(node)); ok && time.Now().After(deadline) {
+		logrus.Tracef("Node %v is past its deletionAge since %v", node.Name, deadline)
+		return true, metrics.TooOld
+	}
+
+	// Delete the node if it is past the cluster-wide maximum lifetime, even if its group has no deletionAge
+	if d.pastMaxLifetime(node) {
+		logrus.Tracef("Node %v is older than the maximum node lifetime", node.Name)
+		return true, metrics.MaxLifetime
+	}
+
+	return false, ""
+}
+
+// deletionAgeDeadline returns when a node whose age starts at since is too old for the group's deletionAge.
+// Based on a hash of the node name, it waits for up to deletionAgeJitter after the deletionAge
+func (d *Deleter) deletionAgeDeadline(groupName, nodeName string, since time.Time) (time.Time, bool) {
+	deletionAge := d.opts.GetDuration(groupName, "deletionAge")
+	if deletionAge == nil {
+		return time.Time{}, false
+	}
+	jitter := 0 * time.Second
+	if maxAfter := d.opts.GetDuration(groupName, "deletionAgeJitter"); maxAfter != nil {
+		hasher := fnv.New32a()
+		hasher.Write([]byte(nodeName))
+		jitter = time.Duration((int64((hasher.Sum32() % 100)) * int64(*maxAfter)) / 100)
+	}
+	return since.Add(*deletionAge).Add(jitter), true
+}
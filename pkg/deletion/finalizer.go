@@ -0,0 +1,134 @@
+package deletion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	k8s_types "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// NodeFinalizer holds deleted nodes until the controller has cleaned up their instance
+	NodeFinalizer = "nodereaper.wish.com/cleanup"
+	// cleanupGracePeriod is how long nodereaperd has to power off a leaving node's instance
+	// after deleting its node, before the controller terminates the instance itself
+	cleanupGracePeriod = 2 * time.Minute
+	// cleanupTimeout is how long the controller keeps trying to clean up after a deleted node,
+	// before releasing it anyway so a broken provider can't hold nodes forever
+	cleanupTimeout = 30 * time.Minute
+)
+
+func hasFinalizer(node *core_v1.Node) bool {
+	for _, finalizer := range node.Finalizers {
+		if finalizer == NodeFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// finalizerPatch adds or removes NodeFinalizer, leaving any other finalizers alone
+func finalizerPatch(add bool) []byte {
+	metadata := map[string]interface{}{}
+	if add {
+		metadata["finalizers"] = []string{NodeFinalizer}
+	} else {
+		metadata["$deleteFromPrimitiveList/finalizers"] = []string{NodeFinalizer}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"metadata": metadata})
+	return data
+}
+
+func (d *Deleter) patchFinalizer(ctx context.Context, nodeName string, add bool) error {
+	callCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+	defer cancel()
+	_, err := d.controller.PatchNode(callCtx, nodeName, k8s_types.StrategicMergePatchType, finalizerPatch(add))
+	if k8s_errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// finalizeNodes adds NodeFinalizer to the managed nodes without it, and releases the deleted
+// nodes holding it once they are cleaned up. Deleted nodes are released even with the finalizer
+// turned off, so turning it off never leaves nodes stuck. Callers must hold statesMu
+func (d *Deleter) finalizeNodes(ctx context.Context) {
+	allNodes, err := d.controller.ListNodes()
+	if err != nil {
+		logrus.Errorf("Could not list nodes: %v", err)
+		return
+	}
+	for _, node := range allNodes {
+		if !d.inShard(node) {
+			continue
+		}
+		if node.DeletionTimestamp == nil {
+			if d.opts.NodeFinalizer && !hasFinalizer(node) && !d.totallyIgnore(node) {
+				if err := d.patchFinalizer(ctx, node.Name, true); err != nil {
+					logrus.Warnf("Error adding finalizer to node %v: %v", node.Name, err)
+				}
+			}
+			continue
+		}
+		if !hasFinalizer(node) {
+			continue
+		}
+
+		err := d.cleanUpNode(ctx, node)
+		if err != nil {
+			if time.Since(node.DeletionTimestamp.Time) < cleanupTimeout {
+				logrus.Warnf("Not yet releasing deleted node %v: %v", node.Name, err)
+				continue
+			}
+			logrus.Errorf("Giving up cleaning up after deleted node %v, releasing it anyway: %v", node.Name, err)
+		}
+		if err := d.patchFinalizer(ctx, node.Name, false); err != nil {
+			logrus.Warnf("Error removing finalizer from node %v: %v", node.Name, err)
+			continue
+		}
+		logrus.Infof("Released deleted node %v", node.Name)
+	}
+}
+
+// cleanUpNode makes sure a deleted node's instance is gone, or on its way out, and that no lifecycle
+// hook is holding its termination. It returns an error until it's safe to release the node
+func (d *Deleter) cleanUpNode(ctx context.Context, node *core_v1.Node) error {
+	// Deleting the machine cleans up after the node
+	if _, _, ok := nodeMachine(node); ok {
+		return nil
+	}
+
+	// Only the instance of a node we took out of its group is left for us to terminate
+	if nodeState := d.trackedNode(node.Name); nodeState != nil &&
+		(nodeState.State == Detached || nodeState.State == ReadyToDelete || nodeState.State == Deleting) {
+		var terminated bool
+		err := d.providerPool.do(ctx, "check instance of "+node.Name, func() (err error) {
+			terminated, err = d.provider.InstanceTerminated(node)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if !terminated {
+			if time.Since(node.DeletionTimestamp.Time) < cleanupGracePeriod {
+				return fmt.Errorf("Waiting for its instance to shut down")
+			}
+			err := d.providerPool.do(ctx, "terminate "+node.Name, func() error {
+				return d.provider.TerminateNode(d.opts, node)
+			})
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("Waiting for its instance to terminate")
+		}
+	}
+
+	return d.providerPool.do(ctx, "complete lifecycle hooks of "+node.Name, func() error {
+		return d.provider.CompleteLifecycleHooks(node)
+	})
+}
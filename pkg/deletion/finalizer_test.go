@@ -0,0 +1,63 @@
+package deletion
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/controller/controllertest"
+	"github.com/wish/nodereaper/pkg/metrics"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestFinalizeDeletedNodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		deletedAgo time.Duration
+		state      State
+		terminated bool
+		released   bool
+	}{
+		{"instance shutting down", time.Minute, Deleting, false, false},
+		{"instance left running", 5 * time.Minute, Deleting, true, false},
+		{"past cleanupTimeout", cleanupTimeout + time.Minute, Deleting, true, true},
+		{"not taken out of its group", time.Minute, DontWantDelete, false, true},
+	}
+	for _, test := range tests {
+		deleted := meta_v1.NewTime(time.Now().Add(-test.deletedAgo))
+		node := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{
+			Name:              "node",
+			DeletionTimestamp: &deleted,
+			Finalizers:        []string{NodeFinalizer},
+		}}
+
+		server := &fakeNodeServer{}
+		httpServer := httptest.NewServer(server)
+		ctrl := controllertest.New([]*core_v1.Node{node}, nil)
+		clientset, err := kubernetes.NewForConfig(&rest.Config{Host: httpServer.URL, ContentConfig: rest.ContentConfig{ContentType: "application/json"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctrl.Clientset = clientset
+
+		provider := &fakeProvider{}
+		d := New(&config.Ops{APITimeout: "10s", ProviderTimeout: "10s"}, ctrl, provider, nil, metrics.New())
+		d.states.Groups["___nogroup___"] = &Group{Nodes: map[string]*NodeState{"node": {Name: "node", State: test.state}}}
+
+		// The fake provider never reports the instance terminated
+		d.finalizeNodes(context.Background())
+		if terminated := len(provider.terminatedNodes()) > 0; terminated != test.terminated {
+			t.Errorf("%v: expected the instance terminated=%v, got %v", test.name, test.terminated, terminated)
+		}
+		if released := len(server.requestsMade()) > 0; released != test.released {
+			t.Errorf("%v: expected the node released=%v, got requests %v", test.name, test.released, server.requestsMade())
+		}
+		httpServer.Close()
+	}
+}
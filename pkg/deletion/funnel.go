@@ -0,0 +1,26 @@
+package deletion
+
+import (
+	"github.com/wish/nodereaper/pkg/metrics"
+)
+
+// stages are the deletion stages counted as nodes move into each state, for the deletion funnel
+var stages = map[State]metrics.DeletionStage{
+	WantDelete:    metrics.StageRequested,
+	Detached:      metrics.StageDetached,
+	ReadyToDelete: metrics.StageReady,
+	Deleting:      metrics.StageLabelApplied,
+}
+
+// recordStage counts the node reaching the deletion stage of the state it moved into
+func (d *Deleter) recordStage(nodeName string, state State) {
+	stage, ok := stages[state]
+	if !ok {
+		return
+	}
+	node, err := d.controller.NodeByName(nodeName)
+	if err != nil || node == nil {
+		return
+	}
+	d.metrics.RecordDeletionStage(d.groupName(node), stage)
+}
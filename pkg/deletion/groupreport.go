@@ -0,0 +1,112 @@
+package deletion
+
+import (
+	"sort"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/metrics"
+)
+
+const (
+	// GroupStatusKey is the key in the locks configmap under which GroupStatusReport is stored
+	GroupStatusKey = "groupStatus"
+	// GroupStatusVersion is the schema version of GroupStatusReport. Within a version, fields are only ever added
+	GroupStatusVersion = "v1"
+)
+
+// GroupStatusReport is the status of every group, in a stable format for external systems
+// following rollouts. Unlike SerializedState, it's not read back by the controller
+type GroupStatusReport struct {
+	Version    string        `json:"version"`
+	UpdateTime time.Time     `json:"updateTime"`
+	Groups     []GroupReport `json:"groups"`
+}
+
+// GroupReport is the status of a single group in GroupStatusReport
+type GroupReport struct {
+	Name            string `json:"name"`
+	Key             string `json:"key"`
+	DesiredSize     *int   `json:"desiredSize,omitempty"`
+	Nodes           int    `json:"nodes"`
+	Paused          bool   `json:"paused"`
+	DeletionEnabled bool   `json:"deletionEnabled"`
+	// States counts the nodes in every state, including the states with no nodes
+	States   map[State]int   `json:"states"`
+	Blockers map[Blocker]int `json:"blockers"`
+	// LastDeletionTime is when a node of the group was last handed to nodereaperd, as far as the history goes back
+	LastDeletionTime *time.Time      `json:"lastDeletionTime,omitempty"`
+	Rollout          RolloutProgress `json:"rollout"`
+}
+
+// RolloutProgress counts the group's nodes by how far along they are in being replaced
+type RolloutProgress struct {
+	// Pending nodes want to be deleted, but haven't started
+	Pending int `json:"pending"`
+	// InProgress nodes are detached or being deleted
+	InProgress int `json:"inProgress"`
+	// UpToDate nodes don't want to be deleted
+	UpToDate int `json:"upToDate"`
+	// WindowStart, WindowDeletions and WindowLimit describe the open deletion window,
+	// if the group limits deletionsPerWindow
+	WindowStart     *time.Time `json:"windowStart,omitempty"`
+	WindowDeletions int        `json:"windowDeletions,omitempty"`
+	WindowLimit     int        `json:"windowLimit,omitempty"`
+}
+
+// groupStatusReport builds the GroupStatusReport of every group. Callers must hold statesMu
+func (d *Deleter) groupStatusReport(now time.Time) GroupStatusReport {
+	lastDeletions := map[string]time.Time{}
+	for _, entry := range d.history.entries() {
+		if entry.Time.After(lastDeletions[entry.Group]) {
+			lastDeletions[entry.Group] = entry.Time
+		}
+	}
+
+	report := GroupStatusReport{
+		Version:    GroupStatusVersion,
+		UpdateTime: now,
+		Groups:     []GroupReport{},
+	}
+	for _, group := range d.states.Groups {
+		g := GroupReport{
+			Name:            group.Name,
+			Key:             group.Key,
+			Nodes:           group.size(),
+			Paused:          group.Paused,
+			DeletionEnabled: !d.opts.GetBool(group.Name, "ignore") && group.scheduleAllowsDeletion(now.In(time.UTC)) && !group.Paused,
+			States:          map[State]int{},
+			Blockers:        map[Blocker]int{},
+		}
+		if group.NumDesired != metrics.VeryHighFalseDesiredSize {
+			desired := group.NumDesired
+			g.DesiredSize = &desired
+		}
+		for _, state := range []State{DontWantDelete, WantDelete, Detached, ReadyToDelete, Deleting} {
+			g.States[state] = 0
+		}
+		for _, node := range group.Nodes {
+			g.States[node.State]++
+			for _, blocker := range d.blockers(group, node) {
+				g.Blockers[blocker]++
+			}
+		}
+		if last, ok := lastDeletions[group.Name]; ok {
+			g.LastDeletionTime = &last
+		}
+
+		g.Rollout.UpToDate = g.States[DontWantDelete]
+		g.Rollout.Pending = g.States[WantDelete]
+		g.Rollout.InProgress = g.States[Detached] + g.States[ReadyToDelete] + g.States[Deleting]
+		if group.DeletionsPerWindow > 0 && !group.Window.Start.IsZero() {
+			start := group.Window.Start
+			g.Rollout.WindowStart = &start
+			g.Rollout.WindowDeletions = group.Window.Deletions
+			g.Rollout.WindowLimit = group.DeletionsPerWindow
+		}
+		report.Groups = append(report.Groups, g)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		return report.Groups[i].Key < report.Groups[j].Key
+	})
+	return report
+}
@@ -0,0 +1,60 @@
+package deletion
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/config"
+)
+
+const (
+	// DeletionAckAnnotation is set by nodereaperd to the value of the deletion label it is about to act on
+	DeletionAckAnnotation = "nodereaper.wish.com/deletion-ack"
+	// DeletionConfirmAnnotation is set by the controller to an acknowledged value it still wants acted on
+	DeletionConfirmAnnotation = "nodereaper.wish.com/deletion-confirmed"
+)
+
+// confirmDeletions answers nodereaperd's acknowledgment of the deletion label, for the deleting nodes
+// whose label is still the one the controller set. A label the controller no longer stands behind,
+// like one left by a controller that restarted before saving its state, is never confirmed.
+// Callers must hold statesMu
+func (d *Deleter) confirmDeletions(ctx context.Context) {
+	if !d.opts.DeletionHandshake {
+		return
+	}
+	key, value, hasValue := config.SplitLabel(d.opts.ForceDeletionLabel)
+	for _, group := range d.states.Groups {
+		for _, nodeState := range group.Nodes {
+			if nodeState.State != Deleting {
+				continue
+			}
+			node, err := d.controller.NodeByName(nodeState.Name)
+			if err != nil || node == nil {
+				continue
+			}
+			ack, ok := node.Annotations[DeletionAckAnnotation]
+			if !ok {
+				continue
+			}
+			if confirmed, ok := node.Annotations[DeletionConfirmAnnotation]; ok && confirmed == ack {
+				continue
+			}
+			want := value
+			if !hasValue {
+				want = nodeState.DeletionToken
+			}
+			if label, ok := node.Labels[key]; !ok || label != ack || ack != want {
+				logrus.Debugf("Not confirming deletion of node %v, as it acknowledged '%v' rather than the deletion label the controller set", node.Name, ack)
+				continue
+			}
+			err = d.patchNode(ctx, node.Name, nodePatch{
+				Annotations: map[string]string{DeletionConfirmAnnotation: ack},
+			})
+			if err != nil {
+				logrus.Warnf("Error confirming deletion of node %v: %v", node.Name, err)
+				continue
+			}
+			logrus.Infof("Confirmed deletion of node %v to nodereaperd", node.Name)
+		}
+	}
+}
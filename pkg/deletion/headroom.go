@@ -0,0 +1,163 @@
+package deletion
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/ratelog"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	headroomScopeGroup   = "group"
+	headroomScopeCluster = "cluster"
+)
+
+// headroomResources are the resources checked before detaching a node
+var headroomResources = []core_v1.ResourceName{core_v1.ResourceCPU, core_v1.ResourceMemory}
+
+// podRequests sums the requests of every container in the pod
+func podRequests(pod *core_v1.Pod) core_v1.ResourceList {
+	total := core_v1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			sum := total[name]
+			sum.Add(quantity)
+			total[name] = sum
+		}
+	}
+	return total
+}
+
+// evictedRequests sums the requests of the pods that would need to be rescheduled if the
+// node went away. DaemonSet and static pods are not rescheduled elsewhere
+func (d *Deleter) evictedRequests(node *core_v1.Node) (core_v1.ResourceList, error) {
+	pods, err := d.controller.PodsOnNode(node.Name)
+	if err != nil {
+		return nil, err
+	}
+	total := core_v1.ResourceList{}
+	for _, pod := range pods {
+		if !podActive(pod) || !podRescheduled(pod) {
+			continue
+		}
+		for name, quantity := range podRequests(pod) {
+			sum := total[name]
+			sum.Add(quantity)
+			total[name] = sum
+		}
+	}
+	return total, nil
+}
+
+// freeCapacity returns the allocatable resources on the node not yet requested by its pods
+func (d *Deleter) freeCapacity(node *core_v1.Node) (core_v1.ResourceList, error) {
+	pods, err := d.controller.PodsOnNode(node.Name)
+	if err != nil {
+		return nil, err
+	}
+	free := core_v1.ResourceList{}
+	for name, quantity := range node.Status.Allocatable {
+		free[name] = quantity.DeepCopy()
+	}
+	for _, pod := range pods {
+		if !podActive(pod) {
+			continue
+		}
+		for name, quantity := range podRequests(pod) {
+			remaining := free[name]
+			remaining.Sub(quantity)
+			free[name] = remaining
+		}
+	}
+	return free, nil
+}
+
+// insufficientHeadroom returns true if the group's headroomCheck is enabled and the other
+// nodes in scope don't have enough free CPU and memory, in total, for the node's pods.
+// This is an aggregate check, so it can pass even though no single node fits a large pod
+func (d *Deleter) insufficientHeadroom(node *core_v1.Node) bool {
+	groupName := d.groupName(node)
+	scope := d.opts.GetString(groupName, "headroomCheck")
+	if scope != headroomScopeGroup && scope != headroomScopeCluster {
+		if scope != "" {
+			ratelog.Errorf("headroom-check/"+groupName, "Unknown headroomCheck %v for group %v, expected %v or %v", scope, groupName, headroomScopeGroup, headroomScopeCluster)
+		}
+		return false
+	}
+
+	needed, err := d.evictedRequests(node)
+	if err != nil {
+		ratelog.Warnf("headroom/"+node.Name, "Could not check headroom for node %v: %v", node.Name, err)
+		return false
+	}
+
+	// Without an instance group label every node is in the same group, unless split up by group rules
+	var others []*core_v1.Node
+	if scope == headroomScopeGroup && d.opts.InstanceGroupLabel != "" && len(d.groupRules) == 0 {
+		others, err = d.controller.NodesInGroup(groupName)
+	} else {
+		others, err = d.controller.ListNodes()
+		if err == nil && scope == headroomScopeGroup {
+			key := d.nodeGroupKey(node)
+			inGroup := []*core_v1.Node{}
+			for _, other := range others {
+				if d.nodeGroupKey(other) == key {
+					inGroup = append(inGroup, other)
+				}
+			}
+			others = inGroup
+		}
+	}
+	if err != nil {
+		ratelog.Warnf("headroom/"+node.Name, "Could not check headroom for node %v: %v", node.Name, err)
+		return false
+	}
+	available := core_v1.ResourceList{}
+	for _, other := range others {
+		if other.Name == node.Name || other.Spec.Unschedulable || !nodeReady(other) || d.leaving(other.Name) {
+			continue
+		}
+		free, err := d.freeCapacity(other)
+		if err != nil {
+			ratelog.Warnf("headroom/"+node.Name, "Could not check headroom for node %v: %v", node.Name, err)
+			return false
+		}
+		for _, name := range headroomResources {
+			sum := available[name]
+			if quantity, ok := free[name]; ok && quantity.Sign() > 0 {
+				sum.Add(quantity)
+			}
+			available[name] = sum
+		}
+	}
+
+	for _, name := range headroomResources {
+		need, ok := needed[name]
+		if !ok {
+			continue
+		}
+		have := available[name]
+		if have.Cmp(need) < 0 {
+			logrus.Debugf("Holding node %v: its pods request %v %v, but only %v is free in its %v", node.Name, need.String(), name, have.String(), scope)
+			return true
+		}
+	}
+	return false
+}
+
+// leaving returns true if the node was already being removed at the start of this poll
+func (d *Deleter) leaving(nodeName string) bool {
+	_, ok := d.leavingNodes[nodeName]
+	return ok
+}
+
+// podRescheduled returns false for pods that are tied to their node
+func podRescheduled(pod *core_v1.Pod) bool {
+	if _, ok := pod.Annotations[MirrorPodAnnotation]; ok {
+		return false
+	}
+	if owner := meta_v1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
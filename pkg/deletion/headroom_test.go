@@ -0,0 +1,68 @@
+package deletion
+
+import (
+	"testing"
+
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/controller/controllertest"
+
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestInsufficientHeadroom(t *testing.T) {
+	newNode := func(name, cpu string) *core_v1.Node {
+		return &core_v1.Node{
+			ObjectMeta: meta_v1.ObjectMeta{Name: name},
+			Status: core_v1.NodeStatus{
+				Allocatable: core_v1.ResourceList{
+					core_v1.ResourceCPU:    resource.MustParse(cpu),
+					core_v1.ResourceMemory: resource.MustParse("16Gi"),
+				},
+				Conditions: []core_v1.NodeCondition{{Type: core_v1.NodeReady, Status: core_v1.ConditionTrue}},
+			},
+		}
+	}
+	newPod := func(name, nodeName, cpu string) *core_v1.Pod {
+		return &core_v1.Pod{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: core_v1.PodSpec{
+				NodeName: nodeName,
+				Containers: []core_v1.Container{{Resources: core_v1.ResourceRequirements{
+					Requests: core_v1.ResourceList{core_v1.ResourceCPU: resource.MustParse(cpu)},
+				}}},
+			},
+			Status: core_v1.PodStatus{Phase: core_v1.PodRunning},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		headroom string
+		other    func(*core_v1.Node)
+		otherCPU string
+		held     bool
+	}{
+		{"enough headroom", "cluster", nil, "4", false},
+		{"too little headroom", "cluster", nil, "2", true},
+		{"check disabled", "", nil, "2", false},
+		{"cordoned node doesn't count", "cluster", func(n *core_v1.Node) { n.Spec.Unschedulable = true }, "4", true},
+		{"not ready node doesn't count", "cluster", func(n *core_v1.Node) { n.Status.Conditions[0].Status = core_v1.ConditionFalse }, "4", true},
+	}
+	for _, test := range tests {
+		node := newNode("node", "4")
+		other := newNode("other", test.otherCPU)
+		if test.other != nil {
+			test.other(other)
+		}
+		// The node's pod needs 3 CPUs, and the other node's pod already takes 1
+		pods := []*core_v1.Pod{newPod("leaving", "node", "3"), newPod("staying", "other", "1")}
+		opts := &config.Ops{}
+		opts.Load(map[string]string{"global.headroomCheck": test.headroom})
+		d := &Deleter{opts: opts, controller: controllertest.New([]*core_v1.Node{node, other}, pods)}
+		if held := d.insufficientHeadroom(node); held != test.held {
+			t.Errorf("%v: expected held=%v, got %v", test.name, test.held, held)
+		}
+	}
+}
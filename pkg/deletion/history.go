@@ -0,0 +1,154 @@
+package deletion
+
+import (
+	"sync"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/metrics"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// maxHistoryEntries bounds the history kept in memory and in the state configmap
+	maxHistoryEntries = 500
+
+	// OutcomeDeleted means the node is gone. With --verify-deletions, it is followed by
+	// OutcomeVerified or OutcomeIncomplete once the deletion is checked
+	OutcomeDeleted    = "deleted"
+	OutcomeVerified   = "verified"
+	OutcomeIncomplete = "incomplete"
+	// OutcomeRebooted means nodereaperd rebooted the node, and it's back in service
+	OutcomeRebooted = "rebooted"
+)
+
+// HistoryEntry records a node that the controller handed to nodereaperd for deletion
+type HistoryEntry struct {
+	Node        string         `json:"node"`
+	Group       string         `json:"group"`
+	Reason      metrics.Reason `json:"reason,omitempty"`
+	RequestedBy string         `json:"requestedBy,omitempty"`
+	DeletionID  string         `json:"deletionID,omitempty"`
+	Time        time.Time      `json:"time"`
+	// Completed is when the node was gone, and Duration how long that took since Time
+	Completed *time.Time `json:"completed,omitempty"`
+	Duration  string     `json:"duration,omitempty"`
+	// Outcome is empty while the node is being deleted
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// history is a bounded, oldest-first log of deletions. It is appended to from
+// StateTransitionFunction, which runs concurrently for every group
+type history struct {
+	mu      sync.Mutex
+	loaded  bool
+	records []HistoryEntry
+}
+
+func newHistory() *history {
+	return &history{
+		records: []HistoryEntry{},
+	}
+}
+
+// adopt takes the history persisted by a previous controller, once
+func (h *history) adopt(old []HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.loaded {
+		return
+	}
+	h.loaded = true
+	h.records = append(old, h.records...)
+	h.trim()
+}
+
+// reset forgets every entry, so the next adopt loads them again
+func (h *history) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.loaded = false
+	h.records = nil
+}
+
+func (h *history) record(e HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, e)
+	h.trim()
+}
+
+// complete records that the node is gone, on its latest entry
+func (h *history) complete(node string, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e := h.latest(node); e != nil && e.Completed == nil {
+		e.Completed = &at
+		e.Duration = at.Sub(e.Time).Round(time.Second).String()
+		e.Outcome = OutcomeDeleted
+	}
+}
+
+// setOutcome sets the outcome of the node's latest entry
+func (h *history) setOutcome(node, outcome string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e := h.latest(node); e != nil {
+		e.Outcome = outcome
+	}
+}
+
+func (h *history) latest(node string) *HistoryEntry {
+	for i := len(h.records) - 1; i >= 0; i-- {
+		if h.records[i].Node == node {
+			return &h.records[i]
+		}
+	}
+	return nil
+}
+
+func (h *history) trim() {
+	if len(h.records) > maxHistoryEntries {
+		h.records = h.records[len(h.records)-maxHistoryEntries:]
+	}
+}
+
+func (h *history) entries() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ret := make([]HistoryEntry, len(h.records))
+	copy(ret, h.records)
+	return ret
+}
+
+// History returns the most recent deletions, oldest first
+func (d *Deleter) History() []HistoryEntry {
+	return d.history.entries()
+}
+
+// FilterHistory returns the entries handed to nodereaperd within [since, until). A zero time leaves that end open
+func FilterHistory(entries []HistoryEntry, since, until time.Time) []HistoryEntry {
+	ret := []HistoryEntry{}
+	for _, e := range entries {
+		if (since.IsZero() || !e.Time.Before(since)) && (until.IsZero() || e.Time.Before(until)) {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func (d *Deleter) historyEntry(node *core_v1.Node) HistoryEntry {
+	e := HistoryEntry{
+		Node:  node.Name,
+		Group: d.groupName(node),
+		Time:  time.Now(),
+	}
+	for _, group := range d.states.Groups {
+		if nodeState, ok := group.Nodes[node.Name]; ok {
+			e.Reason = d.deletionReason(nodeState, node)
+			e.RequestedBy = nodeState.RequestedBy
+			e.DeletionID = nodeState.DeletionID
+			break
+		}
+	}
+	return e
+}
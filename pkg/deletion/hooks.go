@@ -0,0 +1,106 @@
+package deletion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/metrics"
+	"github.com/wish/nodereaper/pkg/ratelog"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// HookRequest is POSTed to each of a group's preDrainHooks before a node is drained
+type HookRequest struct {
+	Node        string         `json:"node"`
+	Group       string         `json:"group"`
+	Reason      metrics.Reason `json:"reason,omitempty"`
+	ProviderID  string         `json:"providerID,omitempty"`
+	InternalIPs []string       `json:"internalIPs,omitempty"`
+	ExternalIPs []string       `json:"externalIPs,omitempty"`
+}
+
+// runPreDrainHooks calls every preDrainHook of the node's group in order, e.g. to deregister
+// it from an external load balancer. Each hook is retried with backoff; if one still fails,
+// the node isn't drained and the hooks are run again on the next poll
+func (d *Deleter) runPreDrainHooks(ctx context.Context, node *core_v1.Node) error {
+	groupName := d.groupName(node)
+	hooks := d.opts.GetString(groupName, "preDrainHooks")
+	if hooks == "" {
+		return nil
+	}
+	timeout := 30 * time.Second
+	if t := d.opts.GetDuration(groupName, "preDrainHookTimeout"); t != nil {
+		timeout = *t
+	}
+	retries, err := strconv.Atoi(d.opts.GetString(groupName, "preDrainHookRetries"))
+	if err != nil {
+		ratelog.Errorf("pre-drain-hook-retries/"+groupName, "Could not parse preDrainHookRetries for group %v: %v", groupName, err)
+		retries = 0
+	}
+
+	entry := d.historyEntry(node)
+	req := HookRequest{
+		Node:       node.Name,
+		Group:      entry.Group,
+		Reason:     entry.Reason,
+		ProviderID: node.Spec.ProviderID,
+	}
+	for _, address := range node.Status.Addresses {
+		switch address.Type {
+		case core_v1.NodeInternalIP:
+			req.InternalIPs = append(req.InternalIPs, address.Address)
+		case core_v1.NodeExternalIP:
+			req.ExternalIPs = append(req.ExternalIPs, address.Address)
+		}
+	}
+	body, _ := json.Marshal(req)
+
+	for _, url := range strings.Split(hooks, ",") {
+		url = strings.TrimSpace(url)
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			err = callHook(ctx, url, body, timeout)
+			if err == nil || attempt >= retries {
+				break
+			}
+			logrus.Warnf("Pre-drain hook %v failed for node %v, retrying in %v: %v", url, node.Name, backoff, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err != nil {
+			return fmt.Errorf("Pre-drain hook %v failed for node %v: %v", url, node.Name, err)
+		}
+		logrus.Infof("Pre-drain hook %v succeeded for node %v", url, node.Name)
+	}
+	return nil
+}
+
+func callHook(ctx context.Context, url string, body []byte, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("hook returned %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package deletion
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// instanceImpaired returns true if the provider reports the node's instance has been failing
+// its status checks for longer than the group's instanceImpairedPeriod
+func (d *Deleter) instanceImpaired(node *core_v1.Node) bool {
+	period := d.opts.GetDuration(d.groupName(node), "instanceImpairedPeriod")
+	if period == nil || node.Spec.ProviderID == "" {
+		return false
+	}
+	since, impaired := d.provider.InstanceImpaired(node)
+	if !impaired {
+		return false
+	}
+	if impairedFor := time.Since(since); impairedFor < *period {
+		logrus.Debugf("Node %v has been impaired for %v", node.Name, impairedFor)
+		return false
+	}
+	logrus.Tracef("Node %v has been failing its instance status checks since %v", node.Name, since)
+	return true
+}
@@ -0,0 +1,146 @@
+package deletion
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/metrics"
+)
+
+const (
+	// DeletedPhase is published when a node that was being deleted leaves the cluster
+	DeletedPhase = "deleted"
+	// FailedPhase is published when a transition fails, with the error. Repeats of the same
+	// error for a node are only published once
+	FailedPhase = "failed"
+)
+
+// Notifier is told about each lifecycle event, e.g. to post it to a chat or monitoring system
+type Notifier interface {
+	Notify(ctx context.Context, e LifecycleEvent) error
+}
+
+// Publisher sends messages to downstream consumers, e.g. an SNS topic
+type Publisher interface {
+	Publish(ctx context.Context, message string) error
+}
+
+// PublisherNotifier publishes lifecycle events as JSON messages
+func PublisherNotifier(p Publisher) Notifier {
+	return &publisherNotifier{p}
+}
+
+type publisherNotifier struct {
+	publisher Publisher
+}
+
+func (n *publisherNotifier) Notify(ctx context.Context, e LifecycleEvent) error {
+	message, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return n.publisher.Publish(ctx, string(message))
+}
+
+// LifecycleEvent is published each time a node moves through deletion
+type LifecycleEvent struct {
+	Node          string         `json:"node"`
+	Group         string         `json:"group"`
+	Reason        metrics.Reason `json:"reason,omitempty"`
+	RequestedBy   string         `json:"requestedBy,omitempty"`
+	DeletionID    string         `json:"deletionID,omitempty"`
+	Phase         string         `json:"phase"`
+	PreviousPhase string         `json:"previousPhase,omitempty"`
+	Time          time.Time      `json:"time"`
+	NodeCreated   *time.Time     `json:"nodeCreated,omitempty"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// AddNotifier adds a destination for lifecycle events
+func (d *Deleter) AddNotifier(n Notifier) {
+	d.notifiers = append(d.notifiers, n)
+}
+
+// failures remembers the last error of each node's failing transition, so it's only published once
+type failures struct {
+	mu   sync.Mutex
+	last map[string]string
+}
+
+// changed records the node's latest error, which is empty on success,
+// and returns true if it's different from the last one
+func (f *failures) changed(nodeName, err string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.last[nodeName] == err {
+		return false
+	}
+	if err == "" {
+		delete(f.last, nodeName)
+	} else {
+		f.last[nodeName] = err
+	}
+	return true
+}
+
+// notifyTransitions notifies of every successful transition of a node, and of new transition errors
+func (d *Deleter) notifyTransitions(f StateTransitionFunction) StateTransitionFunction {
+	return func(ctx context.Context, nodeName string, oldState, newState State) (bool, error) {
+		ok, err := f(ctx, nodeName, oldState, newState)
+		if len(d.notifiers) == 0 {
+			return ok, err
+		}
+		phase := string(newState)
+		switch {
+		case err != nil:
+			if !d.transitionFailures.changed(nodeName, err.Error()) {
+				return ok, err
+			}
+			phase = FailedPhase
+		case ok:
+			d.transitionFailures.changed(nodeName, "")
+		default:
+			return ok, err
+		}
+
+		node, _ := d.controller.NodeByName(nodeName)
+		if node == nil {
+			return ok, err
+		}
+		entry := d.historyEntry(node)
+		created := node.CreationTimestamp.Time
+		e := LifecycleEvent{
+			Node:          nodeName,
+			Group:         entry.Group,
+			Reason:        entry.Reason,
+			RequestedBy:   entry.RequestedBy,
+			DeletionID:    entry.DeletionID,
+			Phase:         phase,
+			PreviousPhase: string(oldState),
+			Time:          entry.Time,
+			NodeCreated:   &created,
+		}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		d.notify(ctx, e)
+		return ok, err
+	}
+}
+
+// notify sends the event to every notifier in the background, so a slow or failing
+// destination never holds up deletions
+func (d *Deleter) notify(ctx context.Context, e LifecycleEvent) {
+	for _, n := range d.notifiers {
+		go func(n Notifier) {
+			ctx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+			defer cancel()
+			if err := n.Notify(ctx, e); err != nil {
+				logrus.Errorf("Error sending %v event for node %v: %v", e.Phase, e.Node, err)
+			}
+		}(n)
+	}
+}
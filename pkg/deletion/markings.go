@@ -0,0 +1,95 @@
+package deletion
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/config"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DeletionReasonAnnotation is set on nodes nodereaper wants to delete, to why. That's the
+	// operator's reason for requested deletions, or one of the metrics reasons otherwise
+	DeletionReasonAnnotation = "nodereaper.wish.com/deletion-reason"
+	// DeletionRequesterAnnotation is set to who requested the deletion, if anyone did
+	DeletionRequesterAnnotation = "nodereaper.wish.com/deletion-requested-by"
+	// DeletionActionAnnotation is set to how the node is going to be removed:
+	// delete-machine, drain-and-terminate, drain-and-power-off or drain-and-reboot
+	DeletionActionAnnotation = "nodereaper.wish.com/deletion-action"
+
+	actionDeleteMachine     = "delete-machine"
+	actionDrainAndTerminate = "drain-and-terminate"
+	actionDrainAndPowerOff  = "drain-and-power-off"
+	actionDrainAndReboot    = "drain-and-reboot"
+)
+
+var markingAnnotations = []string{DeletionReasonAnnotation, DeletionRequesterAnnotation, DeletionActionAnnotation, config.DeletionIDAnnotation}
+
+// markNodes writes why and how each node nodereaper wants to delete is going to be removed onto
+// the node, for tools like k9s and for debugging on the node, and removes them from nodes it no
+// longer wants to delete. Nodes are only patched if that changes anything. Callers must hold statesMu
+func (d *Deleter) markNodes(ctx context.Context) {
+	for _, group := range d.states.Groups {
+		for _, nodeState := range group.Nodes {
+			node, err := d.controller.NodeByName(nodeState.Name)
+			if err != nil || node == nil {
+				continue
+			}
+			patch := nodePatch{}
+			if nodeState.State == DontWantDelete {
+				for _, key := range markingAnnotations {
+					if _, ok := node.Annotations[key]; ok {
+						patch.RemoveAnnotations = append(patch.RemoveAnnotations, key)
+					}
+				}
+			} else {
+				for key, value := range d.markings(nodeState, node) {
+					if current, ok := node.Annotations[key]; !ok || current != value {
+						if patch.Annotations == nil {
+							patch.Annotations = map[string]string{}
+						}
+						patch.Annotations[key] = value
+					}
+				}
+				if _, ok := node.Annotations[DeletionRequesterAnnotation]; ok && nodeState.RequestedBy == "" {
+					patch.RemoveAnnotations = append(patch.RemoveAnnotations, DeletionRequesterAnnotation)
+				}
+			}
+			if len(patch.Annotations) == 0 && len(patch.RemoveAnnotations) == 0 {
+				continue
+			}
+			if err := d.patchNode(ctx, node.Name, patch); err != nil {
+				logrus.Warnf("Error annotating node %v with its deletion reason: %v", node.Name, err)
+			}
+		}
+	}
+}
+
+// markings returns the annotations of a node nodereaper wants to delete
+func (d *Deleter) markings(nodeState *NodeState, node *core_v1.Node) map[string]string {
+	reason := nodeState.RequestedReason
+	if reason == "" {
+		reason = string(d.deletionReason(nodeState, node))
+	}
+	action := actionDrainAndPowerOff
+	if _, _, ok := nodeMachine(node); ok {
+		action = actionDeleteMachine
+	} else if d.drainMode(node) == drainModeServer {
+		action = actionDrainAndTerminate
+	} else if d.rebootsInPlace(node) {
+		action = actionDrainAndReboot
+	}
+
+	annotations := map[string]string{
+		DeletionReasonAnnotation: reason,
+		DeletionActionAnnotation: action,
+	}
+	if nodeState.RequestedBy != "" {
+		annotations[DeletionRequesterAnnotation] = nodeState.RequestedBy
+	}
+	if nodeState.DeletionID != "" {
+		annotations[config.DeletionIDAnnotation] = nodeState.DeletionID
+	}
+	return annotations
+}
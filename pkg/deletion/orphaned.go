@@ -0,0 +1,68 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/ratelog"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// orphanedGroupIgnore only logs that a group no longer exists, at debug level
+	orphanedGroupIgnore = "ignore"
+	// orphanedGroupAlert records a warning event and sets nodereaper_instance_group_orphaned
+	orphanedGroupAlert = "alert"
+	// orphanedGroupDelete also deletes the group's nodes after orphanedGroupGracePeriod
+	orphanedGroupDelete = "delete"
+)
+
+// checkOrphaned records whether the group's instance group still exists with the provider,
+// e.g. because its ASG was deleted or renamed. Callers must hold statesMu
+func (d *Deleter) checkOrphaned(ctx context.Context, group *Group) {
+	exists, err := d.provider.GroupExists(group.Name)
+	if err != nil {
+		logrus.Debugf("Could not check if group %v exists: %v", group.Name, err)
+		return
+	}
+	if exists {
+		if !group.OrphanedSince.IsZero() {
+			logrus.Infof("Group %v exists again", group.Name)
+		}
+		group.OrphanedSince = time.Time{}
+		return
+	}
+	if !group.OrphanedSince.IsZero() {
+		return
+	}
+	group.OrphanedSince = time.Now()
+
+	msg := fmt.Sprintf("Instance group %v of %v nodes no longer exists, it may have been deleted or renamed", group.Name, group.size())
+	switch policy := d.opts.GetString(group.Name, "orphanedGroupPolicy"); policy {
+	case orphanedGroupIgnore:
+		logrus.Debug(msg)
+	case orphanedGroupDelete:
+		gracePeriod := time.Duration(0)
+		if gp := d.opts.GetDuration(group.Name, "orphanedGroupGracePeriod"); gp != nil {
+			gracePeriod = *gp
+		}
+		d.recordControllerEvent(ctx, core_v1.EventTypeWarning, "OrphanedGroup", fmt.Sprintf("%v. Its nodes will be deleted if it doesn't come back within %v", msg, gracePeriod))
+	default:
+		if policy != orphanedGroupAlert {
+			ratelog.Errorf("orphaned-group-policy/"+group.Name, "Unknown orphanedGroupPolicy %v for group %v, expected %v, %v or %v", policy, group.Name, orphanedGroupIgnore, orphanedGroupAlert, orphanedGroupDelete)
+		}
+		d.recordControllerEvent(ctx, core_v1.EventTypeWarning, "OrphanedGroup", msg)
+	}
+}
+
+// orphanedGroupExpired returns true if the group has been missing for its orphanedGroupGracePeriod,
+// and its orphanedGroupPolicy is to delete its nodes
+func (d *Deleter) orphanedGroupExpired(group *Group) bool {
+	if group.OrphanedSince.IsZero() || d.opts.GetString(group.Name, "orphanedGroupPolicy") != orphanedGroupDelete {
+		return false
+	}
+	gracePeriod := d.opts.GetDuration(group.Name, "orphanedGroupGracePeriod")
+	return gracePeriod == nil || time.Since(group.OrphanedSince) >= *gracePeriod
+}
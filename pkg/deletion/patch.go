@@ -0,0 +1,99 @@
+package deletion
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	k8s_types "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// patchAttempts is how many times a node patch is tried before giving up until the next poll
+	patchAttempts = 5
+	// patchBackoff is the wait before the first retry, doubled for every retry after that
+	patchBackoff = 200 * time.Millisecond
+)
+
+// nodePatch is a set of changes to a node, sent together as a single merge patch
+type nodePatch struct {
+	Labels        map[string]string
+	Annotations   map[string]string
+	Unschedulable *bool
+	// RemoveAnnotations and RemoveLabels are deleted from the node
+	RemoveAnnotations []string
+	RemoveLabels      []string
+}
+
+func (p nodePatch) marshal() []byte {
+	metadata := map[string]interface{}{}
+	if len(p.Labels) > 0 || len(p.RemoveLabels) > 0 {
+		labels := map[string]interface{}{}
+		for key, value := range p.Labels {
+			labels[key] = value
+		}
+		for _, key := range p.RemoveLabels {
+			labels[key] = nil
+		}
+		metadata["labels"] = labels
+	}
+	if len(p.Annotations) > 0 || len(p.RemoveAnnotations) > 0 {
+		annotations := map[string]interface{}{}
+		for key, value := range p.Annotations {
+			annotations[key] = value
+		}
+		for _, key := range p.RemoveAnnotations {
+			annotations[key] = nil
+		}
+		metadata["annotations"] = annotations
+	}
+	patch := map[string]interface{}{}
+	if len(metadata) > 0 {
+		patch["metadata"] = metadata
+	}
+	if p.Unschedulable != nil {
+		patch["spec"] = map[string]interface{}{"unschedulable": *p.Unschedulable}
+	}
+	data, _ := json.Marshal(patch)
+	return data
+}
+
+// patchNode applies the patch to the node, retrying conflicts and transient API errors with
+// exponential backoff. Each attempt is bounded by the API timeout
+func (d *Deleter) patchNode(ctx context.Context, nodeName string, patch nodePatch) error {
+	data := patch.marshal()
+	backoff := patchBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+		_, err = d.controller.PatchNode(callCtx, nodeName, k8s_types.MergePatchType, data)
+		// Only this attempt timed out, not the whole operation
+		timedOut := callCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if err == nil || attempt == patchAttempts || !(timedOut || retriablePatchError(err)) {
+			d.metrics.RecordNodePatch(attempt-1, err != nil)
+			return err
+		}
+
+		logrus.Debugf("Retrying patch of node %v in %v after error: %v", nodeName, backoff, err)
+		select {
+		case <-ctx.Done():
+			d.metrics.RecordNodePatch(attempt-1, true)
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retriablePatchError returns true for errors that may go away on their own
+func retriablePatchError(err error) bool {
+	return k8s_errors.IsConflict(err) ||
+		k8s_errors.IsServerTimeout(err) ||
+		k8s_errors.IsTimeout(err) ||
+		k8s_errors.IsTooManyRequests(err) ||
+		k8s_errors.IsInternalError(err) ||
+		k8s_errors.IsServiceUnavailable(err)
+}
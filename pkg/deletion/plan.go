@@ -0,0 +1,116 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// planner stands in for StateTransitionFunction during a plan. It evaluates
+// which nodes we want to delete for real, but only records every other transition
+type planner struct {
+	d       *Deleter
+	mu      sync.Mutex
+	actions map[string]State
+}
+
+func (p *planner) transition(ctx context.Context, nodeName string, oldState, newState State) (bool, error) {
+	// Deciding whether we want to delete a node has no side effects
+	if oldState == DontWantDelete && newState == WantDelete {
+		return p.d.StateTransitionFunction(ctx, nodeName, oldState, newState)
+	}
+	// Neither does checking whether the node is held where it is
+	if node, err := p.d.controller.NodeByName(nodeName); err == nil && node != nil && p.d.deletionHeld(node, oldState, newState) {
+		return false, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.actions[nodeName] = newState
+	return true, nil
+}
+
+// Plan runs a single evaluation pass without acting on or persisting anything,
+// and writes a human readable summary of what the controller would do to w
+func (d *Deleter) Plan(ctx context.Context, w io.Writer) error {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	if err := d.refreshStates(ctx); err != nil {
+		return err
+	}
+
+	p := &planner{
+		d:       d,
+		actions: make(map[string]State),
+	}
+	d.states.Advance(ctx, p.transition)
+
+	groups := []*Group{}
+	for _, group := range d.states.Groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Key < groups[j].Key
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, group := range groups {
+		status := d.groupStatus(group)
+		desired := "unknown"
+		if status.DesiredSize != nil {
+			desired = fmt.Sprint(*status.DesiredSize)
+		}
+		fmt.Fprintf(tw, "Group %v: %v nodes, desired %v, maxSurge %v, maxUnavailable %v, deletion enabled %v, paused %v\n",
+			displayGroupName(group.Name), group.size(), desired, group.MaxSurge, group.MaxUnavailable, status.DeletionEnabled, group.Paused)
+
+		numListed := 0
+		for _, node := range status.Nodes {
+			if node.State == DontWantDelete {
+				continue
+			}
+			numListed++
+
+			action := "wait"
+			if newState, ok := p.actions[node.Name]; ok {
+				action = planAction(newState)
+			}
+			blockers := []string{}
+			for _, b := range node.Blockers {
+				blockers = append(blockers, string(b))
+			}
+			reason := string(node.Reason)
+			if reason == "" {
+				reason = "-"
+			}
+			fmt.Fprintf(tw, "  %v\t%v\t%v\t%v\t%v\n", action, node.Name, node.State, reason, strings.Join(blockers, ","))
+		}
+		if numListed == 0 {
+			fmt.Fprintf(tw, "  nothing to delete\n")
+		}
+	}
+	return tw.Flush()
+}
+
+func planAction(newState State) string {
+	switch newState {
+	case Detached:
+		return "detach"
+	case ReadyToDelete:
+		return "ready"
+	case Deleting:
+		return "delete"
+	}
+	return string(newState)
+}
+
+func displayGroupName(name string) string {
+	if name == "" {
+		return "<none>"
+	}
+	return name
+}
@@ -0,0 +1,122 @@
+package deletion
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// failingContainerReasons are the waiting reasons that point to a broken node rather than a broken workload,
+// when other replicas of the same workload are fine
+var failingContainerReasons = map[string]bool{
+	"CrashLoopBackOff":     true,
+	"CreateContainerError": true,
+	"RunContainerError":    true,
+}
+
+// podFailures tracks since when each node has had too many failing pods.
+// It is used from WantToDelete, which runs concurrently for every group
+type podFailures struct {
+	mu    sync.Mutex
+	since map[string]time.Time
+}
+
+// observe records whether the node has too many failing pods now, and returns since when it has
+func (p *podFailures) observe(nodeName string, failing bool, now time.Time) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !failing {
+		delete(p.since, nodeName)
+		return time.Time{}
+	}
+	since, ok := p.since[nodeName]
+	if !ok {
+		since = now
+		p.since[nodeName] = since
+	}
+	return since
+}
+
+// podFailing returns true if one of the pod's containers is stuck failing to start or run
+func podFailing(pod *core_v1.Pod) bool {
+	for _, statuses := range [][]core_v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.State.Waiting != nil && failingContainerReasons[status.State.Waiting.Reason] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// podReady returns true if the pod's Ready condition is true
+func podReady(pod *core_v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == core_v1.PodReady {
+			return condition.Status == core_v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// healthyElsewhere returns true if another pod of the same workload is Ready on another node
+func (d *Deleter) healthyElsewhere(pod *core_v1.Pod) bool {
+	owner := meta_v1.GetControllerOf(pod)
+	if owner == nil {
+		return false
+	}
+	siblings, err := d.controller.PodsByOwner(owner.UID)
+	if err != nil {
+		logrus.Warnf("Could not list pods owned by %v %v: %v", owner.Kind, owner.Name, err)
+		return false
+	}
+	for _, sibling := range siblings {
+		if sibling.Spec.NodeName != pod.Spec.NodeName && podActive(sibling) && podReady(sibling) {
+			return true
+		}
+	}
+	return false
+}
+
+// systemicPodFailures returns true if at least podFailureThreshold of the node's pods have been failing
+// for podFailurePeriod, while other replicas of the same workloads are healthy on other nodes
+func (d *Deleter) systemicPodFailures(node *core_v1.Node) bool {
+	groupName := d.groupName(node)
+	threshold := d.opts.GetString(groupName, "podFailureThreshold")
+	if threshold == "" {
+		return false
+	}
+	pods, err := d.controller.PodsOnNode(node.Name)
+	if err != nil {
+		logrus.Warnf("Could not list pods on node %v: %v", node.Name, err)
+		return false
+	}
+
+	numActive, numFailing := 0, 0
+	for _, pod := range pods {
+		if !podActive(pod) {
+			continue
+		}
+		numActive++
+		if podFailing(pod) && d.healthyElsewhere(pod) {
+			numFailing++
+		}
+	}
+	failing := numFailing > 0 && numFailing >= percentOrNumToNum(threshold, numActive, true)
+
+	now := time.Now()
+	since := d.podFailures.observe(node.Name, failing, now)
+	if !failing {
+		return false
+	}
+	period := d.opts.GetDuration(groupName, "podFailurePeriod")
+	if period != nil && now.Sub(since) < *period {
+		logrus.Debugf("%v of %v pods on node %v are failing since %v", numFailing, numActive, node.Name, since)
+		return false
+	}
+	logrus.Tracef("%v of %v pods on node %v have been failing since %v, while healthy on other nodes", numFailing, numActive, node.Name, since)
+	return true
+}
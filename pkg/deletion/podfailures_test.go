@@ -0,0 +1,38 @@
+package deletion
+
+import (
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+func TestPodFailing(t *testing.T) {
+	pod := &core_v1.Pod{}
+	pod.Status.ContainerStatuses = []core_v1.ContainerStatus{
+		{State: core_v1.ContainerState{Running: &core_v1.ContainerStateRunning{}}},
+		{State: core_v1.ContainerState{Waiting: &core_v1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+	}
+	if podFailing(pod) {
+		t.Errorf("Expected a pod whose containers are starting not to be failing")
+	}
+
+	pod.Status.ContainerStatuses[1].State.Waiting.Reason = "CrashLoopBackOff"
+	if !podFailing(pod) {
+		t.Errorf("Expected a pod with a container in CrashLoopBackOff to be failing")
+	}
+}
+
+func TestPodFailuresObserve(t *testing.T) {
+	p := &podFailures{since: map[string]time.Time{}}
+	start := time.Now()
+
+	p.observe("a", true, start)
+	if since := p.observe("a", true, start.Add(time.Minute)); !since.Equal(start) {
+		t.Errorf("Expected failures to be tracked since they were first seen, got %v", since)
+	}
+	p.observe("a", false, start.Add(2*time.Minute))
+	if since := p.observe("a", true, start.Add(3*time.Minute)); !since.Equal(start.Add(3 * time.Minute)) {
+		t.Errorf("Expected failures to be tracked again from scratch after recovering, got %v", since)
+	}
+}
@@ -0,0 +1,130 @@
+package deletion
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/ratelog"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// MirrorPodAnnotation is set by the kubelet on the mirror pods of its static pods
+	MirrorPodAnnotation = "kubernetes.io/config.mirror"
+)
+
+// podActive returns true for pods that still hold on to their node
+func podActive(pod *core_v1.Pod) bool {
+	return pod.Status.Phase != core_v1.PodSucceeded && pod.Status.Phase != core_v1.PodFailed
+}
+
+// singletonPods returns the pods on the node that have no other replica to take over
+// while the node drains: pods with no controller, and pods whose controller has no active pod on another node.
+// DaemonSet and static pods are skipped, since they are tied to the node anyway
+func (d *Deleter) singletonPods(node *core_v1.Node) ([]*core_v1.Pod, error) {
+	pods, err := d.controller.PodsOnNode(node.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Could not list pods on node %v: %v", node.Name, err)
+	}
+
+	singletons := []*core_v1.Pod{}
+	for _, pod := range pods {
+		if !podActive(pod) || !podRescheduled(pod) {
+			continue
+		}
+		owner := meta_v1.GetControllerOf(pod)
+		if owner == nil {
+			singletons = append(singletons, pod)
+			continue
+		}
+
+		siblings, err := d.controller.PodsByOwner(owner.UID)
+		if err != nil {
+			return nil, fmt.Errorf("Could not list pods owned by %v %v: %v", owner.Kind, owner.Name, err)
+		}
+		// Replicas on the same node drain along with the pod
+		replicated := false
+		for _, sibling := range siblings {
+			if podActive(sibling) && sibling.Spec.NodeName != node.Name {
+				replicated = true
+				break
+			}
+		}
+		if !replicated {
+			singletons = append(singletons, pod)
+		}
+	}
+	return singletons, nil
+}
+
+// hostsSingletonWorkload returns true if draining the node would take down a
+// non-replicated workload, and the group doesn't allow that. The node is held
+// if its pods can't be listed
+func (d *Deleter) hostsSingletonWorkload(node *core_v1.Node) bool {
+	groupName := d.groupName(node)
+	if d.opts.GetBool(groupName, "deleteSingletonWorkloads") {
+		return false
+	}
+	singletons, err := d.singletonPods(node)
+	if err != nil {
+		logrus.Warnf("Holding deletion of node %v: %v", node.Name, err)
+		return true
+	}
+	if len(singletons) == 0 {
+		return false
+	}
+	logrus.Debugf("Holding deletion of node %v, which hosts non-replicated pod %v/%v", node.Name, singletons[0].Namespace, singletons[0].Name)
+	return true
+}
+
+// criticalPods returns the active pods on the node matching the group's criticalPodSelector
+func (d *Deleter) criticalPods(node *core_v1.Node) []*core_v1.Pod {
+	groupName := d.groupName(node)
+	value := d.opts.GetString(groupName, "criticalPodSelector")
+	if value == "" {
+		return nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		ratelog.Errorf("critical-pod-selector/"+groupName, "Could not parse criticalPodSelector %v for group %v: %v", value, groupName, err)
+		return nil
+	}
+	pods, err := d.controller.PodsOnNode(node.Name)
+	if err != nil {
+		logrus.Warnf("Could not list pods on node %v: %v", node.Name, err)
+		return nil
+	}
+
+	critical := []*core_v1.Pod{}
+	for _, pod := range pods {
+		if podActive(pod) && selector.Matches(labels.Set(pod.Labels)) {
+			critical = append(critical, pod)
+		}
+	}
+	return critical
+}
+
+// deletionHeld returns true if the node must not move from oldState to newState yet,
+// because of the pods it is running, or because its instance can't be identified. It has no side effects
+func (d *Deleter) deletionHeld(node *core_v1.Node, oldState, newState State) bool {
+	// The provider can't detach or terminate an instance it can't identify
+	if node.Spec.ProviderID == "" && (newState == Detached || newState == ReadyToDelete) {
+		logrus.Debugf("Holding node %v in %v until it has a ProviderID", node.Name, oldState)
+		return true
+	}
+	if oldState == WantDelete {
+		if critical := d.criticalPods(node); len(critical) > 0 {
+			logrus.Debugf("Holding node %v in %v while it runs critical pod %v/%v", node.Name, oldState, critical[0].Namespace, critical[0].Name)
+			return true
+		}
+	}
+	if newState == ReadyToDelete && d.hostsSingletonWorkload(node) {
+		return true
+	}
+	if newState == Detached && d.insufficientHeadroom(node) {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package deletion
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// maxEvaluationAge is how long a group may go without being evaluated because nothing changed.
+	// It's a backstop for inputs the fingerprint doesn't cover: time-based triggers are in it
+	maxEvaluationAge = 5 * time.Minute
+)
+
+// groupPoll tracks when a group was last evaluated, and what its inputs were
+type groupPoll struct {
+	next        time.Time
+	evaluated   time.Time
+	fingerprint string
+	// nodeInputs caches the hash of each node's inputs, so only nodes that changed are hashed again
+	nodeInputs map[string]nodeInputs
+}
+
+// nodeInputs is the hash of a node's inputs at a resourceVersion
+type nodeInputs struct {
+	resourceVersion string
+	hash            string
+}
+
+// dueGroups returns the keys of the groups to advance in this poll: groups whose pollPeriod has
+// elapsed, unless every node is at rest and nothing about the group changed since it was last
+// evaluated. Groups with a podFailureThreshold are never skipped, since pods aren't in the
+// fingerprint. Callers must hold statesMu
+func (d *Deleter) dueGroups(now time.Time) []string {
+	due := []string{}
+	for key, group := range d.states.Groups {
+		poll, ok := d.groupPolls[key]
+		if !ok {
+			poll = &groupPoll{}
+			d.groupPolls[key] = poll
+		}
+		if now.Before(poll.next) {
+			continue
+		}
+		if poll.fingerprint != "" && now.Sub(poll.evaluated) < maxEvaluationAge && group.atRest() &&
+			d.opts.GetString(group.Name, "podFailureThreshold") == "" && d.groupFingerprint(group, poll, now) == poll.fingerprint {
+			logrus.Tracef("Skipping group %v, nothing changed since %v", group.Name, poll.evaluated)
+			poll.next = d.nextGroupPoll(group, now)
+			continue
+		}
+		due = append(due, key)
+	}
+
+	for key := range d.groupPolls {
+		if _, ok := d.states.Groups[key]; !ok {
+			delete(d.groupPolls, key)
+		}
+	}
+	return due
+}
+
+// boundGroups keeps the MaxGroupsPerPoll due groups that have been waiting the longest.
+// The rest stay due, and are evaluated in a later poll
+func (d *Deleter) boundGroups(due []string) []string {
+	limit := d.opts.MaxGroupsPerPoll
+	if limit <= 0 || len(due) <= limit {
+		return due
+	}
+	sort.Slice(due, func(i, j int) bool {
+		a, b := d.groupPolls[due[i]], d.groupPolls[due[j]]
+		if !a.next.Equal(b.next) {
+			return a.next.Before(b.next)
+		}
+		return due[i] < due[j]
+	})
+	logrus.Debugf("%v groups are due, evaluating only %v of them in this poll", len(due), limit)
+	return due[:limit]
+}
+
+// groupsEvaluated records that the groups were advanced. Callers must hold statesMu
+func (d *Deleter) groupsEvaluated(groupKeys []string, now time.Time) {
+	for _, key := range groupKeys {
+		group, ok := d.states.Groups[key]
+		poll, pollOk := d.groupPolls[key]
+		if !ok || !pollOk {
+			continue
+		}
+		poll.evaluated = now
+		poll.fingerprint = d.groupFingerprint(group, poll, now)
+		poll.next = d.nextGroupPoll(group, now)
+	}
+}
+
+// nextGroupPoll adds the group's pollPeriod and a random jitter of up to pollJitter of that period.
+// Without a pollPeriod, the group is polled every PollPeriod
+func (d *Deleter) nextGroupPoll(group *Group, now time.Time) time.Time {
+	period := d.opts.GetDuration(group.Name, "pollPeriod")
+	if period == nil || *period <= 0 {
+		return now
+	}
+	jitter := percentOrNumToNum(d.opts.GetString(group.Name, "pollJitter"), int(*period/time.Millisecond), false)
+	if jitter > 0 {
+		return now.Add(*period + time.Duration(rand.Intn(jitter+1))*time.Millisecond)
+	}
+	return now.Add(*period)
+}
+
+// invalidateReasons makes the reasons of nodes not yet wanted for deletion be evaluated again
+func (g *Group) invalidateReasons() {
+	for _, node := range g.Nodes {
+		if node.State == DontWantDelete {
+			node.reasonValid = false
+		}
+	}
+}
+
+// atRest returns true if no node in the group is being deleted or wanted for deletion
+func (g *Group) atRest() bool {
+	return g.stateCount(DontWantDelete) == g.size()
+}
+
+// groupFingerprint summarizes everything a group's evaluation depends on. The passing of time is
+// covered by the next time-based deadline of the group, which changes once it's past
+func (d *Deleter) groupFingerprint(group *Group, poll *groupPoll, now time.Time) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v|%v|%v|%v|%v|%v|%v|%v|%v|%v\n", group.NumDesired, group.MaxSurge, group.MaxUnavailable, group.Paused,
+		group.MinReadyNodes, group.ProtectLastN, group.scheduleAllowsDeletion(now.In(time.UTC)), d.states.Suspended, d.states.MaxTotalSurge,
+		group.OrphanedSince.Unix())
+
+	settings := d.opts.Settings(group.Name)
+	keys := []string{}
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(h, "%v=%v\n", key, settings[key])
+	}
+
+	names := []string{}
+	for name := range group.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	deadline := d.groupDeadline(group, now)
+	inputs := map[string]nodeInputs{}
+	for _, name := range names {
+		nodeState := group.Nodes[name]
+		fmt.Fprintf(h, "%v|%v|%v|%v\n", name, nodeState.State, nodeState.snoozed(), nodeState.Ready)
+		node, err := d.controller.NodeByName(name)
+		if err != nil || node == nil {
+			continue
+		}
+		cached, ok := poll.nodeInputs[name]
+		if !ok || cached.resourceVersion != node.ResourceVersion || node.ResourceVersion == "" {
+			nodeHash := sha256.New()
+			writeNodeInputs(nodeHash, node)
+			cached = nodeInputs{node.ResourceVersion, hex.EncodeToString(nodeHash.Sum(nil))}
+		}
+		inputs[name] = cached
+		fmt.Fprintln(h, cached.hash)
+
+		// The provider's view of the instance isn't part of the node
+		if d.opts.GetBool(group.Name, "deleteOldLaunchConfig") && node.Spec.ProviderID != "" {
+			outdated, err := d.provider.OutdatedLaunchConfig(d.opts, node)
+			fmt.Fprintf(h, "%v|%v\n", outdated, err != nil)
+		}
+		if d.opts.GetDuration(group.Name, "instanceImpairedPeriod") != nil && node.Spec.ProviderID != "" {
+			if since, impaired := d.provider.InstanceImpaired(node); impaired {
+				fmt.Fprintln(h, since.Unix())
+				deadline = earliestAfter(now, deadline, since.Add(*d.opts.GetDuration(group.Name, "instanceImpairedPeriod")))
+			}
+		}
+	}
+	poll.nodeInputs = inputs
+	fmt.Fprintln(h, deadline.Unix())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// groupDeadline returns the next time after now that a time-based reason to delete one of the group's
+// nodes comes due, like deletionAge, or the zero time if there's none
+func (d *Deleter) groupDeadline(group *Group, now time.Time) time.Time {
+	deadline := time.Time{}
+	if interval := d.recycleInterval(group); interval > 0 {
+		deadline = earliestAfter(now, deadline, group.LastRecycle.Add(interval))
+	}
+	if gracePeriod := d.opts.GetDuration(group.Name, "orphanedGroupGracePeriod"); gracePeriod != nil && !group.OrphanedSince.IsZero() {
+		deadline = earliestAfter(now, deadline, group.OrphanedSince.Add(*gracePeriod))
+	}
+	lifetime := d.opts.GetDuration("", "maxNodeLifetime")
+	cordonedAge := d.opts.GetDuration(group.Name, "cordonedDeletionAge")
+	for _, nodeState := range group.Nodes {
+		if t, ok := d.deletionAgeDeadline(group.Name, nodeState.Name, nodeState.since()); ok {
+			deadline = earliestAfter(now, deadline, t)
+		}
+		if lifetime != nil {
+			deadline = earliestAfter(now, deadline, nodeState.CreationTime.Add(*lifetime))
+		}
+		if cordonedAge != nil && nodeState.CordonedSince != nil {
+			deadline = earliestAfter(now, deadline, nodeState.CordonedSince.Add(*cordonedAge))
+		}
+		if nodeState.SnoozedUntil != nil {
+			deadline = earliestAfter(now, deadline, *nodeState.SnoozedUntil)
+		}
+	}
+	return deadline
+}
+
+// earliestAfter returns the earlier of deadline and t, ignoring t if it isn't after now.
+// A zero deadline is no deadline
+func earliestAfter(now, deadline, t time.Time) time.Time {
+	if !t.After(now) || (!deadline.IsZero() && !t.Before(deadline)) {
+		return deadline
+	}
+	return t
+}
+
+// writeNodeInputs writes the parts of a node that deletion decisions look at. The resourceVersion
+// isn't used, since it changes with every status update
+func writeNodeInputs(h io.Writer, node *core_v1.Node) {
+	for _, m := range []map[string]string{node.Labels, node.Annotations} {
+		keys := []string{}
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(h, "%v=%v;", key, m[key])
+		}
+		fmt.Fprintln(h)
+	}
+	for _, taint := range node.Spec.Taints {
+		fmt.Fprintf(h, "%v=%v:%v;", taint.Key, taint.Value, taint.Effect)
+	}
+	fmt.Fprintf(h, "\n%v|%v\n", node.Spec.Unschedulable, node.Spec.ProviderID)
+}
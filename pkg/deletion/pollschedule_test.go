@@ -0,0 +1,71 @@
+package deletion
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/controller/controllertest"
+	"github.com/wish/nodereaper/pkg/metrics"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBoundGroups(t *testing.T) {
+	now := time.Now()
+	d := &Deleter{
+		opts: &config.Ops{MaxGroupsPerPoll: 2},
+		groupPolls: map[string]*groupPoll{
+			"a": {next: now},
+			"b": {next: now.Add(-time.Minute)},
+			"c": {},
+		},
+	}
+
+	if due := d.boundGroups([]string{"a", "b", "c"}); !reflect.DeepEqual(due, []string{"c", "b"}) {
+		t.Errorf("Expected the groups waiting the longest, got %v", due)
+	}
+
+	d.opts.MaxGroupsPerPoll = 0
+	if due := d.boundGroups([]string{"a", "b", "c"}); len(due) != 3 {
+		t.Errorf("Expected every group without a limit, got %v", due)
+	}
+}
+
+func TestDueGroupsTimedTriggers(t *testing.T) {
+	start := time.Now()
+	group := newTestGroup("nodes", 1)
+	// The node reaches its deletionAge a minute after the group is evaluated
+	group.Nodes["nodes-0"].CreationTime = meta_v1.NewTime(start.Add(-59 * time.Minute))
+	node := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "nodes-0", Labels: map[string]string{"group": "nodes"}}}
+	node.Spec.ProviderID = "aws:///us-east-1a/i-0123"
+
+	opts := &config.Ops{InstanceGroupLabel: "group"}
+	opts.Load(map[string]string{"group.nodes.deletionAge": "1h", "group.nodes.deleteOldLaunchConfig": "true"})
+	provider := &fakeProvider{}
+	d := New(opts, controllertest.New([]*core_v1.Node{node}, nil), provider, nil, metrics.New())
+	d.states.Groups[group.Key] = group
+
+	if due := d.dueGroups(start); len(due) != 1 {
+		t.Fatalf("Expected a group never evaluated to be due, got %v", due)
+	}
+	d.groupsEvaluated([]string{group.Key}, start)
+	if due := d.dueGroups(start.Add(30 * time.Second)); len(due) != 0 {
+		t.Errorf("Expected the group to be skipped while nothing changed, got %v", due)
+	}
+	if due := d.dueGroups(start.Add(61 * time.Second)); len(due) != 1 {
+		t.Errorf("Expected the group to be due as soon as its node reached its deletionAge, got %v", due)
+	}
+
+	// A new launch configuration for the group makes it due right away too
+	d.groupsEvaluated([]string{group.Key}, start.Add(61*time.Second))
+	if due := d.dueGroups(start.Add(90 * time.Second)); len(due) != 0 {
+		t.Errorf("Expected the group to be skipped while nothing changed, got %v", due)
+	}
+	provider.outdated = true
+	if due := d.dueGroups(start.Add(91 * time.Second)); len(due) != 1 {
+		t.Errorf("Expected the group to be due once its launch configuration changed, got %v", due)
+	}
+}
@@ -0,0 +1,202 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/controller"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// PreDeleteHookNodeAnnotation is set on the Jobs of PreDeleteHooks to the node they were run for
+const PreDeleteHookNodeAnnotation = "nodereaper.wish.com/node"
+
+// runPreDeleteHooks makes sure a Job of each of the group's preDeleteHooks has succeeded for the node,
+// creating the ones that don't exist yet. It returns false until all of them succeeded, and an error
+// if any of them failed
+func (d *Deleter) runPreDeleteHooks(ctx context.Context, node *core_v1.Node) (bool, error) {
+	hooks := d.opts.GetString(d.groupName(node), "preDeleteHooks")
+	if hooks == "" {
+		return true, nil
+	}
+	done := true
+	for _, ref := range strings.Split(hooks, ",") {
+		namespace, name := d.opts.Namespace, strings.TrimSpace(ref)
+		if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+			namespace, name = parts[0], parts[1]
+		}
+		succeeded, err := d.runPreDeleteHook(ctx, node, namespace, name)
+		if err != nil {
+			return false, err
+		}
+		done = done && succeeded
+	}
+	return done, nil
+}
+
+func (d *Deleter) runPreDeleteHook(ctx context.Context, node *core_v1.Node, namespace, name string) (bool, error) {
+	job, err := d.preDeleteJobFor(ctx, node, namespace, preDeleteJobName(name, node.Name), func(ctx context.Context) (*controller.PreDeleteHook, error) {
+		hook, err := d.controller.GetPreDeleteHook(ctx, namespace, name)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting pre-delete hook %v/%v: %v", namespace, name, err)
+		}
+		return hook, nil
+	})
+	if job == nil {
+		return false, err
+	}
+
+	complete, failure := jobFinished(job)
+	if failure != nil {
+		return false, fmt.Errorf("Pre-delete hook job %v/%v failed for node %v: %v. Delete the job to run it again", namespace, job.Name, node.Name, failure.Message)
+	}
+	if !complete {
+		logrus.Debugf("Waiting for pre-delete hook job %v/%v of node %v", namespace, job.Name, node.Name)
+	}
+	return complete, nil
+}
+
+// runPreDeleteJobTemplate runs the group's preDeleteJobTemplate on the node, with a deadline of
+// preDeleteJobTimeout. It returns false until the job completed or ran out of time, and an error
+// if it failed any other way
+func (d *Deleter) runPreDeleteJobTemplate(ctx context.Context, node *core_v1.Node) (bool, error) {
+	groupName := d.groupName(node)
+	template := d.opts.GetString(groupName, "preDeleteJobTemplate")
+	if template == "" {
+		return true, nil
+	}
+	jobName := preDeleteJobName("nodereaper-pre-delete", node.Name)
+	job, err := d.preDeleteJobFor(ctx, node, d.opts.Namespace, jobName, func(context.Context) (*controller.PreDeleteHook, error) {
+		spec := batch_v1.JobSpec{}
+		if err := yaml.Unmarshal([]byte(template), &spec); err != nil {
+			return nil, fmt.Errorf("Could not parse preDeleteJobTemplate of group %v: %v", groupName, err)
+		}
+		timeout := 30 * time.Minute
+		if t := d.opts.GetDuration(groupName, "preDeleteJobTimeout"); t != nil {
+			timeout = *t
+		}
+		deadline := int64(timeout / time.Second)
+		spec.ActiveDeadlineSeconds = &deadline
+		return &controller.PreDeleteHook{
+			ObjectMeta: meta_v1.ObjectMeta{Name: jobName, Namespace: d.opts.Namespace},
+			Spec: controller.PreDeleteHookSpec{
+				PinToNode: true,
+				Template:  controller.JobTemplateSpec{Spec: spec},
+			},
+		}, nil
+	})
+	if job == nil {
+		return false, err
+	}
+
+	complete, failure := jobFinished(job)
+	switch {
+	case failure != nil && failure.Reason == "DeadlineExceeded":
+		logrus.Warnf("Pre-delete job %v/%v of node %v ran out of time, draining the node anyway", d.opts.Namespace, jobName, node.Name)
+		return true, nil
+	case failure != nil:
+		return false, fmt.Errorf("Pre-delete job %v/%v failed for node %v: %v. Delete the job to run it again", d.opts.Namespace, jobName, node.Name, failure.Message)
+	case !complete:
+		logrus.Debugf("Waiting for pre-delete job %v/%v of node %v", d.opts.Namespace, jobName, node.Name)
+	}
+	return complete, nil
+}
+
+// preDeleteJobFor returns the node's job of a hook, or creates it from newHook and returns nil
+func (d *Deleter) preDeleteJobFor(ctx context.Context, node *core_v1.Node, namespace, jobName string,
+	newHook func(context.Context) (*controller.PreDeleteHook, error)) (*batch_v1.Job, error) {
+	callCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+	defer cancel()
+
+	job, err := d.controller.GetJob(callCtx, namespace, jobName)
+	if k8s_errors.IsNotFound(err) {
+		hook, err := newHook(callCtx)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := d.controller.CreateJob(callCtx, d.preDeleteJob(hook, node, jobName)); err != nil && !k8s_errors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("Error creating pre-delete job %v/%v for node %v: %v", namespace, jobName, node.Name, err)
+		}
+		logrus.Infof("Started pre-delete job %v/%v for node %v", namespace, jobName, node.Name)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error getting job %v/%v: %v", namespace, jobName, err)
+	}
+	return job, nil
+}
+
+// jobFinished returns true if the job completed, or the condition it failed with
+func jobFinished(job *batch_v1.Job) (bool, *batch_v1.JobCondition) {
+	for i, condition := range job.Status.Conditions {
+		if condition.Status != core_v1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batch_v1.JobComplete:
+			return true, nil
+		case batch_v1.JobFailed:
+			return false, &job.Status.Conditions[i]
+		}
+	}
+	return false, nil
+}
+
+// preDeleteJob instantiates the hook's template for the node. Its containers get the node and its
+// group in NODEREAPER_NODE and NODEREAPER_GROUP
+func (d *Deleter) preDeleteJob(hook *controller.PreDeleteHook, node *core_v1.Node, jobName string) *batch_v1.Job {
+	meta := hook.Spec.Template.ObjectMeta.DeepCopy()
+	job := &batch_v1.Job{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        jobName,
+			Namespace:   hook.Namespace,
+			Labels:      meta.Labels,
+			Annotations: meta.Annotations,
+		},
+		Spec: *hook.Spec.Template.Spec.DeepCopy(),
+	}
+	if job.Annotations == nil {
+		job.Annotations = map[string]string{}
+	}
+	job.Annotations[PreDeleteHookNodeAnnotation] = node.Name
+
+	pod := &job.Spec.Template.Spec
+	if pod.RestartPolicy == "" {
+		pod.RestartPolicy = core_v1.RestartPolicyNever
+	}
+	env := []core_v1.EnvVar{
+		{Name: "NODEREAPER_NODE", Value: node.Name},
+		{Name: "NODEREAPER_GROUP", Value: d.groupName(node)},
+	}
+	for i := range pod.InitContainers {
+		pod.InitContainers[i].Env = append(pod.InitContainers[i].Env, env...)
+	}
+	for i := range pod.Containers {
+		pod.Containers[i].Env = append(pod.Containers[i].Env, env...)
+	}
+	// The node may be cordoned or tainted by now, which mustn't keep the pod off it
+	if hook.Spec.PinToNode {
+		pod.NodeName = node.Name
+		pod.Tolerations = append(pod.Tolerations, core_v1.Toleration{Operator: core_v1.TolerationOpExists})
+	}
+	return job
+}
+
+// preDeleteJobName is the hook's name, followed by a hash of the node's name. It stays within
+// the 63 characters allowed in the job-name label of the job's pods
+func preDeleteJobName(hookName, nodeName string) string {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(nodeName))
+	if len(hookName) > 54 {
+		hookName = hookName[:54]
+	}
+	return fmt.Sprintf("%v-%08x", strings.TrimRight(hookName, "-."), hasher.Sum32())
+}
@@ -0,0 +1,64 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// providerPool bounds the number of concurrent provider calls, like detaching an instance,
+// and how often they are made. Each call is given up on after a timeout
+type providerPool struct {
+	slots   chan struct{}
+	limiter *rate.Limiter
+	timeout time.Duration
+}
+
+func newProviderPool(concurrency int, qps float64, timeout time.Duration) *providerPool {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	limit := rate.Inf
+	if qps > 0 {
+		limit = rate.Limit(qps)
+	}
+	return &providerPool{
+		slots:   make(chan struct{}, concurrency),
+		limiter: rate.NewLimiter(limit, concurrency),
+		timeout: timeout,
+	}
+}
+
+// do runs call once a slot is free and the rate limit allows it. A call that times out
+// keeps its slot until it returns, since provider calls can't be cancelled
+func (p *providerPool) do(ctx context.Context, name string, call func() error) error {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("Timed out waiting to %v: %v", name, ctx.Err())
+	}
+	if err := p.limiter.Wait(ctx); err != nil {
+		<-p.slots
+		return fmt.Errorf("Timed out waiting to %v: %v", name, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() { <-p.slots }()
+		done <- call()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("Timed out trying to %v: %v", name, ctx.Err())
+	}
+}
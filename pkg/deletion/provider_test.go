@@ -0,0 +1,25 @@
+package deletion
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestProviderPoolTimeout(t *testing.T) {
+	pool := newProviderPool(1, 0, 10*time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+
+	err := pool.do(context.Background(), "block", func() error {
+		<-release
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Expected a call outlasting the timeout to fail")
+	}
+	// The timed out call still holds the only slot
+	if err := pool.do(context.Background(), "wait", func() error { return nil }); err == nil {
+		t.Errorf("Expected no slot to be free while the timed out call is running")
+	}
+}
@@ -0,0 +1,102 @@
+package deletion
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/metrics"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// rebootsInPlace returns true if nodereaperd reboots the node rather than powering it off. Only nodes
+// drained by nodereaperd can be rebooted, so Cluster API and server drain nodes are always removed.
+// A reboot keeps the instance, so nodes deleted for its launch configuration or lifetime are replaced
+func (d *Deleter) rebootsInPlace(node *core_v1.Node) bool {
+	if _, _, ok := nodeMachine(node); ok || d.drainMode(node) != drainModeAgent {
+		return false
+	}
+	if d.opts.GetString(d.groupName(node), "nodeAction") != config.NodeActionReboot {
+		return false
+	}
+	nodeState := d.nodeState(node)
+	return nodeState == nil || !replacedOnReboot(nodeState.Reason)
+}
+
+// replacedOnReboot returns true for the deletion reasons a reboot doesn't address
+func replacedOnReboot(reason metrics.Reason) bool {
+	return reason == metrics.ConfigurationChanged || reason == metrics.MaxLifetime
+}
+
+// finishReboots puts the Deleting nodes nodereaperd rebooted back in service. nodereaperd removes the
+// deletion label once a node is back up, and the controller then moves it back to DontWantDelete.
+// Callers must hold statesMu
+func (d *Deleter) finishReboots(ctx context.Context) {
+	key, _, _ := config.SplitLabel(d.opts.ForceDeletionLabel)
+	for _, group := range d.states.Groups {
+		for _, nodeState := range group.Nodes {
+			if nodeState.State != Deleting {
+				continue
+			}
+			node, err := d.controller.NodeByName(nodeState.Name)
+			if err != nil || node == nil || node.Annotations[config.NodeActionAnnotation] != config.NodeActionReboot {
+				continue
+			}
+			if _, ok := node.Labels[key]; ok {
+				continue
+			}
+			err = d.patchNode(ctx, node.Name, d.rebootedPatch(node))
+			if err != nil {
+				logrus.Warnf("Error clearing the reboot of node %v: %v", node.Name, err)
+				continue
+			}
+
+			deletionID := nodeState.DeletionID
+			now := time.Now()
+			nodeState.State = DontWantDelete
+			nodeState.LastTransitionTime = now
+			nodeState.RebootedAt = &now
+			nodeState.RequestedReason = ""
+			nodeState.RequestedBy = ""
+			nodeState.DeletionToken = ""
+			nodeState.DeletionID = ""
+			nodeState.WantDeleteSince = nil
+			nodeState.ApprovalRequested = false
+			nodeState.ApprovedBy = ""
+			nodeState.Reason, nodeState.reasonValid = "", false
+			d.history.complete(node.Name, time.Now())
+			d.history.setOutcome(node.Name, OutcomeRebooted)
+
+			logrus.WithFields(logrus.Fields{
+				"audit":       true,
+				"action":      "reboot",
+				"node":        node.Name,
+				"group":       group.Name,
+				"deletion_id": deletionID,
+			}).Infof("Node %v was rebooted and is back in service", node.Name)
+		}
+	}
+}
+
+// rebootedPatch clears the reboot from the node, along with the labels that asked for the node's
+// deletion. The reboot answered them, and they would only start another one
+func (d *Deleter) rebootedPatch(node *core_v1.Node) nodePatch {
+	patch := nodePatch{
+		RemoveAnnotations: []string{config.NodeActionAnnotation, DeletionAckAnnotation, DeletionConfirmAnnotation},
+	}
+	for _, l := range config.ParseRequestLabels(d.opts.RequestDeletionLabel) {
+		if key, _, _ := config.SplitLabel(l.Label); config.HasLabel(key, node.Labels) {
+			patch.RemoveLabels = append(patch.RemoveLabels, key)
+		}
+	}
+	if d.opts.SecurityRecycleLabel != "" {
+		if _, ok := node.Labels[d.opts.SecurityRecycleLabel]; ok {
+			patch.RemoveLabels = append(patch.RemoveLabels, d.opts.SecurityRecycleLabel)
+		}
+		if _, ok := node.Annotations[d.opts.SecurityRecycleLabel]; ok {
+			patch.RemoveAnnotations = append(patch.RemoveAnnotations, d.opts.SecurityRecycleLabel)
+		}
+	}
+	return patch
+}
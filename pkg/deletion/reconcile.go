@@ -0,0 +1,120 @@
+package deletion
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/metrics"
+)
+
+// Decision is what the controller decided to do with a node
+type Decision string
+
+const (
+	// DecisionUntracked means the node isn't tracked at all, see Reconciliation.Exclusion
+	DecisionUntracked Decision = "untracked"
+	// DecisionIgnored means the node is tracked but never deleted, see Reconciliation.Exclusion
+	DecisionIgnored Decision = "ignored"
+	// DecisionKeep means the controller doesn't want to delete the node
+	DecisionKeep Decision = "keep"
+	// DecisionDelete means the controller wants to delete the node, or is deleting it.
+	// Reconciliation.Blockers explains what keeps it from progressing
+	DecisionDelete Decision = "delete"
+)
+
+// Reconciliation is the outcome of evaluating a single node on demand
+type Reconciliation struct {
+	Node          string            `json:"node"`
+	Group         string            `json:"group"`
+	Decision      Decision          `json:"decision"`
+	Reason        metrics.Reason    `json:"reason,omitempty"`
+	Exclusion     metrics.Exclusion `json:"exclusion,omitempty"`
+	PreviousState State             `json:"previousState,omitempty"`
+	State         State             `json:"state,omitempty"`
+	Blockers      []Blocker         `json:"blockers"`
+	Time          time.Time         `json:"time"`
+}
+
+// Reconcile evaluates a single node right away instead of waiting for its group's next poll.
+// Config and states are refreshed as in a poll, then the node, and only the node, is moved
+// as far as its group's limits allow. Other nodes of the group are left for the next poll
+func (d *Deleter) Reconcile(ctx context.Context, nodeName string) (*Reconciliation, error) {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	if d.standby || d.stopped {
+		return nil, ErrStandby
+	}
+	if err := d.refreshStates(ctx); err != nil {
+		return nil, err
+	}
+
+	realNode, err := d.controller.NodeByName(nodeName)
+	if err != nil {
+		return nil, err
+	}
+	if realNode == nil || !d.inShard(realNode) {
+		return nil, ErrNodeNotTracked
+	}
+	r := &Reconciliation{
+		Node:     nodeName,
+		Group:    d.groupName(realNode),
+		Blockers: []Blocker{},
+		Time:     time.Now(),
+	}
+
+	groupKey := d.nodeGroupKey(realNode)
+	group := d.states.Groups[groupKey]
+	var nodeState *NodeState
+	if group != nil {
+		nodeState = group.Nodes[nodeName]
+	}
+	if nodeState == nil {
+		r.Decision = DecisionUntracked
+		if d.providerIDPending(realNode) {
+			r.Exclusion = metrics.MissingProviderID
+		} else if r.Exclusion = d.untrackedReason(realNode); r.Exclusion == "" {
+			return nil, ErrNodeNotTracked
+		}
+		return r, nil
+	}
+
+	r.PreviousState = nodeState.State
+	if !nodeState.NeverDelete {
+		if nodeState.State == DontWantDelete {
+			nodeState.reasonValid = false
+		}
+		transition := d.trackTransitions(d.notifyTransitions(d.StateTransitionFunction))
+		d.states.AdvanceGroup(ctx, groupKey, func(ctx context.Context, name string, oldState, newState State) (bool, error) {
+			if name != nodeName {
+				return false, nil
+			}
+			return transition(ctx, name, oldState, newState)
+		})
+	}
+
+	status := d.nodeStatus(group, nodeState)
+	r.State, r.Reason, r.Blockers = status.State, status.Reason, status.Blockers
+	switch {
+	case nodeState.NeverDelete:
+		r.Decision, r.Exclusion = DecisionIgnored, nodeState.exclusion
+	case nodeState.State == DontWantDelete:
+		r.Decision = DecisionKeep
+	default:
+		r.Decision = DecisionDelete
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"node":     nodeName,
+		"decision": r.Decision,
+		"reason":   r.Reason,
+		"blockers": r.Blockers,
+	}).Infof("Reconciled node %v on demand: %v", nodeName, r.Decision)
+
+	if err := d.saveState(ctx); err != nil {
+		logrus.Errorf("Error saving deletion state: %v", err)
+	}
+	d.recordMetrics()
+	return r, nil
+}
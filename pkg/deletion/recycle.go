@@ -0,0 +1,58 @@
+package deletion
+
+import (
+	"strings"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/ratelog"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// recycleInterval parses the group's recycleRate, like "10%/7d", into the time between two
+// recycled nodes. Returns 0 if the group has no recycleRate
+func (d *Deleter) recycleInterval(group *Group) time.Duration {
+	value := d.opts.GetString(group.Name, "recycleRate")
+	if value == "" {
+		return 0
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		ratelog.Errorf("recycle-rate/"+group.Name, "Could not parse recycleRate %v for group %v, expected e.g. 10%%/7d", value, group.Name)
+		return 0
+	}
+	window, err := config.ParseDuration(parts[1])
+	if err != nil || window <= 0 {
+		ratelog.Errorf("recycle-rate/"+group.Name, "Could not parse recycleRate %v for group %v: %v", value, group.Name, err)
+		return 0
+	}
+	count := percentOrNumToNum(parts[0], group.size(), true)
+	if count <= 0 {
+		return 0
+	}
+	return window / time.Duration(count)
+}
+
+// recycleDue returns true if the node is the oldest node of its group not already on its way
+// out, and the group's recycleRate allows recycling another node
+func (d *Deleter) recycleDue(node *core_v1.Node) bool {
+	group, ok := d.states.Groups[d.nodeGroupKey(node)]
+	if !ok {
+		return false
+	}
+	interval := d.recycleInterval(group)
+	if interval <= 0 || time.Since(group.LastRecycle) < interval {
+		return false
+	}
+
+	var oldest *NodeState
+	for _, nodeState := range group.Nodes {
+		if nodeState.State != DontWantDelete || nodeState.NeverDelete || nodeState.snoozed() {
+			continue
+		}
+		if oldest == nil || nodeState.since().Before(oldest.since()) {
+			oldest = nodeState
+		}
+	}
+	return oldest != nil && oldest.Name == node.Name
+}
@@ -0,0 +1,218 @@
+package deletion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+)
+
+const (
+	eventComponent = "nodereaper"
+)
+
+var (
+	// ErrNodeNotTracked is returned when an operator acts on a node the deleter doesn't know about
+	ErrNodeNotTracked = errors.New("node is not tracked by nodereaper")
+	// ErrGroupNotTracked is returned when an operator acts on a group the deleter doesn't know about
+	ErrGroupNotTracked = errors.New("group is not tracked by nodereaper")
+	// ErrNodeIgnored is returned when an operator requests deletion of a node that is never deleted
+	ErrNodeIgnored = errors.New("node is ignored by nodereaper and will never be deleted")
+	// ErrAlreadyDeleting is returned when an operator requests deletion of a node that is already being deleted
+	ErrAlreadyDeleting = errors.New("node is already being deleted")
+	// ErrNotAwaitingApproval is returned when approving a node that isn't waiting to be deleted
+	ErrNotAwaitingApproval = errors.New("node is not waiting for deletion approval")
+	// ErrStandby is returned when acting on nodes or groups through a replica that isn't the leader
+	ErrStandby = errors.New("this replica is a standby, only the leader can act on nodes")
+)
+
+// RequestDeletion moves a node straight to WantDelete on an operator's behalf.
+// The reason and requester are persisted with the node's state, logged for
+// auditing and recorded as an event on the node
+func (d *Deleter) RequestDeletion(ctx context.Context, nodeName, reason, requester string) error {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	if d.standby {
+		return ErrStandby
+	}
+	if err := d.requestDeletion(ctx, nodeName, reason, requester); err != nil {
+		return err
+	}
+	return d.saveState(ctx)
+}
+
+// requestDeletion is RequestDeletion without persisting the new state. Callers must hold statesMu
+func (d *Deleter) requestDeletion(ctx context.Context, nodeName, reason, requester string) error {
+	nodeState := d.trackedNode(nodeName)
+	if nodeState == nil {
+		return ErrNodeNotTracked
+	}
+	if nodeState.NeverDelete {
+		return ErrNodeIgnored
+	}
+	if nodeState.State != DontWantDelete {
+		return ErrAlreadyDeleting
+	}
+
+	nodeState.State = WantDelete
+	nodeState.LastTransitionTime = time.Now()
+	nodeState.RequestedReason = reason
+	nodeState.RequestedBy = requester
+	nodeState.startDeletion()
+	d.recordStage(nodeName, WantDelete)
+
+	logrus.WithFields(logrus.Fields{
+		"audit":       true,
+		"action":      "request_delete",
+		"node":        nodeName,
+		"deletion_id": nodeState.DeletionID,
+		"reason":      reason,
+		"requester":   requester,
+	}).Infof("Operator %v requested deletion of node %v: %v", requester, nodeName, reason)
+
+	node, err := d.controller.NodeByName(nodeName)
+	if err == nil && node != nil {
+		msg := fmt.Sprintf("Deletion requested by %v: %v", requester, reason)
+		eventCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+		defer cancel()
+		if err := d.controller.RecordNodeEvent(eventCtx, node, nodeState.DeletionID, eventComponent, core_v1.EventTypeNormal, "DeletionRequested", msg); err != nil {
+			logrus.Warnf("Could not record deletion request event for node %v: %v", nodeName, err)
+		}
+	}
+	return nil
+}
+
+// SnoozeNode stops the deleter from moving the node to any other state until
+// the snooze expires. A zero duration cancels an existing snooze
+func (d *Deleter) SnoozeNode(ctx context.Context, nodeName string, duration time.Duration, requester string) error {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	if d.standby {
+		return ErrStandby
+	}
+
+	nodeState := d.trackedNode(nodeName)
+	if nodeState == nil {
+		return ErrNodeNotTracked
+	}
+	if nodeState.State != DontWantDelete && nodeState.State != WantDelete {
+		return ErrAlreadyDeleting
+	}
+
+	if duration <= 0 {
+		nodeState.SnoozedUntil = nil
+	} else {
+		until := time.Now().Add(duration)
+		nodeState.SnoozedUntil = &until
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"audit":     true,
+		"action":    "snooze_node",
+		"node":      nodeName,
+		"duration":  duration,
+		"requester": requester,
+	}).Infof("Operator %v snoozed node %v for %v", requester, nodeName, duration)
+
+	return d.saveState(ctx)
+}
+
+// Approve answers an interactive approval request for the node. A denied node is held
+// in WantDelete until an operator approves it after all
+func (d *Deleter) Approve(ctx context.Context, nodeName string, approved bool, approver string) error {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	if d.standby {
+		return ErrStandby
+	}
+
+	nodeState := d.trackedNode(nodeName)
+	if nodeState == nil {
+		return ErrNodeNotTracked
+	}
+	if nodeState.State != WantDelete {
+		return ErrNotAwaitingApproval
+	}
+
+	action, verb, eventReason := "deny_delete", "denied", "DeletionDenied"
+	nodeState.ApprovedBy, nodeState.DeniedBy = "", approver
+	if approved {
+		action, verb, eventReason = "approve_delete", "approved", "DeletionApproved"
+		nodeState.ApprovedBy, nodeState.DeniedBy = approver, ""
+	}
+	logrus.WithFields(logrus.Fields{
+		"audit":       true,
+		"action":      action,
+		"node":        nodeName,
+		"deletion_id": nodeState.DeletionID,
+		"requester":   approver,
+	}).Infof("%v %v deletion of node %v", approver, verb, nodeName)
+
+	node, err := d.controller.NodeByName(nodeName)
+	if err == nil && node != nil {
+		msg := fmt.Sprintf("Deletion %v by %v", verb, approver)
+		eventCtx, cancel := context.WithTimeout(ctx, d.apiTimeout)
+		defer cancel()
+		if err := d.controller.RecordNodeEvent(eventCtx, node, nodeState.DeletionID, eventComponent, core_v1.EventTypeNormal, eventReason, msg); err != nil {
+			logrus.Warnf("Could not record approval event for node %v: %v", nodeName, err)
+		}
+	}
+
+	return d.saveState(ctx)
+}
+
+// SetGroupPaused pauses or resumes deletion in the group with the given name.
+// It takes effect immediately rather than on the next configmap reload
+func (d *Deleter) SetGroupPaused(ctx context.Context, groupName string, paused bool, requester string) error {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	if d.standby {
+		return ErrStandby
+	}
+
+	group := d.groupByName(groupName)
+	if group == nil {
+		return ErrGroupNotTracked
+	}
+
+	action := "resume_group"
+	if paused {
+		action = "pause_group"
+	}
+	logrus.WithFields(logrus.Fields{
+		"audit":     true,
+		"action":    action,
+		"group":     groupName,
+		"requester": requester,
+	}).Infof("Operator %v set paused=%v for group %v", requester, paused, groupName)
+
+	group.Paused = paused
+	return d.saveState(ctx)
+}
+
+// groupByName finds a tracked group. Callers must hold statesMu
+func (d *Deleter) groupByName(name string) *Group {
+	for _, group := range d.states.Groups {
+		if group.Name == name {
+			return group
+		}
+	}
+	return nil
+}
+
+// trackedNode returns the state of the named node, or nil if the deleter isn't tracking it. Callers must hold statesMu
+func (d *Deleter) trackedNode(nodeName string) *NodeState {
+	for _, group := range d.states.Groups {
+		if n, ok := group.Nodes[nodeName]; ok {
+			return n
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package deletion
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// ShardOf returns which of the shards drives the group, by the hash of its key
+func ShardOf(groupKey string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(groupKey))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// ShardConfigMapName returns the name of the configmap a shard stores its state in, which is
+// also the name of the shard's Lease
+func ShardConfigMapName(lockConfigMapName string, shard int) string {
+	return fmt.Sprintf("%v-shard-%v", lockConfigMapName, shard)
+}
+
+// StateConfigMapNames returns the names of every configmap the controller stores state in
+func StateConfigMapNames(lockConfigMapName string, shards int) []string {
+	if shards <= 1 {
+		return []string{lockConfigMapName}
+	}
+	names := []string{}
+	for i := 0; i < shards; i++ {
+		names = append(names, ShardConfigMapName(lockConfigMapName, i))
+	}
+	return names
+}
+
+// inShard returns whether the node's group is driven by this replica's shard
+func (d *Deleter) inShard(node *core_v1.Node) bool {
+	return ShardOf(d.nodeGroupKey(node), d.opts.Shards) == d.opts.Shard
+}
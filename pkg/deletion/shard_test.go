@@ -0,0 +1,35 @@
+package deletion
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestShardOf(t *testing.T) {
+	seen := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("___ig___group-%v", i)
+		shard := ShardOf(key, 4)
+		if shard < 0 || shard >= 4 {
+			t.Fatalf("Expected %v in one of 4 shards, got %v", key, shard)
+		}
+		if ShardOf(key, 4) != shard {
+			t.Errorf("Expected %v to always be in the same shard", key)
+		}
+		if ShardOf(key, 1) != 0 {
+			t.Errorf("Expected %v in shard 0 without sharding", key)
+		}
+		seen[shard] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("Expected groups in every shard, got %v", seen)
+	}
+
+	if names := StateConfigMapNames("locks", 1); !reflect.DeepEqual(names, []string{"locks"}) {
+		t.Errorf("Expected only the locks configmap without sharding, got %v", names)
+	}
+	if names := StateConfigMapNames("locks", 2); !reflect.DeepEqual(names, []string{"locks-shard-0", "locks-shard-1"}) {
+		t.Errorf("Expected a configmap per shard, got %v", names)
+	}
+}
@@ -1,18 +1,22 @@
 package deletion
 
 import (
+	"context"
 	"sort"
 	"sync"
 	"time"
 
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_types "k8s.io/apimachinery/pkg/types"
 
 	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/calendar"
 	"github.com/wish/nodereaper/pkg/cron"
+	"github.com/wish/nodereaper/pkg/metrics"
 )
 
 // StateTransitionFunction attempts to move a node from oldState to newState
-type StateTransitionFunction func(nodeName string, oldState, newState State) (bool, error)
+type StateTransitionFunction func(ctx context.Context, nodeName string, oldState, newState State) (bool, error)
 
 // State is an enumeration of the stages of the deletion process
 type State string
@@ -32,19 +36,92 @@ const (
 
 // NodeState represents the state of deletion for a single node
 type NodeState struct {
-	Name         string       `json:"-"`
-	State        State        `json:"state"`
-	CreationTime meta_v1.Time `json:"-"`
-	NeverDelete  bool         `json:"-"`
+	Name               string       `json:"-"`
+	State              State        `json:"state"`
+	CreationTime       meta_v1.Time `json:"-"`
+	LastTransitionTime time.Time    `json:"-"`
+	NeverDelete        bool         `json:"-"`
+	ProviderID         string       `json:"-"`
+	// Ready is true if the node is Ready and schedulable
+	Ready bool `json:"-"`
+	// SecurityRecycle nodes are deleted first, and regardless of the group's deletionSchedule and deletionCalendar
+	SecurityRecycle bool `json:"-"`
+	// RequestedReason and RequestedBy are set when an operator asked for this node to be deleted
+	RequestedReason string `json:"requestedReason,omitempty"`
+	RequestedBy     string `json:"requestedBy,omitempty"`
+	// SnoozedUntil holds the node in its current state until the given time
+	SnoozedUntil *time.Time `json:"snoozedUntil,omitempty"`
+	// DeletionToken is the value of the force deletion label the controller set, so nodereaperd can
+	// tell it apart from a stale or copied label
+	DeletionToken string `json:"deletionToken,omitempty"`
+	// DeletionID identifies this deletion of the node, from the moment it entered WantDelete
+	DeletionID string `json:"deletionID,omitempty"`
+	// WantDeleteSince is when this deletion of the node started
+	WantDeleteSince *time.Time `json:"wantDeleteSince,omitempty"`
+	// Surged is set once this deletion detached the node, which made its group launch a replacement.
+	// It stays set through ReadyToDelete and Deleting, while the replacement is still surge
+	Surged bool `json:"surged,omitempty"`
+	// CordonedSince is when the node was first seen cordoned while nodereaper didn't want to delete it
+	CordonedSince *time.Time `json:"cordonedSince,omitempty"`
+	// RebootedAt is when the node was last put back in service after a reboot in place.
+	// Its age is counted from then, as a reboot is what a new node would have brought
+	RebootedAt *time.Time `json:"rebootedAt,omitempty"`
+	// ApprovalRequested is set once an interactive approval was requested,
+	// and ApprovedBy or DeniedBy once someone answered
+	ApprovalRequested bool   `json:"approvalRequested,omitempty"`
+	ApprovedBy        string `json:"approvedBy,omitempty"`
+	DeniedBy          string `json:"deniedBy,omitempty"`
+	// Reason is why the controller wants to delete the node. It's evaluated at most once per poll
+	// while the node is in DontWantDelete, and kept once the node leaves it
+	Reason metrics.Reason `json:"reason,omitempty"`
+	// reasonValid is false if Reason needs evaluating again
+	reasonValid bool
+	// seen is the Deleter's refreshGeneration in which the node was last listed
+	seen uint64
+	// podOwners are the controllers of the pods seen on the node while it was deleting
+	podOwners map[k8s_types.UID]struct{}
+	// exclusion is why NeverDelete is set
+	exclusion metrics.Exclusion
 }
 
-func (n *NodeState) changeState(newState State, f StateTransitionFunction) bool {
-	yes, err := f(n.Name, n.State, newState)
+// worthPersisting returns false if the node's persisted fields are all what a newly seen node would get
+func (n *NodeState) worthPersisting() bool {
+	return n.State != DontWantDelete || n.RequestedReason != "" || n.SnoozedUntil != nil || n.CordonedSince != nil || n.RebootedAt != nil ||
+		n.ApprovalRequested || n.ApprovedBy != "" || n.DeniedBy != ""
+}
+
+// startDeletion gives the node's deletion an ID and start time, as it enters WantDelete
+func (n *NodeState) startDeletion() {
+	now := time.Now()
+	n.DeletionID = newDeletionID()
+	n.WantDeleteSince = &now
+	n.Surged = false
+}
+
+// since returns when the node's age starts: its creation, or its last reboot in place
+func (n *NodeState) since() time.Time {
+	if n.RebootedAt != nil && n.RebootedAt.After(n.CreationTime.Time) {
+		return *n.RebootedAt
+	}
+	return n.CreationTime.Time
+}
+
+func (n *NodeState) snoozed() bool {
+	return n.SnoozedUntil != nil && n.SnoozedUntil.After(time.Now())
+}
+
+func (n *NodeState) changeState(ctx context.Context, newState State, f StateTransitionFunction) bool {
+	yes, err := f(ctx, n.Name, n.State, newState)
+	log := logrus.WithField("deletion_id", n.DeletionID)
 	if yes {
-		logrus.Infof("Successfully changed state of %v from %v to %v", n.Name, n.State, newState)
+		log.Infof("Successfully changed state of %v from %v to %v", n.Name, n.State, newState)
 		n.State = newState
+		n.LastTransitionTime = time.Now()
+		if newState == Detached {
+			n.Surged = true
+		}
 	} else if err != nil {
-		logrus.Errorf("Failed to change state of %v from %v to %v: %v", n.Name, n.State, newState, err)
+		log.Errorf("Failed to change state of %v from %v to %v: %v", n.Name, n.State, newState, err)
 	}
 	return yes
 }
@@ -57,34 +134,143 @@ type Group struct {
 	MaxSurge         int
 	MaxUnavailable   int
 	DeletionSchedule *cron.Schedule
+	// DeletionCalendar, if set, only allows deletions during its events
+	DeletionCalendar *calendar.Calendar
 	NumDesired       int
 	Nodes            map[string]*NodeState
 	PriorityNodes    map[string]struct{}
+	// IsVirtual is set for groups formed by --group-rules, which have no provider group behind them
+	IsVirtual bool
+	// Paused freezes every node in the group past WantDelete until the group is resumed
+	Paused bool
+	// MinReadyNodes is the fewest Ready nodes the group may be left with by starting a deletion
+	MinReadyNodes int
+	// ProtectLastN is the fewest nodes the group may be left with, whatever its desired size
+	ProtectLastN int
+	// OrphanedSince is when the group was found missing with the provider, e.g. because its ASG
+	// was deleted or renamed. Zero while the group exists
+	OrphanedSince time.Time
+	// LastRecycle is when a node was last picked for deletion because of the group's recycleRate
+	LastRecycle time.Time
+	// DeletionsPerWindow is the most nodes that may start being deleted during one opening of
+	// the group's schedule, counted in Window. Unlimited if 0
+	DeletionsPerWindow int
+	// PaceDeletions spreads the nodes that start being deleted evenly over the window
+	PaceDeletions bool
+	Window        DeletionWindow
+	// windowChecked is set once an adopted Window was checked against the group's schedule
+	windowChecked bool
 }
 
 // GroupStates represents a set of state machines describing the progress in deleting nodes
 // from each group
 type GroupStates struct {
 	Groups map[string]*Group
+	// MaxTotalSurge caps the number of surge nodes across every group.
+	// A negative value means there is no cluster-wide cap
+	MaxTotalSurge int
+	// Suspended treats every group as paused, e.g. while the cluster is unhealthy
+	Suspended bool
+}
+
+// surgeBudget is shared between groups advancing in parallel so that the
+// total number of surge nodes never exceeds GroupStates.MaxTotalSurge
+type surgeBudget struct {
+	mu        sync.Mutex
+	remaining int
+}
+
+// take reserves a surge slot, returning false if none are left.
+// A nil budget is unlimited
+func (b *surgeBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining <= 0 {
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// giveBack returns a slot that was taken but not used
+func (b *surgeBudget) giveBack() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining++
 }
 
 // SerializedState is a snapshot of the deletion state for every node.
 // Can be serialized to and from a configmap.
 type SerializedState struct {
-	NodeStates map[string]NodeState `json:"nodeStates"`
+	NodeStates   map[string]NodeState `json:"nodeStates"`
+	PausedGroups map[string]bool      `json:"pausedGroups,omitempty"`
+	// RecycledAt is the LastRecycle of each group that recycled a node, by group key
+	RecycledAt map[string]time.Time `json:"recycledAt,omitempty"`
+	// DeletionWindows is the Window of each group with an open deletion window, by group key
+	DeletionWindows map[string]DeletionWindow `json:"deletionWindows,omitempty"`
+	// History is only read, from states saved before HistoryKey
+	History []HistoryEntry `json:"history,omitempty"`
+	// Groups and UpdateTime are informational, for tools like `nodereaper status`
+	Groups     map[string]GroupSummary `json:"groups,omitempty"`
+	UpdateTime time.Time               `json:"updateTime"`
 }
 
-// SerializeState extracts the basic information about node states to a separate struct
+// SerializeState extracts the basic information about node states to a separate struct.
+// Nodes with nothing worth persisting are left out, since they'd be adopted with the same state
 func (gs *GroupStates) SerializeState() SerializedState {
 	nodeStates := map[string]NodeState{}
+	pausedGroups := map[string]bool{}
+	recycledAt := map[string]time.Time{}
+	deletionWindows := map[string]DeletionWindow{}
 	for _, group := range gs.Groups {
 		for _, node := range group.Nodes {
-			nodeStates[node.Name] = *node
+			if node.worthPersisting() {
+				nodeStates[node.Name] = *node
+			}
+		}
+		if group.Paused {
+			pausedGroups[group.Key] = true
+		}
+		if !group.LastRecycle.IsZero() {
+			recycledAt[group.Key] = group.LastRecycle
+		}
+		if !group.Window.Start.IsZero() {
+			deletionWindows[group.Key] = group.Window
 		}
 	}
 	return SerializedState{
-		NodeStates: nodeStates,
+		NodeStates:      nodeStates,
+		PausedGroups:    pausedGroups,
+		RecycledAt:      recycledAt,
+		DeletionWindows: deletionWindows,
+	}
+}
+
+// removeEmptyGroups forgets groups without any nodes, e.g. after a group was renamed or removed,
+// and returns their keys. Paused groups are kept, so they stay paused if they come back
+func (gs *GroupStates) removeEmptyGroups() []string {
+	removed := []string{}
+	for key, group := range gs.Groups {
+		if group.size() == 0 && !group.Paused {
+			delete(gs.Groups, key)
+			removed = append(removed, key)
+		}
 	}
+	return removed
+}
+
+// scheduleAllowsDeletion returns true if both the deletionSchedule and deletionCalendar allow deletions at t
+func (g *Group) scheduleAllowsDeletion(t time.Time) bool {
+	if g.DeletionSchedule != nil && !g.DeletionSchedule.Matches(t) {
+		return false
+	}
+	return g.DeletionCalendar == nil || g.DeletionCalendar.Active(t)
 }
 
 func (g *Group) size() int {
@@ -104,12 +290,36 @@ func (g *Group) stateCount(states ...State) int {
 	return i
 }
 
+// surgeCount returns the number of the group's nodes whose replacement is surge: those Detached,
+// and those that were detached before moving on. Nodes deleted without being detached
+// never raised the group's size
+func (g *Group) surgeCount() int {
+	i := 0
+	for _, node := range g.Nodes {
+		if node.State == Detached || (node.Surged && (node.State == ReadyToDelete || node.State == Deleting)) {
+			i++
+		}
+	}
+	return i
+}
+
+// readyCount returns the number of Ready nodes that aren't already on their way out
+func (g *Group) readyCount() int {
+	i := 0
+	for _, node := range g.Nodes {
+		if node.Ready && node.State != ReadyToDelete && node.State != Deleting {
+			i++
+		}
+	}
+	return i
+}
+
 func (g *Group) iterateNodes() []*NodeState {
 	// If there are any priority nodes (like the node this is running on)
 	// We focus on them exclusively
 	ret := []*NodeState{}
 	for name := range g.PriorityNodes {
-		if node, ok := g.Nodes[name]; ok && !node.NeverDelete {
+		if node, ok := g.Nodes[name]; ok && !node.NeverDelete && !node.snoozed() {
 			ret = append(ret, node)
 		} else {
 			delete(g.PriorityNodes, name)
@@ -117,15 +327,18 @@ func (g *Group) iterateNodes() []*NodeState {
 	}
 	if len(ret) == 0 {
 		for _, node := range g.Nodes {
-			if !node.NeverDelete {
+			if !node.NeverDelete && !node.snoozed() {
 				ret = append(ret, node)
 			}
 		}
 	}
 
 	// Sort the nodes by creationTime ascending, so that we always
-	// go for the oldest nodes first
+	// go for the oldest nodes first, after any security recycles
 	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SecurityRecycle != ret[j].SecurityRecycle {
+			return ret[i].SecurityRecycle
+		}
 		return ret[i].CreationTime.Before(&ret[j].CreationTime)
 	})
 
@@ -133,93 +346,202 @@ func (g *Group) iterateNodes() []*NodeState {
 }
 
 // Advance tries to move as many nodes in the group as possible to deletion
-func (g *Group) Advance(f StateTransitionFunction) {
+func (g *Group) Advance(ctx context.Context, f StateTransitionFunction) {
+	g.advance(ctx, f, nil, false)
+}
+
+func (g *Group) advance(ctx context.Context, f StateTransitionFunction, budget *surgeBudget, suspended bool) {
+	// Which nodes are considered, and in what order, doesn't change while advancing
+	nodes := g.iterateNodes()
+
 	// Move whatever nodes need to be moved from DontWantDelete -> WantDelete
-	for _, node := range g.iterateNodes() {
+	for _, node := range nodes {
 		if node.State == DontWantDelete {
-			node.changeState(WantDelete, f)
+			node.changeState(ctx, WantDelete, f)
 		}
 	}
 
+	// A paused group keeps track of what it wants to delete, but doesn't act on it
+	if g.Paused || suspended {
+		logrus.Debugf("Group %s is paused", g.Name)
+		return
+	}
+
 	// First attempt to move as many nodes as possible from Detached -> ReadyToDelete and then WantDelete -> ReadyToDelete
 	totalNumberOfNodes := g.size()
 	numBeingDeleted := g.stateCount(ReadyToDelete, Deleting)
 	numNotBeingDeleted := totalNumberOfNodes - numBeingDeleted
 	numCanBeDeleted := numNotBeingDeleted - g.NumDesired + g.MaxUnavailable
+	if numProtected := numNotBeingDeleted - g.ProtectLastN; numProtected < numCanBeDeleted {
+		numCanBeDeleted = numProtected
+	}
 
 	// If a deletionSchedule was specified, make sure that we are in an allowed time before
 	// moving any nodes in WantDelete into the deletion process
-	scheduleAllowsDeletion := g.DeletionSchedule == nil || g.DeletionSchedule.Matches(time.Now().In(time.UTC))
+	now := time.Now()
+	scheduleAllowsDeletion := g.scheduleAllowsDeletion(now.In(time.UTC))
+	g.trackWindow(now, scheduleAllowsDeletion)
 	if !scheduleAllowsDeletion && g.stateCount(WantDelete) > 0 {
-		logrus.Debugf("Group %s can't delete because of crontab", g.Name)
-		logrus.Tracef("Spec: %s, current time %v", g.DeletionSchedule.Source(), time.Now().In(time.UTC))
+		logrus.Debugf("Group %s can't delete because of its deletion schedule or calendar", g.Name)
+		if g.DeletionSchedule != nil {
+			logrus.Tracef("Spec: %s, current time %v", g.DeletionSchedule.Source(), time.Now().In(time.UTC))
+		}
 	}
 
+	// Never start deleting a Ready node if that would leave fewer than MinReadyNodes.
+	// This holds even if the desired size says otherwise, in case it is wrong
+	numReady := g.readyCount()
+
 	// Detached -> ReadyToDelete
-	for _, node := range g.iterateNodes() {
+	for _, node := range nodes {
 		if numCanBeDeleted <= 0 {
 			break
 		}
 		if node.State == Detached {
-			if ok := node.changeState(ReadyToDelete, f); ok {
+			if node.Ready && numReady <= g.MinReadyNodes {
+				logrus.Debugf("Group %s can't delete %s without going below minReadyNodes", g.Name, node.Name)
+				continue
+			}
+			if ok := node.changeState(ctx, ReadyToDelete, f); ok {
 				numCanBeDeleted--
+				if node.Ready {
+					numReady--
+				}
 			}
 		}
 	}
 
-	// WantDelete -> ReadyToDelete
-	if scheduleAllowsDeletion {
-		for _, node := range g.iterateNodes() {
-			if numCanBeDeleted <= 0 {
-				break
+	// WantDelete -> ReadyToDelete. Security recycles don't wait for the schedule
+	for _, node := range nodes {
+		if numCanBeDeleted <= 0 || g.startBudget(now) == 0 {
+			break
+		}
+		if node.State == WantDelete && (scheduleAllowsDeletion || node.SecurityRecycle) {
+			if node.Ready && numReady <= g.MinReadyNodes {
+				logrus.Debugf("Group %s can't delete %s without going below minReadyNodes", g.Name, node.Name)
+				continue
 			}
-			if node.State == WantDelete {
-				if ok := node.changeState(ReadyToDelete, f); ok {
-					numCanBeDeleted--
+			if ok := node.changeState(ctx, ReadyToDelete, f); ok {
+				numCanBeDeleted--
+				g.countStart(time.Now())
+				if node.Ready {
+					numReady--
 				}
 			}
 		}
 	}
 
-	// Now try to move as many nodes as possible from ReadyToDelete -> Deleting
-	for _, node := range g.iterateNodes() {
+	// Now try to move as many nodes as possible from ReadyToDelete -> Deleting.
+	// These transitions call the provider, so they are made in parallel
+	readyToDelete := []*NodeState{}
+	for _, node := range nodes {
 		if node.State == ReadyToDelete {
-			node.changeState(Deleting, f)
+			readyToDelete = append(readyToDelete, node)
 		}
 	}
+	changeStates(ctx, readyToDelete, Deleting, f)
 
-	// Now try to move as many nodes as possible from WantDelete -> Detached
-	if scheduleAllowsDeletion {
-		numCanBeDetached := g.MaxSurge - g.stateCount(Detached, ReadyToDelete, Deleting)
-		if numCanBeDetached < 0 {
-			numCanBeDetached = 0
+	// Now try to move as many nodes as possible from WantDelete -> Detached, in parallel batches.
+	// A node that can't be detached makes room in the next batch for the node after it.
+	// Security recycles don't wait for the schedule
+	numCanBeDetached := g.MaxSurge - g.stateCount(Detached, ReadyToDelete, Deleting)
+	if budget := g.startBudget(time.Now()); budget >= 0 && budget < numCanBeDetached {
+		logrus.Debugf("Group %s can start %v more deletions in this window of its schedule for now", g.Name, budget)
+		numCanBeDetached = budget
+	}
+	candidates := []*NodeState{}
+	for _, node := range nodes {
+		if node.State == WantDelete && (scheduleAllowsDeletion || node.SecurityRecycle) {
+			candidates = append(candidates, node)
 		}
-		for _, node := range g.iterateNodes() {
-			if numCanBeDetached == 0 {
+	}
+	for numCanBeDetached > 0 && len(candidates) > 0 {
+		batch := []*NodeState{}
+		for len(batch) < numCanBeDetached && len(candidates) > 0 {
+			if !budget.take() {
+				logrus.Debugf("Group %s can't detach %s because the total surge budget is used up", g.Name, candidates[0].Name)
+				candidates = nil
 				break
 			}
-			if node.State == WantDelete {
-				if ok := node.changeState(Detached, f); ok {
-					numCanBeDetached--
-				}
+			batch = append(batch, candidates[0])
+			candidates = candidates[1:]
+		}
+		for _, ok := range changeStates(ctx, batch, Detached, f) {
+			if ok {
+				numCanBeDetached--
+				g.countStart(time.Now())
+			} else {
+				budget.giveBack()
 			}
 		}
 	}
 }
 
+// changeStates tries to move every node to newState in parallel, returning which succeeded
+func changeStates(ctx context.Context, nodes []*NodeState, newState State, f StateTransitionFunction) []bool {
+	changed := make([]bool, len(nodes))
+	wait := sync.WaitGroup{}
+	for i, node := range nodes {
+		wait.Add(1)
+		go func(i int, node *NodeState) {
+			defer wait.Done()
+			changed[i] = node.changeState(ctx, newState, f)
+		}(i, node)
+	}
+	wait.Wait()
+	return changed
+}
+
 // Advance tries to advance deletion for all groups, in parallel
-func (gs *GroupStates) Advance(f StateTransitionFunction) {
+func (gs *GroupStates) Advance(ctx context.Context, f StateTransitionFunction) {
+	keys := []string{}
+	for key := range gs.Groups {
+		keys = append(keys, key)
+	}
+	gs.AdvanceGroups(ctx, keys, f)
+}
+
+// AdvanceGroups advances the groups with the given keys in parallel. Groups that
+// aren't advanced still count towards MaxTotalSurge
+func (gs *GroupStates) AdvanceGroups(ctx context.Context, groupKeys []string, f StateTransitionFunction) {
+	budget := gs.surgeBudget()
 	wait := sync.WaitGroup{}
-	for _, group := range gs.Groups {
+	for _, key := range groupKeys {
+		group, ok := gs.Groups[key]
+		if !ok {
+			continue
+		}
 		wait.Add(1)
 		go func(group *Group) {
 			defer wait.Done()
-			group.Advance(f)
+			group.advance(ctx, f, budget, gs.Suspended)
 		}(group)
 	}
 	wait.Wait()
 }
 
+// AdvanceGroup advances a single group, still respecting MaxTotalSurge
+func (gs *GroupStates) AdvanceGroup(ctx context.Context, groupKey string, f StateTransitionFunction) {
+	if group, ok := gs.Groups[groupKey]; ok {
+		group.advance(ctx, f, gs.surgeBudget(), gs.Suspended)
+	}
+}
+
+// surgeBudget returns the number of surge nodes still allowed cluster-wide,
+// or nil if there is no cap
+func (gs *GroupStates) surgeBudget() *surgeBudget {
+	if gs.MaxTotalSurge < 0 {
+		return nil
+	}
+	used := 0
+	for _, group := range gs.Groups {
+		used += group.surgeCount()
+	}
+	return &surgeBudget{
+		remaining: gs.MaxTotalSurge - used,
+	}
+}
+
 // Debug outputs some quick stats about each groups' state
 func (gs *GroupStates) Debug() {
 	for groupKey, group := range gs.Groups {
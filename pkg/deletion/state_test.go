@@ -0,0 +1,330 @@
+package deletion
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/cron"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func alwaysTransition(ctx context.Context, nodeName string, oldState, newState State) (bool, error) {
+	return true, nil
+}
+
+func newTestGroup(name string, numNodes int) *Group {
+	g := &Group{
+		Name:          name,
+		Key:           "___ig___" + name,
+		IsReal:        true,
+		MaxSurge:      numNodes,
+		NumDesired:    numNodes,
+		Nodes:         make(map[string]*NodeState),
+		PriorityNodes: make(map[string]struct{}),
+	}
+	for i := 0; i < numNodes; i++ {
+		nodeName := fmt.Sprintf("%s-%d", name, i)
+		g.Nodes[nodeName] = &NodeState{
+			Name:         nodeName,
+			State:        DontWantDelete,
+			CreationTime: meta_v1.NewTime(time.Now().Add(-time.Duration(i) * time.Hour)),
+		}
+	}
+	return g
+}
+
+func TestMaxTotalSurge(t *testing.T) {
+	gs := GroupStates{
+		Groups: map[string]*Group{
+			"a": newTestGroup("a", 3),
+			"b": newTestGroup("b", 3),
+		},
+		MaxTotalSurge: 2,
+	}
+
+	gs.Advance(context.Background(), alwaysTransition)
+
+	detached := 0
+	for _, group := range gs.Groups {
+		detached += group.stateCount(Detached, ReadyToDelete, Deleting)
+	}
+	if detached != 2 {
+		t.Errorf("Expected 2 surge nodes across all groups, got %v", detached)
+	}
+
+	// Without a cap every node in both groups can be detached
+	gs.MaxTotalSurge = -1
+	gs.Advance(context.Background(), alwaysTransition)
+	detached = 0
+	for _, group := range gs.Groups {
+		detached += group.stateCount(Detached, ReadyToDelete, Deleting)
+	}
+	if detached != 6 {
+		t.Errorf("Expected 6 surge nodes without a cap, got %v", detached)
+	}
+}
+
+func TestMaxTotalSurgeCountsDetachedNodes(t *testing.T) {
+	for _, surged := range []bool{false, true} {
+		a := newTestGroup("a", 2)
+		for _, node := range a.Nodes {
+			node.State = Deleting
+			node.Surged = surged
+		}
+		gs := GroupStates{
+			Groups: map[string]*Group{
+				"a": a,
+				"b": newTestGroup("b", 3),
+			},
+			MaxTotalSurge: 2,
+		}
+
+		gs.Advance(context.Background(), alwaysTransition)
+
+		// Nodes deleted without being detached were never replaced, so they don't use up the budget
+		expected := 2
+		if surged {
+			expected = 0
+		}
+		if detached := gs.Groups["b"].stateCount(Detached); detached != expected {
+			t.Errorf("Expected %v nodes of b detached with surged %v nodes deleting in a, got %v", expected, surged, detached)
+		}
+	}
+}
+
+func TestAdvanceGroups(t *testing.T) {
+	gs := GroupStates{
+		Groups: map[string]*Group{
+			"a": newTestGroup("a", 2),
+			"b": newTestGroup("b", 2),
+		},
+		MaxTotalSurge: -1,
+	}
+
+	gs.AdvanceGroups(context.Background(), []string{"a"}, alwaysTransition)
+	if !gs.Groups["b"].atRest() {
+		t.Errorf("Expected group b not to be advanced")
+	}
+	if gs.Groups["a"].atRest() {
+		t.Errorf("Expected group a to be advanced")
+	}
+}
+
+func TestPausedGroup(t *testing.T) {
+	g := newTestGroup("a", 3)
+	g.Paused = true
+
+	g.Advance(context.Background(), alwaysTransition)
+	if n := g.stateCount(WantDelete); n != 3 {
+		t.Errorf("Expected a paused group to still evaluate its nodes, got %v in %v", n, WantDelete)
+	}
+	if n := g.stateCount(Detached, ReadyToDelete, Deleting); n != 0 {
+		t.Errorf("Expected a paused group not to delete, got %v nodes being deleted", n)
+	}
+
+	g.Paused = false
+	g.Advance(context.Background(), alwaysTransition)
+	if n := g.stateCount(Detached, ReadyToDelete, Deleting); n == 0 {
+		t.Errorf("Expected a resumed group to delete nodes")
+	}
+}
+
+func TestSuspendedGroupStates(t *testing.T) {
+	gs := GroupStates{
+		Groups: map[string]*Group{
+			"a": newTestGroup("a", 2),
+			"b": newTestGroup("b", 2),
+		},
+		MaxTotalSurge: -1,
+		Suspended:     true,
+	}
+
+	gs.Advance(context.Background(), alwaysTransition)
+	for _, group := range gs.Groups {
+		if n := group.stateCount(Detached, ReadyToDelete, Deleting); n != 0 {
+			t.Errorf("Expected no deletions while suspended, got %v in group %v", n, group.Name)
+		}
+	}
+
+	gs.Suspended = false
+	gs.Advance(context.Background(), alwaysTransition)
+	for _, group := range gs.Groups {
+		if n := group.stateCount(Detached, ReadyToDelete, Deleting); n == 0 {
+			t.Errorf("Expected group %v to delete nodes once resumed", group.Name)
+		}
+	}
+}
+
+func TestMinReadyNodes(t *testing.T) {
+	g := newTestGroup("a", 4)
+	// A wrong desired size would otherwise allow deleting every node
+	g.NumDesired = 0
+	g.MinReadyNodes = 3
+	for _, node := range g.Nodes {
+		node.Ready = true
+	}
+
+	g.Advance(context.Background(), alwaysTransition)
+	g.Advance(context.Background(), alwaysTransition)
+	if n := g.stateCount(ReadyToDelete, Deleting); n != 1 {
+		t.Errorf("Expected only 1 node to be deleted with minReadyNodes 3 of 4, got %v", n)
+	}
+}
+
+func TestProtectLastN(t *testing.T) {
+	g := newTestGroup("a", 3)
+	g.NumDesired = 0
+	g.ProtectLastN = 2
+
+	for i := 0; i < 3; i++ {
+		g.Advance(context.Background(), alwaysTransition)
+	}
+	if n := g.stateCount(ReadyToDelete, Deleting); n != 1 {
+		t.Errorf("Expected the last 2 of 3 nodes to be protected, got %v being deleted", n)
+	}
+}
+
+func TestDetachSkipsRefusedNodes(t *testing.T) {
+	g := newTestGroup("a", 3)
+	g.MaxSurge = 1
+	// The oldest node is tried first, but can't be detached
+	refuseOldest := func(ctx context.Context, nodeName string, oldState, newState State) (bool, error) {
+		return !(nodeName == "a-2" && newState == Detached), nil
+	}
+
+	g.Advance(context.Background(), refuseOldest)
+	if n := g.stateCount(Detached); n != 1 {
+		t.Errorf("Expected another node to be detached in place of the refused one, got %v detached", n)
+	}
+	if g.Nodes["a-2"].State != WantDelete {
+		t.Errorf("Expected the refused node to stay in %v, got %v", WantDelete, g.Nodes["a-2"].State)
+	}
+}
+
+func TestSecurityRecycleIgnoresSchedule(t *testing.T) {
+	g := newTestGroup("a", 3)
+	g.MaxSurge = 2
+	// A schedule that never matches
+	g.DeletionSchedule, _ = cron.ParseStandard("0 0 30 2 *")
+	g.Nodes["a-0"].SecurityRecycle = true
+
+	g.Advance(context.Background(), alwaysTransition)
+	if g.Nodes["a-0"].State == WantDelete || g.Nodes["a-0"].State == DontWantDelete {
+		t.Errorf("Expected the security recycle to go ahead outside the schedule, got %v", g.Nodes["a-0"].State)
+	}
+	if n := g.stateCount(WantDelete); n != 2 {
+		t.Errorf("Expected the other nodes to wait for the schedule, got %v in %v", n, WantDelete)
+	}
+}
+
+func TestRemoveEmptyGroups(t *testing.T) {
+	paused := newTestGroup("paused", 0)
+	paused.Paused = true
+	gs := GroupStates{
+		Groups: map[string]*Group{
+			"old":    newTestGroup("old", 2),
+			"new":    newTestGroup("new", 0),
+			"paused": paused,
+		},
+		MaxTotalSurge: -1,
+	}
+
+	// The group was renamed, so its nodes are now tracked under the new key
+	for name, node := range gs.Groups["old"].Nodes {
+		gs.Groups["new"].Nodes[name] = node
+		delete(gs.Groups["old"].Nodes, name)
+	}
+
+	removed := gs.removeEmptyGroups()
+	if len(removed) != 1 || removed[0] != "old" {
+		t.Errorf("Expected only the renamed group to be removed, got %v", removed)
+	}
+	if _, ok := gs.Groups["paused"]; !ok {
+		t.Errorf("Expected an empty paused group to be kept")
+	}
+	if n := gs.Groups["new"].size(); n != 2 {
+		t.Errorf("Expected the renamed group to keep its 2 nodes, got %v", n)
+	}
+}
+
+func TestSerializeStateCompact(t *testing.T) {
+	g := newTestGroup("a", 3)
+	g.Nodes["a-0"].State = Detached
+	until := time.Now().Add(time.Hour)
+	g.Nodes["a-1"].SnoozedUntil = &until
+	gs := GroupStates{Groups: map[string]*Group{g.Key: g}}
+
+	state := gs.SerializeState()
+	if len(state.NodeStates) != 2 {
+		t.Errorf("Expected only the 2 nodes with state worth persisting, got %v", state.NodeStates)
+	}
+	if _, ok := state.NodeStates["a-2"]; ok {
+		t.Errorf("Expected a node in %v with nothing else set to be left out", DontWantDelete)
+	}
+}
+
+func TestDeletionsPerWindow(t *testing.T) {
+	g := newTestGroup("a", 3)
+	g.NumDesired = 0
+	g.DeletionSchedule, _ = cron.ParseStandard("* * * * *")
+	g.DeletionsPerWindow = 1
+
+	g.Advance(context.Background(), alwaysTransition)
+	g.Advance(context.Background(), alwaysTransition)
+	if n := g.stateCount(Detached, ReadyToDelete, Deleting); n != 1 {
+		t.Errorf("Expected 1 deletion in the window, got %v", n)
+	}
+
+	// The window closes and opens again
+	g.trackWindow(time.Now(), false)
+	g.Advance(context.Background(), alwaysTransition)
+	if n := g.stateCount(Detached, ReadyToDelete, Deleting); n != 2 {
+		t.Errorf("Expected another deletion in the next window, got %v", n)
+	}
+}
+
+func TestPaceDeletions(t *testing.T) {
+	g := newTestGroup("a", 3)
+	g.NumDesired = 0
+	g.DeletionSchedule, _ = cron.ParseStandard("* * * * *")
+	g.PaceDeletions = true
+
+	g.Advance(context.Background(), alwaysTransition)
+	g.Advance(context.Background(), alwaysTransition)
+	if n := g.stateCount(Detached, ReadyToDelete, Deleting); n != 1 {
+		t.Errorf("Expected only the first deletion to start right away, got %v", n)
+	}
+
+	// The remaining 2 nodes are spaced evenly over what's left of the window
+	g.Window.LastStarted = g.Window.LastStarted.Add(-g.Window.end.Sub(g.Window.LastStarted) / 2)
+	g.Advance(context.Background(), alwaysTransition)
+	if n := g.stateCount(Detached, ReadyToDelete, Deleting); n != 2 {
+		t.Errorf("Expected the next deletion to start once its turn came, got %v", n)
+	}
+}
+
+func benchmarkAdvance(b *testing.B, numGroups, nodesPerGroup int) {
+	gs := GroupStates{
+		Groups:        map[string]*Group{},
+		MaxTotalSurge: -1,
+	}
+	for i := 0; i < numGroups; i++ {
+		g := newTestGroup(fmt.Sprintf("g%d", i), nodesPerGroup)
+		gs.Groups[g.Key] = g
+	}
+	neverTransition := func(ctx context.Context, nodeName string, oldState, newState State) (bool, error) {
+		return false, nil
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gs.Advance(context.Background(), neverTransition)
+	}
+}
+
+func BenchmarkAdvance1k(b *testing.B) { benchmarkAdvance(b, 20, 50) }
+
+func BenchmarkAdvance5k(b *testing.B) { benchmarkAdvance(b, 100, 50) }
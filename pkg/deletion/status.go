@@ -0,0 +1,321 @@
+package deletion
+
+import (
+	"sort"
+	"time"
+
+	"github.com/wish/nodereaper/pkg/config"
+	"github.com/wish/nodereaper/pkg/metrics"
+)
+
+// Blocker is a reason that a node is not currently progressing through deletion
+type Blocker string
+
+const (
+	// Ignored means the node counts towards its group's size but will never be deleted
+	Ignored Blocker = "ignored"
+	// OutsideDeletionSchedule means the group's deletionSchedule does not allow deletion right now
+	OutsideDeletionSchedule Blocker = "outside_deletion_schedule"
+	// MaxSurgeReached means the group already has as many surge nodes as it is allowed
+	MaxSurgeReached Blocker = "max_surge_reached"
+	// MaxUnavailableReached means deleting the node would leave the group with too few nodes
+	MaxUnavailableReached Blocker = "max_unavailable_reached"
+	// GroupPaused means an operator paused the node's group
+	GroupPaused Blocker = "group_paused"
+	// Snoozed means an operator snoozed the node
+	Snoozed Blocker = "snoozed"
+	// MinReadyNodesReached means deleting the node would leave its group with fewer than minReadyNodes Ready nodes
+	MinReadyNodesReached Blocker = "min_ready_nodes_reached"
+	// LastNodesProtected means the node is one of the last protectLastN nodes of its group
+	LastNodesProtected Blocker = "last_nodes_protected"
+	// HostsSingletonWorkload means the node runs a pod with no other replica, see deleteSingletonWorkloads
+	HostsSingletonWorkload Blocker = "hosts_singleton_workload"
+	// CriticalPodRunning means the node runs a pod matching its group's criticalPodSelector
+	CriticalPodRunning Blocker = "critical_pod_running"
+	// InsufficientHeadroom means the rest of the group or cluster couldn't fit the node's pods, see headroomCheck
+	InsufficientHeadroom Blocker = "insufficient_headroom"
+	// ClusterDegraded means deletions are suspended because too many nodes are NotReady
+	ClusterDegraded Blocker = "cluster_degraded"
+	// ErrorRateExceeded means deletions are suspended because too many recent transitions failed
+	ErrorRateExceeded Blocker = "error_rate_exceeded"
+	// ApprovalDenied means the group's approvalWebhook didn't approve the node's deletion
+	ApprovalDenied Blocker = "approval_denied"
+	// ApprovalPending means the node is waiting for someone to answer an interactive approval request
+	ApprovalPending Blocker = "approval_pending"
+	// MissingProviderID means the node has no ProviderID, so the provider can't detach or terminate it
+	MissingProviderID Blocker = "missing_provider_id"
+)
+
+// NodeStatus is a snapshot of a single node's progress through deletion
+type NodeStatus struct {
+	Name               string         `json:"name"`
+	Group              string         `json:"group"`
+	State              State          `json:"state"`
+	Reason             metrics.Reason `json:"reason,omitempty"`
+	RequestedReason    string         `json:"requestedReason,omitempty"`
+	RequestedBy        string         `json:"requestedBy,omitempty"`
+	Blockers           []Blocker      `json:"blockers"`
+	SnoozedUntil       *time.Time     `json:"snoozedUntil,omitempty"`
+	CreationTime       time.Time      `json:"creationTime"`
+	LastTransitionTime *time.Time     `json:"lastTransitionTime,omitempty"`
+}
+
+// GroupStatus is a snapshot of a group's deletion state and settings
+type GroupStatus struct {
+	Name            string       `json:"name"`
+	Key             string       `json:"key"`
+	IsReal          bool         `json:"isReal"`
+	DesiredSize     *int         `json:"desiredSize,omitempty"`
+	MaxSurge        int          `json:"maxSurge"`
+	MaxUnavailable  int          `json:"maxUnavailable"`
+	Paused          bool         `json:"paused"`
+	DeletionEnabled bool         `json:"deletionEnabled"`
+	Nodes           []NodeStatus `json:"nodes"`
+}
+
+// GroupConfig is the configuration in effect for a group, after applying global settings and
+// defaults. MaxSurge and MaxUnavailable are resolved to a number of nodes, as of the last poll
+type GroupConfig struct {
+	Name           string                    `json:"name"`
+	Key            string                    `json:"key"`
+	MaxSurge       int                       `json:"maxSurge"`
+	MaxUnavailable int                       `json:"maxUnavailable"`
+	Settings       map[string]config.Setting `json:"settings"`
+}
+
+// GroupSummary is a compact form of GroupStatus that is persisted along with
+// node states, so the state configmap can be understood without the controller
+type GroupSummary struct {
+	Name            string          `json:"name"`
+	DesiredSize     *int            `json:"desiredSize,omitempty"`
+	MaxSurge        int             `json:"maxSurge"`
+	MaxUnavailable  int             `json:"maxUnavailable"`
+	Paused          bool            `json:"paused,omitempty"`
+	DeletionEnabled bool            `json:"deletionEnabled"`
+	States          map[State]int   `json:"states"`
+	Blockers        map[Blocker]int `json:"blockers,omitempty"`
+}
+
+// Groups returns the live state of every group the deleter knows about
+func (d *Deleter) Groups() []GroupStatus {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	ret := []GroupStatus{}
+	for _, group := range d.states.Groups {
+		ret = append(ret, d.groupStatus(group))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	return ret
+}
+
+// Group returns the live state of the group with the given name, or nil if the deleter isn't tracking it
+func (d *Deleter) Group(name string) *GroupStatus {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	if group := d.groupByName(name); group != nil {
+		status := d.groupStatus(group)
+		return &status
+	}
+	return nil
+}
+
+// Config returns the configuration in effect for every group the deleter knows about
+func (d *Deleter) Config() []GroupConfig {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	ret := []GroupConfig{}
+	for _, group := range d.states.Groups {
+		ret = append(ret, d.groupConfig(group))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	return ret
+}
+
+// GroupConfig returns the configuration in effect for the group with the given name, or nil
+// if the deleter isn't tracking it
+func (d *Deleter) GroupConfig(name string) *GroupConfig {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	if group := d.groupByName(name); group != nil {
+		c := d.groupConfig(group)
+		return &c
+	}
+	return nil
+}
+
+func (d *Deleter) groupConfig(group *Group) GroupConfig {
+	return GroupConfig{
+		Name:           group.Name,
+		Key:            group.Key,
+		MaxSurge:       group.MaxSurge,
+		MaxUnavailable: group.MaxUnavailable,
+		Settings:       d.opts.EffectiveSettings(group.Name),
+	}
+}
+
+// Node returns the live state of a single node, or nil if the deleter isn't tracking it
+func (d *Deleter) Node(name string) *NodeStatus {
+	d.statesMu.Lock()
+	defer d.statesMu.Unlock()
+
+	for _, group := range d.states.Groups {
+		if node, ok := group.Nodes[name]; ok {
+			status := d.nodeStatus(group, node)
+			return &status
+		}
+	}
+	return nil
+}
+
+func (d *Deleter) groupStatus(group *Group) GroupStatus {
+	scheduleAllowsDeletion := group.scheduleAllowsDeletion(time.Now().In(time.UTC))
+	g := GroupStatus{
+		Name:            group.Name,
+		Key:             group.Key,
+		IsReal:          group.IsReal,
+		MaxSurge:        group.MaxSurge,
+		MaxUnavailable:  group.MaxUnavailable,
+		Paused:          group.Paused,
+		DeletionEnabled: !d.opts.GetBool(group.Name, "ignore") && scheduleAllowsDeletion && !group.Paused,
+		Nodes:           []NodeStatus{},
+	}
+	if group.NumDesired != metrics.VeryHighFalseDesiredSize {
+		desired := group.NumDesired
+		g.DesiredSize = &desired
+	}
+	for _, node := range group.Nodes {
+		g.Nodes = append(g.Nodes, d.nodeStatus(group, node))
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].Name < g.Nodes[j].Name
+	})
+	return g
+}
+
+// groupSummaries summarizes every group, keyed by group key. Callers must hold statesMu
+func (d *Deleter) groupSummaries() map[string]GroupSummary {
+	ret := map[string]GroupSummary{}
+	for groupKey, group := range d.states.Groups {
+		scheduleAllowsDeletion := group.scheduleAllowsDeletion(time.Now().In(time.UTC))
+		summary := GroupSummary{
+			Name:            group.Name,
+			MaxSurge:        group.MaxSurge,
+			MaxUnavailable:  group.MaxUnavailable,
+			Paused:          group.Paused,
+			DeletionEnabled: !d.opts.GetBool(group.Name, "ignore") && scheduleAllowsDeletion && !group.Paused,
+			States:          map[State]int{},
+			Blockers:        map[Blocker]int{},
+		}
+		if group.NumDesired != metrics.VeryHighFalseDesiredSize {
+			desired := group.NumDesired
+			summary.DesiredSize = &desired
+		}
+		for _, node := range group.Nodes {
+			summary.States[node.State]++
+			for _, blocker := range d.blockers(group, node) {
+				summary.Blockers[blocker]++
+			}
+		}
+		ret[groupKey] = summary
+	}
+	return ret
+}
+
+func (d *Deleter) nodeStatus(group *Group, node *NodeState) NodeStatus {
+	n := NodeStatus{
+		Name:            node.Name,
+		Group:           group.Name,
+		State:           node.State,
+		Blockers:        d.blockers(group, node),
+		CreationTime:    node.CreationTime.Time,
+		RequestedReason: node.RequestedReason,
+		RequestedBy:     node.RequestedBy,
+	}
+	if node.snoozed() {
+		n.SnoozedUntil = node.SnoozedUntil
+	}
+	if !node.LastTransitionTime.IsZero() {
+		t := node.LastTransitionTime
+		n.LastTransitionTime = &t
+	}
+	if realNode, err := d.controller.NodeByName(node.Name); err == nil && realNode != nil {
+		n.Reason = d.deletionReason(node, realNode)
+	}
+	return n
+}
+
+// blockers mirrors the checks made in Group.Advance to explain why a node isn't moving
+func (d *Deleter) blockers(group *Group, node *NodeState) []Blocker {
+	blockers := []Blocker{}
+	if node.NeverDelete {
+		blockers = append(blockers, Ignored)
+	}
+
+	scheduleAllowsDeletion := group.scheduleAllowsDeletion(time.Now().In(time.UTC))
+	numCanBeDeleted := group.size() - group.stateCount(ReadyToDelete, Deleting) - group.NumDesired + group.MaxUnavailable
+
+	if node.snoozed() {
+		blockers = append(blockers, Snoozed)
+	}
+	if group.Paused && node.State != DontWantDelete {
+		blockers = append(blockers, GroupPaused)
+	}
+	if node.State != DontWantDelete && node.State != Deleting {
+		if d.breakers.clusterDegraded {
+			blockers = append(blockers, ClusterDegraded)
+		}
+		if d.breakers.errorRateExceeded {
+			blockers = append(blockers, ErrorRateExceeded)
+		}
+	}
+
+	switch node.State {
+	case WantDelete:
+		if !scheduleAllowsDeletion {
+			blockers = append(blockers, OutsideDeletionSchedule)
+		}
+		if d.approvals.isDenied(node.Name) || node.DeniedBy != "" {
+			blockers = append(blockers, ApprovalDenied)
+		} else if node.ApprovalRequested && node.ApprovedBy == "" {
+			blockers = append(blockers, ApprovalPending)
+		}
+		if group.stateCount(Detached, ReadyToDelete, Deleting) >= group.MaxSurge && numCanBeDeleted <= 0 {
+			blockers = append(blockers, MaxSurgeReached)
+		}
+	case Detached:
+		if numCanBeDeleted <= 0 {
+			blockers = append(blockers, MaxUnavailableReached)
+		}
+	}
+	if node.State == WantDelete && node.ProviderID == "" {
+		blockers = append(blockers, MissingProviderID)
+	}
+	if (node.State == WantDelete || node.State == Detached) && node.Ready && group.readyCount() <= group.MinReadyNodes {
+		blockers = append(blockers, MinReadyNodesReached)
+	}
+	if (node.State == WantDelete || node.State == Detached) && group.size()-group.stateCount(ReadyToDelete, Deleting) <= group.ProtectLastN {
+		blockers = append(blockers, LastNodesProtected)
+	}
+	if node.State == WantDelete || node.State == Detached {
+		if realNode, err := d.controller.NodeByName(node.Name); err == nil && realNode != nil {
+			if node.State == WantDelete && len(d.criticalPods(realNode)) > 0 {
+				blockers = append(blockers, CriticalPodRunning)
+			}
+			if node.State == WantDelete && d.insufficientHeadroom(realNode) {
+				blockers = append(blockers, InsufficientHeadroom)
+			}
+			if d.hostsSingletonWorkload(realNode) {
+				blockers = append(blockers, HostsSingletonWorkload)
+			}
+		}
+	}
+	return blockers
+}
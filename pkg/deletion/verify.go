@@ -0,0 +1,168 @@
+package deletion
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8s_types "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// verificationTimeout is how long a deleted node has to meet every success criterion,
+	// before its deletion is recorded as incomplete
+	verificationTimeout = 15 * time.Minute
+
+	criterionReplacementReady   = "replacement_ready"
+	criterionPodsRescheduled    = "pods_rescheduled"
+	criterionInstanceTerminated = "instance_terminated"
+)
+
+// deletionVerification follows a deleted node until its deletion is verified, or times out
+type deletionVerification struct {
+	node       string
+	group      string
+	groupKey   string
+	providerID string
+	deletionID string
+	// expectReplacement is set if the node's group is expected to replace it
+	expectReplacement bool
+	podOwners         map[k8s_types.UID]struct{}
+	deleted           time.Time
+	// failed are the criteria not met at the last check
+	failed []string
+}
+
+// rememberPodOwners adds the controllers of the node's pods to those whose pods are
+// checked after its deletion. Callers must hold statesMu
+func (d *Deleter) rememberPodOwners(nodeState *NodeState) {
+	pods, err := d.controller.PodsOnNode(nodeState.Name)
+	if err != nil {
+		return
+	}
+	for _, pod := range pods {
+		owner := meta_v1.GetControllerOf(pod)
+		if owner == nil || owner.Kind == "DaemonSet" {
+			continue
+		}
+		if nodeState.podOwners == nil {
+			nodeState.podOwners = map[k8s_types.UID]struct{}{}
+		}
+		nodeState.podOwners[owner.UID] = struct{}{}
+	}
+}
+
+// startVerification starts following a node that was deleted. Callers must hold statesMu
+func (d *Deleter) startVerification(group *Group, nodeState *NodeState) {
+	if !d.opts.VerifyDeletions {
+		return
+	}
+	d.verifications[nodeState.Name] = &deletionVerification{
+		node:              nodeState.Name,
+		group:             group.Name,
+		groupKey:          group.Key,
+		providerID:        nodeState.ProviderID,
+		deletionID:        nodeState.DeletionID,
+		expectReplacement: group.IsReal && group.OrphanedSince.IsZero(),
+		podOwners:         nodeState.podOwners,
+		deleted:           time.Now(),
+	}
+}
+
+// verifyDeletions checks the success criteria of every deleted node being followed, and records
+// the outcome once they are all met, or once verificationTimeout passes. Callers must hold statesMu
+func (d *Deleter) verifyDeletions(ctx context.Context) {
+	for name, v := range d.verifications {
+		v.failed = d.unmetCriteria(ctx, v)
+		verified := len(v.failed) == 0
+		if !verified && time.Since(v.deleted) < verificationTimeout {
+			continue
+		}
+		delete(d.verifications, name)
+		d.metrics.RecordDeletionVerified(verified)
+		if verified {
+			d.history.setOutcome(v.node, OutcomeVerified)
+		} else {
+			d.history.setOutcome(v.node, OutcomeIncomplete)
+		}
+
+		fields := logrus.Fields{
+			"audit":       true,
+			"action":      "verify_delete",
+			"node":        v.node,
+			"group":       v.group,
+			"deletion_id": v.deletionID,
+			"verified":    verified,
+		}
+		if verified {
+			logrus.WithFields(fields).Infof("Verified deletion of node %v", v.node)
+		} else {
+			fields["failed"] = strings.Join(v.failed, ",")
+			logrus.WithFields(fields).Warnf("Deletion of node %v is incomplete after %v: %v", v.node, verificationTimeout, strings.Join(v.failed, ", "))
+		}
+	}
+}
+
+// unmetCriteria returns the success criteria the deletion doesn't meet yet. A criterion
+// that can't be checked, like pods without a pod informer, is skipped
+func (d *Deleter) unmetCriteria(ctx context.Context, v *deletionVerification) []string {
+	failed := []string{}
+
+	// The group is back to its desired number of Ready nodes
+	if v.expectReplacement {
+		group, ok := d.states.Groups[v.groupKey]
+		ready := 0
+		if ok {
+			for _, nodeState := range group.Nodes {
+				if nodeState.Ready {
+					ready++
+				}
+			}
+		}
+		if !ok || ready < group.NumDesired {
+			failed = append(failed, criterionReplacementReady)
+		}
+	}
+
+	// No pod is left on the node, and the pods that were on it aren't waiting to be scheduled
+	if pods, err := d.controller.PodsOnNode(v.node); err == nil {
+		rescheduled := len(pods) == 0
+		for uid := range v.podOwners {
+			owned, err := d.controller.PodsByOwner(uid)
+			if err != nil {
+				break
+			}
+			for _, pod := range owned {
+				if pod.Status.Phase == core_v1.PodPending && pod.Spec.NodeName == "" {
+					rescheduled = false
+				}
+			}
+		}
+		if !rescheduled {
+			failed = append(failed, criterionPodsRescheduled)
+		}
+	}
+
+	// The instance is shutting down or gone
+	if v.providerID != "" {
+		node := &core_v1.Node{
+			ObjectMeta: meta_v1.ObjectMeta{Name: v.node},
+			Spec:       core_v1.NodeSpec{ProviderID: v.providerID},
+		}
+		var terminated bool
+		err := d.providerPool.do(ctx, "check instance of "+v.node, func() (err error) {
+			terminated, err = d.provider.InstanceTerminated(node)
+			return err
+		})
+		if err != nil {
+			logrus.Warnf("Error verifying the instance of deleted node %v is terminated: %v", v.node, err)
+		}
+		if !terminated {
+			failed = append(failed, criterionInstanceTerminated)
+		}
+	}
+	return failed
+}
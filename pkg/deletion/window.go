@@ -0,0 +1,106 @@
+package deletion
+
+import (
+	"time"
+)
+
+const (
+	// maxWindowLookback bounds how far back an adopted deletion window is checked for having
+	// stayed open. Older windows are treated as closed
+	maxWindowLookback = 7 * 24 * time.Hour
+)
+
+// DeletionWindow is the persisted progress of a group through the current opening of its
+// deletionSchedule or deletionCalendar
+type DeletionWindow struct {
+	Start     time.Time `json:"start"`
+	Deletions int       `json:"deletions"`
+	// LastStarted is when a node last started being deleted, for paceDeletions
+	LastStarted time.Time `json:"lastStarted,omitempty"`
+	// end is when the window closes, found when it's first needed
+	end time.Time
+}
+
+// tracksWindow returns true if the group's deletions depend on the opening of its schedule
+func (g *Group) tracksWindow() bool {
+	return (g.DeletionsPerWindow > 0 || g.PaceDeletions) && (g.DeletionSchedule != nil || g.DeletionCalendar != nil)
+}
+
+// startBudget returns how many more nodes may start being deleted right now, or -1 if there is no
+// limit. deletionsPerWindow limits the nodes per window, and paceDeletions spaces them evenly
+// between now and the end of the window
+func (g *Group) startBudget(now time.Time) int {
+	if !g.tracksWindow() || g.Window.Start.IsZero() {
+		return -1
+	}
+	budget := -1
+	if g.DeletionsPerWindow > 0 {
+		budget = g.DeletionsPerWindow - g.Window.Deletions
+		if budget < 0 {
+			budget = 0
+		}
+	}
+	if g.PaceDeletions && budget != 0 {
+		if now.Before(g.nextPacedStart()) {
+			return 0
+		}
+		budget = 1
+	}
+	return budget
+}
+
+// nextPacedStart spaces the nodes still waiting to be deleted evenly over what's left of the window
+func (g *Group) nextPacedStart() time.Time {
+	if g.Window.LastStarted.IsZero() {
+		return g.Window.Start
+	}
+	if g.Window.end.IsZero() {
+		g.Window.end = g.windowEnd(g.Window.LastStarted)
+	}
+	waiting := g.stateCount(WantDelete)
+	return g.Window.LastStarted.Add(g.Window.end.Sub(g.Window.LastStarted) / time.Duration(waiting+1))
+}
+
+// windowEnd returns the first minute after t that the schedule doesn't allow deletions
+func (g *Group) windowEnd(t time.Time) time.Time {
+	limit := t.Add(maxWindowLookback)
+	for t = t.Truncate(time.Minute).Add(time.Minute); t.Before(limit); t = t.Add(time.Minute) {
+		if !g.scheduleAllowsDeletion(t.In(time.UTC)) {
+			return t
+		}
+	}
+	return limit
+}
+
+// trackWindow starts counting deletions when the schedule opens, and stops when it closes
+func (g *Group) trackWindow(now time.Time, open bool) {
+	if !g.tracksWindow() || !open {
+		g.Window = DeletionWindow{}
+		return
+	}
+	if g.Window.Start.IsZero() {
+		g.Window = DeletionWindow{Start: now}
+	}
+}
+
+// countStart counts a node that started being deleted against the current window
+func (g *Group) countStart(now time.Time) {
+	if g.tracksWindow() && !g.Window.Start.IsZero() {
+		g.Window.Deletions++
+		g.Window.LastStarted = now
+	}
+}
+
+// stayedOpen returns true if the group's schedule allowed deletions for every minute from start to now,
+// so an adopted window is known to be the same opening of the schedule
+func (g *Group) stayedOpen(start, now time.Time) bool {
+	if now.Sub(start) > maxWindowLookback {
+		return false
+	}
+	for t := start; t.Before(now); t = t.Add(time.Minute) {
+		if !g.scheduleAllowsDeletion(t.In(time.UTC)) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,41 +1,182 @@
 package metrics
 
 import (
-	"io"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	dto "github.com/prometheus/client_model/go"
-	"github.com/prometheus/common/expfmt"
-	"github.com/sirupsen/logrus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 const (
-	contentTypeHeader     = "Content-Type"
-	contentEncodingHeader = "Content-Encoding"
 	// VeryHighFalseDesiredSize : If the actual desired size is unknown, set it to this
 	// and the desired_size metric will not be output for the group
 	VeryHighFalseDesiredSize = 9999999999
+	// snapshotTTL is how long generated metrics are served without any update, before
+	// being generated again to refresh their timestamps
+	snapshotTTL = 15 * time.Second
 )
 
+// providerCallBuckets are the upper bounds, in seconds, of the provider API call latency histogram
+var providerCallBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// deletionDurationBuckets are the upper bounds, in seconds, of the end-to-end deletion duration histogram
+var deletionDurationBuckets = []float64{300, 600, 900, 1200, 1800, 2700, 3600, 7200, 14400, 43200, 86400}
+
 // Reason represents a reason that the controller would want to delete a node
 type Reason string
 
 const (
-	// HasDeletionLabel means the node has the label specified in config.Ops.RequestDeletionLabel
+	// HasDeletionLabel means the node has one of the labels in config.Ops.RequestDeletionLabel, which doesn't specify its own reason
 	HasDeletionLabel Reason = "has_deletion_label"
 	// TooOld means the node is older than the duration specified by config.Ops.DeletionAge
 	TooOld Reason = "too_old"
+	// MaxLifetime means the node is older than global.maxNodeLifetime
+	MaxLifetime Reason = "max_lifetime"
+	// OrphanedGroup means the node's group no longer exists with the provider, and its orphanedGroupPolicy is delete
+	OrphanedGroup Reason = "orphaned_group"
 	// ConfigurationChanged means the node configuration is out of sync with the ASG config
 	ConfigurationChanged Reason = "configuration_changed"
+	// OperatorRequested means an operator requested deletion through the admin API
+	OperatorRequested Reason = "operator_requested"
+	// SecurityRecycle means the node has the label or annotation specified in config.Ops.SecurityRecycleLabel
+	SecurityRecycle Reason = "security_recycle"
+	// InstanceImpaired means the provider reports the node's instance failing its status checks for instanceImpairedPeriod
+	InstanceImpaired Reason = "instance_impaired"
+	// CordonedTooLong means the node has been cordoned for longer than cordonedDeletionAge
+	CordonedTooLong Reason = "cordoned_too_long"
+	// PodFailures means too many of the node's pods kept failing, while healthy on other nodes
+	PodFailures Reason = "pod_failures"
+	// Recycled means the node is the oldest in its group, and the group's recycleRate allows recycling it
+	Recycled Reason = "recycled"
 )
 
-// Reporter is responsible for storing and serving prometheus metrics
+// Exclusion represents a reason that a node is outside the controller's control
+type Exclusion string
+
+const (
+	// StartupGracePeriod means the node is newer than startupGracePeriod, and isn't tracked yet
+	StartupGracePeriod Exclusion = "startup_grace_period"
+	// NotReady means the node isn't Ready, and isn't tracked
+	NotReady Exclusion = "not_ready"
+	// BeingDeleted means the node already has a deletion timestamp
+	BeingDeleted Exclusion = "being_deleted"
+	// Ignored means the node's group has ignore set
+	Ignored Exclusion = "ignore"
+	// IgnoreSelector means the node matches its group's ignoreSelector
+	IgnoreSelector Exclusion = "ignore_selector"
+	// AutoscalerScaleDownDisabled means cluster-autoscaler scale down is disabled for the node
+	AutoscalerScaleDownDisabled Exclusion = "autoscaler_scale_down_disabled"
+	// IgnoreTaints means the node has one of its group's ignoreTaints
+	IgnoreTaints Exclusion = "ignore_taints"
+	// MissingProviderID means the node has had no ProviderID for longer than providerIDTimeout. It is
+	// still tracked, but held in want_delete until it has one
+	MissingProviderID Exclusion = "missing_provider_id"
+)
+
+// exclusions are reported for every group with an excluded node, so the others drop to 0
+var exclusions = []Exclusion{StartupGracePeriod, NotReady, BeingDeleted, Ignored, IgnoreSelector, AutoscalerScaleDownDisabled, IgnoreTaints, MissingProviderID}
+
+// DeletionStage is a step of the deletion process, counted as nodes reach it
+type DeletionStage string
+
+const (
+	// StageRequested counts the nodes entering want_delete, for any reason
+	StageRequested DeletionStage = "requested"
+	// StageDetached counts the nodes detached from their group
+	StageDetached DeletionStage = "detached"
+	// StageReady counts the nodes entering ready_to_delete
+	StageReady DeletionStage = "ready"
+	// StageLabelApplied counts the nodes handed over to be drained and removed
+	StageLabelApplied DeletionStage = "label_applied"
+	// StageNodeDeleted counts the nodes that left the cluster once handed over
+	StageNodeDeleted DeletionStage = "node_deleted"
+)
+
+// deletionStages are reported for every group that reached one, so the others are 0
+var deletionStages = []DeletionStage{StageRequested, StageDetached, StageReady, StageLabelApplied, StageNodeDeleted}
+
+// Reporter is responsible for storing prometheus metrics, and collecting them for the registry
 type Reporter struct {
 	info                  map[string]GroupState
 	seenStateReasonCombos map[Node]time.Time
 	cacheMu               sync.Mutex
+	breakerOpen           bool
+	notReadyNodes         int
+	errorBreakerOpen      bool
+	transitionErrorRate   float64
+	nodePatches           map[string]int
+	nodePatchRetries      int
+	deletionVerifications map[string]int
+	// stagesReached counts the nodes that reached each stage of deletion, by group
+	stagesReached map[string]map[DeletionStage]int
+	// deletionDurations are how long deleted nodes took from want_delete until they left the cluster, by group
+	deletionDurations map[string]*histogram
+	// excluded counts the nodes nodereaper won't delete, by group and why
+	excluded map[string]map[Exclusion]int
+	// providerCalls are the latencies of the cloud provider's API calls, and providerErrors
+	// the calls that failed, by error code
+	providerCalls     map[ProviderCall]*histogram
+	providerErrors    map[providerError]int
+	providerCacheSync time.Time
+	// leader is unset on standby replicas, which report the state persisted by the leader
+	leader bool
+	// unownedShards counts the shards no replica holds the lease of, out of shards, with sharding
+	shards        int
+	unownedShards int
+	// cluster labels every metric in multi-cluster mode
+	cluster string
+	// snapshot is served until something changes, or it's older than snapshotTTL
+	snapshot          []*dto.MetricFamily
+	snapshotTime      time.Time
+	snapshotOutOfDate bool
+}
+
+// ProviderCall identifies a cloud provider API operation, like autoscaling DescribeAutoScalingGroups
+type ProviderCall struct {
+	Service   string
+	Operation string
+}
+
+type providerError struct {
+	ProviderCall
+	code string
+}
+
+// histogram counts observations in buckets with the given upper bounds
+type histogram struct {
+	bounds  []float64
+	count   uint64
+	sum     float64
+	buckets []uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.count++
+	h.sum += v
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+}
+
+// toDto copies the histogram, as the snapshot mustn't change once generated
+func (h *histogram) toDto() *dto.Histogram {
+	buckets := make([]*dto.Bucket, len(h.bounds))
+	for i, bound := range h.bounds {
+		cumulative, upperBound := h.buckets[i], bound
+		buckets[i] = &dto.Bucket{CumulativeCount: &cumulative, UpperBound: &upperBound}
+	}
+	count, sum := h.count, h.sum
+	return &dto.Histogram{SampleCount: &count, SampleSum: &sum, Bucket: buckets}
 }
 
 // Node represents the state of a node's deletion,
@@ -51,6 +192,14 @@ type GroupState struct {
 	WantedNodes     int
 	DeletionEnabled bool
 	Nodes           []Node
+	// ReadyNodes is the number of the group's nodes that are Ready and schedulable
+	ReadyNodes int
+	// NextDeletionWindow is the start of the next event in the group's deletion calendar, if any
+	NextDeletionWindow *time.Time
+	// Orphaned is set if the group no longer exists with the provider
+	Orphaned bool
+	// ScheduleOpen is set if the group's deletionSchedule and deletionCalendar allow deletions now
+	ScheduleOpen bool
 }
 
 // New returns a new metrics reporter
@@ -59,16 +208,172 @@ func New() *Reporter {
 		info:                  make(map[string]GroupState),
 		seenStateReasonCombos: make(map[Node]time.Time),
 		cacheMu:               sync.Mutex{},
+		nodePatches:           make(map[string]int),
+		deletionVerifications: make(map[string]int),
+		stagesReached:         make(map[string]map[DeletionStage]int),
+		deletionDurations:     make(map[string]*histogram),
+		providerCalls:         make(map[ProviderCall]*histogram),
+		providerErrors:        make(map[providerError]int),
 	}
 }
 
+// NewForCluster returns a metrics reporter that labels every metric with the cluster,
+// for registering the reporters of several clusters together
+func NewForCluster(cluster string) *Reporter {
+	m := New()
+	m.cluster = cluster
+	return m
+}
+
 // SetGroupState sets what the controller thinks is the state of the group
 func (m *Reporter) SetGroupState(s map[string]GroupState) {
 	m.cacheMu.Lock()
 	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
 	m.info = s
 }
 
+// SetExcludedNodes sets how many nodes of each group are excluded from deletion, and why
+func (m *Reporter) SetExcludedNodes(excluded map[string]map[Exclusion]int) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	m.excluded = excluded
+}
+
+// RecordProviderCall records the latency of a cloud provider API call, including retries, and its
+// error code if it failed
+func (m *Reporter) RecordProviderCall(call ProviderCall, duration time.Duration, errorCode string) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	h, ok := m.providerCalls[call]
+	if !ok {
+		h = newHistogram(providerCallBuckets)
+		m.providerCalls[call] = h
+	}
+	h.observe(duration.Seconds())
+	if errorCode != "" {
+		m.providerErrors[providerError{call, errorCode}]++
+	}
+}
+
+// SetProviderCacheSync sets when the cloud provider's cache of groups and instances was last updated
+func (m *Reporter) SetProviderCacheSync(t time.Time) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	m.providerCacheSync = t
+}
+
+// SetCircuitBreaker sets whether deletions are suspended because of cluster health,
+// and the number of NotReady nodes that decision was based on
+func (m *Reporter) SetCircuitBreaker(open bool, notReadyNodes int) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	m.breakerOpen = open
+	m.notReadyNodes = notReadyNodes
+}
+
+// SetErrorBreaker sets whether deletions are suspended because too many transitions failed,
+// and the recent error rate that decision was based on
+func (m *Reporter) SetErrorBreaker(open bool, errorRate float64) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	m.errorBreakerOpen = open
+	m.transitionErrorRate = errorRate
+}
+
+// SetLeader sets whether this replica holds the leader lease and acts on nodes,
+// rather than observing the leader as a standby
+func (m *Reporter) SetLeader(leader bool) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	m.leader = leader
+}
+
+// SetUnownedShards sets how many of the shards no replica holds the lease of, so their groups aren't driven
+func (m *Reporter) SetUnownedShards(shards, unowned int) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	m.shards = shards
+	m.unownedShards = unowned
+}
+
+// RecordNodePatch counts a node patch, after retries, and whether it ultimately failed
+func (m *Reporter) RecordNodePatch(retries int, failed bool) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	result := "succeeded"
+	if failed {
+		result = "failed"
+	}
+	m.nodePatches[result]++
+	m.nodePatchRetries += retries
+}
+
+// RecordDeletionVerified counts a deleted node whose deletion was verified, or found incomplete
+func (m *Reporter) RecordDeletionVerified(verified bool) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	result := "verified"
+	if !verified {
+		result = "incomplete"
+	}
+	m.deletionVerifications[result]++
+}
+
+// RecordDeletionStage counts a node of the group reaching a stage of deletion
+func (m *Reporter) RecordDeletionStage(group string, stage DeletionStage) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	if m.stagesReached[group] == nil {
+		m.stagesReached[group] = make(map[DeletionStage]int)
+	}
+	m.stagesReached[group][stage]++
+}
+
+// RecordDeletionDuration records how long a deleted node of the group took from entering
+// want_delete until it left the cluster
+func (m *Reporter) RecordDeletionDuration(group string, duration time.Duration) {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	m.snapshotOutOfDate = true
+	h, ok := m.deletionDurations[group]
+	if !ok {
+		h = newHistogram(deletionDurationBuckets)
+		m.deletionDurations[group] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// metrics returns the current snapshot, generating it again if it is out of date.
+// The snapshot isn't modified afterwards, so it can be encoded without holding cacheMu
+func (m *Reporter) metrics() []*dto.MetricFamily {
+	m.cacheMu.Lock()
+	defer m.cacheMu.Unlock()
+	if m.snapshot == nil || m.snapshotOutOfDate || time.Since(m.snapshotTime) > snapshotTTL {
+		m.snapshot = m.generateMetrics()
+		if m.cluster != "" {
+			for _, mf := range m.snapshot {
+				for _, metric := range mf.Metric {
+					metric.Label = append([]*dto.LabelPair{{Name: s("cluster"), Value: s(m.cluster)}}, metric.Label...)
+				}
+			}
+		}
+		m.snapshotTime = time.Now()
+		m.snapshotOutOfDate = false
+	}
+	return m.snapshot
+}
+
 func (m *Reporter) generateMetrics() []*dto.MetricFamily {
 
 	timeMs := int64(time.Now().Unix()) * 1000
@@ -86,6 +391,10 @@ func (m *Reporter) generateMetrics() []*dto.MetricFamily {
 	desiredFamily := generateGaugeFamily("nodereaper_instance_group_desired_size", "Desired number of nodes in the instance group")
 	statesFamily := generateGaugeFamily("nodereaper_instance_group_state", "The number of nodes in a particular state of deletion")
 	enabledFamily := generateGaugeFamily("nodereaper_instance_group_deletion_enabled", "1 if nodereaper is allowed to delete nodes in this group, 0 otherwise")
+	scheduleFamily := generateGaugeFamily("nodereaper_schedule_open", "1 if the group's deletion schedule and calendar allow deletions now, 0 otherwise")
+	orphanedFamily := generateGaugeFamily("nodereaper_instance_group_orphaned", "1 if the group no longer exists with the provider, e.g. because its ASG was deleted or renamed, 0 otherwise")
+	discrepancyFamily := generateGaugeFamily("nodereaper_instance_group_size_discrepancy", "Ready and schedulable nodes in the instance group minus its desired size. Negative while the group is short of nodes")
+	windowFamily := generateGaugeFamily("nodereaper_instance_group_next_deletion_window", "Unix time of the start of the next event in the group's deletion calendar")
 
 	for groupName, group := range m.info {
 		groupKey := "group"
@@ -104,6 +413,41 @@ func (m *Reporter) generateMetrics() []*dto.MetricFamily {
 			TimestampMs: &timeMs,
 		})
 
+		scheduleVal := 0.0
+		if group.ScheduleOpen {
+			scheduleVal = 1.0
+		}
+		scheduleFamily.Metric = append(scheduleFamily.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{
+				&dto.LabelPair{Name: &groupKey, Value: &groupVal},
+			},
+			Gauge:       &dto.Gauge{Value: &scheduleVal},
+			TimestampMs: &timeMs,
+		})
+
+		orphanedVal := 0.0
+		if group.Orphaned {
+			orphanedVal = 1.0
+		}
+		orphanedFamily.Metric = append(orphanedFamily.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{
+				&dto.LabelPair{Name: &groupKey, Value: &groupVal},
+			},
+			Gauge:       &dto.Gauge{Value: &orphanedVal},
+			TimestampMs: &timeMs,
+		})
+
+		if group.NextDeletionWindow != nil {
+			window := float64(group.NextDeletionWindow.Unix())
+			windowFamily.Metric = append(windowFamily.Metric, &dto.Metric{
+				Label: []*dto.LabelPair{
+					&dto.LabelPair{Name: &groupKey, Value: &groupVal},
+				},
+				Gauge:       &dto.Gauge{Value: &window},
+				TimestampMs: &timeMs,
+			})
+		}
+
 		if group.WantedNodes != VeryHighFalseDesiredSize {
 			desired := float64(group.WantedNodes)
 			desiredFamily.Metric = append(desiredFamily.Metric, &dto.Metric{
@@ -113,6 +457,14 @@ func (m *Reporter) generateMetrics() []*dto.MetricFamily {
 				Gauge:       &dto.Gauge{Value: &desired},
 				TimestampMs: &timeMs,
 			})
+			discrepancy := float64(group.ReadyNodes - group.WantedNodes)
+			discrepancyFamily.Metric = append(discrepancyFamily.Metric, &dto.Metric{
+				Label: []*dto.LabelPair{
+					&dto.LabelPair{Name: &groupKey, Value: &groupVal},
+				},
+				Gauge:       &dto.Gauge{Value: &discrepancy},
+				TimestampMs: &timeMs,
+			})
 		}
 
 		stateReasonCounts := map[string]map[Reason]int{}
@@ -155,54 +507,261 @@ func (m *Reporter) generateMetrics() []*dto.MetricFamily {
 		}
 	}
 
-	out := []*dto.MetricFamily{}
+	breakerFamily := generateGaugeFamily("nodereaper_circuit_breaker_open", "1 if all deletions are suspended because too many nodes are NotReady, 0 otherwise")
+	breakerVal := 0.0
+	if m.breakerOpen {
+		breakerVal = 1.0
+	}
+	breakerFamily.Metric = append(breakerFamily.Metric, &dto.Metric{
+		Gauge:       &dto.Gauge{Value: &breakerVal},
+		TimestampMs: &timeMs,
+	})
+	notReadyFamily := generateGaugeFamily("nodereaper_not_ready_nodes", "The number of NotReady nodes, not counting nodes being deleted")
+	notReadyVal := float64(m.notReadyNodes)
+	notReadyFamily.Metric = append(notReadyFamily.Metric, &dto.Metric{
+		Gauge:       &dto.Gauge{Value: &notReadyVal},
+		TimestampMs: &timeMs,
+	})
+
+	errorBreakerFamily := generateGaugeFamily("nodereaper_error_breaker_open", "1 if all deletions are suspended because too many state transitions failed, 0 otherwise")
+	errorBreakerVal := 0.0
+	if m.errorBreakerOpen {
+		errorBreakerVal = 1.0
+	}
+	errorBreakerFamily.Metric = append(errorBreakerFamily.Metric, &dto.Metric{
+		Gauge:       &dto.Gauge{Value: &errorBreakerVal},
+		TimestampMs: &timeMs,
+	})
+	errorRateFamily := generateGaugeFamily("nodereaper_transition_error_rate", "The proportion of state transitions that failed within transitionErrorWindow")
+	errorRateVal := m.transitionErrorRate
+	errorRateFamily.Metric = append(errorRateFamily.Metric, &dto.Metric{
+		Gauge:       &dto.Gauge{Value: &errorRateVal},
+		TimestampMs: &timeMs,
+	})
+
+	leaderFamily := generateGaugeFamily("nodereaper_leader", "1 if this replica is the leader acting on nodes, 0 if it is a standby reporting the leader's persisted state")
+	leaderVal := 0.0
+	if m.leader {
+		leaderVal = 1.0
+	}
+	leaderFamily.Metric = append(leaderFamily.Metric, &dto.Metric{
+		Gauge:       &dto.Gauge{Value: &leaderVal},
+		TimestampMs: &timeMs,
+	})
+
+	unownedShardsFamily := generateGaugeFamily("nodereaper_unowned_shards", "The number of shards no replica holds the lease of, whose groups aren't driven")
+	if m.shards > 0 {
+		unownedVal := float64(m.unownedShards)
+		unownedShardsFamily.Metric = append(unownedShardsFamily.Metric, &dto.Metric{
+			Gauge:       &dto.Gauge{Value: &unownedVal},
+			TimestampMs: &timeMs,
+		})
+	}
+
+	excludedFamily := generateGaugeFamily("nodereaper_nodes_excluded", "The number of nodes nodereaper won't delete, by why they are excluded")
+	for groupName, reasons := range m.excluded {
+		for _, exclusion := range exclusions {
+			n := float64(reasons[exclusion])
+			excludedFamily.Metric = append(excludedFamily.Metric, &dto.Metric{
+				Label: []*dto.LabelPair{
+					&dto.LabelPair{Name: s("group"), Value: s(groupName)},
+					&dto.LabelPair{Name: s("exclusion"), Value: s(string(exclusion))},
+				},
+				Gauge:       &dto.Gauge{Value: &n},
+				TimestampMs: &timeMs,
+			})
+		}
+	}
+
+	generateCounterFamily := func(name, help string) *dto.MetricFamily {
+		c := dto.MetricType_COUNTER
+		return &dto.MetricFamily{
+			Name:   &name,
+			Help:   &help,
+			Type:   &c,
+			Metric: []*dto.Metric{},
+		}
+	}
+	generateHistogramFamily := func(name, help string) *dto.MetricFamily {
+		h := dto.MetricType_HISTOGRAM
+		return &dto.MetricFamily{
+			Name:   &name,
+			Help:   &help,
+			Type:   &h,
+			Metric: []*dto.Metric{},
+		}
+	}
+	patchesFamily := generateCounterFamily("nodereaper_node_patches_total", "The number of node patches, by whether they succeeded after any retries")
+	for _, result := range []string{"succeeded", "failed"} {
+		n := float64(m.nodePatches[result])
+		patchesFamily.Metric = append(patchesFamily.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{
+				&dto.LabelPair{Name: s("result"), Value: s(result)},
+			},
+			Counter:     &dto.Counter{Value: &n},
+			TimestampMs: &timeMs,
+		})
+	}
+	patchRetriesFamily := generateCounterFamily("nodereaper_node_patch_retries_total", "The number of node patch attempts retried after a conflict or transient error")
+	patchRetriesVal := float64(m.nodePatchRetries)
+	patchRetriesFamily.Metric = append(patchRetriesFamily.Metric, &dto.Metric{
+		Counter:     &dto.Counter{Value: &patchRetriesVal},
+		TimestampMs: &timeMs,
+	})
+
+	verifiedFamily := generateCounterFamily("nodereaper_deletion_verified_total", "The number of deleted nodes checked after their deletion, by whether every success criterion was met")
+	for _, result := range []string{"verified", "incomplete"} {
+		n := float64(m.deletionVerifications[result])
+		verifiedFamily.Metric = append(verifiedFamily.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{
+				&dto.LabelPair{Name: s("result"), Value: s(result)},
+			},
+			Counter:     &dto.Counter{Value: &n},
+			TimestampMs: &timeMs,
+		})
+	}
+
+	stagesFamily := generateCounterFamily("nodereaper_deletion_stage_total", "The number of nodes that reached each stage of deletion, by group")
+	for groupName, stages := range m.stagesReached {
+		for _, stage := range deletionStages {
+			n := float64(stages[stage])
+			stagesFamily.Metric = append(stagesFamily.Metric, &dto.Metric{
+				Label: []*dto.LabelPair{
+					&dto.LabelPair{Name: s("group"), Value: s(groupName)},
+					&dto.LabelPair{Name: s("stage"), Value: s(string(stage))},
+				},
+				Counter:     &dto.Counter{Value: &n},
+				TimestampMs: &timeMs,
+			})
+		}
+	}
+
+	durationsFamily := generateHistogramFamily("nodereaper_deletion_duration_seconds", "The time deleted nodes took from entering want_delete until they left the cluster, by group")
+	for groupName, h := range m.deletionDurations {
+		durationsFamily.Metric = append(durationsFamily.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{
+				&dto.LabelPair{Name: s("group"), Value: s(groupName)},
+			},
+			Histogram:   h.toDto(),
+			TimestampMs: &timeMs,
+		})
+	}
+
+	callsFamily := generateHistogramFamily("nodereaper_aws_api_call_duration_seconds", "The latency of AWS API calls, including retries, by service and operation")
+	for call, h := range m.providerCalls {
+		callsFamily.Metric = append(callsFamily.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{
+				&dto.LabelPair{Name: s("service"), Value: s(call.Service)},
+				&dto.LabelPair{Name: s("operation"), Value: s(call.Operation)},
+			},
+			Histogram:   h.toDto(),
+			TimestampMs: &timeMs,
+		})
+	}
+	callErrorsFamily := generateCounterFamily("nodereaper_aws_api_errors_total", "The number of failed AWS API calls, after retries, by service, operation and error code")
+	for e, n := range m.providerErrors {
+		val := float64(n)
+		callErrorsFamily.Metric = append(callErrorsFamily.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{
+				&dto.LabelPair{Name: s("service"), Value: s(e.Service)},
+				&dto.LabelPair{Name: s("operation"), Value: s(e.Operation)},
+				&dto.LabelPair{Name: s("code"), Value: s(e.code)},
+			},
+			Counter:     &dto.Counter{Value: &val},
+			TimestampMs: &timeMs,
+		})
+	}
+	cacheAgeFamily := generateGaugeFamily("nodereaper_aws_cache_age_seconds", "Seconds since the cache of ASGs and instances was last updated")
+	if !m.providerCacheSync.IsZero() {
+		age := time.Since(m.providerCacheSync).Seconds()
+		cacheAgeFamily.Metric = append(cacheAgeFamily.Metric, &dto.Metric{
+			Gauge:       &dto.Gauge{Value: &age},
+			TimestampMs: &timeMs,
+		})
+	}
+
+	out := []*dto.MetricFamily{leaderFamily, breakerFamily, notReadyFamily, errorBreakerFamily, errorRateFamily, patchesFamily, patchRetriesFamily, verifiedFamily}
 	if len(desiredFamily.Metric) > 0 {
 		out = append(out, desiredFamily)
 	}
+	if len(discrepancyFamily.Metric) > 0 {
+		out = append(out, discrepancyFamily)
+	}
 	if len(statesFamily.Metric) > 0 {
 		out = append(out, statesFamily)
 	}
 	if len(enabledFamily.Metric) > 0 {
 		out = append(out, enabledFamily)
 	}
+	if len(scheduleFamily.Metric) > 0 {
+		out = append(out, scheduleFamily)
+	}
+	if len(orphanedFamily.Metric) > 0 {
+		out = append(out, orphanedFamily)
+	}
+	if len(windowFamily.Metric) > 0 {
+		out = append(out, windowFamily)
+	}
+	if len(excludedFamily.Metric) > 0 {
+		out = append(out, excludedFamily)
+	}
+	for _, family := range []*dto.MetricFamily{stagesFamily, durationsFamily, callsFamily, callErrorsFamily, cacheAgeFamily, unownedShardsFamily} {
+		if len(family.Metric) > 0 {
+			out = append(out, family)
+		}
+	}
 
 	return out
 }
 
-// Handler returns metrics in response to an HTTP request
-func (m *Reporter) Handler(rsp http.ResponseWriter, req *http.Request) {
-	logrus.Trace("Serving prometheus metrics")
-	m.cacheMu.Lock()
-	defer m.cacheMu.Unlock()
+// Register adds the reporters to the registry controller-runtime serves its own metrics from. The
+// reporters should each be labelled with a different cluster
+func Register(reporters ...*Reporter) error {
+	for _, r := range reporters {
+		if err := ctrlmetrics.Registry.Register(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	metrics := m.generateMetrics()
-	contentType := expfmt.Negotiate(req.Header)
-	header := rsp.Header()
-	header.Set(contentTypeHeader, string(contentType))
-	w := io.Writer(rsp)
-	enc := expfmt.NewEncoder(w, contentType)
+// Handler serves the metrics of every registered reporter, along with controller-runtime's own
+func Handler() http.Handler {
+	return promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{})
+}
+
+// Describe sends no descriptions, which makes the reporter an unchecked collector, since
+// the groups and reasons it reports change over time
+func (m *Reporter) Describe(chan<- *prometheus.Desc) {}
 
-	var lastErr error
-	for _, mf := range metrics {
-		if err := enc.Encode(mf); err != nil {
-			lastErr = err
-			httpError(rsp, err)
-			return
+// Collect sends every metric of the current snapshot
+func (m *Reporter) Collect(ch chan<- prometheus.Metric) {
+	for _, mf := range m.metrics() {
+		desc := prometheus.NewDesc(mf.GetName(), mf.GetHelp(), nil, nil)
+		for _, metric := range mf.Metric {
+			ch <- snapshotMetric{desc: desc, metric: metric}
 		}
 	}
+}
 
-	if lastErr != nil {
-		httpError(rsp, lastErr)
-	}
+// snapshotMetric is a metric of a snapshot, already in the form the registry encodes
+type snapshotMetric struct {
+	desc   *prometheus.Desc
+	metric *dto.Metric
+}
+
+func (s snapshotMetric) Desc() *prometheus.Desc {
+	return s.desc
 }
 
-func httpError(rsp http.ResponseWriter, err error) {
-	rsp.Header().Del(contentEncodingHeader)
-	http.Error(
-		rsp,
-		"An error has occurred while serving metrics:\n\n"+err.Error(),
-		http.StatusInternalServerError,
-	)
+// Write copies the metric, since the snapshot is shared between scrapes
+func (s snapshotMetric) Write(out *dto.Metric) error {
+	out.Label = s.metric.Label
+	out.Gauge = s.metric.Gauge
+	out.Counter = s.metric.Counter
+	out.Histogram = s.metric.Histogram
+	out.TimestampMs = s.metric.TimestampMs
+	return nil
 }
 
 func s(ss string) *string {
@@ -0,0 +1,138 @@
+package ratelog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultInterval is how often the package level functions log each key until SetInterval is called
+const DefaultInterval = 10 * time.Minute
+
+// Limiter logs a recurring message at most once per interval for each key, such as an error
+// hit by the same node every poll. Suppressed occurrences are counted and reported with the next
+// message logged for the key, and every occurrence is logged at trace level in full
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	keys     map[string]*occurrences
+	// lastPrune is when keys last had the entries that went quiet removed
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// occurrences tracks a key since it was last logged
+type occurrences struct {
+	logged     time.Time
+	last       time.Time
+	suppressed int
+	level      logrus.Level
+	msg        string
+}
+
+// New creates a Limiter. An interval of 0 logs every occurrence
+func New(interval time.Duration) *Limiter {
+	return &Limiter{
+		interval: interval,
+		keys:     map[string]*occurrences{},
+		now:      time.Now,
+	}
+}
+
+// SetInterval changes how often each key is logged
+func (l *Limiter) SetInterval(interval time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = interval
+}
+
+// Warnf logs the message at warning level, unless the key was logged less than the interval ago
+func (l *Limiter) Warnf(key string, format string, args ...interface{}) {
+	l.logf(logrus.WarnLevel, key, format, args...)
+}
+
+// Errorf logs the message at error level, unless the key was logged less than the interval ago
+func (l *Limiter) Errorf(key string, format string, args ...interface{}) {
+	l.logf(logrus.ErrorLevel, key, format, args...)
+}
+
+func (l *Limiter) logf(level logrus.Level, key string, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	suppressed, since, ok := l.allow(key, level, msg)
+	if !ok {
+		logrus.WithField("ratelimit_key", key).Tracef("%v", msg)
+		return
+	}
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%v (repeated %v more times since %v)", msg, suppressed, since.Format(time.RFC3339))
+	}
+	logrus.StandardLogger().Logf(level, "%v", msg)
+}
+
+// allow records an occurrence of the key, and returns whether to log it along with
+// how many occurrences were suppressed since it was last logged
+func (l *Limiter) allow(key string, level logrus.Level, msg string) (int, time.Time, bool) {
+	l.mu.Lock()
+	now := l.now()
+	quiet := l.prune(now)
+	defer func() {
+		l.mu.Unlock()
+		for _, o := range quiet {
+			logrus.StandardLogger().Logf(o.level, "%v (repeated %v more times until %v)", o.msg, o.suppressed, o.last.Format(time.RFC3339))
+		}
+	}()
+
+	o, ok := l.keys[key]
+	if !ok {
+		l.keys[key] = &occurrences{logged: now, last: now, level: level, msg: msg}
+		return 0, time.Time{}, true
+	}
+	o.last, o.level, o.msg = now, level, msg
+	if now.Sub(o.logged) < l.interval {
+		o.suppressed++
+		return 0, time.Time{}, false
+	}
+	suppressed, since := o.suppressed, o.logged
+	o.logged, o.suppressed = now, 0
+	return suppressed, since, true
+}
+
+// prune forgets the keys that didn't recur for an interval, so a problem that comes back
+// after it was fixed is logged right away. It returns the forgotten keys that had suppressed
+// occurrences, which are still to be reported. Callers must hold mu
+func (l *Limiter) prune(now time.Time) []*occurrences {
+	if now.Sub(l.lastPrune) < l.interval {
+		return nil
+	}
+	l.lastPrune = now
+	quiet := []*occurrences{}
+	for key, o := range l.keys {
+		if now.Sub(o.last) < l.interval {
+			continue
+		}
+		delete(l.keys, key)
+		if o.suppressed > 0 {
+			quiet = append(quiet, o)
+		}
+	}
+	return quiet
+}
+
+var std = New(DefaultInterval)
+
+// SetInterval changes how often the package level functions log each key
+func SetInterval(interval time.Duration) {
+	std.SetInterval(interval)
+}
+
+// Warnf logs the message at warning level, unless the key was logged less than the interval ago
+func Warnf(key string, format string, args ...interface{}) {
+	std.Warnf(key, format, args...)
+}
+
+// Errorf logs the message at error level, unless the key was logged less than the interval ago
+func Errorf(key string, format string, args ...interface{}) {
+	std.Errorf(key, format, args...)
+}
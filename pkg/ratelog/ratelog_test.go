@@ -0,0 +1,50 @@
+package ratelog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAllow(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	l := New(10 * time.Minute)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		after      time.Duration
+		key        string
+		allowed    bool
+		suppressed int
+	}{
+		{0, "a", true, 0},
+		{time.Minute, "a", false, 0},
+		{time.Minute, "b", true, 0},
+		{5 * time.Minute, "a", false, 0},
+		{10 * time.Minute, "a", true, 2},
+		{11 * time.Minute, "a", false, 0},
+		// b didn't recur for an interval, so it's logged like it's new
+		{25 * time.Minute, "b", true, 0},
+		// a's suppressed occurrence was reported when it was forgotten
+		{30 * time.Minute, "a", true, 0},
+	}
+	for i, test := range tests {
+		now = start.Add(test.after)
+		suppressed, _, allowed := l.allow(test.key, logrus.WarnLevel, test.key)
+		if allowed != test.allowed || suppressed != test.suppressed {
+			t.Errorf("Test %v: expected %v at %v to be allowed %v with %v suppressed, got %v with %v suppressed",
+				i, test.key, test.after, test.allowed, test.suppressed, allowed, suppressed)
+		}
+	}
+}
+
+func TestAllowDisabled(t *testing.T) {
+	l := New(0)
+	for i := 0; i < 3; i++ {
+		if _, _, allowed := l.allow("a", logrus.WarnLevel, "a"); !allowed {
+			t.Errorf("Expected every occurrence to be allowed with no interval")
+		}
+	}
+}
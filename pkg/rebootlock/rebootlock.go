@@ -0,0 +1,168 @@
+package rebootlock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apps_v1 "k8s.io/api/apps/v1"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// KuredAnnotation is the annotation kured stores its lock in, on its own daemonset
+	KuredAnnotation = "weave.works/kured-node-lock"
+)
+
+// Lock is a cluster-wide "one node at a time" lock, stored as an annotation on a
+// daemonset (compatible with kured) or a configmap. Whoever holds it may reboot or
+// power off their node. A lock held longer than its TTL, or by a node that no longer exists,
+// is taken over, so a node that went away without releasing it doesn't block every other node
+type Lock struct {
+	annotation string
+	nodeID     string
+	ttl        time.Duration
+	get        func() (meta_v1.Object, error)
+	update     func(meta_v1.Object) error
+	nodeExists func(name string) (bool, error)
+}
+
+// lockValue matches the value kured writes, so that kured and nodereaperd respect each other's lock
+type lockValue struct {
+	NodeID   string      `json:"nodeID"`
+	Metadata interface{} `json:"metadata"`
+	Created  time.Time   `json:"created"`
+}
+
+// NewDaemonSetLock creates a lock stored on a daemonset, like kured's. A ttl of 0 never expires the lock
+func NewDaemonSetLock(clientset kubernetes.Interface, namespace, name, annotation, nodeID string, ttl time.Duration) *Lock {
+	return &Lock{
+		annotation: annotation,
+		nodeID:     nodeID,
+		ttl:        ttl,
+		nodeExists: nodeExists(clientset),
+		get: func() (meta_v1.Object, error) {
+			return clientset.AppsV1().DaemonSets(namespace).Get(context.Background(), name, meta_v1.GetOptions{})
+		},
+		update: func(obj meta_v1.Object) error {
+			_, err := clientset.AppsV1().DaemonSets(namespace).Update(context.Background(), obj.(*apps_v1.DaemonSet), meta_v1.UpdateOptions{})
+			return err
+		},
+	}
+}
+
+// NewConfigMapLock creates a lock stored on an existing configmap. A ttl of 0 never expires the lock
+func NewConfigMapLock(clientset kubernetes.Interface, namespace, name, annotation, nodeID string, ttl time.Duration) *Lock {
+	return &Lock{
+		annotation: annotation,
+		nodeID:     nodeID,
+		ttl:        ttl,
+		nodeExists: nodeExists(clientset),
+		get: func() (meta_v1.Object, error) {
+			return clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, meta_v1.GetOptions{})
+		},
+		update: func(obj meta_v1.Object) error {
+			_, err := clientset.CoreV1().ConfigMaps(namespace).Update(context.Background(), obj.(*core_v1.ConfigMap), meta_v1.UpdateOptions{})
+			return err
+		},
+	}
+}
+
+func nodeExists(clientset kubernetes.Interface) func(string) (bool, error) {
+	return func(name string) (bool, error) {
+		_, err := clientset.CoreV1().Nodes().Get(context.Background(), name, meta_v1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
+
+// Acquire tries to take the lock, returning false if another node holds it. Taking a lock
+// we already hold succeeds, and so does taking one that expired or whose holder is gone.
+// Concurrent attempts are settled by the API server rejecting updates with a stale resourceVersion
+func (l *Lock) Acquire() (bool, error) {
+	obj, err := l.get()
+	if err != nil {
+		return false, fmt.Errorf("Error reading reboot lock: %v", err)
+	}
+	annotations := obj.GetAnnotations()
+	if raw, ok := annotations[l.annotation]; ok {
+		value := lockValue{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			return false, fmt.Errorf("Error parsing reboot lock %v: %v", raw, err)
+		}
+		if value.NodeID == l.nodeID {
+			return true, nil
+		}
+		stale, err := l.stale(value)
+		if err != nil || !stale {
+			return false, err
+		}
+	}
+
+	value, err := json.Marshal(lockValue{
+		NodeID:   l.nodeID,
+		Metadata: map[string]string{"holder": "nodereaperd"},
+		Created:  time.Now().UTC(),
+	})
+	if err != nil {
+		return false, err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[l.annotation] = string(value)
+	obj.SetAnnotations(annotations)
+	if err := l.update(obj); err != nil {
+		return false, fmt.Errorf("Error taking reboot lock: %v", err)
+	}
+	return true, nil
+}
+
+// stale reports whether another node's lock can be taken over
+func (l *Lock) stale(value lockValue) (bool, error) {
+	if l.ttl > 0 && time.Since(value.Created) > l.ttl {
+		logrus.Warnf("Taking over the reboot lock %v held by %v since %v", l.annotation, value.NodeID, value.Created)
+		return true, nil
+	}
+	exists, err := l.nodeExists(value.NodeID)
+	if err != nil {
+		return false, fmt.Errorf("Error looking up reboot lock holder %v: %v", value.NodeID, err)
+	}
+	if !exists {
+		logrus.Warnf("Taking over the reboot lock %v held by %v, which no longer exists", l.annotation, value.NodeID)
+	}
+	return !exists, nil
+}
+
+// Release gives up the lock if we hold it
+func (l *Lock) Release() error {
+	obj, err := l.get()
+	if err != nil {
+		return fmt.Errorf("Error reading reboot lock: %v", err)
+	}
+	annotations := obj.GetAnnotations()
+	raw, ok := annotations[l.annotation]
+	if !ok {
+		return nil
+	}
+	value := lockValue{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return fmt.Errorf("Error parsing reboot lock %v: %v", raw, err)
+	}
+	if value.NodeID != l.nodeID {
+		return nil
+	}
+	delete(annotations, l.annotation)
+	obj.SetAnnotations(annotations)
+	if err := l.update(obj); err != nil {
+		return fmt.Errorf("Error releasing reboot lock: %v", err)
+	}
+	return nil
+}
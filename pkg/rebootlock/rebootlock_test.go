@@ -0,0 +1,116 @@
+package rebootlock
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testAnnotation = "test/lock"
+
+func lockedBy(t *testing.T, nodeID string, created time.Time) string {
+	value, err := json.Marshal(lockValue{NodeID: nodeID, Created: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(value)
+}
+
+func newClientset(annotation string, nodes ...string) kubernetes.Interface {
+	configMap := &core_v1.ConfigMap{ObjectMeta: meta_v1.ObjectMeta{Namespace: "kube-system", Name: "lock"}}
+	if annotation != "" {
+		configMap.Annotations = map[string]string{testAnnotation: annotation}
+	}
+	objects := []runtime.Object{configMap}
+	for _, node := range nodes {
+		objects = append(objects, &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: node}})
+	}
+	return fake.NewClientset(objects...)
+}
+
+func holder(t *testing.T, clientset kubernetes.Interface) string {
+	configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(context.Background(), "lock", meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, ok := configMap.Annotations[testAnnotation]
+	if !ok {
+		return ""
+	}
+	value := lockValue{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		t.Fatal(err)
+	}
+	return value.NodeID
+}
+
+func TestAcquire(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name     string
+		lock     string
+		nodes    []string
+		ttl      time.Duration
+		acquired bool
+		holder   string
+	}{
+		{"free", "", []string{"a", "b"}, time.Hour, true, "a"},
+		{"held by us", lockedBy(t, "a", now.Add(-2*time.Hour)), []string{"a", "b"}, time.Hour, true, "a"},
+		{"held by another node", lockedBy(t, "b", now), []string{"a", "b"}, time.Hour, false, "b"},
+		{"expired", lockedBy(t, "b", now.Add(-2*time.Hour)), []string{"a", "b"}, time.Hour, true, "a"},
+		{"no ttl", lockedBy(t, "b", now.Add(-2*time.Hour)), []string{"a", "b"}, 0, false, "b"},
+		{"holder gone", lockedBy(t, "b", now), []string{"a"}, time.Hour, true, "a"},
+	}
+	for _, test := range tests {
+		clientset := newClientset(test.lock, test.nodes...)
+		lock := NewConfigMapLock(clientset, "kube-system", "lock", testAnnotation, "a", test.ttl)
+		acquired, err := lock.Acquire()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if acquired != test.acquired {
+			t.Errorf("%v: expected acquired %v, got %v", test.name, test.acquired, acquired)
+		}
+		if h := holder(t, clientset); h != test.holder {
+			t.Errorf("%v: expected the lock to be held by %q, got %q", test.name, test.holder, h)
+		}
+	}
+}
+
+func TestRelease(t *testing.T) {
+	clientset := newClientset("", "a", "b")
+	a := NewConfigMapLock(clientset, "kube-system", "lock", testAnnotation, "a", time.Hour)
+	b := NewConfigMapLock(clientset, "kube-system", "lock", testAnnotation, "b", time.Hour)
+	if acquired, err := a.Acquire(); err != nil || !acquired {
+		t.Fatalf("Expected a to acquire the lock, got %v, %v", acquired, err)
+	}
+
+	// Releasing a lock held by another node leaves it alone
+	if err := b.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if h := holder(t, clientset); h != "a" {
+		t.Errorf("Expected the lock to still be held by a, got %q", h)
+	}
+	if acquired, err := b.Acquire(); err != nil || acquired {
+		t.Errorf("Expected b not to acquire the lock, got %v, %v", acquired, err)
+	}
+
+	if err := a.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if h := holder(t, clientset); h != "" {
+		t.Errorf("Expected the lock to be free, got %q", h)
+	}
+	if acquired, err := b.Acquire(); err != nil || !acquired {
+		t.Errorf("Expected b to acquire the lock, got %v, %v", acquired, err)
+	}
+}
@@ -0,0 +1,158 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wish/nodereaper/pkg/deletion"
+)
+
+const (
+	// InteractionsPath is where Slack's interactivity requests are served, next to the admin API
+	InteractionsPath = "/api/v1/slack/interactions"
+
+	// maxRequestAge rejects replayed requests, as Slack recommends
+	maxRequestAge = 5 * time.Minute
+)
+
+// Handler receives button presses on approval requests. Requests are authenticated with
+// Slack's request signature rather than the admin token
+type Handler struct {
+	deleter       *deletion.Deleter
+	signingSecret string
+}
+
+// NewHandler creates a handler for Slack interactivity requests
+func NewHandler(deleter *deletion.Deleter, signingSecret string) *Handler {
+	return &Handler{
+		deleter,
+		signingSecret,
+	}
+}
+
+type interaction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+}
+
+// ServeHTTP handles a Slack interactivity request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+	if err := h.verify(r.Header, body, time.Now()); err != nil {
+		logrus.Warnf("Rejected Slack request from %v: %v", r.RemoteAddr, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	payload := interaction{}
+	if err := json.Unmarshal([]byte(form.Get("payload")), &payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Type != "block_actions" || len(payload.Actions) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	action := payload.Actions[0]
+	if action.ActionID != approveAction && action.ActionID != denyAction {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	approved := action.ActionID == approveAction
+	user := payload.User.Username
+	if user == "" {
+		user = payload.User.ID
+	}
+
+	var reply string
+	err = h.deleter.Approve(r.Context(), action.Value, approved, "slack:"+user)
+	switch {
+	case err == nil && approved:
+		reply = fmt.Sprintf("Deletion of node *%v* was approved by <@%v>", action.Value, payload.User.ID)
+	case err == nil:
+		reply = fmt.Sprintf("Deletion of node *%v* was denied by <@%v>", action.Value, payload.User.ID)
+	default:
+		reply = fmt.Sprintf("Could not answer for node *%v*: %v", action.Value, err)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	// Replace the buttons with the outcome, so nobody answers twice
+	if payload.ResponseURL != "" {
+		go respond(payload.ResponseURL, reply, err == nil)
+	}
+}
+
+// verify checks the request signature, see https://api.slack.com/authentication/verifying-requests-from-slack
+func (h *Handler) verify(header http.Header, body []byte, now time.Time) error {
+	ts := header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp '%v'", ts)
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > maxRequestAge || age < -maxRequestAge {
+		return fmt.Errorf("request timestamp is %v old", age)
+	}
+
+	mac := hmac.New(sha256.New, []byte(h.signingSecret))
+	fmt.Fprintf(mac, "v0:%v:", ts)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func respond(responseURL, text string, replace bool) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"text":             text,
+		"replace_original": replace,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		logrus.Errorf("Error creating Slack response: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		logrus.Errorf("Error responding to Slack: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
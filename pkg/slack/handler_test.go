@@ -0,0 +1,28 @@
+package slack
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	// The example from Slack's documentation
+	h := NewHandler(nil, "8f742231b10e8888abcd99yyyzzz85a5")
+	body := []byte("token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c")
+	signed := time.Unix(1531420618, 0)
+
+	header := http.Header{}
+	header.Set("X-Slack-Request-Timestamp", "1531420618")
+	header.Set("X-Slack-Signature", "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503")
+
+	if err := h.verify(header, body, signed); err != nil {
+		t.Errorf("Valid signature rejected: %v", err)
+	}
+	if err := h.verify(header, body, signed.Add(10*time.Minute)); err == nil {
+		t.Errorf("Replayed request accepted")
+	}
+	if err := h.verify(header, append(body, 'x'), signed); err == nil {
+		t.Errorf("Tampered body accepted")
+	}
+}
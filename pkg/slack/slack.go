@@ -0,0 +1,102 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/wish/nodereaper/pkg/deletion"
+)
+
+const (
+	postMessageURL = "https://slack.com/api/chat.postMessage"
+
+	approveAction = "approve"
+	denyAction    = "deny"
+)
+
+// Approver posts approval requests to a Slack channel, with buttons to approve or deny the deletion.
+// Button presses come back through Handler
+type Approver struct {
+	token   string
+	channel string
+	cluster string
+}
+
+// NewApprover creates an approver posting to channel with a bot token. cluster is included
+// in messages if set, to tell clusters apart
+func NewApprover(token, channel, cluster string) *Approver {
+	return &Approver{
+		token:   token,
+		channel: channel,
+		cluster: cluster,
+	}
+}
+
+// RequestApproval posts a message asking to approve the node's deletion
+func (a *Approver) RequestApproval(ctx context.Context, req deletion.ApprovalRequest) error {
+	text := fmt.Sprintf("Nodereaper wants to delete node *%v* in group *%v*", req.Node, req.Group)
+	if a.cluster != "" {
+		text += fmt.Sprintf(" of cluster *%v*", a.cluster)
+	}
+	if req.Reason != "" {
+		text += fmt.Sprintf(" because %v", req.Reason)
+	}
+	if req.RequestedBy != "" {
+		text += fmt.Sprintf(", as requested by %v", req.RequestedBy)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"channel": a.channel,
+		"text":    text,
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]interface{}{"type": "mrkdwn", "text": text},
+			},
+			map[string]interface{}{
+				"type": "actions",
+				"elements": []interface{}{
+					button(approveAction, "Approve", "primary", req.Node),
+					button(denyAction, "Deny", "danger", req.Node),
+				},
+			},
+		},
+	})
+	httpReq, err := http.NewRequest(http.MethodPost, postMessageURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json; charset=utf-8")
+	httpReq.Header.Set("Authorization", "Bearer "+a.token)
+	resp, err := http.DefaultClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Error posting to Slack: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Slack reports most errors with a 200 and ok=false
+	result := struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("Error parsing Slack response (%v): %v", resp.Status, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("Slack returned error %v", result.Error)
+	}
+	return nil
+}
+
+func button(actionID, text, style, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "button",
+		"action_id": actionID,
+		"style":     style,
+		"value":     value,
+		"text":      map[string]interface{}{"type": "plain_text", "text": text},
+	}
+}
@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	admission_v1beta1 "k8s.io/api/admission/v1beta1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Path is where the webhook is served
+	Path = "/validate-node"
+	// ForceDeleteAnnotation lets anyone delete a node that nodereaper is deleting, with ProtectDeletion
+	ForceDeleteAnnotation = "nodereaper.wish.com/force-delete"
+)
+
+// Server is a validating admission webhook for nodes. It rejects adding or changing
+// the force-deletion label or the deletion taint by anyone but nodereaper itself,
+// since nodereaperd powers off any node carrying the label
+type Server struct {
+	forceDeletionLabel string
+	deletionTaint      string
+	labelWriters       map[string]bool
+	taintWriters       map[string]bool
+	// leavingState returns the state of a node nodereaper has detached or is deleting. Deleting
+	// nodes isn't checked if nil
+	leavingState func(nodeName string) (string, bool)
+	deleters     map[string]bool
+}
+
+// New creates the webhook. labelWriters and taintWriters are the usernames allowed to
+// set the force-deletion label and the deletion taint, respectively
+func New(forceDeletionLabel, deletionTaint string, labelWriters, taintWriters []string) *Server {
+	return &Server{
+		forceDeletionLabel: forceDeletionLabel,
+		deletionTaint:      deletionTaint,
+		labelWriters:       toSet(labelWriters),
+		taintWriters:       toSet(taintWriters),
+	}
+}
+
+// ProtectDeletion also rejects deleting a node that leavingState reports nodereaper detached or is
+// deleting, by anyone but the deleters, unless the node has ForceDeleteAnnotation. Deleting the node
+// early would leave a detached instance running without a node, or race the drain
+func (s *Server) ProtectDeletion(leavingState func(nodeName string) (string, bool), deleters []string) {
+	s.leavingState = leavingState
+	s.deleters = toSet(deleters)
+}
+
+func toSet(users []string) map[string]bool {
+	set := map[string]bool{}
+	for _, user := range users {
+		set[user] = true
+	}
+	return set
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	review := admission_v1beta1.AdmissionReview{}
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = s.review(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&review); err != nil {
+		logrus.Errorf("Error writing admission response: %v", err)
+	}
+}
+
+func (s *Server) review(req *admission_v1beta1.AdmissionRequest) *admission_v1beta1.AdmissionResponse {
+	allowed := &admission_v1beta1.AdmissionResponse{Allowed: true}
+	if req.Kind.Kind == "Node" && req.Operation == admission_v1beta1.Delete {
+		return s.reviewDelete(req)
+	}
+	if req.Kind.Kind != "Node" || (req.Operation != admission_v1beta1.Create && req.Operation != admission_v1beta1.Update) {
+		return allowed
+	}
+
+	node := core_v1.Node{}
+	if err := json.Unmarshal(req.Object.Raw, &node); err != nil {
+		return deny(fmt.Sprintf("could not decode node: %v", err))
+	}
+	oldNode := core_v1.Node{}
+	if len(req.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, &oldNode); err != nil {
+			return deny(fmt.Sprintf("could not decode old node: %v", err))
+		}
+	}
+
+	user := req.UserInfo.Username
+	if s.forceDeletionLabel != "" && !s.labelWriters[user] {
+		newValue, newOk := node.Labels[s.forceDeletionLabel]
+		oldValue, oldOk := oldNode.Labels[s.forceDeletionLabel]
+		if newOk && (!oldOk || newValue != oldValue) {
+			logrus.WithFields(logrus.Fields{"audit": true, "user": user, "node": node.Name}).Warnf("Denied setting force deletion label %v", s.forceDeletionLabel)
+			return deny(fmt.Sprintf("only nodereaper may set the %v label", s.forceDeletionLabel))
+		}
+	}
+	if s.deletionTaint != "" && !s.taintWriters[user] {
+		newTaint := findTaint(node.Spec.Taints, s.deletionTaint)
+		oldTaint := findTaint(oldNode.Spec.Taints, s.deletionTaint)
+		if newTaint != nil && (oldTaint == nil || !newTaint.MatchTaint(oldTaint) || newTaint.Value != oldTaint.Value) {
+			logrus.WithFields(logrus.Fields{"audit": true, "user": user, "node": node.Name}).Warnf("Denied setting deletion taint %v", s.deletionTaint)
+			return deny(fmt.Sprintf("only nodereaper may set the %v taint", s.deletionTaint))
+		}
+	}
+	return allowed
+}
+
+func (s *Server) reviewDelete(req *admission_v1beta1.AdmissionRequest) *admission_v1beta1.AdmissionResponse {
+	allowed := &admission_v1beta1.AdmissionResponse{Allowed: true}
+	user := req.UserInfo.Username
+	if s.leavingState == nil || s.deleters[user] {
+		return allowed
+	}
+	state, leaving := s.leavingState(req.Name)
+	if !leaving {
+		return allowed
+	}
+
+	// The old object is only sent from Kubernetes 1.15. Without it, the annotation can't be checked
+	if len(req.OldObject.Raw) > 0 {
+		node := core_v1.Node{}
+		if err := json.Unmarshal(req.OldObject.Raw, &node); err != nil {
+			return deny(fmt.Sprintf("could not decode node: %v", err))
+		}
+		if _, ok := node.Annotations[ForceDeleteAnnotation]; ok {
+			logrus.WithFields(logrus.Fields{"audit": true, "user": user, "node": req.Name}).Warnf("Allowed deleting %v node with %v", state, ForceDeleteAnnotation)
+			return allowed
+		}
+	}
+	logrus.WithFields(logrus.Fields{"audit": true, "user": user, "node": req.Name}).Warnf("Denied deleting %v node", state)
+	return deny(fmt.Sprintf("nodereaper is deleting node %v (%v). Annotate it with %v to delete it anyway", req.Name, state, ForceDeleteAnnotation))
+}
+
+func findTaint(taints []core_v1.Taint, key string) *core_v1.Taint {
+	for i := range taints {
+		if taints[i].Key == key {
+			return &taints[i]
+		}
+	}
+	return nil
+}
+
+func deny(msg string) *admission_v1beta1.AdmissionResponse {
+	return &admission_v1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &meta_v1.Status{
+			Status:  meta_v1.StatusFailure,
+			Message: msg,
+			Reason:  meta_v1.StatusReasonForbidden,
+			Code:    http.StatusForbidden,
+		},
+	}
+}
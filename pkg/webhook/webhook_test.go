@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	admission_v1beta1 "k8s.io/api/admission/v1beta1"
+	authentication_v1 "k8s.io/api/authentication/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	controllerUser = "system:serviceaccount:kube-system:nodereaper"
+	daemonsetUser  = "system:serviceaccount:kube-system:nodereaperd"
+)
+
+func nodeRequest(t *testing.T, user string, oldNode, node *core_v1.Node) *admission_v1beta1.AdmissionRequest {
+	raw, err := json.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldRaw, err := json.Marshal(oldNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &admission_v1beta1.AdmissionRequest{
+		Kind:      meta_v1.GroupVersionKind{Version: "v1", Kind: "Node"},
+		Operation: admission_v1beta1.Update,
+		UserInfo:  authentication_v1.UserInfo{Username: user},
+		Object:    runtime.RawExtension{Raw: raw},
+		OldObject: runtime.RawExtension{Raw: oldRaw},
+	}
+}
+
+func TestReview(t *testing.T) {
+	s := New("force-delete", "NodereaperDeletingNode", []string{controllerUser}, []string{controllerUser, daemonsetUser})
+
+	plain := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "a", Labels: map[string]string{}}}
+	labeled := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "a", Labels: map[string]string{"force-delete": "true"}}}
+	tainted := &core_v1.Node{
+		ObjectMeta: meta_v1.ObjectMeta{Name: "a"},
+		Spec:       core_v1.NodeSpec{Taints: []core_v1.Taint{{Key: "NodereaperDeletingNode", Effect: core_v1.TaintEffectNoExecute}}},
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		oldNode *core_v1.Node
+		node    *core_v1.Node
+		allowed bool
+	}{
+		{"controller sets label", controllerUser, plain, labeled, true},
+		{"user sets label", "alice", plain, labeled, false},
+		{"daemonset sets label", daemonsetUser, plain, labeled, false},
+		{"user removes label", "alice", labeled, plain, true},
+		{"user leaves existing label alone", "alice", labeled, labeled, true},
+		{"daemonset sets taint", daemonsetUser, plain, tainted, true},
+		{"user sets taint", "alice", plain, tainted, false},
+	}
+	for _, test := range tests {
+		rsp := s.review(nodeRequest(t, test.user, test.oldNode, test.node))
+		if rsp.Allowed != test.allowed {
+			t.Errorf("%v: expected allowed=%v, got %v", test.name, test.allowed, rsp.Allowed)
+		}
+	}
+}
+
+func TestReviewDelete(t *testing.T) {
+	s := New("force-delete", "NodereaperDeletingNode", []string{controllerUser}, []string{controllerUser, daemonsetUser})
+	s.ProtectDeletion(func(nodeName string) (string, bool) {
+		return "detached", nodeName == "leaving"
+	}, []string{controllerUser, daemonsetUser})
+
+	deleteRequest := func(user string, node *core_v1.Node) *admission_v1beta1.AdmissionRequest {
+		raw, err := json.Marshal(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &admission_v1beta1.AdmissionRequest{
+			Kind:      meta_v1.GroupVersionKind{Version: "v1", Kind: "Node"},
+			Name:      node.Name,
+			Operation: admission_v1beta1.Delete,
+			UserInfo:  authentication_v1.UserInfo{Username: user},
+			OldObject: runtime.RawExtension{Raw: raw},
+		}
+	}
+	leaving := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "leaving"}}
+	forced := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "leaving", Annotations: map[string]string{ForceDeleteAnnotation: ""}}}
+	staying := &core_v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: "staying"}}
+
+	tests := []struct {
+		name    string
+		user    string
+		node    *core_v1.Node
+		allowed bool
+	}{
+		{"user deletes leaving node", "alice", leaving, false},
+		{"user deletes leaving node with force annotation", "alice", forced, true},
+		{"daemonset deletes leaving node", daemonsetUser, leaving, true},
+		{"user deletes other node", "alice", staying, true},
+	}
+	for _, test := range tests {
+		rsp := s.review(deleteRequest(test.user, test.node))
+		if rsp.Allowed != test.allowed {
+			t.Errorf("%v: expected allowed=%v, got %v", test.name, test.allowed, rsp.Allowed)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+// Control API for the nodereaper controller.
+//
+// This mirrors the admin HTTP API served under /api/v1/ (see pkg/admin) so that
+// orchestrators can drive node recycling with generated, strongly typed clients.
+//
+// The controller serves it with pkg/control when --grpc-bind-address is set.
+//
+// Regenerate the Go code with go generate ./proto/..., which needs protoc and the plugins pinned in generate.go:
+//   go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.36.5
+//   go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.5.1
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: proto/nodereaper/v1/control.proto
+
+package nodereaperv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Control_ListGroups_FullMethodName      = "/nodereaper.v1.Control/ListGroups"
+	Control_GetGroup_FullMethodName        = "/nodereaper.v1.Control/GetGroup"
+	Control_GetNode_FullMethodName         = "/nodereaper.v1.Control/GetNode"
+	Control_RequestDeletion_FullMethodName = "/nodereaper.v1.Control/RequestDeletion"
+	Control_SnoozeNode_FullMethodName      = "/nodereaper.v1.Control/SnoozeNode"
+	Control_Approve_FullMethodName         = "/nodereaper.v1.Control/Approve"
+	Control_PauseGroup_FullMethodName      = "/nodereaper.v1.Control/PauseGroup"
+	Control_ResumeGroup_FullMethodName     = "/nodereaper.v1.Control/ResumeGroup"
+	Control_ListHistory_FullMethodName     = "/nodereaper.v1.Control/ListHistory"
+)
+
+// ControlClient is the client API for Control service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ControlClient interface {
+	// ListGroups returns every group and the state of each of its nodes
+	ListGroups(ctx context.Context, in *ListGroupsRequest, opts ...grpc.CallOption) (*ListGroupsResponse, error)
+	// GetGroup returns a single group
+	GetGroup(ctx context.Context, in *GetGroupRequest, opts ...grpc.CallOption) (*GroupStatus, error)
+	// GetNode returns the state of a single node
+	GetNode(ctx context.Context, in *GetNodeRequest, opts ...grpc.CallOption) (*NodeStatus, error)
+	// RequestDeletion moves a node to want_delete on an operator's behalf
+	RequestDeletion(ctx context.Context, in *RequestDeletionRequest, opts ...grpc.CallOption) (*NodeStatus, error)
+	// SnoozeNode holds a node in its current state for a while
+	SnoozeNode(ctx context.Context, in *SnoozeNodeRequest, opts ...grpc.CallOption) (*NodeStatus, error)
+	// Approve answers the interactive approval request of a node in want_delete
+	Approve(ctx context.Context, in *ApproveRequest, opts ...grpc.CallOption) (*NodeStatus, error)
+	// PauseGroup stops moving nodes in the group past want_delete
+	PauseGroup(ctx context.Context, in *SetGroupPausedRequest, opts ...grpc.CallOption) (*GroupStatus, error)
+	// ResumeGroup undoes PauseGroup
+	ResumeGroup(ctx context.Context, in *SetGroupPausedRequest, opts ...grpc.CallOption) (*GroupStatus, error)
+	// ListHistory returns the most recent deletions, oldest first
+	ListHistory(ctx context.Context, in *ListHistoryRequest, opts ...grpc.CallOption) (*ListHistoryResponse, error)
+}
+
+type controlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewControlClient(cc grpc.ClientConnInterface) ControlClient {
+	return &controlClient{cc}
+}
+
+func (c *controlClient) ListGroups(ctx context.Context, in *ListGroupsRequest, opts ...grpc.CallOption) (*ListGroupsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListGroupsResponse)
+	err := c.cc.Invoke(ctx, Control_ListGroups_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) GetGroup(ctx context.Context, in *GetGroupRequest, opts ...grpc.CallOption) (*GroupStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GroupStatus)
+	err := c.cc.Invoke(ctx, Control_GetGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) GetNode(ctx context.Context, in *GetNodeRequest, opts ...grpc.CallOption) (*NodeStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NodeStatus)
+	err := c.cc.Invoke(ctx, Control_GetNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) RequestDeletion(ctx context.Context, in *RequestDeletionRequest, opts ...grpc.CallOption) (*NodeStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NodeStatus)
+	err := c.cc.Invoke(ctx, Control_RequestDeletion_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) SnoozeNode(ctx context.Context, in *SnoozeNodeRequest, opts ...grpc.CallOption) (*NodeStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NodeStatus)
+	err := c.cc.Invoke(ctx, Control_SnoozeNode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) Approve(ctx context.Context, in *ApproveRequest, opts ...grpc.CallOption) (*NodeStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NodeStatus)
+	err := c.cc.Invoke(ctx, Control_Approve_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) PauseGroup(ctx context.Context, in *SetGroupPausedRequest, opts ...grpc.CallOption) (*GroupStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GroupStatus)
+	err := c.cc.Invoke(ctx, Control_PauseGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) ResumeGroup(ctx context.Context, in *SetGroupPausedRequest, opts ...grpc.CallOption) (*GroupStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GroupStatus)
+	err := c.cc.Invoke(ctx, Control_ResumeGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlClient) ListHistory(ctx context.Context, in *ListHistoryRequest, opts ...grpc.CallOption) (*ListHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListHistoryResponse)
+	err := c.cc.Invoke(ctx, Control_ListHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ControlServer is the server API for Control service.
+// All implementations must embed UnimplementedControlServer
+// for forward compatibility.
+type ControlServer interface {
+	// ListGroups returns every group and the state of each of its nodes
+	ListGroups(context.Context, *ListGroupsRequest) (*ListGroupsResponse, error)
+	// GetGroup returns a single group
+	GetGroup(context.Context, *GetGroupRequest) (*GroupStatus, error)
+	// GetNode returns the state of a single node
+	GetNode(context.Context, *GetNodeRequest) (*NodeStatus, error)
+	// RequestDeletion moves a node to want_delete on an operator's behalf
+	RequestDeletion(context.Context, *RequestDeletionRequest) (*NodeStatus, error)
+	// SnoozeNode holds a node in its current state for a while
+	SnoozeNode(context.Context, *SnoozeNodeRequest) (*NodeStatus, error)
+	// Approve answers the interactive approval request of a node in want_delete
+	Approve(context.Context, *ApproveRequest) (*NodeStatus, error)
+	// PauseGroup stops moving nodes in the group past want_delete
+	PauseGroup(context.Context, *SetGroupPausedRequest) (*GroupStatus, error)
+	// ResumeGroup undoes PauseGroup
+	ResumeGroup(context.Context, *SetGroupPausedRequest) (*GroupStatus, error)
+	// ListHistory returns the most recent deletions, oldest first
+	ListHistory(context.Context, *ListHistoryRequest) (*ListHistoryResponse, error)
+	mustEmbedUnimplementedControlServer()
+}
+
+// UnimplementedControlServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedControlServer struct{}
+
+func (UnimplementedControlServer) ListGroups(context.Context, *ListGroupsRequest) (*ListGroupsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListGroups not implemented")
+}
+func (UnimplementedControlServer) GetGroup(context.Context, *GetGroupRequest) (*GroupStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetGroup not implemented")
+}
+func (UnimplementedControlServer) GetNode(context.Context, *GetNodeRequest) (*NodeStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNode not implemented")
+}
+func (UnimplementedControlServer) RequestDeletion(context.Context, *RequestDeletionRequest) (*NodeStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestDeletion not implemented")
+}
+func (UnimplementedControlServer) SnoozeNode(context.Context, *SnoozeNodeRequest) (*NodeStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SnoozeNode not implemented")
+}
+func (UnimplementedControlServer) Approve(context.Context, *ApproveRequest) (*NodeStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Approve not implemented")
+}
+func (UnimplementedControlServer) PauseGroup(context.Context, *SetGroupPausedRequest) (*GroupStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseGroup not implemented")
+}
+func (UnimplementedControlServer) ResumeGroup(context.Context, *SetGroupPausedRequest) (*GroupStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeGroup not implemented")
+}
+func (UnimplementedControlServer) ListHistory(context.Context, *ListHistoryRequest) (*ListHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListHistory not implemented")
+}
+func (UnimplementedControlServer) mustEmbedUnimplementedControlServer() {}
+func (UnimplementedControlServer) testEmbeddedByValue()                 {}
+
+// UnsafeControlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ControlServer will
+// result in compilation errors.
+type UnsafeControlServer interface {
+	mustEmbedUnimplementedControlServer()
+}
+
+func RegisterControlServer(s grpc.ServiceRegistrar, srv ControlServer) {
+	// If the following call pancis, it indicates UnimplementedControlServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Control_ServiceDesc, srv)
+}
+
+func _Control_ListGroups_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListGroupsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ListGroups(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ListGroups_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ListGroups(ctx, req.(*ListGroupsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_GetGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).GetGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_GetGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).GetGroup(ctx, req.(*GetGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_GetNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).GetNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_GetNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).GetNode(ctx, req.(*GetNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_RequestDeletion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestDeletionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).RequestDeletion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_RequestDeletion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).RequestDeletion(ctx, req.(*RequestDeletionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_SnoozeNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SnoozeNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).SnoozeNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_SnoozeNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).SnoozeNode(ctx, req.(*SnoozeNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_Approve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApproveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).Approve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_Approve_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).Approve(ctx, req.(*ApproveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_PauseGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetGroupPausedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).PauseGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_PauseGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).PauseGroup(ctx, req.(*SetGroupPausedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_ResumeGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetGroupPausedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ResumeGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ResumeGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ResumeGroup(ctx, req.(*SetGroupPausedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Control_ListHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServer).ListHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Control_ListHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).ListHistory(ctx, req.(*ListHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Control_ServiceDesc is the grpc.ServiceDesc for Control service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Control_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "nodereaper.v1.Control",
+	HandlerType: (*ControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListGroups",
+			Handler:    _Control_ListGroups_Handler,
+		},
+		{
+			MethodName: "GetGroup",
+			Handler:    _Control_GetGroup_Handler,
+		},
+		{
+			MethodName: "GetNode",
+			Handler:    _Control_GetNode_Handler,
+		},
+		{
+			MethodName: "RequestDeletion",
+			Handler:    _Control_RequestDeletion_Handler,
+		},
+		{
+			MethodName: "SnoozeNode",
+			Handler:    _Control_SnoozeNode_Handler,
+		},
+		{
+			MethodName: "Approve",
+			Handler:    _Control_Approve_Handler,
+		},
+		{
+			MethodName: "PauseGroup",
+			Handler:    _Control_PauseGroup_Handler,
+		},
+		{
+			MethodName: "ResumeGroup",
+			Handler:    _Control_ResumeGroup_Handler,
+		},
+		{
+			MethodName: "ListHistory",
+			Handler:    _Control_ListHistory_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/nodereaper/v1/control.proto",
+}
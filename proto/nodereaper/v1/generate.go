@@ -0,0 +1,6 @@
+// Package nodereaperv1 holds the gRPC control API generated from control.proto. The generated
+// files record the protoc-gen-go and protoc-gen-go-grpc versions used, which are pinned here:
+// protoc-gen-go v1.36.5, matching google.golang.org/protobuf in go.mod, and protoc-gen-go-grpc v1.5.1
+package nodereaperv1
+
+//go:generate protoc --proto_path=../../.. --go_out=paths=source_relative:../../.. --go-grpc_out=paths=source_relative:../../.. proto/nodereaper/v1/control.proto